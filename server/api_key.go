@@ -0,0 +1,259 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	prefixAPIKey      = "api_key_"
+	prefixAPIKeyIndex = "api_keys_"
+
+	// The request header of the plugin API keys.
+	headerAPIKey = "X-Jira-Api-Key"
+
+	MaxAPIKeysPerUser = 20
+)
+
+// APIKey authenticates the requests of external automation to the
+// automation API, as the Mattermost user who created it. Only a hash of the
+// key is stored.
+type APIKey struct {
+	Id               string    `json:"id"`
+	Name             string    `json:"name"`
+	MattermostUserId string    `json:"mattermost_user_id"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+func apiKeyKey(secret string) string {
+	return hashkey(prefixAPIKey, secret)
+}
+
+func apiKeyIndexKey(mattermostUserId string) string {
+	return hashkey(prefixAPIKeyIndex, mattermostUserId)
+}
+
+// apiKeyIndex lists the keys of a user, by the KV key of each one.
+type apiKeyIndex map[string]APIKey
+
+func (p *Plugin) loadAPIKeys(mattermostUserId string) (apiKeyIndex, error) {
+	data, appErr := p.API.KVGet(apiKeyIndexKey(mattermostUserId))
+	if appErr != nil {
+		return nil, appErr
+	}
+	index := apiKeyIndex{}
+	if data != nil {
+		err := json.Unmarshal(data, &index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// apiKeysEnabled returns whether the API keys can be created and used. Like
+// the personal access tokens of Mattermost, they authenticate requests
+// without a session, so they are only enabled with those.
+func (p *Plugin) apiKeysEnabled() bool {
+	enabled := p.API.GetConfig().ServiceSettings.EnableUserAccessTokens
+	return enabled != nil && *enabled
+}
+
+// canUseAPIKeys returns whether mattermostUserId may create and use API
+// keys, which takes the permission to create personal access tokens.
+func (p *Plugin) canUseAPIKeys(mattermostUserId string) error {
+	if !p.apiKeysEnabled() {
+		return errors.New("API keys require the personal access tokens, which are disabled on this server. Please contact your system administrator")
+	}
+	if !p.API.HasPermissionTo(mattermostUserId, model.PERMISSION_CREATE_USER_ACCESS_TOKEN) {
+		return errors.New("API keys require the permission to create personal access tokens. Please contact your system administrator")
+	}
+	return nil
+}
+
+// createAPIKey returns a new API key of mattermostUserId, and its secret.
+func (p *Plugin) createAPIKey(mattermostUserId, name string) (*APIKey, string, error) {
+	err := p.canUseAPIKeys(mattermostUserId)
+	if err != nil {
+		return nil, "", err
+	}
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return nil, "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	key := &APIKey{
+		Id:               model.NewId()[:8],
+		Name:             name,
+		MattermostUserId: mattermostUserId,
+		CreatedAt:        time.Now().UTC(),
+	}
+
+	err = p.atomicModify(apiKeyIndexKey(mattermostUserId), func(initialBytes []byte) ([]byte, error) {
+		index := apiKeyIndex{}
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &index)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(index) >= MaxAPIKeysPerUser {
+			return nil, errors.Errorf("you can't have more than %d API keys, please revoke one first", MaxAPIKeysPerUser)
+		}
+		index[apiKeyKey(secret)] = *key
+		return json.Marshal(index)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, "", err
+	}
+	appErr := p.API.KVSet(apiKeyKey(secret), data)
+	if appErr != nil {
+		return nil, "", appErr
+	}
+	return key, secret, nil
+}
+
+// revokeAPIKey deletes the key of mattermostUserId with id.
+func (p *Plugin) revokeAPIKey(mattermostUserId, id string) (*APIKey, error) {
+	var revoked *APIKey
+	var kvKey string
+	err := p.atomicModify(apiKeyIndexKey(mattermostUserId), func(initialBytes []byte) ([]byte, error) {
+		index := apiKeyIndex{}
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &index)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for k, key := range index {
+			if key.Id == id {
+				key := key
+				revoked, kvKey = &key, k
+				delete(index, k)
+				return json.Marshal(index)
+			}
+		}
+		return nil, errors.Errorf("API key %q not found", id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	appErr := p.API.KVDelete(kvKey)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return revoked, nil
+}
+
+// authenticateAPIKey returns the Mattermost user of secret, unless the user
+// was deactivated or may no longer use API keys.
+func (p *Plugin) authenticateAPIKey(secret string) (string, error) {
+	data, appErr := p.API.KVGet(apiKeyKey(secret))
+	if appErr != nil {
+		return "", appErr
+	}
+	if data == nil {
+		return "", errors.New("invalid API key")
+	}
+	key := APIKey{}
+	err := json.Unmarshal(data, &key)
+	if err != nil {
+		return "", err
+	}
+	user, appErr := p.API.GetUser(key.MattermostUserId)
+	if appErr != nil {
+		return "", appErr
+	}
+	if user.DeleteAt != 0 {
+		return "", errors.New("the user of the API key is deactivated")
+	}
+	err = p.canUseAPIKeys(key.MattermostUserId)
+	if err != nil {
+		return "", err
+	}
+	return key.MattermostUserId, nil
+}
+
+// requestUserId returns the Mattermost user a request is made by, either
+// with a plugin API key, or with a Mattermost session or personal access
+// token.
+func (p *Plugin) requestUserId(r *http.Request) (string, error) {
+	if secret := r.Header.Get(headerAPIKey); secret != "" {
+		return p.authenticateAPIKey(secret)
+	}
+	mattermostUserId := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserId == "" {
+		return "", errors.New("not authorized")
+	}
+	return mattermostUserId, nil
+}
+
+func executeAPIKeyCreate(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) == 0 {
+		return p.help(header)
+	}
+	key, secret, err := p.createAPIKey(header.UserId, strings.Join(args, " "))
+	if err != nil {
+		return p.responsef(header, "Failed to create the API key: %v", err)
+	}
+	return p.responsef(header, "API key `%s` created, with id `%s`. It is only shown once:\n```\n%s\n```\n"+
+		"Send it in the `%s` header of the requests to `%s%s`. It has your permissions, `/jira api-key revoke %s` revokes it.",
+		key.Name, key.Id, secret, headerAPIKey, p.GetPluginURL(), routeAutomationAPI, key.Id)
+}
+
+func executeAPIKeyList(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) != 0 {
+		return p.help(header)
+	}
+	index, err := p.loadAPIKeys(header.UserId)
+	if err != nil {
+		return p.responsef(header, "Failed to load your API keys: %v", err)
+	}
+	if len(index) == 0 {
+		return p.responsef(header, "You have no API keys. `/jira api-key create <name>` creates one.")
+	}
+
+	keys := []APIKey{}
+	for _, key := range index {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	text := "###### Your API keys:\n"
+	for _, key := range keys {
+		text += fmt.Sprintf("* `%s` %s, created on %s\n", key.Id, key.Name, key.CreatedAt.Format("2006-01-02"))
+	}
+	return p.responsef(header, "%s", text)
+}
+
+func executeAPIKeyRevoke(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) != 1 {
+		return p.help(header)
+	}
+	key, err := p.revokeAPIKey(header.UserId, args[0])
+	if err != nil {
+		return p.responsef(header, "Failed to revoke the API key: %v", err)
+	}
+	return p.responsef(header, "API key `%s` %s revoked.", key.Id, key.Name)
+}
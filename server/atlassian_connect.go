@@ -71,15 +71,18 @@ func httpACInstalled(p *Plugin, w http.ResponseWriter, r *http.Request) (int, er
 			errors.Errorf("Jira instance %s is already installed", asc.BaseURL))
 	}
 
-	// Create a permanent instance record, also store it as current
+	// Create a permanent instance record, also store it as current if it is
+	// the first one installed, or replaces the current one
 	jiraInstance := NewJIRACloudInstance(p, asc.BaseURL, true, string(body), &asc)
 	err = p.instanceStore.StoreJIRAInstance(jiraInstance)
 	if err != nil {
 		return respondErr(w, http.StatusInternalServerError, err)
 	}
-	err = p.StoreCurrentJIRAInstanceAndNotify(jiraInstance)
-	if err != nil {
-		return respondErr(w, http.StatusInternalServerError, err)
+	if current, loadErr := p.currentInstanceStore.LoadCurrentJIRAInstance(); loadErr != nil || current.GetURL() == jiraInstance.GetURL() {
+		err = p.StoreCurrentJIRAInstanceAndNotify(jiraInstance)
+		if err != nil {
+			return respondErr(w, http.StatusInternalServerError, err)
+		}
 	}
 
 	// Setup autolink
@@ -0,0 +1,312 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// The automation API manages the channel subscriptions by team and channel
+// name, for external automation like Terraform or CI jobs. Its requests are
+// authenticated by a Mattermost personal access token, or by a plugin API
+// key, and are allowed with the permissions of their user.
+//
+// Version 1, described by assets/openapi.yaml:
+//
+//	GET    /automation/v1/openapi.yaml
+//	GET    /automation/v1/teams/{team}/channels/{channel}/subscriptions
+//	POST   /automation/v1/teams/{team}/channels/{channel}/subscriptions
+//	GET    /automation/v1/teams/{team}/channels/{channel}/subscriptions/{id or name}
+//	PUT    /automation/v1/teams/{team}/channels/{channel}/subscriptions/{id or name}
+//	DELETE /automation/v1/teams/{team}/channels/{channel}/subscriptions/{id or name}
+const (
+	routeAutomationAPI     = "/automation/v1"
+	routeAutomationOpenAPI = routeAutomationAPI + "/openapi.yaml"
+)
+
+// AutomationSubscription is a channel subscription in the automation API.
+// The team and channel of the URL take precedence over the ones of the body.
+type AutomationSubscription struct {
+	Id string `json:"id"`
+	ExportedSubscription
+}
+
+func newAutomationSubscription(team, channel string, sub *ChannelSubscription) *AutomationSubscription {
+	return &AutomationSubscription{
+		Id: sub.Id,
+		ExportedSubscription: ExportedSubscription{
+			Team:      team,
+			Channel:   channel,
+			Name:      sub.Name,
+			Filters:   sub.Filters,
+			Digest:    sub.Digest,
+			Templates: sub.Templates,
+			Actions:   sub.Actions,
+			RateLimit: sub.RateLimit,
+		},
+	}
+}
+
+// automationRequest is a request to the subscriptions of a channel.
+type automationRequest struct {
+	ji               Instance
+	mattermostUserId string
+	team             string
+	channel          *model.Channel
+
+	// The id or name of the subscription, if any.
+	subscription string
+}
+
+func httpAutomationAPI(p *Plugin, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.URL.Path == routeAutomationOpenAPI {
+		return httpAutomationOpenAPI(p, w, r)
+	}
+
+	mattermostUserId, err := p.requestUserId(r)
+	if err != nil {
+		return respondErr(w, http.StatusUnauthorized, err)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, routeAutomationAPI+"/"), "/")
+	if (len(parts) != 5 && len(parts) != 6) ||
+		parts[0] != "teams" || parts[2] != "channels" || parts[4] != "subscriptions" {
+		return respondErr(w, http.StatusNotFound, errors.New("not found"))
+	}
+
+	req := &automationRequest{
+		mattermostUserId: mattermostUserId,
+		team:             parts[1],
+	}
+	if len(parts) == 6 {
+		req.subscription = parts[5]
+	}
+	channel, appErr := p.API.GetChannelByNameForTeamName(parts[1], parts[3], false)
+	if appErr != nil {
+		return respondErr(w, http.StatusNotFound,
+			errors.Errorf("channel %q of team %q not found", parts[3], parts[1]))
+	}
+	req.channel = channel
+
+	req.ji, err = p.loadUserInstance(mattermostUserId, r.URL.Query().Get(argInstanceURL))
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	if _, appErr = p.API.GetChannelMember(channel.Id, mattermostUserId); appErr != nil {
+		return respondErr(w, http.StatusForbidden,
+			errors.New("Not a member of the channel specified"))
+	}
+	if err = p.hasPermissionToManageSubscription(req.ji, mattermostUserId, channel.Id); err != nil {
+		return respondErr(w, http.StatusForbidden,
+			errors.Wrap(err, "you don't have permission to manage subscriptions"))
+	}
+
+	switch {
+	case r.Method == http.MethodGet && req.subscription == "":
+		return p.httpAutomationListSubscriptions(w, req)
+	case r.Method == http.MethodPost && req.subscription == "":
+		return p.httpAutomationCreateSubscription(w, r, req)
+	case r.Method == http.MethodGet:
+		return p.httpAutomationGetSubscription(w, req)
+	case r.Method == http.MethodPut:
+		return p.httpAutomationUpdateSubscription(w, r, req)
+	case r.Method == http.MethodDelete:
+		return p.httpAutomationDeleteSubscription(w, req)
+	default:
+		return respondErr(w, http.StatusMethodNotAllowed,
+			errors.New("method "+r.Method+" is not allowed"))
+	}
+}
+
+func httpAutomationOpenAPI(p *Plugin, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return respondErr(w, http.StatusMethodNotAllowed,
+			errors.New("method "+r.Method+" is not allowed, must be GET"))
+	}
+	bundlePath, err := p.API.GetBundlePath()
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	spec, err := ioutil.ReadFile(filepath.Join(bundlePath, "assets", "openapi.yaml"))
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	w.Header().Set("Content-Type", "application/x-yaml")
+	_, err = w.Write(spec)
+	if err != nil {
+		return http.StatusInternalServerError, errors.WithMessage(err, "failed to write response")
+	}
+	return http.StatusOK, nil
+}
+
+func (p *Plugin) httpAutomationListSubscriptions(w http.ResponseWriter, req *automationRequest) (int, error) {
+	subs, err := p.getSubscriptionsForChannel(req.ji, req.channel.Id)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError,
+			errors.Wrap(err, "unable to get channel subscriptions"))
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Name < subs[j].Name
+	})
+	result := []*AutomationSubscription{}
+	for i := range subs {
+		result = append(result, newAutomationSubscription(req.team, req.channel.Name, &subs[i]))
+	}
+	return respondJSON(w, result)
+}
+
+func (p *Plugin) httpAutomationGetSubscription(w http.ResponseWriter, req *automationRequest) (int, error) {
+	sub, status, err := p.automationSubscription(req)
+	if err != nil {
+		return respondErr(w, status, err)
+	}
+	return respondJSON(w, newAutomationSubscription(req.team, req.channel.Name, sub))
+}
+
+func (p *Plugin) httpAutomationCreateSubscription(w http.ResponseWriter, r *http.Request, req *automationRequest) (int, error) {
+	in := AutomationSubscription{}
+	err := json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.WithMessage(err, "failed to decode incoming request"))
+	}
+	if in.Id != "" {
+		return respondErr(w, http.StatusBadRequest, errors.New("a new subscription can't have an id"))
+	}
+
+	sub := in.channelSubscription(req.channel.Id)
+	jiraUser, status, err := p.saveAutomationSubscription(req, sub, false)
+	if err != nil {
+		return respondErr(w, status, err)
+	}
+
+	code, err := respondJSON(w, newAutomationSubscription(req.team, req.channel.Name, sub))
+	if err != nil {
+		return code, err
+	}
+	p.API.CreatePost(&model.Post{
+		UserId:    p.getConfig().botUserID,
+		ChannelId: sub.ChannelId,
+		Message:   fmt.Sprintf("Jira subscription, \"%v\", was added to this channel by %v", sub.Name, jiraUser.DisplayName),
+	})
+	return http.StatusOK, nil
+}
+
+func (p *Plugin) httpAutomationUpdateSubscription(w http.ResponseWriter, r *http.Request, req *automationRequest) (int, error) {
+	existing, status, err := p.automationSubscription(req)
+	if err != nil {
+		return respondErr(w, status, err)
+	}
+	in := AutomationSubscription{}
+	err = json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.WithMessage(err, "failed to decode incoming request"))
+	}
+	if in.Id != "" && in.Id != existing.Id {
+		return respondErr(w, http.StatusBadRequest, errors.New("the id of a subscription can't be changed"))
+	}
+
+	sub := in.channelSubscription(req.channel.Id)
+	sub.Id = existing.Id
+	jiraUser, status, err := p.saveAutomationSubscription(req, sub, true)
+	if err != nil {
+		return respondErr(w, status, err)
+	}
+
+	code, err := respondJSON(w, newAutomationSubscription(req.team, req.channel.Name, sub))
+	if err != nil {
+		return code, err
+	}
+	p.API.CreatePost(&model.Post{
+		UserId:    p.getConfig().botUserID,
+		ChannelId: sub.ChannelId,
+		Message:   fmt.Sprintf("Jira subscription, \"%v\", was updated by %v", sub.Name, jiraUser.DisplayName),
+	})
+	return http.StatusOK, nil
+}
+
+func (p *Plugin) httpAutomationDeleteSubscription(w http.ResponseWriter, req *automationRequest) (int, error) {
+	sub, status, err := p.automationSubscription(req)
+	if err != nil {
+		return respondErr(w, status, err)
+	}
+	jiraUser, err := p.userStore.LoadJIRAUser(req.ji, req.mattermostUserId)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	err = p.removeChannelSubscription(req.ji, sub.Id)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError,
+			errors.Wrap(err, "unable to remove channel subscription"))
+	}
+
+	code, err := respondJSON(w, map[string]interface{}{"status": "OK"})
+	if err != nil {
+		return code, err
+	}
+	p.API.CreatePost(&model.Post{
+		UserId:    p.getConfig().botUserID,
+		ChannelId: sub.ChannelId,
+		Message:   fmt.Sprintf("Jira subscription, \"%v\", was removed from this channel by %v", sub.Name, jiraUser.DisplayName),
+	})
+	return http.StatusOK, nil
+}
+
+// automationSubscription returns the subscription of the channel of req, by
+// id or by name.
+func (p *Plugin) automationSubscription(req *automationRequest) (*ChannelSubscription, int, error) {
+	subs, err := p.getSubscriptionsForChannel(req.ji, req.channel.Id)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "unable to get channel subscriptions")
+	}
+	for i := range subs {
+		if subs[i].Id == req.subscription {
+			return &subs[i], http.StatusOK, nil
+		}
+	}
+	for i := range subs {
+		if subs[i].Name == req.subscription {
+			return &subs[i], http.StatusOK, nil
+		}
+	}
+	return nil, http.StatusNotFound, errors.Errorf("subscription %q not found", req.subscription)
+}
+
+// saveAutomationSubscription validates and stores sub, as the user of req.
+func (p *Plugin) saveAutomationSubscription(req *automationRequest, sub *ChannelSubscription, edit bool) (*JIRAUser, int, error) {
+	jiraUser, err := p.userStore.LoadJIRAUser(req.ji, req.mattermostUserId)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.WithMessage(err, "the user must be connected to Jira")
+	}
+	client, err := req.ji.GetClient(jiraUser)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if edit {
+		err = p.editChannelSubscription(req.ji, sub, client)
+	} else {
+		err = p.addChannelSubscription(req.ji, sub, client)
+	}
+	if err != nil {
+		if cause := errors.Cause(err); StatusCode(cause) == http.StatusBadRequest {
+			// The subscription is invalid.
+			return nil, http.StatusBadRequest, cause
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+	return &jiraUser, http.StatusOK, nil
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+func TestAutomationAPI(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	p.userStore = mockUserStore{}
+	p.updateConfig(func(conf *config) {
+		conf.RolesAllowedToEditJiraSubscriptions = "users"
+	})
+
+	kv := map[string][]byte{}
+	api.On("KVGet", mock.Anything).Return(func(key string) []byte {
+		return kv[key]
+	}, nil)
+	api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		kv[args.String(0)] = args.Get(2).([]byte)
+	}).Return(true, nil)
+	api.On("KVSet", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		kv[args.String(0)] = args.Get(1).([]byte)
+	}).Return(nil)
+	api.On("KVDelete", mock.Anything).Run(func(args mock.Arguments) {
+		delete(kv, args.String(0))
+	}).Return(nil)
+
+	enableTokens := true
+	api.On("GetConfig").Return(func() *model.Config {
+		return &model.Config{ServiceSettings: model.ServiceSettings{EnableUserAccessTokens: &enableTokens}}
+	})
+
+	userId := model.NewId()
+	channelId := model.NewId()
+	user := &model.User{Id: userId}
+	api.On("GetUser", userId).Return(func(string) *model.User {
+		return user
+	}, nil)
+	canCreateTokens := true
+	api.On("HasPermissionTo", userId, model.PERMISSION_CREATE_USER_ACCESS_TOKEN).Return(func(string, *model.Permission) bool {
+		return canCreateTokens
+	})
+	api.On("GetChannelByNameForTeamName", "ops", "alerts", false).Return(&model.Channel{Id: channelId, Name: "alerts"}, nil)
+	api.On("GetChannelByNameForTeamName", "ops", "other", false).Return(nil, &model.AppError{Message: "not found"})
+	api.On("GetChannelMember", channelId, userId).Return(&model.ChannelMember{}, nil)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+
+	key, secret, err := p.createAPIKey(userId, "terraform")
+	require.Nil(t, err)
+
+	do := func(method, path, apiKey string, body interface{}) (int, []byte) {
+		var data []byte
+		if body != nil {
+			data, err = json.Marshal(body)
+			require.Nil(t, err)
+		}
+		r := httptest.NewRequest(method, path, bytes.NewReader(data))
+		if apiKey != "" {
+			r.Header.Set(headerAPIKey, apiKey)
+		}
+		w := httptest.NewRecorder()
+		p.serveHTTP(&plugin.Context{}, w, r)
+		return w.Code, w.Body.Bytes()
+	}
+	const route = "/automation/v1/teams/ops/channels/alerts/subscriptions"
+
+	status, _ := do(http.MethodGet, route, "", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = do(http.MethodGet, route, "wrong", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = do(http.MethodGet, "/automation/v1/teams/ops/channels/other/subscriptions", secret, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	bugs := AutomationSubscription{ExportedSubscription: ExportedSubscription{
+		Name: "Bugs",
+		Filters: SubscriptionFilters{
+			Events: NewStringSet("event_created"),
+			JQL:    "project = PROJ AND issuetype = Bug",
+		},
+	}}
+	status, body := do(http.MethodPost, route, secret, bugs)
+	require.Equal(t, http.StatusOK, status, string(body))
+	created := AutomationSubscription{}
+	require.Nil(t, json.Unmarshal(body, &created))
+	assert.NotEmpty(t, created.Id)
+	assert.Equal(t, "ops", created.Team)
+	assert.Equal(t, "alerts", created.Channel)
+
+	invalid := bugs
+	invalid.Name = "No events"
+	invalid.Filters.Events = nil
+	status, _ = do(http.MethodPost, route, secret, invalid)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	bugs.Filters.Events = NewStringSet("event_created", "event_deleted")
+	status, body = do(http.MethodPut, route+"/Bugs", secret, bugs)
+	require.Equal(t, http.StatusOK, status, string(body))
+
+	status, body = do(http.MethodGet, route+"/"+created.Id, secret, nil)
+	require.Equal(t, http.StatusOK, status)
+	got := AutomationSubscription{}
+	require.Nil(t, json.Unmarshal(body, &got))
+	assert.Equal(t, created.Id, got.Id)
+	assert.True(t, got.Filters.Events.Equals(NewStringSet("event_created", "event_deleted")))
+
+	status, body = do(http.MethodGet, route, secret, nil)
+	require.Equal(t, http.StatusOK, status)
+	list := []AutomationSubscription{}
+	require.Nil(t, json.Unmarshal(body, &list))
+	assert.Len(t, list, 1)
+
+	status, _ = do(http.MethodDelete, route+"/Bugs", secret, nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = do(http.MethodGet, route+"/Bugs", secret, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// Deactivated users, and disabled personal access tokens, disable the
+	// keys.
+	user.DeleteAt = model.GetMillis()
+	status, _ = do(http.MethodGet, route, secret, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	user.DeleteAt = 0
+	enableTokens = false
+	status, _ = do(http.MethodGet, route, secret, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	_, _, err = p.createAPIKey(userId, "other")
+	assert.NotNil(t, err)
+	enableTokens = true
+	status, _ = do(http.MethodGet, route, secret, nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	// So does the permission to create personal access tokens.
+	canCreateTokens = false
+	status, _ = do(http.MethodGet, route, secret, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	_, _, err = p.createAPIKey(userId, "other")
+	assert.NotNil(t, err)
+	canCreateTokens = true
+
+	_, err = p.revokeAPIKey(userId, key.Id)
+	require.Nil(t, err)
+	status, _ = do(http.MethodGet, route, secret, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
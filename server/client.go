@@ -66,11 +66,16 @@ type IssueService interface {
 
 	AddAttachment(api plugin.API, issueKey, fileID string, maxSize utils.ByteSize) (mattermostName, jiraName, mime string, err error)
 	AddComment(issueKey string, comment *jira.Comment) (*jira.Comment, error)
+	AddWatcher(issueKey string, user *jira.User) error
 	DoTransition(issueKey, transitionID string) error
+	DoTransitionWithPayload(issueKey string, payload map[string]interface{}) error
+	GetTransitionsWithFields(issueKey string) ([]transitionMeta, error)
 	GetCreateMeta(*jira.GetQueryOptions) (*jira.CreateMetaInfo, error)
+	GetPriorities() ([]jira.Priority, error)
 	GetTransitions(issueKey string) ([]jira.Transition, error)
 	UpdateAssignee(issueKey string, user *jira.User) error
 	UpdateComment(issueKey string, comment *jira.Comment) (*jira.Comment, error)
+	UpdateIssue(issueKey string, data map[string]interface{}) error
 }
 
 // JiraClient is the common implementation of most Jira APIs, except those that are
@@ -220,6 +225,39 @@ func (client JiraClient) UpdateAssignee(issueKey string, user *jira.User) error
 	return err
 }
 
+// AddWatcher adds a user to the watchers of an issue.
+func (client JiraClient) AddWatcher(issueKey string, user *jira.User) error {
+	// Jira Cloud identifies users by their account id, Jira Server by their name.
+	name := user.AccountID
+	if name == "" {
+		name = user.Name
+	}
+	resp, err := client.Jira.Issue.AddWatcher(issueKey, name)
+	if err != nil {
+		return userFriendlyJiraError(resp, err)
+	}
+	return nil
+}
+
+// UpdateIssue edits an issue, data is the body of the edit issue request,
+// like {"fields": {"priority": {"id": "1"}}}.
+func (client JiraClient) UpdateIssue(issueKey string, data map[string]interface{}) error {
+	resp, err := client.Jira.Issue.UpdateIssue(issueKey, data)
+	if err != nil {
+		return userFriendlyJiraError(resp, err)
+	}
+	return nil
+}
+
+// GetPriorities returns the issue priorities.
+func (client JiraClient) GetPriorities() ([]jira.Priority, error) {
+	priorities, resp, err := client.Jira.Priority.GetList()
+	if err != nil {
+		return nil, userFriendlyJiraError(resp, err)
+	}
+	return priorities, nil
+}
+
 // AddComment adds a comment to an issue.
 func (client JiraClient) AddComment(issueKey string, comment *jira.Comment) (*jira.Comment, error) {
 	added, resp, err := client.Jira.Issue.AddComment(issueKey, comment)
@@ -259,6 +297,29 @@ func (client JiraClient) DoTransition(issueKey, transitionID string) error {
 	return nil
 }
 
+// GetTransitionsWithFields returns the transitions of an issue, with the
+// fields of their screens.
+func (client JiraClient) GetTransitionsWithFields(issueKey string) ([]transitionMeta, error) {
+	result := struct {
+		Transitions []transitionMeta `json:"transitions"`
+	}{}
+	err := client.RESTGet("2/issue/"+issueKey+"/transitions", map[string]string{"expand": "transitions.fields"}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Transitions, nil
+}
+
+// DoTransitionWithPayload executes a transition on an issue, payload is the
+// body of the transition request, with the transition, fields and update.
+func (client JiraClient) DoTransitionWithPayload(issueKey string, payload map[string]interface{}) error {
+	resp, err := client.Jira.Issue.DoTransitionWithPayload(issueKey, payload)
+	if err != nil {
+		return userFriendlyJiraError(resp, err)
+	}
+	return nil
+}
+
 // AddAttachment uploads a file attachment
 func (client JiraClient) AddAttachment(api plugin.API, issueKey, fileID string, maxSize utils.ByteSize) (
 	mattermostName, jiraName, mime string, err error) {
@@ -0,0 +1,336 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	goexpvar "expvar"
+	"strings"
+	"sync"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+)
+
+const (
+	// How long the responses of Jira are cached, by what they are about.
+	ClientCacheProjectTTL     = time.Hour
+	ClientCacheTransitionsTTL = 5 * time.Minute
+	ClientCacheUserGroupsTTL  = 15 * time.Minute
+
+	// Past this number of entries, the expired ones are purged, and if
+	// none are, the whole cache is.
+	MaxClientCacheEntries = 10000
+)
+
+// The kinds of cached responses, used for the stats and the invalidation.
+const (
+	cacheKindProjects    = "projects"
+	cacheKindCreateMeta  = "create_meta"
+	cacheKindPriorities  = "priorities"
+	cacheKindTransitions = "transitions"
+	cacheKindUserGroups  = "user_groups"
+)
+
+type clientCacheEntry struct {
+	data    []byte
+	expires time.Time
+
+	instanceURL string
+	kind        string
+	// The issue key, for the responses about an issue
+	issueKey string
+}
+
+type clientCacheCounts struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// clientCache caches the responses of Jira that rarely change, for all the
+// clients of the server. The responses are cached by user, since they
+// depend on the permissions of the user in Jira.
+type clientCache struct {
+	lock    sync.Mutex
+	entries map[string]clientCacheEntry
+	counts  map[string]*clientCacheCounts
+	now     func() time.Time
+}
+
+func newClientCache() *clientCache {
+	return &clientCache{
+		entries: map[string]clientCacheEntry{},
+		counts:  map[string]*clientCacheCounts{},
+		now:     time.Now,
+	}
+}
+
+// wrap returns client, caching its responses for jiraUser. It returns client
+// unchanged if there is no cache.
+func (c *clientCache) wrap(instanceURL string, jiraUser JIRAUser, client Client) Client {
+	if c == nil {
+		return client
+	}
+	return &cachingClient{
+		Client:      client,
+		cache:       c,
+		instanceURL: instanceURL,
+		userKey:     jiraUser.Key(),
+	}
+}
+
+func (c *clientCache) get(key, kind string, dest interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	counts := c.counts[kind]
+	if counts == nil {
+		counts = &clientCacheCounts{}
+		c.counts[kind] = counts
+	}
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		counts.Misses++
+		return false
+	}
+	// The responses are unmarshaled anew so that the callers can't modify
+	// the cached ones.
+	if json.Unmarshal(entry.data, dest) != nil {
+		counts.Misses++
+		return false
+	}
+	counts.Hits++
+	return true
+}
+
+func (c *clientCache) set(key string, entry clientCacheEntry, ttl time.Duration, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= MaxClientCacheEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= MaxClientCacheEntries {
+			c.entries = map[string]clientCacheEntry{}
+		}
+	}
+	entry.data = data
+	entry.expires = now.Add(ttl)
+	c.entries[key] = entry
+}
+
+// invalidate removes the responses of an instance of a kind, for all the
+// users. If issueKey is not empty, only the ones about the issue are.
+func (c *clientCache) invalidate(instanceURL, kind, issueKey string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for k, e := range c.entries {
+		if e.instanceURL == instanceURL && e.kind == kind && (issueKey == "" || e.issueKey == issueKey) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// invalidateForWebhook removes the responses made stale by a webhook event
+// of an instance.
+func (c *clientCache) invalidateForWebhook(instanceURL string, data []byte) {
+	event := struct {
+		WebhookEvent string `json:"webhookEvent"`
+		Issue        struct {
+			Key string `json:"key"`
+		} `json:"issue"`
+	}{}
+	if json.Unmarshal(data, &event) != nil {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(event.WebhookEvent, "jira:issue_"):
+		// The transitions depend on the status, and the type, of the issue.
+		if event.Issue.Key != "" {
+			c.invalidate(instanceURL, cacheKindTransitions, event.Issue.Key)
+		}
+	case strings.HasPrefix(event.WebhookEvent, "project_"),
+		strings.HasPrefix(event.WebhookEvent, "option_"):
+		c.invalidate(instanceURL, cacheKindProjects, "")
+		c.invalidate(instanceURL, cacheKindCreateMeta, "")
+		c.invalidate(instanceURL, cacheKindPriorities, "")
+		c.invalidate(instanceURL, cacheKindTransitions, "")
+	case strings.HasPrefix(event.WebhookEvent, "user_"),
+		strings.HasPrefix(event.WebhookEvent, "group_"):
+		c.invalidate(instanceURL, cacheKindUserGroups, "")
+	}
+}
+
+// stats returns the hits and misses by kind, and the number of entries.
+func (c *clientCache) stats() interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	counts := map[string]clientCacheCounts{}
+	for kind, kindCounts := range c.counts {
+		counts[kind] = *kindCounts
+	}
+	return map[string]interface{}{
+		"entries": len(c.entries),
+		"counts":  counts,
+	}
+}
+
+func initClientCacheCounter(c *clientCache) {
+	if c == nil {
+		return
+	}
+	goexpvar.Publish("jira/client_cache", goexpvar.Func(c.stats))
+}
+
+// cachingClient is a Client that caches the responses of the projects,
+// issue types, create-meta, transitions, and user groups.
+type cachingClient struct {
+	Client
+	cache       *clientCache
+	instanceURL string
+	userKey     string
+}
+
+func (client *cachingClient) key(kind string, args ...string) string {
+	return strings.Join(append([]string{client.instanceURL, client.userKey, kind}, args...), "\x00")
+}
+
+func (client *cachingClient) entry(kind, issueKey string) clientCacheEntry {
+	return clientCacheEntry{
+		instanceURL: client.instanceURL,
+		kind:        kind,
+		issueKey:    issueKey,
+	}
+}
+
+func (client *cachingClient) GetProject(key string) (*jira.Project, error) {
+	cacheKey := client.key(cacheKindProjects, "project", key)
+	project := &jira.Project{}
+	if client.cache.get(cacheKey, cacheKindProjects, project) {
+		return project, nil
+	}
+	project, err := client.Client.GetProject(key)
+	if err != nil {
+		return nil, err
+	}
+	client.cache.set(cacheKey, client.entry(cacheKindProjects, ""), ClientCacheProjectTTL, project)
+	return project, nil
+}
+
+func (client *cachingClient) GetAllProjectKeys() ([]string, error) {
+	cacheKey := client.key(cacheKindProjects, "keys")
+	keys := []string{}
+	if client.cache.get(cacheKey, cacheKindProjects, &keys) {
+		return keys, nil
+	}
+	keys, err := client.Client.GetAllProjectKeys()
+	if err != nil {
+		return nil, err
+	}
+	client.cache.set(cacheKey, client.entry(cacheKindProjects, ""), ClientCacheProjectTTL, keys)
+	return keys, nil
+}
+
+func (client *cachingClient) GetCreateMeta(options *jira.GetQueryOptions) (*jira.CreateMetaInfo, error) {
+	optionsKey, err := json.Marshal(options)
+	if err != nil {
+		return client.Client.GetCreateMeta(options)
+	}
+	cacheKey := client.key(cacheKindCreateMeta, string(optionsKey))
+	meta := &jira.CreateMetaInfo{}
+	if client.cache.get(cacheKey, cacheKindCreateMeta, meta) {
+		return meta, nil
+	}
+	meta, err = client.Client.GetCreateMeta(options)
+	if err != nil {
+		return nil, err
+	}
+	client.cache.set(cacheKey, client.entry(cacheKindCreateMeta, ""), ClientCacheProjectTTL, meta)
+	return meta, nil
+}
+
+func (client *cachingClient) GetPriorities() ([]jira.Priority, error) {
+	cacheKey := client.key(cacheKindPriorities)
+	priorities := []jira.Priority{}
+	if client.cache.get(cacheKey, cacheKindPriorities, &priorities) {
+		return priorities, nil
+	}
+	priorities, err := client.Client.GetPriorities()
+	if err != nil {
+		return nil, err
+	}
+	client.cache.set(cacheKey, client.entry(cacheKindPriorities, ""), ClientCacheProjectTTL, priorities)
+	return priorities, nil
+}
+
+func (client *cachingClient) GetTransitions(issueKey string) ([]jira.Transition, error) {
+	cacheKey := client.key(cacheKindTransitions, issueKey)
+	transitions := []jira.Transition{}
+	if client.cache.get(cacheKey, cacheKindTransitions, &transitions) {
+		return transitions, nil
+	}
+	transitions, err := client.Client.GetTransitions(issueKey)
+	if err != nil {
+		return nil, err
+	}
+	client.cache.set(cacheKey, client.entry(cacheKindTransitions, issueKey), ClientCacheTransitionsTTL, transitions)
+	return transitions, nil
+}
+
+func (client *cachingClient) GetTransitionsWithFields(issueKey string) ([]transitionMeta, error) {
+	cacheKey := client.key(cacheKindTransitions, issueKey, "fields")
+	transitions := []transitionMeta{}
+	if client.cache.get(cacheKey, cacheKindTransitions, &transitions) {
+		return transitions, nil
+	}
+	transitions, err := client.Client.GetTransitionsWithFields(issueKey)
+	if err != nil {
+		return nil, err
+	}
+	client.cache.set(cacheKey, client.entry(cacheKindTransitions, issueKey), ClientCacheTransitionsTTL, transitions)
+	return transitions, nil
+}
+
+func (client *cachingClient) GetUserGroups(user JIRAUser) ([]*jira.UserGroup, error) {
+	cacheKey := client.key(cacheKindUserGroups, user.Key())
+	groups := []*jira.UserGroup{}
+	if client.cache.get(cacheKey, cacheKindUserGroups, &groups) {
+		return groups, nil
+	}
+	groups, err := client.Client.GetUserGroups(user)
+	if err != nil {
+		return nil, err
+	}
+	client.cache.set(cacheKey, client.entry(cacheKindUserGroups, ""), ClientCacheUserGroupsTTL, groups)
+	return groups, nil
+}
+
+// The transitions of an issue change with its status, and its type.
+
+func (client *cachingClient) DoTransition(issueKey, transitionID string) error {
+	defer client.cache.invalidate(client.instanceURL, cacheKindTransitions, issueKey)
+	return client.Client.DoTransition(issueKey, transitionID)
+}
+
+func (client *cachingClient) DoTransitionWithPayload(issueKey string, payload map[string]interface{}) error {
+	defer client.cache.invalidate(client.instanceURL, cacheKindTransitions, issueKey)
+	return client.Client.DoTransitionWithPayload(issueKey, payload)
+}
+
+func (client *cachingClient) UpdateIssue(issueKey string, data map[string]interface{}) error {
+	defer client.cache.invalidate(client.instanceURL, cacheKindTransitions, issueKey)
+	return client.Client.UpdateIssue(issueKey, data)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingTestClient struct {
+	testClient
+	calls map[string]int
+}
+
+func (client *countingTestClient) GetProject(key string) (*jira.Project, error) {
+	client.calls["GetProject"]++
+	return &jira.Project{Key: key, Name: "Project " + key}, nil
+}
+
+func (client *countingTestClient) GetTransitions(issueKey string) ([]jira.Transition, error) {
+	client.calls["GetTransitions"]++
+	return []jira.Transition{{ID: "1", Name: "Start"}}, nil
+}
+
+func (client *countingTestClient) DoTransition(issueKey, transitionID string) error {
+	return nil
+}
+
+func (client *countingTestClient) GetUserGroups(user JIRAUser) ([]*jira.UserGroup, error) {
+	client.calls["GetUserGroups"]++
+	return []*jira.UserGroup{{Name: "jira-users"}}, nil
+}
+
+func TestClientCache(t *testing.T) {
+	now := time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC)
+	cache := newClientCache()
+	cache.now = func() time.Time { return now }
+
+	upstream := &countingTestClient{calls: map[string]int{}}
+	alice := cache.wrap(mockCurrentInstanceURL, JIRAUser{User: jira.User{AccountID: "alice"}}, upstream)
+	bob := cache.wrap(mockCurrentInstanceURL, JIRAUser{User: jira.User{AccountID: "bob"}}, upstream)
+
+	project, err := alice.GetProject("PROJ")
+	require.Nil(t, err)
+	project.Name = "modified"
+	project, err = alice.GetProject("PROJ")
+	require.Nil(t, err)
+	assert.Equal(t, "Project PROJ", project.Name, "cached responses can't be modified")
+	assert.Equal(t, 1, upstream.calls["GetProject"])
+
+	// The responses are cached by user.
+	_, err = bob.GetProject("PROJ")
+	require.Nil(t, err)
+	assert.Equal(t, 2, upstream.calls["GetProject"])
+
+	// They expire.
+	now = now.Add(ClientCacheProjectTTL)
+	_, err = alice.GetProject("PROJ")
+	require.Nil(t, err)
+	assert.Equal(t, 3, upstream.calls["GetProject"])
+
+	// The transitions are invalidated by transitioning the issue, or by its
+	// webhook events, for all the users.
+	_, _ = alice.GetTransitions("PROJ-1")
+	_, _ = bob.GetTransitions("PROJ-1")
+	_, _ = alice.GetTransitions("PROJ-2")
+	assert.Equal(t, 3, upstream.calls["GetTransitions"])
+	require.Nil(t, bob.DoTransition("PROJ-1", "1"))
+	_, _ = alice.GetTransitions("PROJ-1")
+	_, _ = alice.GetTransitions("PROJ-2")
+	assert.Equal(t, 4, upstream.calls["GetTransitions"])
+
+	cache.invalidateForWebhook(mockCurrentInstanceURL, []byte(`{"webhookEvent":"jira:issue_updated","issue":{"key":"PROJ-2"}}`))
+	_, _ = alice.GetTransitions("PROJ-1")
+	_, _ = alice.GetTransitions("PROJ-2")
+	assert.Equal(t, 5, upstream.calls["GetTransitions"])
+
+	// Only the events of the instance invalidate its responses.
+	_, _ = alice.GetUserGroups(JIRAUser{User: jira.User{AccountID: "alice"}})
+	cache.invalidateForWebhook("http://other.some", []byte(`{"webhookEvent":"user_updated"}`))
+	_, _ = alice.GetUserGroups(JIRAUser{User: jira.User{AccountID: "alice"}})
+	assert.Equal(t, 1, upstream.calls["GetUserGroups"])
+	cache.invalidateForWebhook(mockCurrentInstanceURL, []byte(`{"webhookEvent":"user_updated"}`))
+	_, _ = alice.GetUserGroups(JIRAUser{User: jira.User{AccountID: "alice"}})
+	assert.Equal(t, 2, upstream.calls["GetUserGroups"])
+
+	stats := cache.stats().(map[string]interface{})
+	counts := stats["counts"].(map[string]clientCacheCounts)
+	assert.Equal(t, clientCacheCounts{Hits: 1, Misses: 3}, counts[cacheKindProjects])
+	assert.Equal(t, clientCacheCounts{Hits: 1, Misses: 2}, counts[cacheKindUserGroups])
+
+	// Without a cache, the clients are not wrapped.
+	var noCache *clientCache
+	assert.Equal(t, upstream, noCache.wrap(mockCurrentInstanceURL, JIRAUser{}, upstream))
+}
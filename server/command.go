@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
+	"net/url"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
@@ -15,34 +18,59 @@ import (
 
 const helpTextHeader = "###### Mattermost Jira Plugin - Slash Command Help\n"
 
-const commonHelpText = "\n* `/jira connect` - Connect your Mattermost account to your Jira account\n" +
-	"* `/jira disconnect` - Disconnect your Mattermost account from your Jira account\n" +
-	"* `/jira assign <issue-key> <assignee>` - Change the assignee of a Jira issue\n" +
+const commonHelpText = "\n* `/jira connect [jira-url]` - Connect your Mattermost account to your Jira account, on the default or the specified Jira instance\n" +
+	"* `/jira disconnect [jira-url]` - Disconnect your Mattermost account from your Jira account\n" +
+	"* `/jira assign <issue-key> [assignee]` - Change the assignee of a Jira issue. Without an assignee, opens a dialog to pick one\n" +
 	"* `/jira unassign <issue-key>` - Unassign the Jira issue\n" +
+	"* `/jira comment <issue-key>` - Open a dialog to comment on a Jira issue\n" +
 	"* `/jira create <text (optional)>` - Create a new Issue with 'text' inserted into the description field\n" +
-	"* `/jira transition <issue-key> <state>` - Change the state of a Jira issue\n" +
+	"* `/jira create --project <key> --type <issue type> [options] \"<summary>\" [-- <description>]` - Create a new Issue without the dialog. `/jira create --help` lists the options\n" +
+	"* `/jira transition <issue-key> [state] [--field \"<field name>=<value>\"] [-- <comment>]` - Change the state of a Jira issue, with the fields its screen requires. Without a state, opens a dialog to pick the transition\n" +
 	"* `/jira info` - Display information about the current user and the Jira plug-in\n" +
 	"* `/jira help` - Launch the Jira plugin command line help syntax\n" +
 	"* `/jira view <issue-key>` - View the details of a specific Jira issue\n" +
+	"* `/jira search <JQL or text>` - Search Jira issues. `/jira search save <name> <JQL>` saves a search, to run it with `/jira search @<name>`\n" +
+	"* `/jira subscribe test <name> [--days <days>]` - Show what a subscription of this channel would have posted for the issues updated in the last days, 7 by default\n" +
+	"* `/jira subscribe timezone [timezone]` - Show or, as a team administrator, set the timezone of the digest schedules of this team's subscriptions, like `America/New_York`\n" +
+	"* `/jira watch` - Manage your personal subscriptions, delivered by DM\n" +
+	"* `/jira link <issue-key>` - In a reply to a thread, sync the thread with the comments of a Jira issue. `/jira unlink` stops it\n" +
+	"* `/jira api-key create <name>` - Create an API key for the automation API, to manage subscriptions from scripts. `/jira api-key list` and `/jira api-key revoke <id>` manage them\n" +
 	"* `/jira settings [setting] [value]` - Update your user settings\n" +
-	"  * [setting] can be `notifications`\n" +
-	"  * [value] can be `on` or `off`\n"
+	"  * [setting] can be `notifications`, `events`, `mute`, `unmute`, `quiet-hours` or `batch`. `/jira settings help` describes them\n" +
+	"* `--instance <jira-url>` - Add to any command to use the Jira instance at <jira-url>, when you are connected to several\n"
 
 const sysAdminHelpText = "\n###### For System Administrators:\n" +
 	"Install:\n" +
 	"* `/jira install cloud <URL>` - Connect Mattermost to a Jira Cloud instance located at <URL>\n" +
 	"* `/jira install server <URL>` - Connect Mattermost to a Jira Server or Data Center instance located at <URL>\n" +
+	"* `/jira install cloud-oauth <URL>` - Connect Mattermost to a Jira Cloud instance located at <URL> with an OAuth 2.0 app, instead of an Atlassian Connect app. Opens a dialog for the client ID and secret of the app\n" +
 	"Uninstall:\n" +
 	"* `/jira uninstall cloud <URL>` - Disconnect Mattermost from a Jira Cloud instance located at <URL>\n" +
 	"* `/jira uninstall server <URL>` - Disconnect Mattermost from a Jira Server or Data Center instance located at <URL>\n" +
+	"* `/jira uninstall cloud-oauth <URL>` - Disconnect Mattermost from a Jira Cloud OAuth instance located at <URL>\n" +
+	"* `/jira debug instance list` - List the installed Jira instances\n" +
+	"* `/jira debug instance select <number or URL>` - Make an installed Jira instance the default\n" +
+	"* `/jira debug instance delete <number or URL>` - Remove an installed Jira instance\n" +
 	"* `/jira stats` - Display usage statistics\n" +
-	"* `/jira webhook` -  Show the Mattermost webhook to receive JQL queries\n" +
+	"* `/jira webhook [jira-url]` -  Show the Mattermost webhook to receive JQL queries\n" +
+	"* `/jira webhook dead list` - List the webhook events that failed to process after all retries\n" +
+	"* `/jira webhook dead replay <id or all>` - Process failed webhook events again\n" +
+	"* `/jira webhook dead purge <id or all>` - Discard failed webhook events\n" +
 	"* `/jira subscribe` - Configure the Jira notifications sent to this channel\n" +
-	"* `/jira subscribe list` - Display all the the subscription rules setup across all the channels and teams on your Mattermost instance\n"
+	"* `/jira subscribe list [jira-url]` - Display all the the subscription rules setup across all the channels and teams on your Mattermost instance\n" +
+	"* `/jira subscribe export [json|yaml] [jira-url]` - Export all the channel subscriptions to a file, sent to you by direct message\n" +
+	"* `/jira subscribe import <link to a message with the file, or the file content>` - Add the channel subscriptions of an exported file, to the Jira instance they were exported from\n" +
+	"* `/jira template` - Customize the notification messages posted for each event type\n"
 
 // Available settings
 const (
 	settingsNotifications = "notifications"
+	settingsEvents        = "events"
+	settingsMute          = "mute"
+	settingsUnmute        = "unmute"
+	settingsQuietHours    = "quiet-hours"
+	settingsBatch         = "batch"
+	settingsHelp          = "help"
 )
 
 type CommandHandlerFunc func(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse
@@ -54,28 +82,55 @@ type CommandHandler struct {
 
 var jiraCommandHandler = CommandHandler{
 	handlers: map[string]CommandHandlerFunc{
-		"connect":            executeConnect,
-		"disconnect":         executeDisconnect,
-		"install/cloud":      executeInstallCloud,
-		"install/server":     executeInstallServer,
-		"view":               executeView,
-		"settings":           executeSettings,
-		"transition":         executeTransition,
-		"assign":             executeAssign,
-		"unassign":           executeUnassign,
-		"uninstall":          executeUninstall,
-		"webhook":            executeWebhookURL,
-		"stats":              executeStats,
-		"info":               executeInfo,
-		"help":               commandHelp,
-		"subscribe/list":     executeSubscribeList,
-		"debug/stats/reset":  executeDebugStatsReset,
-		"debug/stats/save":   executeDebugStatsSave,
-		"debug/stats/expvar": executeDebugStatsExpvar,
-		"debug/workflow":     executeDebugWorkflow,
-		// "debug/instance/list":   executeDebugInstanceList,
-		// "debug/instance/select": executeDebugInstanceSelect,
-		// "debug/instance/delete": executeDebugInstanceDelete,
+		"connect":               executeConnect,
+		"disconnect":            executeDisconnect,
+		"install/cloud":         executeInstallCloud,
+		"install/server":        executeInstallServer,
+		"install/cloud-oauth":   executeInstallCloudOAuth,
+		"view":                  executeView,
+		"create":                executeCreate,
+		"comment":               executeComment,
+		"search":                executeSearch,
+		"search/save":           executeSearchSave,
+		"search/list":           executeSearchList,
+		"search/delete":         executeSearchDelete,
+		"settings":              executeSettings,
+		"transition":            executeTransition,
+		"assign":                executeAssign,
+		"unassign":              executeUnassign,
+		"uninstall":             executeUninstall,
+		"webhook":               executeWebhookURL,
+		"webhook/dead/list":     executeWebhookDeadList,
+		"webhook/dead/replay":   executeWebhookDeadReplay,
+		"webhook/dead/purge":    executeWebhookDeadPurge,
+		"stats":                 executeStats,
+		"info":                  executeInfo,
+		"help":                  commandHelp,
+		"subscribe/list":        executeSubscribeList,
+		"subscribe/export":      executeSubscribeExport,
+		"subscribe/import":      executeSubscribeImport,
+		"subscribe/test":        executeSubscribeTest,
+		"subscribe/timezone":    executeSubscribeTimezone,
+		"api-key/create":        executeAPIKeyCreate,
+		"api-key/list":          executeAPIKeyList,
+		"api-key/revoke":        executeAPIKeyRevoke,
+		"watch":                 executeWatch,
+		"watch/list":            executeWatchList,
+		"watch/remove":          executeWatchRemove,
+		"link":                  executeLink,
+		"unlink":                executeUnlink,
+		"template":              executeTemplate,
+		"template/list":         executeTemplateList,
+		"template/set":          executeTemplateSet,
+		"template/unset":        executeTemplateUnset,
+		"template/preview":      executeTemplatePreview,
+		"debug/stats/reset":     executeDebugStatsReset,
+		"debug/stats/save":      executeDebugStatsSave,
+		"debug/stats/expvar":    executeDebugStatsExpvar,
+		"debug/workflow":        executeDebugWorkflow,
+		"debug/instance/list":   executeDebugInstanceList,
+		"debug/instance/select": executeDebugInstanceSelect,
+		"debug/instance/delete": executeDebugInstanceDelete,
 	},
 	defaultHandler: executeJiraDefault,
 }
@@ -120,7 +175,8 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, commandArgs *model.CommandArg
 	if err != nil {
 		return p.responsef(commandArgs, err.Error()), nil
 	}
-	args := strings.Fields(commandArgs.Command)
+	_, command := splitInstanceOption(commandArgs.Command)
+	args := strings.Fields(command)
 	if len(args) == 0 || args[0] != "/jira" {
 		return p.help(commandArgs), nil
 	}
@@ -128,14 +184,18 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, commandArgs *model.CommandArg
 }
 
 func executeDisconnect(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
-	if len(args) != 0 {
+	if len(args) > 1 {
 		return p.help(header)
 	}
+	instanceURL, err := instanceURLArg(p, header, args)
+	if err != nil {
+		return p.responsef(header, err.Error())
+	}
 
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	ji, err := p.loadUserInstance(header.UserId, instanceURL)
 	if err != nil {
-		p.errorf("executeDisconnect: failed to load current Jira instance: %v", err)
-		return p.responsef(header, "Failed to load current Jira instance. Please contact your system administrator.")
+		p.errorf("executeDisconnect: failed to load Jira instance: %v", err)
+		return p.responsef(header, "Failed to load Jira instance. Please contact your system administrator.")
 	}
 
 	jiraUser, err := p.userStore.LoadJIRAUser(ji, header.UserId)
@@ -148,16 +208,23 @@ func executeDisconnect(p *Plugin, c *plugin.Context, header *model.CommandArgs,
 		return p.responsef(header, "Could not complete the **disconnection** request. Error: %v", err)
 	}
 
-	return p.responsef(header, "You have successfully disconnected your Jira account (**%s**).", jiraUser.DisplayName)
+	return p.responsef(header, "You have successfully disconnected your Jira account (**%s**) from %s.", jiraUser.DisplayName, ji.GetURL())
 }
 
 func executeConnect(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
-	if len(args) != 0 {
+	if len(args) > 1 {
 		return p.help(header)
 	}
+	instanceURL, err := instanceURLArg(p, header, args)
+	if err != nil {
+		return p.responsef(header, err.Error())
+	}
 
-	instance, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	instance, err := p.loadInstance(instanceURL)
 	if err != nil {
+		if instanceURL != "" {
+			return p.responsef(header, "Jira instance %s is not installed. Please contact your system administrator.", instanceURL)
+		}
 		return p.responsef(header, "There is no Jira instance installed. Please contact your system administrator.")
 	}
 
@@ -166,15 +233,63 @@ func executeConnect(p *Plugin, c *plugin.Context, header *model.CommandArgs, arg
 		return p.responsef(header, "You already have a Jira account linked to your Mattermost account. Please use `/jira disconnect` to disconnect.")
 	}
 
-	return p.responsef(header, "[Click here to link your Jira account](%s%s)",
-		p.GetPluginURL(), routeUserConnect)
+	return p.responsef(header, "[Click here to link your Jira account](%s%s?%s)",
+		p.GetPluginURL(), routeUserConnect, url.Values{argInstanceURL: {instance.GetURL()}}.Encode())
 }
 
-func executeSettings(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+// instanceURLArg returns the normalized Jira instance URL from an optional
+// command argument or the --instance option, or "" if it was not specified.
+func instanceURLArg(p *Plugin, header *model.CommandArgs, args []string) (string, error) {
+	instanceURL, _ := splitInstanceOption(header.Command)
+	if len(args) > 0 {
+		instanceURL = args[0]
+	}
+	if instanceURL == "" {
+		return "", nil
+	}
+	return utils.NormalizeInstallURL(p.GetSiteURL(), instanceURL)
+}
+
+var reInstanceOption = regexp.MustCompile(`(^|\s)--instance(\s+|=)(\S+)`)
+
+// splitInstanceOption returns the value of the --instance option of a
+// command, which selects the Jira instance of any command, and the command
+// without it.
+func splitInstanceOption(command string) (string, string) {
+	m := reInstanceOption.FindStringSubmatchIndex(command)
+	if m == nil {
+		return "", command
+	}
+	return command[m[6]:m[7]], command[:m[0]] + command[m[1]:]
+}
+
+// loadCommandInstance returns the Jira instance selected with the --instance
+// option of a command, or else the instance of the user. On failure, it
+// returns the response to the command.
+func (p *Plugin) loadCommandInstance(header *model.CommandArgs) (Instance, *model.CommandResponse) {
+	instanceURL, err := instanceURLArg(p, header, nil)
 	if err != nil {
-		p.errorf("executeSettings: failed to load current Jira instance: %v", err)
-		return p.responsef(header, "Failed to load current Jira instance. Please contact your system administrator.")
+		return nil, p.responsef(header, err.Error())
+	}
+	ji, err := p.loadUserInstance(header.UserId, instanceURL)
+	if err == nil {
+		return ji, nil
+	}
+	if instanceURL != "" {
+		return nil, p.responsef(header, "Jira instance %s is not installed. Please contact your system administrator.", instanceURL)
+	}
+	if multiple, ok := err.(*multipleInstancesError); ok {
+		return nil, p.responsef(header, "You are connected to several Jira instances: %s. Please select one with `--instance <jira-url>`.",
+			strings.Join(multiple.URLs, ", "))
+	}
+	p.errorf("failed to load the Jira instance of user %s: %v", header.UserId, err)
+	return nil, p.responsef(header, "Failed to load current Jira instance. Please contact your system administrator.")
+}
+
+func executeSettings(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	ji, resp := p.loadCommandInstance(header)
+	if resp != nil {
+		return resp
 	}
 
 	mattermostUserId := header.UserId
@@ -190,6 +305,16 @@ func executeSettings(p *Plugin, c *plugin.Context, header *model.CommandArgs, ar
 	switch args[0] {
 	case settingsNotifications:
 		return p.settingsNotifications(header, ji, mattermostUserId, jiraUser, args)
+	case settingsEvents:
+		return p.settingsEvents(header, ji, mattermostUserId, jiraUser, args)
+	case settingsMute, settingsUnmute:
+		return p.settingsMute(header, ji, mattermostUserId, jiraUser, args)
+	case settingsQuietHours:
+		return p.settingsQuietHours(header, ji, mattermostUserId, jiraUser, args)
+	case settingsBatch:
+		return p.settingsBatch(header, ji, mattermostUserId, jiraUser, args)
+	case settingsHelp:
+		return p.responsef(header, settingsHelpText)
 	default:
 		return p.responsef(header, "Unknown setting.")
 	}
@@ -206,10 +331,9 @@ func executeView(p *Plugin, c *plugin.Context, header *model.CommandArgs, args .
 		return p.responsef(header, "Please specify an issue key in the form `/jira view <issue-key>`.")
 	}
 
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		p.errorf("executeView: failed to load current Jira instance: %v", err)
-		return p.responsef(header, "Failed to load current Jira instance. Please contact your system administrator.")
+	ji, resp := p.loadCommandInstance(header)
+	if resp != nil {
+		return resp
 	}
 
 	mattermostUserId := header.UserId
@@ -241,7 +365,7 @@ func executeDebugInstanceList(p *Plugin, c *plugin.Context, header *model.Comman
 		return p.responsef(header, "%v", err)
 	}
 	if !authorized {
-		return p.responsef(header, "`/jira list` can only be run by a system administrator.")
+		return p.responsef(header, "`/jira debug instance list` can only be run by a system administrator.")
 	}
 	if len(args) != 0 {
 		return p.help(header)
@@ -255,9 +379,9 @@ func executeDebugInstanceList(p *Plugin, c *plugin.Context, header *model.Comman
 		return p.responsef(header, "(none installed)\n")
 	}
 
-	current, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		return p.responsef(header, "Failed to load current Jira instance: %v", err)
+	currentURL := ""
+	if current, err := p.currentInstanceStore.LoadCurrentJIRAInstance(); err == nil {
+		currentURL = current.GetURL()
 	}
 
 	keys := []string{}
@@ -265,7 +389,7 @@ func executeDebugInstanceList(p *Plugin, c *plugin.Context, header *model.Comman
 		keys = append(keys, key)
 	}
 	sort.Strings(keys)
-	text := "Known Jira instances (selected instance is **bold**)\n\n| |URL|Type|\n|--|--|--|\n"
+	text := "Known Jira instances (default instance is **bold**)\n\n| |URL|Type|\n|--|--|--|\n"
 	for i, key := range keys {
 		ji, err := p.instanceStore.LoadJIRAInstance(key)
 		if err != nil {
@@ -282,7 +406,7 @@ func executeDebugInstanceList(p *Plugin, c *plugin.Context, header *model.Comman
 			details = ji.GetType()
 		}
 		format := "|%v|%s|%s|\n"
-		if key == current.GetURL() {
+		if key == currentURL {
 			format = "| **%v** | **%s** |%s|\n"
 		}
 		text += fmt.Sprintf(format, i+1, key, details)
@@ -299,7 +423,19 @@ func executeSubscribeList(p *Plugin, c *plugin.Context, header *model.CommandArg
 		return p.responsef(header, "`/jira subscribe list` can only be run by a system administrator.")
 	}
 
-	msg, err := p.listChannelSubscriptions(header.TeamId)
+	if len(args) > 1 {
+		return p.help(header)
+	}
+	instanceURL, err := instanceURLArg(p, header, args)
+	if err != nil {
+		return p.responsef(header, err.Error())
+	}
+	ji, err := p.loadInstance(instanceURL)
+	if err != nil {
+		return p.responsef(header, "Failed to load Jira instance: %v", err)
+	}
+
+	msg, err := p.listChannelSubscriptions(ji, header.TeamId)
 	if err != nil {
 		return p.responsef(header, "%v", err)
 	}
@@ -345,7 +481,7 @@ func executeInstallCloud(p *Plugin, c *plugin.Context, header *model.CommandArgs
 		return p.responsef(header, err.Error())
 	}
 
-	u, err := p.GetWebhookURL(header.TeamId, header.ChannelId)
+	u, err := p.GetWebhookURL(jiraURL, header.TeamId, header.ChannelId)
 	if err != nil {
 		return p.responsef(header, err.Error())
 	}
@@ -364,11 +500,12 @@ func executeInstallCloud(p *Plugin, c *plugin.Context, header *model.CommandArgs
 7. Click the "More Actions" (...) option of any message in the channel (available when you hover over a message).
 
 If you see an option to create a Jira issue, you're all set! If not, refer to our [documentation](https://mattermost.gitbook.io/plugin-jira) for troubleshooting help.
-Jira webhook URL: %s
+Jira webhook URL for the channel subscriptions: %s
+Jira webhook URL for this channel: %s
 `
 
 	// TODO What is the exact group membership in Jira required? Site-admins?
-	return p.responsef(header, addResponseFormat, jiraURL, jiraURL, p.GetPluginURL(), routeACJSON, u)
+	return p.responsef(header, addResponseFormat, jiraURL, jiraURL, p.GetPluginURL(), routeACJSON, p.GetSubscriptionsWebhookURL(jiraURL), u)
 }
 
 func executeInstallServer(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
@@ -394,7 +531,7 @@ func executeInstallServer(p *Plugin, c *plugin.Context, header *model.CommandArg
 		return p.responsef(header, "The Jira URL you provided looks like a Jira Cloud URL - install it with:\n```\n/jira install cloud %s\n```", jiraURL)
 	}
 
-	u, err := p.GetWebhookURL(header.TeamId, header.ChannelId)
+	u, err := p.GetWebhookURL(jiraURL, header.TeamId, header.ChannelId)
 	if err != nil {
 		return p.responsef(header, err.Error())
 	}
@@ -418,23 +555,110 @@ func executeInstallServer(p *Plugin, c *plugin.Context, header *model.CommandArg
 7. Click the "More Actions" (...) option of any message in the channel (available when you hover over a message).
 
 If you see an option to create a Jira issue, you're all set! If not, refer to our [documentation](https://mattermost.gitbook.io/plugin-jira) for troubleshooting help.
-Jira webhook URL: %s
+Jira webhook URL for the channel subscriptions: %s
+Jira webhook URL for this channel: %s
 `
 	ji := NewJIRAServerInstance(p, jiraURL)
 	err = p.instanceStore.StoreJIRAInstance(ji)
 	if err != nil {
 		return p.responsef(header, err.Error())
 	}
-	err = p.StoreCurrentJIRAInstanceAndNotify(ji)
-	if err != nil {
-		return p.responsef(header, err.Error())
+	// The first installed instance becomes the default, re-installing the
+	// default instance updates it
+	if current, loadErr := p.currentInstanceStore.LoadCurrentJIRAInstance(); loadErr != nil || current.GetURL() == ji.GetURL() {
+		err = p.StoreCurrentJIRAInstanceAndNotify(ji)
+		if err != nil {
+			return p.responsef(header, err.Error())
+		}
 	}
 
 	pkey, err := publicKeyString(p)
 	if err != nil {
 		return p.responsef(header, "Failed to load public key: %v", err)
 	}
-	return p.responsef(header, addResponseFormat, jiraURL, p.GetSiteURL(), ji.GetMattermostKey(), pkey, u)
+	return p.responsef(header, addResponseFormat, jiraURL, p.GetSiteURL(), ji.GetMattermostKey(), pkey, p.GetSubscriptionsWebhookURL(jiraURL), u)
+}
+
+func executeInstallCloudOAuth(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	authorized, err := authorizedSysAdmin(p, header.UserId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if !authorized {
+		return p.responsef(header, "`/jira install` can only be run by a system administrator.")
+	}
+	if len(args) != 1 {
+		return p.help(header)
+	}
+	jiraURL, err := utils.NormalizeInstallURL(p.GetSiteURL(), args[0])
+	if err != nil {
+		return p.responsef(header, err.Error())
+	}
+	if strings.Contains(jiraURL, "http:") {
+		jiraURL = strings.Replace(jiraURL, "http:", "https:", -1)
+		return p.responsef(header, "`/jira install cloud-oauth` requires a secure connection (HTTPS). Please run the following command:\n```\n/jira install cloud-oauth %s\n```", jiraURL)
+	}
+
+	// The client secret is asked in a dialog, so that it is not posted.
+	err = p.openInstallCloudOAuthDialog(jiraURL, header.TriggerId)
+	if err != nil {
+		return p.responsef(header, "Failed to open the installation dialog: %v", err)
+	}
+	return &model.CommandResponse{}
+}
+
+// installCloudOAuth installs the Jira Cloud instance at jiraURL with the
+// OAuth 2.0 app of clientId, and returns the instructions to finish the
+// configuration.
+func (p *Plugin) installCloudOAuth(jiraURL, clientId, clientSecret, teamId, channelId string) (string, error) {
+	ji := NewJIRACloudOAuthInstance(p, jiraURL, clientId, clientSecret)
+	// The cloud Id is found when a user connects if Jira doesn't tell it
+	var err error
+	ji.CloudId, err = fetchCloudId(jiraURL)
+	if err != nil {
+		p.API.LogWarn("Failed to find the Jira cloud Id", "instance", jiraURL, "error", err.Error())
+		if previous, loadErr := p.instanceStore.LoadJIRAInstance(jiraURL); loadErr == nil {
+			if previousOAuth, ok := previous.(*jiraCloudOAuthInstance); ok {
+				ji.CloudId = previousOAuth.CloudId
+			}
+		}
+	}
+	err = p.instanceStore.StoreJIRAInstance(ji)
+	if err != nil {
+		return "", err
+	}
+	// The first installed instance becomes the default, re-installing the
+	// default instance updates it
+	if current, loadErr := p.currentInstanceStore.LoadCurrentJIRAInstance(); loadErr != nil || current.GetURL() == ji.GetURL() {
+		err = p.StoreCurrentJIRAInstanceAndNotify(ji)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	u, err := p.GetWebhookURL(jiraURL, teamId, channelId)
+	if err != nil {
+		return "", err
+	}
+
+	const addResponseFormat = `` +
+		`Jira Cloud OAuth instance %s has been installed. To finish the configuration, check the app of the client ID in the [Atlassian developer console](%s):
+
+1. In **Authorization**, the **OAuth 2.0 (3LO)** callback URL must be %s%s
+2. In **Permissions**, the **Jira API** must be added, with the %s scopes.
+3. In **Distribution**, the app must be shared if the Jira users are not all developers of the app.
+4. Use the "/jira connect" command to connect your Mattermost account with your Jira account.
+
+Jira webhook URL for the channel subscriptions: %s
+Jira webhook URL for this channel: %s
+`
+	scopes := []string{}
+	for _, scope := range atlassianOAuthScopes {
+		if scope != "offline_access" {
+			scopes = append(scopes, "`"+scope+"`")
+		}
+	}
+	return fmt.Sprintf(addResponseFormat, jiraURL, ji.GetManageAppsURL(), p.GetPluginURL(), routeOAuth2Complete, strings.Join(scopes, ", "), p.GetSubscriptionsWebhookURL(jiraURL), u), nil
 }
 
 // executeUninstall will uninstall the jira instance if the url matches, and then update all connected clients
@@ -456,9 +680,9 @@ func executeUninstall(p *Plugin, c *plugin.Context, header *model.CommandArgs, a
 		return p.responsef(header, err.Error())
 	}
 
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	ji, err := p.instanceStore.LoadJIRAInstance(jiraURL)
 	if err != nil {
-		return p.responsef(header, "No current Jira instance to uninstall")
+		return p.responsef(header, "Jira instance %s is not installed. Please enter the URL correctly to confirm the uninstall command.", jiraURL)
 	}
 
 	var ok bool
@@ -467,16 +691,14 @@ func executeUninstall(p *Plugin, c *plugin.Context, header *model.CommandArgs, a
 		_, ok = ji.(*jiraCloudInstance)
 	case "server":
 		_, ok = ji.(*jiraServerInstance)
+	case JIRATypeCloudOAuth:
+		_, ok = ji.(*jiraCloudOAuthInstance)
 	default:
 		return p.help(header)
 	}
 
 	if !ok {
-		return p.responsef(header, fmt.Sprintf("The current Jira instance is not a %s instance", args[0]))
-	}
-
-	if jiraURL != ji.GetURL() {
-		return p.responsef(header, `You have entered an incorrect URL. The current Jira instance URL is %s. Please enter the URL correctly to confirm the uninstall command.`, ji.GetURL())
+		return p.responsef(header, fmt.Sprintf("Jira instance %s is not a %s instance", ji.GetURL(), args[0]))
 	}
 
 	err = p.instanceStore.DeleteJiraInstance(ji.GetURL())
@@ -484,17 +706,20 @@ func executeUninstall(p *Plugin, c *plugin.Context, header *model.CommandArgs, a
 		return p.responsef(header, "Failed to delete Jira instance "+ji.GetURL())
 	}
 
-	// Notify users we have uninstalled an instance
-	p.API.PublishWebSocketEvent(
-		wSEventInstanceStatus,
-		map[string]interface{}{
-			"instance_installed": false,
-			"instance_type":      "",
-		},
-		&model.WebsocketBroadcast{},
-	)
+	// Notify users we have uninstalled the last instance. If another instance
+	// took over as the default, the store has already notified them of it.
+	if _, err = p.currentInstanceStore.LoadCurrentJIRAInstance(); err != nil {
+		p.API.PublishWebSocketEvent(
+			wSEventInstanceStatus,
+			map[string]interface{}{
+				"instance_installed": false,
+				"instance_type":      "",
+			},
+			&model.WebsocketBroadcast{},
+		)
+	}
 
-	u, err := p.GetWebhookURL(header.TeamId, header.ChannelId)
+	u, err := p.GetWebhookURL(ji.GetURL(), header.TeamId, header.ChannelId)
 	if err != nil {
 		return p.responsef(header, err.Error())
 	}
@@ -512,7 +737,11 @@ func executeUnassign(p *Plugin, c *plugin.Context, header *model.CommandArgs, ar
 	}
 	issueKey := strings.ToUpper(args[0])
 
-	msg, err := p.unassignJiraIssue(header.UserId, issueKey)
+	ji, resp := p.loadCommandInstance(header)
+	if resp != nil {
+		return resp
+	}
+	msg, err := p.unassignJiraIssue(ji, header.UserId, issueKey)
 	if err != nil {
 		return p.responsef(header, "%v", err)
 	}
@@ -521,13 +750,20 @@ func executeUnassign(p *Plugin, c *plugin.Context, header *model.CommandArgs, ar
 }
 
 func executeAssign(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
-	if len(args) < 2 {
+	if len(args) < 1 {
 		return p.responsef(header, "Please specify an issue key and an assignee search string, in the form `/jira assign <issue-key> <assignee>`.")
 	}
 	issueKey := strings.ToUpper(args[0])
+	if len(args) == 1 {
+		return p.openIssueDialogFromCommand(header, issueDialogAssign, issueKey)
+	}
 	userSearch := strings.Join(args[1:], " ")
 
-	msg, err := p.assignJiraIssue(header.UserId, issueKey, userSearch)
+	ji, resp := p.loadCommandInstance(header)
+	if resp != nil {
+		return resp
+	}
+	msg, err := p.assignJiraIssue(ji, header.UserId, issueKey, userSearch)
 	if err != nil {
 		return p.responsef(header, "%v", err)
 	}
@@ -536,13 +772,41 @@ func executeAssign(p *Plugin, c *plugin.Context, header *model.CommandArgs, args
 }
 
 func executeTransition(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
-	if len(args) < 2 {
+	if len(args) < 1 {
 		return p.help(header)
 	}
 	issueKey := strings.ToUpper(args[0])
-	toState := strings.Join(args[1:], " ")
+	if len(args) == 1 {
+		return p.openIssueDialogFromCommand(header, issueDialogTransition, issueKey)
+	}
+
+	// The command is parsed again, the field values may be quoted.
+	_, text := splitInstanceOption(header.Command)
+	text = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), "/jira"))
+	text = strings.TrimPrefix(text, "transition")
+	transition, err := parseTransitionArgs(text)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if transition.ToState == "" {
+		return p.openIssueDialogFromCommand(header, issueDialogTransition, transition.IssueKey)
+	}
 
-	msg, err := p.transitionJiraIssue(header.UserId, issueKey, toState)
+	ji, resp := p.loadCommandInstance(header)
+	if resp != nil {
+		return resp
+	}
+	msg, err := p.transitionJiraIssue(ji, header.UserId, transition.IssueKey, transition.ToState, transition.Fields)
+	if fieldsErr, ok := err.(*transitionFieldsError); ok && header.TriggerId != "" {
+		// Ask for the missing fields, keeping the ones that were given.
+		jiraUser, loadErr := p.userStore.LoadJIRAUser(ji, header.UserId)
+		if loadErr == nil {
+			loadErr = p.openTransitionFieldsDialog(ji, jiraUser, header.TriggerId, fieldsErr, transition.Fields)
+		}
+		if loadErr == nil {
+			return &model.CommandResponse{}
+		}
+	}
 	if err != nil {
 		return p.responsef(header, "%v", err)
 	}
@@ -550,12 +814,23 @@ func executeTransition(p *Plugin, c *plugin.Context, header *model.CommandArgs,
 	return p.responsef(header, msg)
 }
 
+func executeComment(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) != 1 {
+		return p.responsef(header, "Please specify an issue key in the form `/jira comment <issue-key>`.")
+	}
+	return p.openIssueDialogFromCommand(header, issueDialogComment, strings.ToUpper(args[0]))
+}
+
 func executeInfo(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
 	if len(args) != 0 {
 		return p.help(header)
 	}
 
-	uinfo := getUserInfo(p, header.UserId)
+	instanceURL, err := instanceURLArg(p, header, nil)
+	if err != nil {
+		return p.responsef(header, err.Error())
+	}
+	uinfo := getUserInfo(p, header.UserId, instanceURL)
 
 	resp := fmt.Sprintf("Mattermost Jira plugin version: %s, "+
 		"[%s](https://github.com/mattermost/mattermost-plugin-jira/commit/%s), built %s\n",
@@ -576,6 +851,7 @@ func executeInfo(p *Plugin, c *plugin.Context, header *model.CommandArgs, args .
 	for k, v := range uinfo.InstanceDetails {
 		resp += fmt.Sprintf(" * %s: %s\n", k, v)
 	}
+	resp += fmt.Sprintf(" * Circuit breaker: %s\n", p.circuitBreakers.instanceStatus(uinfo.JIRAURL))
 
 	bullet := func(cond bool, k string, v interface{}) string {
 		if !cond {
@@ -641,6 +917,14 @@ func executeStatsImpl(p *Plugin, c *plugin.Context, commandArgs *model.CommandAr
 	resp := fmt.Sprintf("Mattermost Jira plugin version: %s, "+
 		"[%s](https://github.com/mattermost/mattermost-plugin-jira/commit/%s), built %s\n",
 		manifest.Version, BuildHashShort, BuildHash, BuildDate)
+	if p.webhookPool != nil {
+		queued, bufferSize, busy, maxProcs := p.webhookPool.status()
+		resp += fmt.Sprintf("Webhook queue on this server: %d/%d events, %d/%d workers busy\n",
+			queued, bufferSize, busy, maxProcs)
+	}
+	if circuits := p.circuitBreakers.statusText(); circuits != "" {
+		resp += "Jira circuit breakers on this server:\n" + circuits
+	}
 
 	pattern := strings.Join(args, " ")
 	print := expvar.PrintExpvars
@@ -709,15 +993,96 @@ func executeWebhookURL(p *Plugin, c *plugin.Context, header *model.CommandArgs,
 	if !authorized {
 		return p.responsef(header, "`/jira webhook` can only be run by a system administrator.")
 	}
+	if len(args) > 1 {
+		return p.help(header)
+	}
+	instanceURL, err := instanceURLArg(p, header, args)
+	if err != nil {
+		return p.responsef(header, err.Error())
+	}
+	ji, err := p.loadInstance(instanceURL)
+	if err != nil {
+		return p.responsef(header, "Failed to load Jira instance: %v", err)
+	}
+
+	u, err := p.GetWebhookURL(ji.GetURL(), header.TeamId, header.ChannelId)
+	if err != nil {
+		return p.responsef(header, err.Error())
+	}
+	return p.responsef(header, "Please use the following URLs to set up the Jira webhooks of %s:\n"+
+		"* For the channel subscriptions: %s\n"+
+		"* For this channel only: %s",
+		ji.GetURL(), p.GetSubscriptionsWebhookURL(ji.GetURL()), u)
+}
+
+func executeWebhookDeadList(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	authorized, err := authorizedSysAdmin(p, header.UserId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if !authorized {
+		return p.responsef(header, "`/jira webhook dead list` can only be run by a system administrator.")
+	}
 	if len(args) != 0 {
 		return p.help(header)
 	}
 
-	u, err := p.GetWebhookURL(header.TeamId, header.ChannelId)
+	msgs, err := p.webhookQueueStore.LoadDeadWebhookMessages()
 	if err != nil {
 		return p.responsef(header, err.Error())
 	}
-	return p.responsef(header, "Please use the following URL to set up a Jira webhook: %v", u)
+	if len(msgs) == 0 {
+		return p.responsef(header, "There are no failed webhook events.")
+	}
+
+	text := fmt.Sprintf("%d failed webhook event(s):\n", len(msgs))
+	text += "| ID | Received | Instance | Attempts | Last error |\n|--|--|--|--|--|\n"
+	for _, msg := range msgs {
+		text += fmt.Sprintf("|`%s`|%s|%s|%d|%s|\n", msg.Id, msg.Created.Format(time.RFC822),
+			msg.InstanceURL, msg.Attempts, strings.ReplaceAll(msg.LastError, "|", "\\|"))
+	}
+	return p.responsef(header, text)
+}
+
+func executeWebhookDeadReplay(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	return executeWebhookDeadImpl(p, header, "replay", "Replayed", p.replayDeadWebhookMessage, args...)
+}
+
+func executeWebhookDeadPurge(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	return executeWebhookDeadImpl(p, header, "purge", "Purged", p.webhookQueueStore.DeleteDeadWebhookMessage, args...)
+}
+
+func executeWebhookDeadImpl(p *Plugin, header *model.CommandArgs, command, done string, f func(id string) error, args ...string) *model.CommandResponse {
+	authorized, err := authorizedSysAdmin(p, header.UserId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if !authorized {
+		return p.responsef(header, "`/jira webhook dead %s` can only be run by a system administrator.", command)
+	}
+	if len(args) != 1 {
+		return p.help(header)
+	}
+
+	ids := []string{args[0]}
+	if args[0] == "all" {
+		msgs, err := p.webhookQueueStore.LoadDeadWebhookMessages()
+		if err != nil {
+			return p.responsef(header, err.Error())
+		}
+		ids = []string{}
+		for _, msg := range msgs {
+			ids = append(ids, msg.Id)
+		}
+	}
+
+	for i, id := range ids {
+		err = f(id)
+		if err != nil {
+			return p.responsef(header, "%s %d failed webhook event(s), then failed: %v", done, i, err)
+		}
+	}
+	return p.responsef(header, "%s %d failed webhook event(s).", done, len(ids))
 }
 
 func getCommand() *model.Command {
@@ -752,6 +1117,13 @@ func (p *Plugin) responseRedirect(redirectURL string) *model.CommandResponse {
 }
 
 func executeDebugInstanceSelect(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	authorized, err := authorizedSysAdmin(p, header.UserId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if !authorized {
+		return p.responsef(header, "`/jira debug instance select` can only be run by a system administrator.")
+	}
 	if len(args) != 1 {
 		return p.help(header)
 	}
@@ -763,7 +1135,7 @@ func executeDebugInstanceSelect(p *Plugin, c *plugin.Context, header *model.Comm
 			return p.responsef(header, "Failed to load known Jira instances: %v", err)
 		}
 		if num < 1 || int(num) > len(known) {
-			return p.responsef(header, "Wrong instance number %v, must be 1-%v\n", num, len(known)+1)
+			return p.responsef(header, "Wrong instance number %v, must be 1-%v\n", num, len(known))
 		}
 
 		keys := []string{}
@@ -783,10 +1155,17 @@ func executeDebugInstanceSelect(p *Plugin, c *plugin.Context, header *model.Comm
 		return p.responsef(header, err.Error())
 	}
 
-	return executeInfo(p, c, header)
+	return executeDebugInstanceList(p, c, header)
 }
 
 func executeDebugInstanceDelete(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	authorized, err := authorizedSysAdmin(p, header.UserId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if !authorized {
+		return p.responsef(header, "`/jira debug instance delete` can only be run by a system administrator.")
+	}
 	if len(args) != 1 {
 		return p.help(header)
 	}
@@ -803,7 +1182,7 @@ func executeDebugInstanceDelete(p *Plugin, c *plugin.Context, header *model.Comm
 	num, err := strconv.ParseUint(instanceKey, 10, 8)
 	if err == nil {
 		if num < 1 || int(num) > len(known) {
-			return p.responsef(header, "Wrong instance number %v, must be 1-%v\n", num, len(known)+1)
+			return p.responsef(header, "Wrong instance number %v, must be 1-%v\n", num, len(known))
 		}
 
 		keys := []string{}
@@ -820,9 +1199,6 @@ func executeDebugInstanceDelete(p *Plugin, c *plugin.Context, header *model.Comm
 		return p.responsef(header, "failed to delete Jira instance %s: %v", instanceKey, err)
 	}
 
-	// if that was our only instance, just respond with an empty list.
-	if len(known) == 1 {
-		return executeDebugInstanceList(p, c, header)
-	}
-	return executeDebugInstanceSelect(p, c, header, "1")
+	// The store picks a new default instance if the deleted one was it.
+	return executeDebugInstanceList(p, c, header)
 }
@@ -77,12 +77,12 @@ func TestPlugin_ExecuteCommand_Settings(t *testing.T) {
 		"no params, with notifications": {
 			commandArgs:                &model.CommandArgs{Command: "/jira settings", UserId: mockUserIDWithNotifications},
 			initializeEmptyUserStorage: false,
-			expectedMsg:                "Current settings:\n\tNotifications: on",
+			expectedMsg:                "Current settings:\n\tNotifications: on\n\tEvents: assigned on, mentioned on, commented on, status on\n\tMuted projects: none\n\tQuiet hours: off\n\tBatch: off",
 		},
 		"no params, without notifications": {
 			commandArgs:                &model.CommandArgs{Command: "/jira settings", UserId: mockUserIDWithoutNotifications},
 			initializeEmptyUserStorage: false,
-			expectedMsg:                "Current settings:\n\tNotifications: off",
+			expectedMsg:                "Current settings:\n\tNotifications: off\n\tEvents: assigned on, mentioned on, commented on, status on\n\tMuted projects: none\n\tQuiet hours: off\n\tBatch: off",
 		},
 		"unknown setting": {
 			commandArgs:                &model.CommandArgs{Command: "/jira settings test", UserId: mockUserIDWithoutNotifications},
@@ -109,6 +109,36 @@ func TestPlugin_ExecuteCommand_Settings(t *testing.T) {
 			initializeEmptyUserStorage: false,
 			expectedMsg:                "Settings updated. Notifications off.",
 		},
+		"disable an event": {
+			commandArgs:                &model.CommandArgs{Command: "/jira settings events mentioned off", UserId: mockUserIDWithNotifications},
+			initializeEmptyUserStorage: false,
+			expectedMsg:                "Settings updated. Notifications for mentioned off.",
+		},
+		"unknown event": {
+			commandArgs:                &model.CommandArgs{Command: "/jira settings events created off", UserId: mockUserIDWithNotifications},
+			initializeEmptyUserStorage: false,
+			expectedMsg:                "Unknown event \"created\". The events are: assigned, mentioned, commented, status.",
+		},
+		"mute a project": {
+			commandArgs:                &model.CommandArgs{Command: "/jira settings mute mm", UserId: mockUserIDWithNotifications},
+			initializeEmptyUserStorage: false,
+			expectedMsg:                "Settings updated. Notifications for MM muted.",
+		},
+		"unmute a project that is not muted": {
+			commandArgs:                &model.CommandArgs{Command: "/jira settings unmute MM", UserId: mockUserIDWithNotifications},
+			initializeEmptyUserStorage: false,
+			expectedMsg:                "MM is not muted.",
+		},
+		"batch": {
+			commandArgs:                &model.CommandArgs{Command: "/jira settings batch 15", UserId: mockUserIDWithNotifications},
+			initializeEmptyUserStorage: false,
+			expectedMsg:                "Settings updated. Notifications are sent together, at most once every 15 minutes.",
+		},
+		"batch with invalid minutes": {
+			commandArgs:                &model.CommandArgs{Command: "/jira settings batch 0", UserId: mockUserIDWithNotifications},
+			initializeEmptyUserStorage: false,
+			expectedMsg:                "The batch interval must be a number of minutes between 1 and 1440, or `off`.",
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -315,3 +345,18 @@ func TestPlugin_ExecuteCommand_Uninstall(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitInstanceOption(t *testing.T) {
+	for command, expected := range map[string][2]string{
+		"/jira view KEY-1":                          {"", "/jira view KEY-1"},
+		"/jira view KEY-1 --instance jira.some":     {"jira.some", "/jira view KEY-1"},
+		"/jira --instance=https://jira.some view K": {"https://jira.some", "/jira view K"},
+		"/jira view --instances K":                  {"", "/jira view --instances K"},
+	} {
+		t.Run(command, func(t *testing.T) {
+			instanceURL, rest := splitInstanceOption(command)
+			assert.Equal(t, expected[0], instanceURL)
+			assert.Equal(t, expected[1], rest)
+		})
+	}
+}
@@ -0,0 +1,447 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/markdown"
+)
+
+const (
+	prefixThreadLink   = "thread_link_"
+	prefixIssueThreads = "issue_threads_"
+	prefixCommentPost  = "comment_post_"
+	prefixPostComment  = "post_comment_"
+
+	// The Jira comment mirrored by a reply
+	PostPropJiraCommentId = "jira_comment_id"
+
+	syncedCommentMaxLength = 4000
+)
+
+// ThreadLink links a Mattermost thread to a Jira issue. Replies in the thread
+// are added as comments to the issue, and the comments on the issue are
+// posted as replies in the thread.
+type ThreadLink struct {
+	InstanceURL      string `json:"instance_url"`
+	IssueKey         string `json:"issue_key"`
+	ChannelId        string `json:"channel_id"`
+	RootId           string `json:"root_id"`
+	MattermostUserId string `json:"mattermost_user_id"`
+}
+
+// A Jira comment links to the post it was made of, as a permalink.
+var rePermalinkPostId = regexp.MustCompile(`/pl/([a-z0-9]{26})`)
+
+func threadLinkKey(rootId string) string {
+	return hashkey(prefixThreadLink, rootId)
+}
+
+func issueThreadsKey(instanceURL, issueKey string) string {
+	return hashkey(prefixIssueThreads, instanceURL+"/"+issueKey)
+}
+
+func commentPostKey(instanceURL, commentId, rootId string) string {
+	return hashkey(prefixCommentPost, instanceURL+"/"+commentId+"/"+rootId)
+}
+
+func postCommentKey(postId string) string {
+	return hashkey(prefixPostComment, postId)
+}
+
+func (p *Plugin) loadThreadLink(rootId string) (*ThreadLink, error) {
+	data, appErr := p.API.KVGet(threadLinkKey(rootId))
+	if appErr != nil {
+		return nil, errors.WithMessage(appErr, "failed to load thread link")
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	link := &ThreadLink{}
+	err := json.Unmarshal(data, link)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load thread link")
+	}
+	return link, nil
+}
+
+func (p *Plugin) linkThread(link ThreadLink) error {
+	data, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+	appErr := p.API.KVSet(threadLinkKey(link.RootId), data)
+	if appErr != nil {
+		return errors.WithMessage(appErr, "failed to store thread link")
+	}
+	return p.modifyIssueThreads(link.InstanceURL, link.IssueKey, func(rootIds StringSet) StringSet {
+		return rootIds.Add(link.RootId)
+	})
+}
+
+func (p *Plugin) unlinkThread(link ThreadLink) error {
+	appErr := p.API.KVDelete(threadLinkKey(link.RootId))
+	if appErr != nil {
+		return errors.WithMessage(appErr, "failed to delete thread link")
+	}
+	return p.modifyIssueThreads(link.InstanceURL, link.IssueKey, func(rootIds StringSet) StringSet {
+		return rootIds.Subtract(link.RootId)
+	})
+}
+
+func (p *Plugin) modifyIssueThreads(instanceURL, issueKey string, modify func(rootIds StringSet) StringSet) error {
+	return p.atomicModify(issueThreadsKey(instanceURL, issueKey), func(initialBytes []byte) ([]byte, error) {
+		rootIds := NewStringSet()
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &rootIds)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(modify(rootIds))
+	})
+}
+
+// loadIssueThreadLinks returns the threads linked to an issue.
+func (p *Plugin) loadIssueThreadLinks(instanceURL, issueKey string) ([]ThreadLink, error) {
+	data, appErr := p.API.KVGet(issueThreadsKey(instanceURL, issueKey))
+	if appErr != nil {
+		return nil, errors.WithMessage(appErr, "failed to load the threads linked to "+issueKey)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	rootIds := NewStringSet()
+	err := json.Unmarshal(data, &rootIds)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load the threads linked to "+issueKey)
+	}
+
+	links := []ThreadLink{}
+	for _, rootId := range rootIds.Elems() {
+		link, err := p.loadThreadLink(rootId)
+		if err != nil {
+			return nil, err
+		}
+		if link != nil {
+			links = append(links, *link)
+		}
+	}
+	return links, nil
+}
+
+func (p *Plugin) storeCommentPost(instanceURL, commentId, rootId, postId string) {
+	appErr := p.API.KVSet(commentPostKey(instanceURL, commentId, rootId), []byte(postId))
+	if appErr != nil {
+		p.errorf("Failed to store the post of comment %s, err: %v", commentId, appErr)
+	}
+	appErr = p.API.KVSet(postCommentKey(postId), []byte(commentId))
+	if appErr != nil {
+		p.errorf("Failed to store the comment of post %s, err: %v", postId, appErr)
+	}
+}
+
+// MessageHasBeenPosted adds the replies in linked threads as comments to the
+// linked issue.
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	if post.RootId == "" || post.UserId == p.getUserID() || post.IsSystemMessage() {
+		return
+	}
+	link, err := p.loadThreadLink(post.RootId)
+	if err != nil {
+		p.errorf("MessageHasBeenPosted: %v", err)
+		return
+	}
+	if link == nil {
+		return
+	}
+
+	err = p.syncPostToComment(link, post)
+	if err != nil {
+		p.errorf("Failed to add post %s as a comment to %s, err: %v", post.Id, link.IssueKey, err)
+		p.API.SendEphemeralPost(post.UserId, &model.Post{
+			UserId:    p.getUserID(),
+			ChannelId: post.ChannelId,
+			RootId:    post.RootId,
+			Message:   fmt.Sprintf("Your reply was not added as a comment to %s: %v", link.IssueKey, err),
+		})
+	}
+}
+
+// MessageHasBeenUpdated mirrors the edits of replies to their Jira comments.
+func (p *Plugin) MessageHasBeenUpdated(c *plugin.Context, newPost, oldPost *model.Post) {
+	if newPost.RootId == "" || newPost.UserId == p.getUserID() || newPost.Message == oldPost.Message {
+		return
+	}
+	commentId, appErr := p.API.KVGet(postCommentKey(newPost.Id))
+	if appErr != nil || len(commentId) == 0 {
+		return
+	}
+	link, err := p.loadThreadLink(newPost.RootId)
+	if err != nil || link == nil {
+		return
+	}
+
+	client, err := p.threadLinkClient(link, newPost.UserId)
+	if err != nil {
+		p.errorf("Failed to update the comment of post %s, err: %v", newPost.Id, err)
+		return
+	}
+	_, err = client.UpdateComment(link.IssueKey, &jira.Comment{
+		ID:   string(commentId),
+		Body: p.commentBody(newPost),
+	})
+	if err != nil {
+		p.errorf("Failed to update the comment of post %s, err: %v", newPost.Id, err)
+	}
+}
+
+func (p *Plugin) syncPostToComment(link *ThreadLink, post *model.Post) error {
+	ji, err := p.loadInstance(link.InstanceURL)
+	if err != nil {
+		return err
+	}
+	client, err := p.threadLinkClient(link, post.UserId)
+	if err != nil {
+		return err
+	}
+
+	body := p.commentBody(post)
+	added, err := client.AddComment(link.IssueKey, &jira.Comment{Body: body})
+	if err != nil {
+		return err
+	}
+	p.storeCommentPost(link.InstanceURL, added.ID, link.RootId, post.Id)
+
+	if len(post.FileIds) > 0 {
+		go addCommentAttachments(ji, client, post.UserId, link.IssueKey, added, body, post.FileIds)
+	}
+	return nil
+}
+
+func (p *Plugin) threadLinkClient(link *ThreadLink, mattermostUserId string) (Client, error) {
+	ji, err := p.loadInstance(link.InstanceURL)
+	if err != nil {
+		return nil, err
+	}
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, mattermostUserId)
+	if err != nil {
+		return nil, errors.New("your account is not connected to Jira, please type `/jira connect`")
+	}
+	return ji.GetClient(jiraUser)
+}
+
+func (p *Plugin) commentBody(post *model.Post) string {
+	permalink := fmt.Sprintf("%s/_redirect/pl/%s", p.GetSiteURL(), post.Id)
+	return fmt.Sprintf("%s\n\n_Posted in [Mattermost|%s]_", post.Message, permalink)
+}
+
+// isCommentFromThread returns true if a comment was made of a reply in the
+// thread, so that it is not posted back.
+func (p *Plugin) isCommentFromThread(body, rootId string) bool {
+	for _, m := range rePermalinkPostId.FindAllStringSubmatch(body, -1) {
+		post, appErr := p.API.GetPost(m[1])
+		if appErr == nil && post != nil && (post.RootId == rootId || post.Id == rootId) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncCommentToThreads posts, edits or removes the replies mirroring a
+// comment in the threads linked to its issue. The replies made by users are
+// not changed: their comments are mirrored from Mattermost. The comments
+// restricted to a role or a group are not mirrored, as the members of the
+// channel may not be allowed to see them.
+func (p *Plugin) syncCommentToThreads(ji Instance, wh *webhook) error {
+	jwh := wh.JiraWebhook
+	commentId := jwh.Comment.ID
+	events := wh.Events()
+	if ji == nil || commentId == "" || jwh.Issue.Key == "" || events.Intersection(commentEvents).Len() == 0 {
+		return nil
+	}
+
+	links, err := p.loadIssueThreadLinks(ji.GetURL(), jwh.Issue.Key)
+	if err != nil {
+		return err
+	}
+
+	restricted := jwh.Comment.Visibility.Type != "" || jwh.Comment.Visibility.Value != ""
+	botUserId := p.getUserID()
+	for _, link := range links {
+		key := commentPostKey(link.InstanceURL, commentId, link.RootId)
+		postIdBytes, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return appErr
+		}
+		postId := string(postIdBytes)
+
+		switch {
+		case events.ContainsAny(eventCreatedComment):
+			if restricted || postId != "" || p.isCommentFromThread(jwh.Comment.Body, link.RootId) {
+				continue
+			}
+			post := &model.Post{
+				UserId:    botUserId,
+				ChannelId: link.ChannelId,
+				RootId:    link.RootId,
+				ParentId:  link.RootId,
+				Message:   commentReplyMessage(ji, jwh, "**commented** in Jira"),
+			}
+			post.AddProp(PostPropJiraCommentId, commentId)
+			created, appErr := p.API.CreatePost(post)
+			if appErr != nil {
+				return appErr
+			}
+			p.storeCommentPost(link.InstanceURL, commentId, link.RootId, created.Id)
+
+		case events.ContainsAny(eventUpdatedComment) && !restricted:
+			if postId == "" {
+				continue
+			}
+			post, appErr := p.API.GetPost(postId)
+			if appErr != nil || post.UserId != botUserId {
+				continue
+			}
+			post.Message = commentReplyMessage(ji, jwh, "**edited a comment** in Jira")
+			_, appErr = p.API.UpdatePost(post)
+			if appErr != nil {
+				return appErr
+			}
+
+		case events.ContainsAny(eventDeletedComment, eventUpdatedComment):
+			// An edited comment that became restricted is removed too.
+			if postId == "" {
+				continue
+			}
+			post, appErr := p.API.GetPost(postId)
+			if appErr == nil && post.UserId == botUserId {
+				appErr = p.API.DeletePost(postId)
+				if appErr != nil {
+					return appErr
+				}
+			}
+			_ = p.API.KVDelete(key)
+			_ = p.API.KVDelete(postCommentKey(postId))
+		}
+	}
+	return nil
+}
+
+func commentReplyMessage(ji Instance, jwh *JiraWebhook, verb string) string {
+	author := jwh.Comment.UpdateAuthor
+	if author.DisplayName == "" {
+		author = jwh.Comment.Author
+	}
+	body := markdown.Truncate(markdown.FromJira(jwh.Comment.Body), syncedCommentMaxLength)
+	return replaceJiraAccountIds(ji, fmt.Sprintf("%s %s:\n%s", mdUser(&author), verb, body))
+}
+
+const linkHelpText = "###### Link a thread to a Jira issue:\n" +
+	"* `/jira link <issue-key>` - In a reply to a thread, link the thread to an issue. Replies are added as comments to the issue, and its comments are posted in the thread\n" +
+	"* `/jira unlink` - In a reply to a linked thread, stop syncing it with its issue\n"
+
+// checkThreadLinkPermission checks that the thread of a /jira link or
+// /jira unlink is in the channel of the command, and that the user can post
+// in it.
+func (p *Plugin) checkThreadLinkPermission(header *model.CommandArgs) error {
+	root, appErr := p.API.GetPost(header.RootId)
+	if appErr != nil || root.ChannelId != header.ChannelId {
+		return errors.New("The thread was not found in this channel.")
+	}
+	if !p.API.HasPermissionToChannel(header.UserId, header.ChannelId, model.PERMISSION_CREATE_POST) {
+		return errors.New("You don't have permission to post in this channel.")
+	}
+	return nil
+}
+
+func executeLink(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) != 1 || header.RootId == "" {
+		return p.responsef(header, linkHelpText)
+	}
+	if err := p.checkThreadLinkPermission(header); err != nil {
+		return p.responsef(header, "%v", err)
+	}
+
+	ji, resp := p.loadCommandInstance(header)
+	if resp != nil {
+		return resp
+	}
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, header.UserId)
+	if err != nil {
+		return p.responsef(header, "Your username is not connected to Jira. Please type `jira connect`. %v", err)
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	issue, err := client.GetIssue(strings.ToUpper(args[0]), nil)
+	if err != nil {
+		return p.responsef(header, "Failed to load issue %s: %v", args[0], err)
+	}
+
+	existing, err := p.loadThreadLink(header.RootId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if existing != nil {
+		return p.responsef(header, "This thread is already linked to %s. Use `/jira unlink` first.", existing.IssueKey)
+	}
+
+	err = p.linkThread(ThreadLink{
+		InstanceURL:      ji.GetURL(),
+		IssueKey:         issue.Key,
+		ChannelId:        header.ChannelId,
+		RootId:           header.RootId,
+		MattermostUserId: header.UserId,
+	})
+	if err != nil {
+		return p.responsef(header, "Failed to link the thread: %v", errors.Cause(err))
+	}
+
+	_, appErr := p.API.CreatePost(&model.Post{
+		UserId:    p.getUserID(),
+		ChannelId: header.ChannelId,
+		RootId:    header.RootId,
+		ParentId:  header.RootId,
+		Message: fmt.Sprintf("This thread is linked to [%s](%s/browse/%s). Replies are added as comments to the issue, and its comments are posted here.",
+			issue.Key, ji.GetURL(), issue.Key),
+	})
+	if appErr != nil {
+		p.errorf("Failed to post the thread link notice, err: %v", appErr)
+	}
+	return &model.CommandResponse{}
+}
+
+func executeUnlink(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) != 0 || header.RootId == "" {
+		return p.responsef(header, linkHelpText)
+	}
+	if err := p.checkThreadLinkPermission(header); err != nil {
+		return p.responsef(header, "%v", err)
+	}
+
+	link, err := p.loadThreadLink(header.RootId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if link == nil || link.ChannelId != header.ChannelId {
+		return p.responsef(header, "This thread is not linked to a Jira issue.")
+	}
+	err = p.unlinkThread(*link)
+	if err != nil {
+		return p.responsef(header, "Failed to unlink the thread: %v", errors.Cause(err))
+	}
+	return p.responsef(header, "This thread is no longer linked to %s.", link.IssueKey)
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+func TestSyncCommentToThreads(t *testing.T) {
+	const (
+		rootId    = "rootpostid0123456789012345"
+		replyId   = "replypostid012345678901234"
+		botUserId = "botUserId"
+	)
+	link := ThreadLink{
+		InstanceURL: mockCurrentInstanceURL,
+		IssueKey:    "TES-41",
+		ChannelId:   "channelId",
+		RootId:      rootId,
+	}
+	linkBytes, err := json.Marshal(link)
+	require.Nil(t, err)
+	rootIdsBytes, err := json.Marshal(NewStringSet(rootId))
+	require.Nil(t, err)
+	key := commentPostKey(mockCurrentInstanceURL, "10019", rootId)
+
+	for name, tc := range map[string]struct {
+		file            string
+		body            string
+		restricted      bool
+		existingPostId  string
+		existingPostBy  string
+		expectCreate    bool
+		expectUpdate    bool
+		expectDelete    bool
+		expectUnmapped  bool
+		commentFromPost *model.Post
+	}{
+		"created": {
+			file:         "webhook-cloud-comment-created.json",
+			expectCreate: true,
+		},
+		"created, restricted to a role": {
+			file:       "webhook-cloud-comment-created.json",
+			restricted: true,
+		},
+		"created, already posted": {
+			file:           "webhook-cloud-comment-created.json",
+			existingPostId: replyId,
+			existingPostBy: botUserId,
+		},
+		"created from a reply in the thread": {
+			file:            "webhook-cloud-comment-created.json",
+			body:            "From Mattermost\n\n_Posted in [Mattermost|https://mm.example.com/_redirect/pl/" + replyId + "]_",
+			commentFromPost: &model.Post{Id: replyId, RootId: rootId},
+		},
+		"updated": {
+			file:           "webhook-cloud-comment-updated.json",
+			existingPostId: replyId,
+			existingPostBy: botUserId,
+			expectUpdate:   true,
+		},
+		"updated, restricted to a role": {
+			file:           "webhook-cloud-comment-updated.json",
+			restricted:     true,
+			existingPostId: replyId,
+			existingPostBy: botUserId,
+			expectDelete:   true,
+			expectUnmapped: true,
+		},
+		"updated, reply made in Mattermost": {
+			file:           "webhook-cloud-comment-updated.json",
+			existingPostId: replyId,
+			existingPostBy: "userId",
+		},
+		"updated, not mirrored": {
+			file: "webhook-cloud-comment-updated.json",
+		},
+		"deleted": {
+			file:           "webhook-cloud-comment-deleted.json",
+			existingPostId: replyId,
+			existingPostBy: botUserId,
+			expectDelete:   true,
+			expectUnmapped: true,
+		},
+		"deleted, reply made in Mattermost": {
+			file:           "webhook-cloud-comment-deleted.json",
+			existingPostId: replyId,
+			existingPostBy: "userId",
+			expectUnmapped: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := getJiraTestData(tc.file)
+			require.Nil(t, err)
+			w, err := ParseWebhook(data)
+			require.Nil(t, err)
+			wh := w.(*webhook)
+			if tc.body != "" {
+				wh.JiraWebhook.Comment.Body = tc.body
+			}
+			if tc.restricted {
+				wh.JiraWebhook.Comment.Visibility = jira.CommentVisibility{Type: "role", Value: "Developers"}
+			}
+			wh.JiraWebhook.Comment.ID = "10019"
+			wh.JiraWebhook.Issue.Key = "TES-41"
+
+			api := &plugintest.API{}
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.updateConfig(func(conf *config) {
+				conf.botUserID = botUserId
+			})
+			p.currentInstanceStore = mockCurrentInstanceStore{p}
+			p.userStore = mockUserStore{}
+			ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+			require.Nil(t, err)
+
+			api.On("KVGet", issueThreadsKey(mockCurrentInstanceURL, "TES-41")).Return(rootIdsBytes, nil)
+			api.On("KVGet", threadLinkKey(rootId)).Return(linkBytes, nil)
+			var existing []byte
+			if tc.existingPostId != "" {
+				existing = []byte(tc.existingPostId)
+				api.On("GetPost", tc.existingPostId).Return(&model.Post{Id: tc.existingPostId, RootId: rootId, UserId: tc.existingPostBy}, nil)
+			}
+			api.On("KVGet", key).Return(existing, nil)
+			if tc.commentFromPost != nil {
+				api.On("GetPost", tc.commentFromPost.Id).Return(tc.commentFromPost, nil)
+			}
+			api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{Id: replyId}, nil)
+			api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{Id: replyId}, nil)
+			api.On("DeletePost", replyId).Return(nil)
+			api.On("KVSet", mock.Anything, mock.Anything).Return(nil)
+			api.On("KVDelete", mock.Anything).Return(nil)
+
+			err = p.syncCommentToThreads(ji, wh)
+			require.Nil(t, err)
+
+			if tc.expectCreate {
+				api.AssertCalled(t, "CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+					return post.RootId == rootId && post.ChannelId == "channelId" && post.UserId == botUserId &&
+						post.Message == "Test User **commented** in Jira:\nAdded a comment" &&
+						post.Props[PostPropJiraCommentId] == "10019"
+				}))
+				api.AssertCalled(t, "KVSet", key, []byte(replyId))
+				api.AssertCalled(t, "KVSet", postCommentKey(replyId), []byte("10019"))
+			} else {
+				api.AssertNotCalled(t, "CreatePost", mock.Anything)
+			}
+			if tc.expectUpdate {
+				api.AssertCalled(t, "UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+					return post.Id == replyId && post.Message == "Test User **edited a comment** in Jira:\nAdded a comment, then edited it"
+				}))
+			} else {
+				api.AssertNotCalled(t, "UpdatePost", mock.Anything)
+			}
+			if tc.expectDelete {
+				api.AssertCalled(t, "DeletePost", replyId)
+			} else {
+				api.AssertNotCalled(t, "DeletePost", mock.Anything)
+			}
+			if tc.expectUnmapped {
+				api.AssertCalled(t, "KVDelete", key)
+				api.AssertCalled(t, "KVDelete", postCommentKey(replyId))
+			}
+		})
+	}
+}
+
+func TestExecuteUnlink(t *testing.T) {
+	const rootId = "rootpostid0123456789012345"
+	link := ThreadLink{
+		InstanceURL: mockCurrentInstanceURL,
+		IssueKey:    "TES-41",
+		ChannelId:   "channelId",
+		RootId:      rootId,
+	}
+	linkBytes, err := json.Marshal(link)
+	require.Nil(t, err)
+
+	for name, tc := range map[string]struct {
+		rootChannelId  string
+		canPost        bool
+		expectUnlinked bool
+	}{
+		"unlinked": {
+			rootChannelId:  "channelId",
+			canPost:        true,
+			expectUnlinked: true,
+		},
+		"thread of another channel": {
+			rootChannelId: "otherChannelId",
+			canPost:       true,
+		},
+		"no permission": {
+			rootChannelId: "channelId",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			api.On("GetPost", rootId).Return(&model.Post{Id: rootId, ChannelId: tc.rootChannelId}, nil)
+			api.On("HasPermissionToChannel", "userId", "channelId", model.PERMISSION_CREATE_POST).Return(tc.canPost)
+			api.On("KVGet", threadLinkKey(rootId)).Return(linkBytes, nil)
+			api.On("KVGet", issueThreadsKey(mockCurrentInstanceURL, "TES-41")).Return([]byte(`["`+rootId+`"]`), nil)
+			api.On("KVDelete", threadLinkKey(rootId)).Return(nil)
+			api.On("KVCompareAndSet", issueThreadsKey(mockCurrentInstanceURL, "TES-41"), mock.Anything, []byte(`[]`)).Return(true, nil)
+			api.On("SendEphemeralPost", "userId", mock.Anything).Return(nil)
+
+			executeUnlink(p, nil, &model.CommandArgs{UserId: "userId", ChannelId: "channelId", RootId: rootId})
+			if tc.expectUnlinked {
+				api.AssertCalled(t, "KVDelete", threadLinkKey(rootId))
+			} else {
+				api.AssertNotCalled(t, "KVDelete", mock.Anything)
+			}
+		})
+	}
+}
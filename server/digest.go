@@ -0,0 +1,520 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	DigestHourly = "hourly"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+
+	prefixDigest       = "digest_"
+	keyDigestIndex     = "index_digest"
+	prefixTeamTimezone = "team_timezone_"
+
+	// How often the pending digests are checked, and sent if due.
+	DigestCheckInterval = time.Minute
+
+	// How long a server has to post a digest it claimed, before another
+	// server may post it.
+	DigestClaimTimeout = 5 * time.Minute
+
+	// Events past this count are only counted, to keep digests within a
+	// reasonable KV value and post size.
+	DigestMaxEvents = 500
+)
+
+var digestIndex = keyIndex{key: keyDigestIndex, prefix: prefixDigest}
+
+const (
+	digestKindCreated      = "created"
+	digestKindResolved     = "resolved"
+	digestKindTransitioned = "transitioned"
+	digestKindCommented    = "commented"
+	digestKindDeleted      = "deleted"
+	digestKindUpdated      = "updated"
+)
+
+var digestKinds = []string{
+	digestKindCreated,
+	digestKindResolved,
+	digestKindTransitioned,
+	digestKindCommented,
+	digestKindDeleted,
+	digestKindUpdated,
+}
+
+// SubscriptionDigest configures a subscription to buffer its events, and to
+// post a single summary on a schedule.
+type SubscriptionDigest struct {
+	// One of hourly, daily, or weekly.
+	Schedule string `json:"schedule"`
+
+	// HH:MM, for daily and weekly digests.
+	Time string `json:"time,omitempty"`
+
+	// Day of the week, for weekly digests.
+	Weekday string `json:"weekday,omitempty"`
+
+	// IANA timezone name. If empty, the timezone of the team of the channel,
+	// set with /jira subscribe timezone, or UTC.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// digest is the events buffered for a digest subscription, waiting to be
+// posted.
+type digest struct {
+	InstanceURL    string        `json:"instance_url"`
+	SubscriptionId string        `json:"subscription_id"`
+	ChannelId      string        `json:"channel_id"`
+	Since          time.Time     `json:"since"`
+	Events         []digestEvent `json:"events"`
+	Dropped        int           `json:"dropped,omitempty"`
+
+	// Set while a server posts the digest, until it may be posted by
+	// another server.
+	Sending time.Time `json:"sending,omitempty"`
+}
+
+type digestEvent struct {
+	IssueKey  string    `json:"issue_key"`
+	IssueURL  string    `json:"issue_url"`
+	IssueType string    `json:"issue_type"`
+	Summary   string    `json:"summary"`
+	Project   string    `json:"project"`
+	Status    string    `json:"status"`
+	Kind      string    `json:"kind"`
+	Time      time.Time `json:"time"`
+}
+
+func digestKey(instanceURL, subscriptionId string) string {
+	return hashkey(prefixDigest, instanceURL+"/"+subscriptionId)
+}
+
+func (d SubscriptionDigest) location() (*time.Location, error) {
+	if d.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(d.Timezone)
+}
+
+func (d SubscriptionDigest) clock() (int, int, error) {
+	t, err := time.Parse("15:04", d.Time)
+	if err != nil {
+		return 0, 0, errors.Errorf("time must be HH:MM, not %q", d.Time)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+func (d SubscriptionDigest) weekday() (time.Weekday, error) {
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		name := wd.String()
+		if strings.EqualFold(d.Weekday, name) || strings.EqualFold(d.Weekday, name[:3]) {
+			return wd, nil
+		}
+	}
+	return 0, errors.Errorf("weekday must be a day of the week, not %q", d.Weekday)
+}
+
+func (d SubscriptionDigest) validate() error {
+	switch d.Schedule {
+	case DigestHourly, DigestDaily, DigestWeekly:
+	default:
+		return errors.Errorf("schedule must be one of %s, %s, or %s", DigestHourly, DigestDaily, DigestWeekly)
+	}
+	_, err := d.Next(time.Now())
+	return err
+}
+
+// Next returns the first scheduled digest time after t.
+func (d SubscriptionDigest) Next(t time.Time) (time.Time, error) {
+	loc, err := d.location()
+	if err != nil {
+		return time.Time{}, errors.Errorf("unknown timezone %q", d.Timezone)
+	}
+	t = t.In(loc)
+	y, m, day := t.Date()
+
+	if d.Schedule == DigestHourly {
+		return time.Date(y, m, day, t.Hour(), 0, 0, 0, loc).Add(time.Hour), nil
+	}
+
+	hour, min, err := d.clock()
+	if err != nil {
+		return time.Time{}, err
+	}
+	step := 1
+	if d.Schedule == DigestWeekly {
+		wd, err := d.weekday()
+		if err != nil {
+			return time.Time{}, err
+		}
+		day += (int(wd) - int(t.Weekday()) + 7) % 7
+		step = 7
+	}
+	next := time.Date(y, m, day, hour, min, 0, 0, loc)
+	if !next.After(t) {
+		next = time.Date(y, m, day+step, hour, min, 0, 0, loc)
+	}
+	return next, nil
+}
+
+func newDigestEvent(wh *webhook) digestEvent {
+	jwh := wh.JiraWebhook
+	e := digestEvent{
+		IssueKey:  jwh.Issue.Key,
+		IssueType: jwh.mdIssueType(),
+		Summary:   jwh.mdIssueSummary(),
+		Project:   jwh.Issue.Fields.Project.Key,
+		Time:      time.Now(),
+		Kind:      digestKindUpdated,
+	}
+	if pos := strings.LastIndex(jwh.Issue.Self, "/rest/api"); pos >= 0 {
+		e.IssueURL = jwh.Issue.Self[:pos] + "/browse/" + jwh.Issue.Key
+	}
+	if jwh.Issue.Fields.Status != nil {
+		e.Status = jwh.Issue.Fields.Status.Name
+	}
+
+	events := wh.Events()
+	switch {
+	case events.ContainsAny(eventCreated):
+		e.Kind = digestKindCreated
+	case events.ContainsAny(eventUpdatedResolved):
+		e.Kind = digestKindResolved
+	case events.ContainsAny(eventUpdatedStatus, eventUpdatedReopened):
+		e.Kind = digestKindTransitioned
+	case events.ContainsAny(eventCreatedComment):
+		e.Kind = digestKindCommented
+	case events.ContainsAny(eventDeleted, eventDeletedUnresolved):
+		e.Kind = digestKindDeleted
+	}
+	return e
+}
+
+// addToDigest buffers wh for the next digest of sub.
+func (p *Plugin) addToDigest(ji Instance, sub ChannelSubscription, wh *webhook) error {
+	e := newDigestEvent(wh)
+	if e.IssueURL == "" {
+		e.IssueURL = ji.GetURL() + "/browse/" + e.IssueKey
+	}
+
+	err := p.atomicModify(digestKey(ji.GetURL(), sub.Id), func(initialBytes []byte) ([]byte, error) {
+		d := digest{
+			InstanceURL:    ji.GetURL(),
+			SubscriptionId: sub.Id,
+			ChannelId:      sub.ChannelId,
+			Since:          e.Time,
+		}
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &d)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(d.Events) < DigestMaxEvents {
+			d.Events = append(d.Events, e)
+		} else {
+			d.Dropped++
+		}
+		return json.Marshal(&d)
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "failed to add %s to the digest for subscription %q", e.IssueKey, sub.Id)
+	}
+	return p.addToKeyIndex(digestIndex, digestKey(ji.GetURL(), sub.Id))
+}
+
+// sendDueDigests posts the digests scheduled at or before now. Digests are
+// claimed atomically, so only one server posts each of them.
+func (p *Plugin) sendDueDigests(now time.Time) {
+	index, err := p.loadKeyIndex(digestIndex)
+	if err != nil {
+		p.errorf("Failed to list digests, err: %v", err)
+		return
+	}
+	for _, key := range index.Elems() {
+		err := p.sendDigestIfDue(key, now)
+		if err != nil {
+			p.errorf("Failed to send digest %s, err: %v", key, err)
+		}
+	}
+}
+
+func (p *Plugin) sendDigestIfDue(key string, now time.Time) error {
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return appErr
+	}
+	if data == nil {
+		return p.removeFromKeyIndex(digestIndex, key)
+	}
+	d := digest{}
+	err := json.Unmarshal(data, &d)
+	if err != nil {
+		return err
+	}
+
+	ji, err := p.loadInstance(d.InstanceURL)
+	if err != nil {
+		return err
+	}
+	subs, err := p.getSubscriptions(ji)
+	if err != nil {
+		return err
+	}
+	sub, ok := subs.Channel.ById[d.SubscriptionId]
+	if !ok {
+		// The subscription is gone, and so is its digest.
+		appErr = p.API.KVDelete(key)
+		if appErr != nil {
+			return appErr
+		}
+		return p.removeFromKeyIndex(digestIndex, key)
+	}
+
+	// A subscription switched back to immediate posting gets its pending
+	// digest right away.
+	if sub.Digest != nil {
+		if sub.Digest.Timezone == "" {
+			timezone, err := p.channelTimezone(sub.ChannelId)
+			if err != nil {
+				return err
+			}
+			withTimezone := *sub.Digest
+			withTimezone.Timezone = timezone
+			sub.Digest = &withTimezone
+		}
+		next, err := sub.Digest.Next(d.Since)
+		if err != nil {
+			return err
+		}
+		if next.After(now) {
+			return nil
+		}
+	}
+
+	// The digest is claimed while it is posted, and only cleared once it is
+	// posted, so that a failed post is retried.
+	var taken digest
+	claimed := false
+	err = p.atomicModify(key, func(initialBytes []byte) ([]byte, error) {
+		claimed = false
+		taken = digest{}
+		if initialBytes == nil {
+			return nil, errors.New("digest was deleted")
+		}
+		err := json.Unmarshal(initialBytes, &taken)
+		if err != nil {
+			return nil, err
+		}
+		if !taken.Since.Equal(d.Since) || taken.Sending.After(now) {
+			// Already sent, or being sent, by another server.
+			return initialBytes, nil
+		}
+		if len(taken.Events) == 0 && taken.Dropped == 0 {
+			// Nothing to post, start the next period.
+			taken.Since = now
+			return json.Marshal(&taken)
+		}
+		claimed = true
+		taken.Sending = now.Add(DigestClaimTimeout)
+		return json.Marshal(&taken)
+	})
+	if err != nil || !claimed {
+		return err
+	}
+
+	_, appErr = p.API.CreatePost(&model.Post{
+		UserId:    p.getUserID(),
+		ChannelId: taken.ChannelId,
+		Message:   renderDigest(&sub, &taken),
+	})
+
+	err = p.atomicModify(key, func(initialBytes []byte) ([]byte, error) {
+		if initialBytes == nil {
+			return nil, nil
+		}
+		current := digest{}
+		err := json.Unmarshal(initialBytes, &current)
+		if err != nil {
+			return nil, err
+		}
+		if !current.Since.Equal(taken.Since) {
+			return initialBytes, nil
+		}
+		current.Sending = time.Time{}
+		if appErr != nil {
+			return json.Marshal(&current)
+		}
+		// Keep the events added while posting, for the next digest.
+		if len(current.Events) > len(taken.Events) {
+			current.Events = current.Events[len(taken.Events):]
+		} else {
+			current.Events = nil
+		}
+		current.Dropped -= taken.Dropped
+		if current.Dropped < 0 {
+			current.Dropped = 0
+		}
+		current.Since = now
+		return json.Marshal(&current)
+	})
+	if appErr != nil {
+		return appErr
+	}
+	return err
+}
+
+// channelTimezone returns the digest timezone of the team of a channel, or
+// an empty string for UTC.
+func (p *Plugin) channelTimezone(channelId string) (string, error) {
+	channel, appErr := p.API.GetChannel(channelId)
+	if appErr != nil {
+		return "", errors.WithMessagef(appErr, "failed to load channel %q", channelId)
+	}
+	data, appErr := p.API.KVGet(prefixTeamTimezone + channel.TeamId)
+	if appErr != nil {
+		return "", errors.WithMessagef(appErr, "failed to load the timezone of team %q", channel.TeamId)
+	}
+	return string(data), nil
+}
+
+func executeSubscribeTimezone(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) > 1 {
+		return p.help(header)
+	}
+	if len(args) == 0 {
+		data, appErr := p.API.KVGet(prefixTeamTimezone + header.TeamId)
+		if appErr != nil {
+			return p.responsef(header, "Failed to load the timezone of the team: %v", appErr)
+		}
+		if len(data) == 0 {
+			return p.responsef(header, "The digests of this team are scheduled in UTC.")
+		}
+		return p.responsef(header, "The digests of this team are scheduled in %s.", string(data))
+	}
+
+	if !p.API.HasPermissionToTeam(header.UserId, header.TeamId, model.PERMISSION_MANAGE_TEAM) {
+		return p.responsef(header, "`/jira subscribe timezone` can only be changed by a team administrator.")
+	}
+	timezone := args[0]
+	if strings.EqualFold(timezone, "UTC") {
+		appErr := p.API.KVDelete(prefixTeamTimezone + header.TeamId)
+		if appErr != nil {
+			return p.responsef(header, "Failed to update the timezone of the team: %v", appErr)
+		}
+		return p.responsef(header, "The digests of this team are now scheduled in UTC.")
+	}
+	_, err := time.LoadLocation(timezone)
+	if err != nil {
+		return p.responsef(header, "Unknown timezone %q, use an IANA timezone name, like `America/New_York`.", timezone)
+	}
+	appErr := p.API.KVSet(prefixTeamTimezone+header.TeamId, []byte(timezone))
+	if appErr != nil {
+		return p.responsef(header, "Failed to update the timezone of the team: %v", appErr)
+	}
+	return p.responsef(header, "The digests of this team are now scheduled in %s.", timezone)
+}
+
+type digestIssue struct {
+	digestEvent
+	kinds StringSet
+}
+
+func renderDigest(sub *ChannelSubscription, d *digest) string {
+	loc := time.UTC
+	if sub.Digest != nil {
+		if l, err := sub.Digest.location(); err == nil {
+			loc = l
+		}
+	}
+
+	// The latest event of an issue determines its status.
+	issues := map[string]*digestIssue{}
+	kindCounts := map[string]int{}
+	for _, e := range d.Events {
+		kindCounts[e.Kind]++
+		issue := issues[e.IssueKey]
+		if issue == nil {
+			issue = &digestIssue{kinds: NewStringSet()}
+			issues[e.IssueKey] = issue
+		}
+		issue.digestEvent = e
+		issue.kinds = issue.kinds.Add(e.Kind)
+	}
+
+	// project -> status -> issues
+	grouped := map[string]map[string][]*digestIssue{}
+	for _, issue := range issues {
+		status := issue.Status
+		if status == "" {
+			status = "No status"
+		}
+		if grouped[issue.Project] == nil {
+			grouped[issue.Project] = map[string][]*digestIssue{}
+		}
+		grouped[issue.Project][status] = append(grouped[issue.Project][status], issue)
+	}
+
+	counts := []string{}
+	for _, kind := range digestKinds {
+		if kindCounts[kind] > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", kindCounts[kind], kind))
+		}
+	}
+
+	total := len(d.Events) + d.Dropped
+	text := fmt.Sprintf("#### Jira digest: %s\n", sub.Name)
+	text += fmt.Sprintf("%d event(s) for %d issue(s) since %s: %s.\n",
+		total, len(issues), d.Since.In(loc).Format("Mon Jan 2 15:04 MST"), strings.Join(counts, ", "))
+	if d.Dropped > 0 {
+		text += fmt.Sprintf("_%d more event(s) are not listed._\n", d.Dropped)
+	}
+
+	projects := []string{}
+	for project := range grouped {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+	for _, project := range projects {
+		text += fmt.Sprintf("\n##### %s\n", project)
+		byStatus := grouped[project]
+		statuses := []string{}
+		for status := range byStatus {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			list := byStatus[status]
+			sort.Slice(list, func(i, j int) bool {
+				return list[i].IssueKey < list[j].IssueKey
+			})
+			text += fmt.Sprintf("**%s** (%d)\n", status, len(list))
+			for _, issue := range list {
+				kinds := []string{}
+				for _, kind := range digestKinds {
+					if issue.kinds.ContainsAny(kind) {
+						kinds = append(kinds, kind)
+					}
+				}
+				text += fmt.Sprintf("* %s [%s](%s): %s _(%s)_\n",
+					issue.IssueType, issue.IssueKey, issue.IssueURL, issue.Summary, strings.Join(kinds, ", "))
+			}
+		}
+	}
+	return text
+}
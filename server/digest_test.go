@@ -0,0 +1,220 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+func TestSubscriptionDigestNext(t *testing.T) {
+	// Wednesday
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	for name, tc := range map[string]struct {
+		digest      SubscriptionDigest
+		expected    time.Time
+		expectedErr bool
+	}{
+		"hourly": {
+			digest:   SubscriptionDigest{Schedule: DigestHourly},
+			expected: time.Date(2020, 1, 15, 11, 0, 0, 0, time.UTC),
+		},
+		"daily, later today": {
+			digest:   SubscriptionDigest{Schedule: DigestDaily, Time: "17:00"},
+			expected: time.Date(2020, 1, 15, 17, 0, 0, 0, time.UTC),
+		},
+		"daily, tomorrow": {
+			digest:   SubscriptionDigest{Schedule: DigestDaily, Time: "09:00"},
+			expected: time.Date(2020, 1, 16, 9, 0, 0, 0, time.UTC),
+		},
+		"daily, exactly now": {
+			digest:   SubscriptionDigest{Schedule: DigestDaily, Time: "10:30"},
+			expected: time.Date(2020, 1, 16, 10, 30, 0, 0, time.UTC),
+		},
+		"daily, in a timezone": {
+			digest:   SubscriptionDigest{Schedule: DigestDaily, Time: "09:00", Timezone: "America/New_York"},
+			expected: time.Date(2020, 1, 15, 14, 0, 0, 0, time.UTC),
+		},
+		"weekly, later this week": {
+			digest:   SubscriptionDigest{Schedule: DigestWeekly, Time: "09:00", Weekday: "friday"},
+			expected: time.Date(2020, 1, 17, 9, 0, 0, 0, time.UTC),
+		},
+		"weekly, next week": {
+			digest:   SubscriptionDigest{Schedule: DigestWeekly, Time: "09:00", Weekday: "Mon"},
+			expected: time.Date(2020, 1, 20, 9, 0, 0, 0, time.UTC),
+		},
+		"weekly, today passed": {
+			digest:   SubscriptionDigest{Schedule: DigestWeekly, Time: "09:00", Weekday: "Wednesday"},
+			expected: time.Date(2020, 1, 22, 9, 0, 0, 0, time.UTC),
+		},
+		"bad time": {
+			digest:      SubscriptionDigest{Schedule: DigestDaily, Time: "9am"},
+			expectedErr: true,
+		},
+		"bad weekday": {
+			digest:      SubscriptionDigest{Schedule: DigestWeekly, Time: "09:00", Weekday: "someday"},
+			expectedErr: true,
+		},
+		"bad timezone": {
+			digest:      SubscriptionDigest{Schedule: DigestHourly, Timezone: "Mars/Olympus_Mons"},
+			expectedErr: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			next, err := tc.digest.Next(now)
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.True(t, tc.expected.Equal(next), "expected %v, got %v", tc.expected, next)
+		})
+	}
+
+	assert.Error(t, SubscriptionDigest{Schedule: "monthly"}.validate())
+	assert.Nil(t, SubscriptionDigest{Schedule: DigestHourly}.validate())
+}
+
+func TestRenderDigest(t *testing.T) {
+	sub := &ChannelSubscription{
+		Name:   "Busy project",
+		Digest: &SubscriptionDigest{Schedule: DigestDaily, Time: "09:00"},
+	}
+	d := &digest{
+		Since: time.Date(2020, 1, 15, 9, 0, 0, 0, time.UTC),
+		Events: []digestEvent{
+			{IssueKey: "TES-2", IssueURL: "https://jira.some/browse/TES-2", IssueType: "bug", Summary: "Second", Project: "TES", Status: "To Do", Kind: digestKindCreated},
+			{IssueKey: "TES-1", IssueURL: "https://jira.some/browse/TES-1", IssueType: "story", Summary: "First", Project: "TES", Status: "To Do", Kind: digestKindCreated},
+			{IssueKey: "TES-1", IssueURL: "https://jira.some/browse/TES-1", IssueType: "story", Summary: "First", Project: "TES", Status: "Done", Kind: digestKindTransitioned},
+			{IssueKey: "ABC-1", IssueURL: "https://jira.some/browse/ABC-1", IssueType: "task", Summary: "Other", Project: "ABC", Status: "Done", Kind: digestKindResolved},
+		},
+	}
+
+	expected := "#### Jira digest: Busy project\n" +
+		"4 event(s) for 3 issue(s) since Wed Jan 15 09:00 UTC: 2 created, 1 resolved, 1 transitioned.\n" +
+		"\n##### ABC\n" +
+		"**Done** (1)\n" +
+		"* task [ABC-1](https://jira.some/browse/ABC-1): Other _(resolved)_\n" +
+		"\n##### TES\n" +
+		"**Done** (1)\n" +
+		"* story [TES-1](https://jira.some/browse/TES-1): First _(created, transitioned)_\n" +
+		"**To Do** (1)\n" +
+		"* bug [TES-2](https://jira.some/browse/TES-2): Second _(created)_\n"
+	assert.Equal(t, expected, renderDigest(sub, d))
+}
+
+func TestSendDigestIfDue(t *testing.T) {
+	since := time.Date(2020, 1, 15, 9, 30, 0, 0, time.UTC)
+	sub := ChannelSubscription{
+		Id:        model.NewId(),
+		ChannelId: model.NewId(),
+		Name:      "Daily",
+		Digest:    &SubscriptionDigest{Schedule: DigestDaily, Time: "10:00"},
+	}
+	teamId := model.NewId()
+	subsBytes, err := json.Marshal(withExistingChannelSubscriptions([]ChannelSubscription{sub}))
+	require.Nil(t, err)
+	key := digestKey(mockCurrentInstanceURL, sub.Id)
+	digestBytes, err := json.Marshal(&digest{
+		InstanceURL:    mockCurrentInstanceURL,
+		SubscriptionId: sub.Id,
+		ChannelId:      sub.ChannelId,
+		Since:          since,
+		Events: []digestEvent{
+			{IssueKey: "TES-1", Project: "TES", Status: "To Do", Kind: digestKindCreated},
+		},
+	})
+	require.Nil(t, err)
+
+	for name, tc := range map[string]struct {
+		now          time.Time
+		teamTimezone string
+		postErr      *model.AppError
+		expectedPost bool
+	}{
+		"not due": {
+			now: since.Add(20 * time.Minute),
+		},
+		"due": {
+			now:          since.Add(40 * time.Minute),
+			expectedPost: true,
+		},
+		"not due in the timezone of the team": {
+			now:          since.Add(40 * time.Minute),
+			teamTimezone: "America/New_York",
+		},
+		"due in the timezone of the team": {
+			now:          since.Add(5*time.Hour + 40*time.Minute),
+			teamTimezone: "America/New_York",
+			expectedPost: true,
+		},
+		"post failed": {
+			now:          since.Add(40 * time.Minute),
+			postErr:      &model.AppError{Message: "failed"},
+			expectedPost: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.currentInstanceStore = mockCurrentInstanceStore{p}
+
+			kv := map[string][]byte{
+				keyWithMockInstance(JIRA_SUBSCRIPTIONS_KEY): subsBytes,
+				key: digestBytes,
+			}
+			if tc.teamTimezone != "" {
+				kv[prefixTeamTimezone+teamId] = []byte(tc.teamTimezone)
+			}
+			api.On("KVGet", mock.Anything).Return(func(key string) []byte {
+				return kv[key]
+			}, nil)
+			api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				kv[args.String(0)] = args.Get(2).([]byte)
+			}).Return(true, nil)
+			api.On("GetChannel", sub.ChannelId).Return(&model.Channel{Id: sub.ChannelId, TeamId: teamId}, nil)
+			api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+				return post.ChannelId == sub.ChannelId
+			})).Return(func(post *model.Post) *model.Post {
+				// The digest is claimed while it is posted.
+				d := digest{}
+				require.Nil(t, json.Unmarshal(kv[key], &d))
+				assert.True(t, d.Sending.After(tc.now))
+				return post
+			}, tc.postErr)
+
+			err := p.sendDigestIfDue(key, tc.now)
+			d := digest{}
+			require.Nil(t, json.Unmarshal(kv[key], &d))
+			assert.True(t, d.Sending.IsZero())
+			if !tc.expectedPost {
+				require.Nil(t, err)
+				api.AssertNotCalled(t, "CreatePost", mock.Anything)
+				assert.Equal(t, digestBytes, kv[key])
+				return
+			}
+			api.AssertCalled(t, "CreatePost", mock.Anything)
+			if tc.postErr != nil {
+				// The events are kept for the next attempt.
+				require.NotNil(t, err)
+				assert.True(t, d.Since.Equal(since))
+				assert.Len(t, d.Events, 1)
+				return
+			}
+			require.Nil(t, err)
+			assert.True(t, d.Since.Equal(tc.now))
+			assert.Len(t, d.Events, 0)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package expvar
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/circonus-labs/circonusllhist"
+)
+
+// The upper bounds of the buckets of the Prometheus histograms.
+var (
+	DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+	SizeBuckets     = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+// WritePrometheus writes the endpoints of stats in the Prometheus text
+// exposition format, as counters and histograms named with prefix, and
+// labeled by endpoint name.
+func (stats *Stats) WritePrometheus(w io.Writer, prefix string) error {
+	endpoints := []Endpoint{}
+	stats.Do(func(name string, e *Endpoint) {
+		ep := e.Get()
+		ep.Name = name
+		endpoints = append(endpoints, ep)
+	})
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Name < endpoints[j].Name
+	})
+
+	pw := &PrometheusWriter{w: w}
+	for _, counter := range []struct {
+		name, help string
+		value      func(e *Endpoint) int64
+	}{
+		{"requests_total", "The number of requests.", func(e *Endpoint) int64 { return e.Total }},
+		{"request_errors_total", "The number of requests that failed.", func(e *Endpoint) int64 { return e.Errors }},
+		{"requests_ignored_total", "The number of requests that were ignored.", func(e *Endpoint) int64 { return e.Ignored }},
+	} {
+		pw.Header(prefix+counter.name, "counter", counter.help)
+		for i := range endpoints {
+			pw.Sample(prefix+counter.name, endpointLabels(&endpoints[i]), float64(counter.value(&endpoints[i])))
+		}
+	}
+
+	for _, histogram := range []struct {
+		name, help string
+		scale      float64
+		buckets    []float64
+		value      func(e *Endpoint) *circonusllhist.Histogram
+	}{
+		{"request_duration_seconds", "The duration of the requests.", 1e-9, DurationBuckets,
+			func(e *Endpoint) *circonusllhist.Histogram { return e.Elapsed }},
+		{"request_size_bytes", "The size of the requests.", 1, SizeBuckets,
+			func(e *Endpoint) *circonusllhist.Histogram { return e.RequestSize }},
+		{"response_size_bytes", "The size of the responses.", 1, SizeBuckets,
+			func(e *Endpoint) *circonusllhist.Histogram { return e.ResponseSize }},
+	} {
+		pw.Header(prefix+histogram.name, "histogram", histogram.help)
+		for i := range endpoints {
+			pw.Histogram(prefix+histogram.name, endpointLabels(&endpoints[i]),
+				histogram.value(&endpoints[i]), histogram.scale, histogram.buckets)
+		}
+	}
+	return pw.err
+}
+
+func endpointLabels(e *Endpoint) [][2]string {
+	return [][2]string{{"endpoint", e.Name}}
+}
+
+// PrometheusWriter writes metrics in the Prometheus text exposition format.
+// The first error is kept, and returned by Err.
+type PrometheusWriter struct {
+	w   io.Writer
+	err error
+}
+
+func NewPrometheusWriter(w io.Writer) *PrometheusWriter {
+	return &PrometheusWriter{w: w}
+}
+
+func (pw *PrometheusWriter) Err() error {
+	return pw.err
+}
+
+func (pw *PrometheusWriter) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+// Header writes the HELP and TYPE lines of a metric.
+func (pw *PrometheusWriter) Header(name, metricType, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// Sample writes a value of a metric.
+func (pw *PrometheusWriter) Sample(name string, labels [][2]string, value float64) {
+	pw.printf("%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+// Histogram writes the buckets, sum and count of h, its values multiplied by
+// scale. The values in a bin of h are counted in the buckets above the lower
+// bound of the bin.
+func (pw *PrometheusWriter) Histogram(name string, labels [][2]string, h *circonusllhist.Histogram, scale float64, buckets []float64) {
+	counts := make([]int64, len(buckets))
+	var total int64
+	sum := 0.0
+	if h != nil {
+		for _, s := range h.DecStrings() {
+			found := decStringsRegexp.FindStringSubmatch(s)
+			if len(found) != 3 {
+				continue
+			}
+			value, err := strconv.ParseFloat(found[1], 64)
+			if err != nil {
+				continue
+			}
+			count, err := strconv.ParseInt(found[2], 10, 64)
+			if err != nil {
+				continue
+			}
+			value *= scale
+			for i, le := range buckets {
+				if value <= le {
+					counts[i] += count
+				}
+			}
+			total += count
+		}
+		sum = h.ApproxSum() * scale
+	}
+
+	for i, le := range buckets {
+		pw.Sample(name+"_bucket", append(labels[:len(labels):len(labels)], [2]string{"le", formatValue(le)}), float64(counts[i]))
+	}
+	pw.Sample(name+"_bucket", append(labels[:len(labels):len(labels)], [2]string{"le", "+Inf"}), float64(total))
+	pw.Sample(name+"_sum", labels, sum)
+	pw.Sample(name+"_count", labels, float64(total))
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := []string{}
+	for _, l := range labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l[0], labelValueReplacer.Replace(l[1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
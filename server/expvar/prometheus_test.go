@@ -0,0 +1,36 @@
+package expvar
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	stats := NewUnpublishedStats(nil)
+	stats.EnsureEndpoint("api/v2/\"quoted\"").Record(10, 2000, 20*time.Millisecond, false, false)
+	stats.EnsureEndpoint("api/v2/\"quoted\"").Record(10, 2000, 2*time.Second, true, false)
+	stats.EnsureEndpoint("webhook").Record(500, 0, 40*time.Millisecond, false, true)
+
+	out := &bytes.Buffer{}
+	err := stats.WritePrometheus(out, "test_")
+	require.NoError(t, err)
+	text := out.String()
+
+	require.Contains(t, text, "# TYPE test_requests_total counter\n")
+	require.Contains(t, text, `test_requests_total{endpoint="api/v2/\"quoted\""} 2`+"\n")
+	require.Contains(t, text, `test_request_errors_total{endpoint="api/v2/\"quoted\""} 1`+"\n")
+	require.Contains(t, text, `test_requests_ignored_total{endpoint="webhook"} 1`+"\n")
+
+	require.Contains(t, text, "# TYPE test_request_duration_seconds histogram\n")
+	require.Contains(t, text, `test_request_duration_seconds_bucket{endpoint="api/v2/\"quoted\"",le="0.01"} 0`+"\n")
+	require.Contains(t, text, `test_request_duration_seconds_bucket{endpoint="api/v2/\"quoted\"",le="0.025"} 1`+"\n")
+	require.Contains(t, text, `test_request_duration_seconds_bucket{endpoint="api/v2/\"quoted\"",le="2.5"} 2`+"\n")
+	require.Contains(t, text, `test_request_duration_seconds_bucket{endpoint="api/v2/\"quoted\"",le="+Inf"} 2`+"\n")
+	require.Contains(t, text, `test_request_duration_seconds_count{endpoint="api/v2/\"quoted\""} 2`+"\n")
+	require.Contains(t, text, `test_response_size_bytes_bucket{endpoint="webhook",le="100"} 1`+"\n")
+	require.Contains(t, text, `test_request_size_bytes_bucket{endpoint="webhook",le="100"} 0`+"\n")
+	require.Contains(t, text, `test_request_size_bytes_bucket{endpoint="webhook",le="1000"} 1`+"\n")
+}
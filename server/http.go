@@ -28,9 +28,21 @@ const (
 	routeAPIUserInfo               = "/api/v2/userinfo"
 	routeAPISubscribeWebhook       = "/api/v2/webhook"
 	routeAPISubscriptionsChannel   = "/api/v2/subscriptions/channel"
+	routeAPISubscriptionsExport    = "/api/v2/subscriptions/export"
+	routeAPISubscriptionsImport    = "/api/v2/subscriptions/import"
+	routeAPISubscriptionsPreview   = "/api/v2/subscriptions/preview"
 	routeAPISettingsInfo           = "/api/v2/settingsinfo"
 	routeAPIStats                  = "/api/v2/stats"
+	routeMetrics                   = "/metrics"
 	routeIssueTransition           = "/api/v2/transition"
+	routeAPIIssueDialog            = "/api/v2/issue-dialog"
+	routeAPIDialogComment          = "/api/v2/dialog/comment"
+	routeAPIDialogAssign           = "/api/v2/dialog/assign"
+	routeAPIDialogTransition       = "/api/v2/dialog/transition"
+	routeAPIDialogPriority         = "/api/v2/dialog/priority"
+	routeAPIDialogInstallOAuth     = "/api/v2/dialog/install-cloud-oauth"
+	routeAPISearchAction           = "/api/v2/search-action"
+	routeAPIPostAction             = "/api/v2/post-action"
 	routeACInstalled               = "/ac/installed"
 	routeACJSON                    = "/ac/atlassian-connect.json"
 	routeACUninstalled             = "/ac/uninstalled"
@@ -42,6 +54,7 @@ const (
 	routeIncomingWebhook           = "/webhook"
 	routeOAuth1Complete            = "/oauth1/complete.html"
 	routeOAuth1PublicKey           = "/oauth1/public_key.html" // TODO remove, debugging?
+	routeOAuth2Complete            = "/oauth2/complete"
 	routeUserStart                 = "/user/start"
 	routeUserConnect               = "/user/connect"
 	routeUserDisconnect            = "/user/disconnect"
@@ -62,17 +75,31 @@ func (p *Plugin) serveHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	switch r.URL.Path {
 	// Issue APIs
 	case routeAPICreateIssue:
-		return withInstance(p.currentInstanceStore, w, r, httpAPICreateIssue)
+		return withInstance(p, w, r, httpAPICreateIssue)
 	case routeAPIGetCreateIssueMetadata:
-		return withInstance(p.currentInstanceStore, w, r, httpAPIGetCreateIssueMetadataForProjects)
+		return withInstance(p, w, r, httpAPIGetCreateIssueMetadataForProjects)
 	case routeAPIGetJiraProjectMetadata:
-		return withInstance(p.currentInstanceStore, w, r, httpAPIGetJiraProjectMetadata)
+		return withInstance(p, w, r, httpAPIGetJiraProjectMetadata)
 	case routeAPIGetSearchIssues:
-		return withInstance(p.currentInstanceStore, w, r, httpAPIGetSearchIssues)
+		return withInstance(p, w, r, httpAPIGetSearchIssues)
 	case routeAPIAttachCommentToIssue:
-		return withInstance(p.currentInstanceStore, w, r, httpAPIAttachCommentToIssue)
+		return withInstance(p, w, r, httpAPIAttachCommentToIssue)
 	case routeIssueTransition:
-		return withInstance(p.currentInstanceStore, w, r, httpAPITransitionIssue)
+		return withInstance(p, w, r, httpAPITransitionIssue)
+	case routeAPIIssueDialog:
+		return withInstance(p, w, r, httpAPIOpenIssueDialog)
+	case routeAPIDialogComment:
+		return withInstance(p, w, r, httpAPIDialogComment)
+	case routeAPIDialogAssign:
+		return withInstance(p, w, r, httpAPIDialogAssign)
+	case routeAPIDialogTransition:
+		return withInstance(p, w, r, httpAPIDialogTransition)
+	case routeAPIDialogPriority:
+		return withInstance(p, w, r, httpAPIDialogPriority)
+	case routeAPISearchAction:
+		return withInstance(p, w, r, httpAPISearchAction)
+	case routeAPIPostAction:
+		return withInstance(p, w, r, httpAPIPostAction)
 
 	// User APIs
 	case routeAPIUserInfo:
@@ -83,6 +110,8 @@ func (p *Plugin) serveHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	// Stats
 	case routeAPIStats:
 		return httpAPIStats(p, w, r)
+	case routeMetrics:
+		return httpMetrics(p, w, r)
 
 	// Atlassian Connect application
 	case routeACInstalled:
@@ -107,19 +136,31 @@ func (p *Plugin) serveHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	// Oauth1 (Jira Server)
 	case routeOAuth1Complete:
 		return withServerInstance(p, w, r, httpOAuth1aComplete)
-	case routeUserDisconnect:
-		return withServerInstance(p, w, r, httpOAuth1aDisconnect)
 	case routeOAuth1PublicKey:
 		return httpOAuth1aPublicKey(p, w, r)
 
+	// OAuth2 (Jira Cloud OAuth)
+	case routeOAuth2Complete:
+		return httpOAuth2Complete(p, w, r)
+	case routeAPIDialogInstallOAuth:
+		return httpAPIInstallCloudOAuthDialog(p, w, r)
+
 	// User connect/disconnect links
+	case routeUserDisconnect:
+		return withInstance(p, w, r, httpUserDisconnect)
 	case routeUserConnect:
-		return withInstance(p.currentInstanceStore, w, r, httpUserConnect)
+		return withInstance(p, w, r, httpUserConnect)
 	case routeUserStart:
-		return withInstance(p.currentInstanceStore, w, r, httpUserStart)
+		return withInstance(p, w, r, httpUserStart)
 	// Firehose webhook setup for channel subscriptions
 	case routeAPISubscribeWebhook:
 		return httpSubscribeWebhook(p, w, r)
+	case routeAPISubscriptionsExport:
+		return httpAPISubscriptionsExport(p, w, r)
+	case routeAPISubscriptionsImport:
+		return httpAPISubscriptionsImport(p, w, r)
+	case routeAPISubscriptionsPreview:
+		return httpAPISubscriptionPreview(p, w, r)
 
 	// expvar
 	case "/debug/vars":
@@ -142,11 +183,15 @@ func (p *Plugin) serveHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	case routeWorkflowCreateIssue:
 		{
 			if c.SourcePluginId != "" {
-				return withInstance(p.currentInstanceStore, w, r, httpWorkflowCreateIssue)
+				return withInstance(p, w, r, httpWorkflowCreateIssue)
 			}
 		}
 	}
 
+	if strings.HasPrefix(r.URL.Path, routeAutomationAPI+"/") {
+		return httpAutomationAPI(p, w, r)
+	}
+
 	if strings.HasPrefix(r.URL.Path, routeAPISubscriptionsChannel) {
 		return httpChannelSubscriptions(p, w, r)
 	}
@@ -4,20 +4,28 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 )
 
 const (
-	JIRATypeCloud  = "cloud"
-	JIRATypeServer = "server"
+	JIRATypeCloud      = "cloud"
+	JIRATypeCloudOAuth = "cloud-oauth"
+	JIRATypeServer     = "server"
 )
 
 const prefixForInstance = true
 
 const wSEventInstanceStatus = "instance_status"
 
+// argInstanceURL is the query parameter used to select a specific Jira
+// instance in HTTP requests; the default instance is used when it is absent.
+const argInstanceURL = "instance_url"
+
 type Instance interface {
 	GetClient(jiraUser JIRAUser) (Client, error)
 	GetDisplayDetails() map[string]string
@@ -75,10 +83,80 @@ func (ji *JIRAInstance) Init(p *Plugin) {
 
 type withInstanceFunc func(ji Instance, w http.ResponseWriter, r *http.Request) (int, error)
 
-func withInstance(store CurrentInstanceStore, w http.ResponseWriter, r *http.Request, f withInstanceFunc) (int, error) {
-	ji, err := store.LoadCurrentJIRAInstance()
+func withInstance(p *Plugin, w http.ResponseWriter, r *http.Request, f withInstanceFunc) (int, error) {
+	ji, err := p.loadUserInstance(r.Header.Get("Mattermost-User-Id"), r.URL.Query().Get(argInstanceURL))
+	if _, ok := err.(*multipleInstancesError); ok {
+		return respondErr(w, http.StatusBadRequest, err)
+	}
 	if err != nil {
 		return respondErr(w, http.StatusInternalServerError, err)
 	}
 	return f(ji, w, r)
 }
+
+// loadInstance returns the Jira instance installed at instanceURL. An empty
+// instanceURL selects the default instance, i.e. the "current" instance
+// stored in CurrentInstanceStore.
+func (p *Plugin) loadInstance(instanceURL string) (Instance, error) {
+	current, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	if instanceURL == "" {
+		return current, err
+	}
+	if err == nil && current.GetURL() == instanceURL {
+		return current, nil
+	}
+	return p.instanceStore.LoadJIRAInstance(instanceURL)
+}
+
+// multipleInstancesError is returned when a user is connected to several Jira
+// instances, none of them the default one, and did not select one.
+type multipleInstancesError struct {
+	URLs []string
+}
+
+func (e *multipleInstancesError) Error() string {
+	return fmt.Sprintf("connected to several Jira instances, please select one of: %s", strings.Join(e.URLs, ", "))
+}
+
+// loadUserInstance returns the Jira instance to use for a Mattermost user. If
+// instanceURL is not specified, it is the default instance if the user is
+// connected to it, otherwise the instance the user is connected to. When the
+// user is connected to several other instances, the error is a
+// *multipleInstancesError.
+func (p *Plugin) loadUserInstance(mattermostUserId, instanceURL string) (Instance, error) {
+	if instanceURL != "" || mattermostUserId == "" {
+		return p.loadInstance(instanceURL)
+	}
+
+	urls, err := p.userStore.LoadUserInstances(mattermostUserId)
+	if err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return p.loadInstance("")
+	}
+
+	current, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	if err == nil && NewStringSet(urls...).ContainsAny(current.GetURL()) {
+		return current, nil
+	}
+	sort.Strings(urls)
+	var installed []Instance
+	for _, u := range urls {
+		ji, err := p.loadInstance(u)
+		if err == nil {
+			installed = append(installed, ji)
+		}
+	}
+	switch len(installed) {
+	case 0:
+		return p.loadInstance("")
+	case 1:
+		return installed[0], nil
+	}
+	e := &multipleInstancesError{}
+	for _, ji := range installed {
+		e.URLs = append(e.URLs, ji.GetURL())
+	}
+	return nil, e
+}
@@ -63,7 +63,13 @@ func NewJIRACloudInstance(p *Plugin, key string, installed bool, rawASC string,
 type withCloudInstanceFunc func(jci *jiraCloudInstance, w http.ResponseWriter, r *http.Request) (int, error)
 
 func withCloudInstance(p *Plugin, w http.ResponseWriter, r *http.Request, f withCloudInstanceFunc) (int, error) {
-	return withInstance(p.currentInstanceStore, w, r, func(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
+	// Atlassian Connect requests identify the Jira instance by the JWT issuer.
+	jci, err := p.loadCloudInstanceByJWT(r)
+	if err == nil {
+		return f(jci, w, r)
+	}
+
+	return withInstance(p, w, r, func(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
 		jci, ok := ji.(*jiraCloudInstance)
 		if !ok {
 			return respondErr(w, http.StatusBadRequest, errors.New("Must be a JIRA Cloud instance, is "+ji.GetType()))
@@ -72,6 +78,44 @@ func withCloudInstance(p *Plugin, w http.ResponseWriter, r *http.Request, f with
 	})
 }
 
+// loadCloudInstanceByJWT finds the installed Jira Cloud instance whose client
+// key matches the "iss" claim of the JWT in the request. The token is not
+// verified here, that is done with the instance's shared secret once found.
+func (p *Plugin) loadCloudInstanceByJWT(r *http.Request) (*jiraCloudInstance, error) {
+	tokenString := r.FormValue(argJiraJWT)
+	if tokenString == "" {
+		return nil, errors.New("no jwt in the request")
+	}
+	claims := jwt.MapClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(tokenString, claims)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse JWT")
+	}
+	clientKey, _ := claims["iss"].(string)
+	if clientKey == "" {
+		return nil, errors.New("no issuer in JWT")
+	}
+
+	known, err := p.instanceStore.LoadKnownJIRAInstances()
+	if err != nil {
+		return nil, err
+	}
+	for key, typ := range known {
+		if typ != JIRATypeCloud {
+			continue
+		}
+		ji, err := p.instanceStore.LoadJIRAInstance(key)
+		if err != nil {
+			continue
+		}
+		jci, ok := ji.(*jiraCloudInstance)
+		if ok && jci.AtlassianSecurityContext != nil && jci.AtlassianSecurityContext.ClientKey == clientKey {
+			return jci, nil
+		}
+	}
+	return nil, errors.Errorf("no Jira Cloud instance found for client key %s", clientKey)
+}
+
 func (jci jiraCloudInstance) GetMattermostKey() string {
 	return jci.AtlassianSecurityContext.Key
 }
@@ -127,7 +171,7 @@ func (jci jiraCloudInstance) GetClient(jiraUser JIRAUser) (Client, error) {
 	if err != nil {
 		return nil, errors.WithMessage(err, "failed to get Jira client for user "+jiraUser.DisplayName)
 	}
-	return newCloudClient(client), nil
+	return jci.GetPlugin().clientCache.wrap(jci.GetURL(), jiraUser, newCloudClient(client)), nil
 }
 
 // Creates a client for acting on behalf of a user
@@ -152,6 +196,7 @@ func (jci jiraCloudInstance) getJIRAClientForUser(jiraUser JIRAUser) (*jira.Clie
 		utils.WithResponseSizeLimit(conf.maxAttachmentSize))
 	httpClient = expvar.WrapHTTPClient(httpClient,
 		conf.stats, endpointNameFromRequest)
+	httpClient = jci.GetPlugin().wrapJiraHTTPClient(jci.GetURL(), httpClient)
 
 	jiraClient, err := jira.NewClient(httpClient, oauth2Conf.BaseURL)
 	return jiraClient, httpClient, err
@@ -173,6 +218,7 @@ func (jci jiraCloudInstance) getJIRAClientForBot() (*jira.Client, error) {
 		utils.WithResponseSizeLimit(conf.maxAttachmentSize))
 	httpClient = expvar.WrapHTTPClient(httpClient,
 		conf.stats, endpointNameFromRequest)
+	httpClient = jci.GetPlugin().wrapJiraHTTPClient(jci.GetURL(), httpClient)
 
 	return jira.NewClient(httpClient, jwtConf.BaseURL)
 }
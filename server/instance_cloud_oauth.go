@@ -0,0 +1,428 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/expvar"
+	"github.com/mattermost/mattermost-plugin-jira/server/utils"
+)
+
+// The Atlassian OAuth 2.0 (3LO) endpoints, variables so that the tests can
+// replace them.
+var (
+	atlassianOAuthEndpoint = oauth2.Endpoint{
+		AuthURL:   "https://auth.atlassian.com/authorize",
+		TokenURL:  "https://auth.atlassian.com/oauth/token",
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+	atlassianAPIURL = "https://api.atlassian.com"
+)
+
+// atlassianHTTPClient makes the requests to the Atlassian OAuth and API
+// endpoints that aren't made by a Jira client.
+var atlassianHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// atlassianContext is the context of the OAuth 2.0 requests, so that they
+// use atlassianHTTPClient.
+func atlassianContext() context.Context {
+	return context.WithValue(context.Background(), oauth2.HTTPClient, atlassianHTTPClient)
+}
+
+const (
+	prefixTokenRefreshLock = "token_refresh_"
+
+	// How long a server may take to refresh an access token, and how long
+	// the other servers wait for it.
+	tokenRefreshTimeout = 30 * time.Second
+
+	tokenRefreshPollInterval = 200 * time.Millisecond
+)
+
+// The scopes the users grant to the plugin. offline_access gets a refresh
+// token.
+var atlassianOAuthScopes = []string{
+	"read:jira-user",
+	"read:jira-work",
+	"write:jira-work",
+	"offline_access",
+}
+
+// jiraCloudOAuthInstance is a Jira Cloud instance the users connect to with
+// OAuth 2.0 (3LO), through an app created in the Atlassian developer
+// console, rather than with an Atlassian Connect app.
+type jiraCloudOAuthInstance struct {
+	*JIRAInstance
+
+	JiraBaseURL string
+
+	// The Id of the Jira site in the Atlassian API, found when the first
+	// user connects if it wasn't at install.
+	CloudId string
+
+	OAuthClientId     string
+	OAuthClientSecret string
+
+	// The SiteURL may change as we go, so we store the PluginKey when as it was installed
+	MattermostKey string
+}
+
+var _ Instance = (*jiraCloudOAuthInstance)(nil)
+
+func NewJIRACloudOAuthInstance(p *Plugin, jiraURL, clientId, clientSecret string) *jiraCloudOAuthInstance {
+	return &jiraCloudOAuthInstance{
+		JIRAInstance:      NewJIRAInstance(p, JIRATypeCloudOAuth, jiraURL),
+		JiraBaseURL:       jiraURL,
+		OAuthClientId:     clientId,
+		OAuthClientSecret: clientSecret,
+		MattermostKey:     p.GetPluginKey(),
+	}
+}
+
+func (jci jiraCloudOAuthInstance) GetURL() string {
+	return jci.JiraBaseURL
+}
+
+func (jci jiraCloudOAuthInstance) GetManageAppsURL() string {
+	return "https://developer.atlassian.com/console/myapps/"
+}
+
+func (jci jiraCloudOAuthInstance) GetMattermostKey() string {
+	return jci.MattermostKey
+}
+
+func (jci jiraCloudOAuthInstance) GetDisplayDetails() map[string]string {
+	cloudId := jci.CloudId
+	if cloudId == "" {
+		cloudId = "Not known until a user connects"
+	}
+	return map[string]string{
+		"Jira Cloud OAuth Client ID": jci.OAuthClientId,
+		"Jira Cloud ID":              cloudId,
+	}
+}
+
+func (jci jiraCloudOAuthInstance) getOAuth2Config() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     jci.OAuthClientId,
+		ClientSecret: jci.OAuthClientSecret,
+		Endpoint:     atlassianOAuthEndpoint,
+		RedirectURL:  jci.GetPluginURL() + routeOAuth2Complete,
+		Scopes:       atlassianOAuthScopes,
+	}
+}
+
+func (jci jiraCloudOAuthInstance) GetUserConnectURL(mattermostUserId string) (string, error) {
+	// The state identifies the user, and the instance, when Atlassian
+	// redirects back.
+	state := model.NewId() + model.NewId()
+	err := jci.Plugin.otsStore.StoreOneTimeSecret(state, mattermostUserId+" "+jci.GetURL())
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to get a connect link")
+	}
+
+	return jci.getOAuth2Config().AuthCodeURL(state,
+		oauth2.SetAuthURLParam("audience", "api.atlassian.com"),
+		oauth2.SetAuthURLParam("prompt", "consent")), nil
+}
+
+func (jci jiraCloudOAuthInstance) GetClient(jiraUser JIRAUser) (client Client, returnErr error) {
+	defer func() {
+		if returnErr == nil {
+			return
+		}
+		returnErr = errors.WithMessage(returnErr, "failed to get a Jira client for "+jiraUser.DisplayName)
+	}()
+
+	if jiraUser.OAuth2Token == nil {
+		return nil, errors.New("No access token, please use /jira connect")
+	}
+	if jci.CloudId == "" {
+		return nil, errors.New("the Jira Cloud ID of " + jci.GetURL() + " is not known, please use /jira connect")
+	}
+
+	conf := jci.GetPlugin().getConfig()
+	httpClient := &http.Client{
+		Transport: &oauth2.Transport{
+			Source: &userTokenSource{
+				jci:      &jci,
+				jiraUser: jiraUser,
+				token:    jiraUser.OAuth2Token,
+			},
+		},
+	}
+	httpClient = utils.WrapHTTPClient(httpClient,
+		utils.WithRequestSizeLimit(conf.maxAttachmentSize),
+		utils.WithResponseSizeLimit(conf.maxAttachmentSize))
+	httpClient = expvar.WrapHTTPClient(httpClient,
+		conf.stats, endpointNameFromRequest)
+	httpClient = jci.GetPlugin().wrapJiraHTTPClient(jci.GetURL(), httpClient)
+
+	jiraClient, err := jira.NewClient(httpClient, jci.apiURL())
+	if err != nil {
+		return nil, err
+	}
+
+	return jci.GetPlugin().clientCache.wrap(jci.GetURL(), jiraUser, newCloudClient(jiraClient)), nil
+}
+
+// apiURL is the base URL of the Jira REST API of the instance, for the
+// OAuth 2.0 access tokens.
+func (jci jiraCloudOAuthInstance) apiURL() string {
+	return atlassianAPIURL + "/ex/jira/" + jci.CloudId + "/"
+}
+
+// userTokenSource returns the access token of a user, refreshing it when it
+// expires, and storing the refreshed token.
+type userTokenSource struct {
+	jci      *jiraCloudOAuthInstance
+	jiraUser JIRAUser
+
+	lock  sync.Mutex
+	token *oauth2.Token
+}
+
+func (s *userTokenSource) Token() (*oauth2.Token, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.token.Valid() {
+		return s.token, nil
+	}
+
+	// The refreshes of the token of a user are serialized across the
+	// cluster, so that a rotated refresh token is only used once. Another
+	// client may have refreshed the token already, and the refresh token it
+	// used is no longer valid.
+	p := s.jci.GetPlugin()
+	mattermostUserId, err := p.userStore.LoadMattermostUserId(s.jci, s.jiraUser.Key())
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to refresh the Jira access token")
+	}
+	lockKey := hashkey(prefixTokenRefreshLock, s.jci.GetURL()+"/"+mattermostUserId)
+	deadline := time.Now().Add(tokenRefreshTimeout)
+	var claim []byte
+	for claim == nil {
+		jiraUser, err := p.userStore.LoadJIRAUser(s.jci, mattermostUserId)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to refresh the Jira access token")
+		}
+		if jiraUser.OAuth2Token.Valid() {
+			s.token = jiraUser.OAuth2Token
+			return s.token, nil
+		}
+		claim, err = p.claimKey(lockKey, tokenRefreshTimeout)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to refresh the Jira access token")
+		}
+		if claim == nil {
+			if time.Now().After(deadline) {
+				return nil, errors.New("timed out waiting for the Jira access token to be refreshed")
+			}
+			time.Sleep(tokenRefreshPollInterval)
+		}
+	}
+	defer func() {
+		if err := p.releaseKey(lockKey, claim); err != nil {
+			p.errorf("Failed to release the refresh of the Jira access token of user %s, err: %v", mattermostUserId, err)
+		}
+	}()
+
+	// The token may have been refreshed before the claim.
+	jiraUser, err := p.userStore.LoadJIRAUser(s.jci, mattermostUserId)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to refresh the Jira access token")
+	}
+	if jiraUser.OAuth2Token.Valid() {
+		s.token = jiraUser.OAuth2Token
+		return s.token, nil
+	}
+	if jiraUser.OAuth2Token == nil || jiraUser.OAuth2Token.RefreshToken == "" {
+		return nil, errors.New("the Jira access token expired, please use /jira connect")
+	}
+
+	token, err := s.jci.getOAuth2Config().TokenSource(atlassianContext(), jiraUser.OAuth2Token).Token()
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to refresh the Jira access token, please use /jira connect")
+	}
+	jiraUser.OAuth2Token = token
+	err = p.userStore.StoreUserInfo(s.jci, mattermostUserId, jiraUser)
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return s.token, nil
+}
+
+// accessibleResource is a Jira site a user granted the plugin access to.
+type accessibleResource struct {
+	Id   string `json:"id"`
+	URL  string `json:"url"`
+	Name string `json:"name"`
+}
+
+// findCloudId returns the cloud Id of the instance, among the sites token
+// grants access to.
+func (jci jiraCloudOAuthInstance) findCloudId(token *oauth2.Token) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, atlassianAPIURL+"/oauth/token/accessible-resources", nil)
+	if err != nil {
+		return "", err
+	}
+	token.SetAuthHeader(req)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := atlassianHTTPClient.Do(req)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to list the Jira sites")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to list the Jira sites")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("failed to list the Jira sites: %s", resp.Status)
+	}
+	resources := []accessibleResource{}
+	err = json.Unmarshal(body, &resources)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to list the Jira sites")
+	}
+
+	for _, resource := range resources {
+		if strings.TrimSuffix(resource.URL, "/") == strings.TrimSuffix(jci.GetURL(), "/") {
+			return resource.Id, nil
+		}
+	}
+	return "", errors.Errorf("access to %s was not granted, please select it when connecting", jci.GetURL())
+}
+
+// fetchCloudId reads the cloud Id of a Jira Cloud site from its tenant info,
+// which doesn't require authentication.
+func fetchCloudId(jiraURL string) (string, error) {
+	resp, err := atlassianHTTPClient.Get(strings.TrimSuffix(jiraURL, "/") + "/_edge/tenant_info")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("failed to read the tenant info of %s: %s", jiraURL, resp.Status)
+	}
+	info := struct {
+		CloudId string `json:"cloudId"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to read the tenant info of "+jiraURL)
+	}
+	if info.CloudId == "" {
+		return "", errors.New("no cloud Id in the tenant info of " + jiraURL)
+	}
+	return info.CloudId, nil
+}
+
+// parseOAuth2State returns the Mattermost user Id, and the instance URL, of
+// the state of a connect link.
+func parseOAuth2State(secret string) (string, string, error) {
+	parts := strings.SplitN(secret, " ", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New("invalid state")
+	}
+	return parts[0], parts[1], nil
+}
+
+// openInstallCloudOAuthDialog opens the dialog that asks for the OAuth 2.0
+// app to install the Jira Cloud instance at jiraURL with.
+func (p *Plugin) openInstallCloudOAuthDialog(jiraURL, triggerId string) error {
+	appErr := p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: triggerId,
+		URL:       fmt.Sprintf("/plugins/%s%s", manifest.Id, routeAPIDialogInstallOAuth),
+		Dialog: model.Dialog{
+			CallbackId:  "install-cloud-oauth",
+			Title:       "Install Jira Cloud OAuth",
+			SubmitLabel: "Install",
+			State:       jiraURL,
+			Elements: []model.DialogElement{{
+				DisplayName: "Client ID",
+				Name:        "client_id",
+				Type:        "text",
+				HelpText:    "The client ID of the OAuth 2.0 (3LO) app for " + jiraURL + ", in the settings of the app in the Atlassian developer console.",
+			}, {
+				DisplayName: "Client secret",
+				Name:        "client_secret",
+				Type:        "text",
+				SubType:     "password",
+			}},
+		},
+	})
+	if appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func httpAPIInstallCloudOAuthDialog(p *Plugin, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodPost {
+		return respondErr(w, http.StatusMethodNotAllowed,
+			errors.New("method "+r.Method+" is not allowed, must be POST"))
+	}
+	request := model.SubmitDialogRequestFromJson(r.Body)
+	if request == nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.New("Missing request data"))
+	}
+	if request.Cancelled {
+		return http.StatusOK, nil
+	}
+
+	mattermostUserId, err := actionUserId(r, request.UserId)
+	if err != nil {
+		return respondErr(w, http.StatusUnauthorized, err)
+	}
+	authorized, err := authorizedSysAdmin(p, mattermostUserId)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	if !authorized {
+		return respondErr(w, http.StatusForbidden,
+			errors.New("only system administrators can install Jira instances"))
+	}
+	jiraURL := request.State
+	if !strings.HasPrefix(jiraURL, "https://") {
+		return respondErr(w, http.StatusBadRequest, errors.New("invalid dialog state"))
+	}
+
+	clientId, _ := request.Submission["client_id"].(string)
+	clientSecret, _ := request.Submission["client_secret"].(string)
+	errs := map[string]string{}
+	if strings.TrimSpace(clientId) == "" {
+		errs["client_id"] = "Please enter the client ID of the app."
+	}
+	if strings.TrimSpace(clientSecret) == "" {
+		errs["client_secret"] = "Please enter the client secret of the app."
+	}
+	if len(errs) > 0 {
+		return respondJSON(w, &model.SubmitDialogResponse{Errors: errs})
+	}
+
+	msg, err := p.installCloudOAuth(jiraURL, strings.TrimSpace(clientId), strings.TrimSpace(clientSecret), request.TeamId, request.ChannelId)
+	if err != nil {
+		return respondJSON(w, &model.SubmitDialogResponse{Error: err.Error()})
+	}
+	_ = p.API.SendEphemeralPost(mattermostUserId, makePost(p.getUserID(), request.ChannelId, msg))
+	return respondJSON(w, &model.SubmitDialogResponse{})
+}
@@ -0,0 +1,435 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"text/template"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+const mockCloudOAuthURL = "https://mmtest.atlassian.net"
+
+func setupCloudOAuthTest() *Plugin {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	store := NewStore(p)
+	p.currentInstanceStore = store
+	p.instanceStore = store
+	p.userStore = store
+	p.otsStore = store
+
+	siteURL := "https://mm.example.com"
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	kv := map[string][]byte{}
+	api.On("KVGet", mock.Anything).Return(func(key string) []byte {
+		return kv[key]
+	}, nil)
+	api.On("KVSet", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		kv[args.String(0)] = args.Get(1).([]byte)
+	}).Return(nil)
+	api.On("KVSetWithExpiry", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		kv[args.String(0)] = args.Get(1).([]byte)
+	}).Return(nil)
+	api.On("KVDelete", mock.Anything).Run(func(args mock.Arguments) {
+		delete(kv, args.String(0))
+	}).Return(nil)
+	api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, oldValue, newValue []byte) bool {
+		if !bytes.Equal(kv[key], oldValue) || (oldValue == nil && kv[key] != nil) {
+			return false
+		}
+		kv[key] = newValue
+		return true
+	}, nil)
+	api.On("KVCompareAndDelete", mock.Anything, mock.Anything).Return(func(key string, oldValue []byte) bool {
+		if !bytes.Equal(kv[key], oldValue) {
+			return false
+		}
+		delete(kv, key)
+		return true
+	}, nil)
+	return p
+}
+
+func TestCloudOAuthUserConnectURL(t *testing.T) {
+	p := setupCloudOAuthTest()
+	jci := NewJIRACloudOAuthInstance(p, mockCloudOAuthURL, "client-id", "client-secret")
+
+	connectURL, err := jci.GetUserConnectURL("mattermostUserId")
+	require.Nil(t, err)
+	u, err := url.Parse(connectURL)
+	require.Nil(t, err)
+	assert.Equal(t, "auth.atlassian.com", u.Host)
+	query := u.Query()
+	assert.Equal(t, "client-id", query.Get("client_id"))
+	assert.Equal(t, "api.atlassian.com", query.Get("audience"))
+	assert.Equal(t, "https://mm.example.com/plugins/jira/oauth2/complete", query.Get("redirect_uri"))
+	assert.Contains(t, query.Get("scope"), "offline_access")
+
+	// The state is only valid once.
+	secret, err := p.otsStore.LoadOneTimeSecret(query.Get("state"))
+	require.Nil(t, err)
+	mattermostUserId, instanceURL, err := parseOAuth2State(secret)
+	require.Nil(t, err)
+	assert.Equal(t, "mattermostUserId", mattermostUserId)
+	assert.Equal(t, mockCloudOAuthURL, instanceURL)
+	secret, err = p.otsStore.LoadOneTimeSecret(query.Get("state"))
+	require.Nil(t, err)
+	_, _, err = parseOAuth2State(secret)
+	assert.NotNil(t, err)
+}
+
+func TestCloudOAuthTokenRefresh(t *testing.T) {
+	p := setupCloudOAuthTest()
+	jci := NewJIRACloudOAuthInstance(p, mockCloudOAuthURL, "client-id", "client-secret")
+
+	refreshes := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Nil(t, r.ParseForm())
+		assert.Equal(t, "refresh_token", r.Form.Get("grant_type"))
+		assert.Equal(t, "client-id", r.Form.Get("client_id"))
+		assert.Equal(t, "refresh-1", r.Form.Get("refresh_token"))
+		refreshes++
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access-2",
+			"refresh_token": "refresh-2",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	}))
+	defer ts.Close()
+	defer func(endpoint oauth2.Endpoint) { atlassianOAuthEndpoint = endpoint }(atlassianOAuthEndpoint)
+	atlassianOAuthEndpoint.TokenURL = ts.URL
+
+	jiraUser := JIRAUser{
+		User: jira.User{AccountID: "accountId"},
+		OAuth2Token: &oauth2.Token{
+			AccessToken:  "access-1",
+			RefreshToken: "refresh-1",
+			Expiry:       time.Now().Add(-time.Minute),
+		},
+	}
+	require.Nil(t, p.userStore.StoreUserInfo(jci, "mattermostUserId", jiraUser))
+
+	source := &userTokenSource{jci: jci, jiraUser: jiraUser, token: jiraUser.OAuth2Token}
+	token, err := source.Token()
+	require.Nil(t, err)
+	assert.Equal(t, "access-2", token.AccessToken)
+
+	// The rotated refresh token is stored, and used by the other clients.
+	stored, err := p.userStore.LoadJIRAUser(jci, "mattermostUserId")
+	require.Nil(t, err)
+	assert.Equal(t, "refresh-2", stored.OAuth2Token.RefreshToken)
+
+	other := &userTokenSource{jci: jci, jiraUser: jiraUser, token: jiraUser.OAuth2Token}
+	token, err = other.Token()
+	require.Nil(t, err)
+	assert.Equal(t, "access-2", token.AccessToken)
+	assert.Equal(t, 1, refreshes)
+
+	// The refresh is not claimed anymore.
+	claim, err := p.claimKey(hashkey(prefixTokenRefreshLock, mockCloudOAuthURL+"/mattermostUserId"), time.Minute)
+	require.Nil(t, err)
+	assert.NotNil(t, claim)
+}
+
+func TestCloudOAuthTokenRefreshClaimed(t *testing.T) {
+	p := setupCloudOAuthTest()
+	jci := NewJIRACloudOAuthInstance(p, mockCloudOAuthURL, "client-id", "client-secret")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Fail(t, "the token must not be refreshed while another server refreshes it")
+	}))
+	defer ts.Close()
+	defer func(endpoint oauth2.Endpoint) { atlassianOAuthEndpoint = endpoint }(atlassianOAuthEndpoint)
+	atlassianOAuthEndpoint.TokenURL = ts.URL
+
+	expired := JIRAUser{
+		User: jira.User{AccountID: "accountId"},
+		OAuth2Token: &oauth2.Token{
+			AccessToken:  "access-1",
+			RefreshToken: "refresh-1",
+			Expiry:       time.Now().Add(-time.Minute),
+		},
+	}
+	require.Nil(t, p.userStore.StoreUserInfo(jci, "mattermostUserId", expired))
+
+	// Another server claimed the refresh, and stores the new token.
+	lockKey := hashkey(prefixTokenRefreshLock, mockCloudOAuthURL+"/mattermostUserId")
+	claim, err := p.claimKey(lockKey, time.Minute)
+	require.Nil(t, err)
+	require.NotNil(t, claim)
+	other, err := p.claimKey(lockKey, time.Minute)
+	require.Nil(t, err)
+	require.Nil(t, other)
+
+	refreshed := expired
+	refreshed.OAuth2Token = &oauth2.Token{
+		AccessToken:  "access-2",
+		RefreshToken: "refresh-2",
+		Expiry:       time.Now().Add(time.Hour),
+	}
+	go func() {
+		time.Sleep(2 * tokenRefreshPollInterval)
+		assert.Nil(t, p.userStore.StoreUserInfo(jci, "mattermostUserId", refreshed))
+	}()
+
+	source := &userTokenSource{jci: jci, jiraUser: expired, token: expired.OAuth2Token}
+	token, err := source.Token()
+	require.Nil(t, err)
+	assert.Equal(t, "access-2", token.AccessToken)
+}
+
+func TestOAuth2Complete(t *testing.T) {
+	resources := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  "access-1",
+				"refresh_token": "refresh-1",
+				"token_type":    "Bearer",
+				"expires_in":    3600,
+			})
+		case "/oauth/token/accessible-resources":
+			assert.Equal(t, "Bearer access-1", r.Header.Get("Authorization"))
+			_ = json.NewEncoder(w).Encode([]accessibleResource{
+				{Id: "otherCloudId", URL: "https://other.atlassian.net", Name: "other"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer resources.Close()
+	defer func(endpoint oauth2.Endpoint, apiURL string) {
+		atlassianOAuthEndpoint = endpoint
+		atlassianAPIURL = apiURL
+	}(atlassianOAuthEndpoint, atlassianAPIURL)
+	atlassianOAuthEndpoint.TokenURL = resources.URL + "/token"
+	atlassianAPIURL = resources.URL
+
+	for name, tc := range map[string]struct {
+		query          string
+		state          string
+		serverInstance bool
+		expectedStatus int
+		expectedError  string
+	}{
+		"missing state": {
+			query:          "code=code",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Missing code or state",
+		},
+		"expired state": {
+			query:          "code=code&state=expired",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "The connect link expired",
+		},
+		"state of another user": {
+			query:          "code=code&state=state",
+			state:          "otherUserId " + mockCloudOAuthURL,
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "Not authorized, user id does not match link",
+		},
+		"not a cloud OAuth instance": {
+			query:          "code=code&state=state",
+			state:          "mattermostUserId " + mockCloudOAuthURL,
+			serverInstance: true,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Must be a Jira Cloud OAuth instance, is server",
+		},
+		"site not granted": {
+			query:          "code=code&state=state",
+			state:          "mattermostUserId " + mockCloudOAuthURL,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Access to " + mockCloudOAuthURL + " was not granted",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := setupCloudOAuthTest()
+			api := p.API.(*plugintest.API)
+			api.On("GetUser", "mattermostUserId").Return(&model.User{Id: "mattermostUserId"}, nil)
+			p.templates = map[string]*template.Template{
+				"/other/message.html": template.Must(template.New("").Parse("{{.Header}} {{.Message}}")),
+			}
+
+			if tc.serverInstance {
+				require.Nil(t, p.instanceStore.StoreJIRAInstance(NewJIRAServerInstance(p, mockCloudOAuthURL)))
+			} else {
+				require.Nil(t, p.instanceStore.StoreJIRAInstance(NewJIRACloudOAuthInstance(p, mockCloudOAuthURL, "client-id", "client-secret")))
+			}
+			if tc.state != "" {
+				require.Nil(t, p.otsStore.StoreOneTimeSecret("state", tc.state))
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, routeOAuth2Complete+"?"+tc.query, nil)
+			r.Header.Set("Mattermost-User-Id", "mattermostUserId")
+			status, _ := httpOAuth2Complete(p, w, r)
+			assert.Equal(t, tc.expectedStatus, status)
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			assert.Contains(t, w.Body.String(), tc.expectedError)
+
+			ji, err := p.instanceStore.LoadJIRAInstance(mockCloudOAuthURL)
+			require.Nil(t, err)
+			_, err = p.userStore.LoadJIRAUser(ji, "mattermostUserId")
+			assert.NotNil(t, err, "the user must not be connected")
+		})
+	}
+}
+
+func TestLoadCloudOAuthInstance(t *testing.T) {
+	p := setupCloudOAuthTest()
+	jci := NewJIRACloudOAuthInstance(p, mockCloudOAuthURL, "client-id", "client-secret")
+	jci.CloudId = "cloudId"
+	require.Nil(t, p.instanceStore.StoreJIRAInstance(jci))
+
+	ji, err := p.instanceStore.LoadJIRAInstance(mockCloudOAuthURL)
+	require.Nil(t, err)
+	loaded, ok := ji.(*jiraCloudOAuthInstance)
+	require.True(t, ok)
+	assert.Equal(t, JIRATypeCloudOAuth, loaded.GetType())
+	assert.Equal(t, mockCloudOAuthURL, loaded.GetURL())
+	assert.Equal(t, "cloudId", loaded.CloudId)
+	assert.Equal(t, "client-secret", loaded.OAuthClientSecret)
+	assert.Equal(t, "https://api.atlassian.com/ex/jira/cloudId/", loaded.apiURL())
+}
+
+func TestCloudOAuthUserDisconnect(t *testing.T) {
+	p := setupCloudOAuthTest()
+	api := p.API.(*plugintest.API)
+	api.On("PublishWebSocketEvent", WS_EVENT_DISCONNECT, mock.Anything, mock.Anything).Return()
+	p.templates = map[string]*template.Template{
+		"/other/message.html": template.Must(template.New("").Parse("{{.Header}} {{.Message}}")),
+	}
+
+	jci := NewJIRACloudOAuthInstance(p, mockCloudOAuthURL, "client-id", "client-secret")
+	jiraUser := JIRAUser{
+		User:        jira.User{AccountID: "accountId"},
+		OAuth2Token: &oauth2.Token{AccessToken: "access-1"},
+	}
+
+	for name, tc := range map[string]struct {
+		ji             Instance
+		expectedStatus int
+	}{
+		"Atlassian Connect": {
+			ji:             NewJIRACloudInstance(p, mockCloudOAuthURL, true, "", &AtlassianSecurityContext{}),
+			expectedStatus: http.StatusBadRequest,
+		},
+		"cloud OAuth": {
+			ji:             jci,
+			expectedStatus: http.StatusOK,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Nil(t, p.userStore.StoreUserInfo(jci, "mattermostUserId", jiraUser))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, routeUserDisconnect, nil)
+			r.Header.Set("Mattermost-User-Id", "mattermostUserId")
+			status, _ := httpUserDisconnect(tc.ji, w, r)
+			assert.Equal(t, tc.expectedStatus, status)
+
+			_, err := p.userStore.LoadJIRAUser(jci, "mattermostUserId")
+			if tc.expectedStatus == http.StatusOK {
+				assert.NotNil(t, err, "the user must be disconnected")
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestInstallCloudOAuthDialog(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("GetUser", "adminId").Return(&model.User{Id: "adminId", Roles: "system_admin system_user"}, nil)
+	api.On("GetUser", "userId").Return(&model.User{Id: "userId", Roles: "system_user"}, nil)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	for name, tc := range map[string]struct {
+		userId       string
+		header       string
+		state        string
+		submission   map[string]interface{}
+		expectStatus int
+		expectErrors []string
+	}{
+		"no header": {
+			userId:       "adminId",
+			state:        mockCloudOAuthURL,
+			expectStatus: http.StatusUnauthorized,
+		},
+		"another user": {
+			userId:       "adminId",
+			header:       "userId",
+			state:        mockCloudOAuthURL,
+			expectStatus: http.StatusUnauthorized,
+		},
+		"not a system administrator": {
+			userId:       "userId",
+			header:       "userId",
+			state:        mockCloudOAuthURL,
+			expectStatus: http.StatusForbidden,
+		},
+		"insecure URL": {
+			userId:       "adminId",
+			header:       "adminId",
+			state:        "http://mmtest.atlassian.net",
+			expectStatus: http.StatusBadRequest,
+		},
+		"missing secret": {
+			userId:       "adminId",
+			header:       "adminId",
+			state:        mockCloudOAuthURL,
+			submission:   map[string]interface{}{"client_id": "clientId"},
+			expectStatus: http.StatusOK,
+			expectErrors: []string{"client_secret"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			request := &model.SubmitDialogRequest{
+				UserId:     tc.userId,
+				State:      tc.state,
+				Submission: tc.submission,
+			}
+			r := httptest.NewRequest(http.MethodPost, routeAPIDialogInstallOAuth, bytes.NewReader(request.ToJson()))
+			if tc.header != "" {
+				r.Header.Set("Mattermost-User-Id", tc.header)
+			}
+			w := httptest.NewRecorder()
+			status, _ := httpAPIInstallCloudOAuthDialog(p, w, r)
+			require.Equal(t, tc.expectStatus, status)
+			if tc.expectErrors == nil {
+				return
+			}
+			response := model.SubmitDialogResponseFromJson(w.Result().Body)
+			require.NotNil(t, response)
+			errs := []string{}
+			for name := range response.Errors {
+				errs = append(errs, name)
+			}
+			assert.Equal(t, tc.expectErrors, errs)
+		})
+	}
+}
@@ -6,6 +6,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/andygrunwald/go-jira"
 	"github.com/dghubble/oauth1"
@@ -47,7 +48,7 @@ func (jsi jiraServerInstance) GetManageAppsURL() string {
 type withServerInstanceFunc func(jsi *jiraServerInstance, w http.ResponseWriter, r *http.Request) (int, error)
 
 func withServerInstance(p *Plugin, w http.ResponseWriter, r *http.Request, f withServerInstanceFunc) (int, error) {
-	return withInstance(p.currentInstanceStore, w, r, func(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
+	return withInstance(p, w, r, func(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
 		jsi, ok := ji.(*jiraServerInstance)
 		if !ok {
 			return respondErr(w, http.StatusBadRequest, errors.New("Must be a Jira Server instance, is "+ji.GetType()))
@@ -124,13 +125,14 @@ func (jsi jiraServerInstance) GetClient(jiraUser JIRAUser) (client Client, retur
 		utils.WithResponseSizeLimit(conf.maxAttachmentSize))
 	httpClient = expvar.WrapHTTPClient(httpClient,
 		conf.stats, endpointNameFromRequest)
+	httpClient = jsi.GetPlugin().wrapJiraHTTPClient(jsi.GetURL(), httpClient)
 
 	jiraClient, err := jira.NewClient(httpClient, jsi.GetURL())
 	if err != nil {
 		return nil, err
 	}
 
-	return newServerClient(jiraClient), nil
+	return jsi.GetPlugin().clientCache.wrap(jsi.GetURL(), jiraUser, newServerClient(jiraClient)), nil
 }
 
 func (jsi jiraServerInstance) getOAuth1Config() *oauth1.Config {
@@ -163,7 +165,7 @@ func (jsi *jiraServerInstance) GetOAuth1Config() (returnConfig *oauth1.Config, r
 	jsi.oauth1Config = &oauth1.Config{
 		ConsumerKey:    jsi.MattermostKey,
 		ConsumerSecret: "dontcare",
-		CallbackURL:    jsi.GetPluginURL() + "/" + routeOAuth1Complete,
+		CallbackURL:    jsi.GetPluginURL() + "/" + routeOAuth1Complete + "?" + url.Values{argInstanceURL: {jsi.GetURL()}}.Encode(),
 		Endpoint: oauth1.Endpoint{
 			RequestTokenURL: jsi.GetURL() + "/plugins/servlet/oauth/request-token",
 			AuthorizeURL:    jsi.GetURL() + "/plugins/servlet/oauth/authorize",
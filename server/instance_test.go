@@ -0,0 +1,114 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	otherInstanceURL = "http://otherJiraInstanceURL.some"
+	thirdInstanceURL = "http://thirdJiraInstanceURL.some"
+)
+
+type mockInstanceStore struct {
+	InstanceStore
+	plugin *Plugin
+}
+
+func (store mockInstanceStore) LoadJIRAInstance(key string) (Instance, error) {
+	if key != otherInstanceURL && key != thirdInstanceURL {
+		return nil, errors.New("not found: " + key)
+	}
+	return &jiraServerInstance{
+		JIRAInstance:  NewJIRAInstance(store.plugin, JIRATypeServer, key),
+		JIRAServerURL: key,
+	}, nil
+}
+
+type mockUserStoreInstances struct {
+	mockUserStore
+	urls []string
+	err  error
+}
+
+func (store mockUserStoreInstances) LoadUserInstances(mattermostUserId string) ([]string, error) {
+	return store.urls, store.err
+}
+
+func TestLoadUserInstance(t *testing.T) {
+	p := &Plugin{}
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	p.instanceStore = mockInstanceStore{plugin: p}
+
+	for name, tc := range map[string]struct {
+		userInstances []string
+		loadErr       error
+		instanceURL   string
+		expectedURL   string
+		expectedErr   bool
+		expectedURLs  []string
+	}{
+		"not connected, default instance": {
+			expectedURL: mockCurrentInstanceURL,
+		},
+		"explicit instance": {
+			instanceURL: otherInstanceURL,
+			expectedURL: otherInstanceURL,
+		},
+		"explicit instance not installed": {
+			instanceURL: "http://unknown.some",
+			expectedErr: true,
+		},
+		"connected to the other instance only": {
+			userInstances: []string{otherInstanceURL},
+			expectedURL:   otherInstanceURL,
+		},
+		"connected to both, prefers default": {
+			userInstances: []string{otherInstanceURL, mockCurrentInstanceURL},
+			expectedURL:   mockCurrentInstanceURL,
+		},
+		"connected to an uninstalled instance": {
+			userInstances: []string{"http://unknown.some"},
+			expectedURL:   mockCurrentInstanceURL,
+		},
+		"connected to two other instances": {
+			userInstances: []string{thirdInstanceURL, otherInstanceURL},
+			expectedErr:   true,
+			expectedURLs:  []string{otherInstanceURL, thirdInstanceURL},
+		},
+		"connected to two other instances, explicit instance": {
+			userInstances: []string{thirdInstanceURL, otherInstanceURL},
+			instanceURL:   thirdInstanceURL,
+			expectedURL:   thirdInstanceURL,
+		},
+		"failed to load the instances of the user": {
+			loadErr:     errors.New("failed"),
+			expectedErr: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p.userStore = mockUserStoreInstances{urls: tc.userInstances, err: tc.loadErr}
+
+			ji, err := p.loadUserInstance("testMattermostUserId012345", tc.instanceURL)
+			if tc.expectedErr {
+				require.Error(t, err)
+				multiple, ok := err.(*multipleInstancesError)
+				if tc.expectedURLs != nil {
+					require.True(t, ok)
+					assert.Equal(t, tc.expectedURLs, multiple.URLs)
+				} else {
+					assert.False(t, ok)
+				}
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.expectedURL, ji.GetURL())
+		})
+	}
+}
@@ -72,7 +72,15 @@ func httpAPITransitionIssue(ji Instance, w http.ResponseWriter, r *http.Request)
 		return respondErr(w, http.StatusUnauthorized, err)
 	}
 
-	msg, err = plugin.transitionJiraIssue(mattermostUserId, issueKey, toState)
+	msg, err = plugin.transitionJiraIssue(ji, mattermostUserId, issueKey, toState, nil)
+	if fieldsErr, ok := err.(*transitionFieldsError); ok {
+		// Ask for the fields of the transition screen.
+		err = plugin.openTransitionFieldsDialog(ji, jiraUser, requestData.TriggerId, fieldsErr, nil)
+		if err != nil {
+			_ = plugin.API.SendEphemeralPost(mattermostUserId, makePost(jiraBotID, channelID, fieldsErr.Error()))
+		}
+		return http.StatusOK, nil
+	}
 	if err != nil {
 		msg = "Failed to transition this issue."
 		_ = plugin.API.SendEphemeralPost(mattermostUserId, makePost(jiraBotID, channelID, msg))
@@ -193,16 +201,14 @@ func httpAPICreateIssue(ji Instance, w http.ResponseWriter, r *http.Request) (in
 	if len(create.RequiredFieldsNotCovered) > 0 {
 		createURL := MakeCreateIssueURL(ji, project, issue)
 
-		message := "The project you tried to create an issue for has **required fields** this plugin does not yet support:"
-
-		var fieldsString string
+		fieldNames := []string{}
 		for _, v := range create.RequiredFieldsNotCovered {
 			// Second position in the slice is the localized name of that key.
-			fieldsString = fieldsString + fmt.Sprintf("- %+v\n", v[1])
+			fieldNames = append(fieldNames, v[1])
 		}
 
 		reply := &model.Post{
-			Message:   fmt.Sprintf("[Please create your Jira issue manually](%v). %v\n%v", createURL, message, fieldsString),
+			Message:   requiredFieldsMessage(createURL, fieldNames),
 			ChannelId: channelId,
 			RootId:    rootId,
 			ParentId:  rootId,
@@ -238,7 +244,29 @@ func httpAPICreateIssue(ji Instance, w http.ResponseWriter, r *http.Request) (in
 			errors.WithMessage(err, "failed to create issue"))
 	}
 
-	// Reply with an ephemeral post with the Jira issue formatted as slack attachment.
+	err = ji.GetPlugin().postCreatedIssue(ji, client, jiraUser, mattermostUserId, channelId, rootId, created)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	if post != nil && len(post.FileIds) > 0 {
+		go func() {
+			conf := ji.GetPlugin().getConfig()
+			for _, fileId := range post.FileIds {
+				mattermostName, _, _, e := client.AddAttachment(api, created.ID, fileId, conf.maxAttachmentSize)
+				if e != nil {
+					notifyOnFailedAttachment(ji, mattermostUserId, created.Key, e, "file: %s", mattermostName)
+				}
+			}
+		}()
+	}
+
+	return respondJSON(w, created)
+}
+
+// postCreatedIssue replies to the user with the created issue formatted as a
+// slack attachment, and lets the channel members know about it.
+func (p *Plugin) postCreatedIssue(ji Instance, client Client, jiraUser JIRAUser, mattermostUserId, channelId, rootId string, created *jira.Issue) error {
 	msg := fmt.Sprintf("Created Jira issue [%s](%s/browse/%s)", created.Key, ji.GetURL(), created.Key)
 
 	reply := &model.Post{
@@ -246,24 +274,22 @@ func httpAPICreateIssue(ji Instance, w http.ResponseWriter, r *http.Request) (in
 		ChannelId: channelId,
 		RootId:    rootId,
 		ParentId:  rootId,
-		UserId:    ji.GetPlugin().getConfig().botUserID,
+		UserId:    p.getConfig().botUserID,
 	}
 
-	attachment, err := ji.GetPlugin().getIssueAsSlackAttachment(ji, jiraUser, created.Key)
+	attachment, err := p.getIssueAsSlackAttachment(ji, jiraUser, created.Key)
 	if err != nil {
-		return respondErr(w, http.StatusInternalServerError,
-			errors.WithMessage(err, "failed to create notification post "+create.PostId))
+		return errors.WithMessage(err, "failed to create notification post for "+created.Key)
 	}
 
 	reply.AddProp("attachments", attachment)
-	_ = api.SendEphemeralPost(mattermostUserId, reply)
+	_ = p.API.SendEphemeralPost(mattermostUserId, reply)
 
 	// Fetching issue details as Jira only returns the issue id and issue key at the time of
 	// issue creation. We will not have issue summary in the creation response.
 	createdIssue, err := client.GetIssue(created.Key, nil)
 	if err != nil {
-		return respondErr(w, http.StatusInternalServerError,
-			errors.WithMessage(err, "failed to fetch issue details "+created.Key))
+		return errors.WithMessage(err, "failed to fetch issue details "+created.Key)
 	}
 
 	// Create a public post for all the channel members
@@ -274,25 +300,23 @@ func httpAPICreateIssue(ji Instance, w http.ResponseWriter, r *http.Request) (in
 		ParentId:  rootId,
 		UserId:    mattermostUserId,
 	}
-	_, appErr = api.CreatePost(publicReply)
+	_, appErr := p.API.CreatePost(publicReply)
 	if appErr != nil {
-		return respondErr(w, http.StatusInternalServerError,
-			errors.WithMessage(appErr, "failed to create notification post "+create.PostId))
+		return errors.WithMessage(appErr, "failed to create notification post for "+created.Key)
 	}
+	return nil
+}
 
-	if post != nil && len(post.FileIds) > 0 {
-		go func() {
-			conf := ji.GetPlugin().getConfig()
-			for _, fileId := range post.FileIds {
-				mattermostName, _, _, e := client.AddAttachment(api, created.ID, fileId, conf.maxAttachmentSize)
-				if e != nil {
-					notifyOnFailedAttachment(ji, mattermostUserId, created.Key, e, "file: %s", mattermostName)
-				}
-			}
-		}()
-	}
+// requiredFieldsMessage asks the user to create the issue in Jira, when the
+// project has required fields that could not be filled.
+func requiredFieldsMessage(createURL string, fieldNames []string) string {
+	message := "The project you tried to create an issue for has **required fields** this plugin does not yet support:"
 
-	return respondJSON(w, created)
+	var fieldsString string
+	for _, name := range fieldNames {
+		fieldsString = fieldsString + fmt.Sprintf("- %+v\n", name)
+	}
+	return fmt.Sprintf("[Please create your Jira issue manually](%v). %v\n%v", createURL, message, fieldsString)
 }
 
 func httpWorkflowCreateIssue(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
@@ -437,8 +461,7 @@ func httpAPIGetSearchIssues(ji Instance, w http.ResponseWriter, r *http.Request)
 		fieldsStr = "key,summary"
 	}
 	if len(jqlString) == 0 {
-		escaped := strings.ReplaceAll(q, `"`, `\"`)
-		jqlString = fmt.Sprintf(`text ~ "%s" OR text ~ "%s*"`, escaped, escaped)
+		jqlString = textSearchJQL(q)
 	}
 
 	limit := 50
@@ -486,6 +509,13 @@ func httpAPIGetSearchIssues(ji Instance, w http.ResponseWriter, r *http.Request)
 	return respondJSON(w, result)
 }
 
+// textSearchJQL returns the JQL query for the issues containing text, or
+// words starting with it.
+func textSearchJQL(text string) string {
+	escaped := strings.ReplaceAll(text, `"`, `\"`)
+	return fmt.Sprintf(`text ~ "%s" OR text ~ "%s*"`, escaped, escaped)
+}
+
 func httpAPIGetJiraProjectMetadata(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
 	if r.Method != http.MethodGet {
 		return respondErr(w, http.StatusMethodNotAllowed,
@@ -625,33 +655,7 @@ func httpAPIAttachCommentToIssue(ji Instance, w http.ResponseWriter, r *http.Req
 			errors.WithMessage(err, "failed to attach the comment, postId: "+attach.PostId))
 	}
 
-	go func() {
-		conf := ji.GetPlugin().getConfig()
-		extraText := ""
-		for _, fileId := range post.FileIds {
-			mattermostName, jiraName, mime, e := client.AddAttachment(api, attach.IssueKey, fileId, conf.maxAttachmentSize)
-			if e != nil {
-				notifyOnFailedAttachment(ji, mattermostUserId, attach.IssueKey, e, "file: %s", mattermostName)
-				continue
-			}
-			if isImageMIME(mime) || isEmbbedableMIME(mime) {
-				extraText += "\n\nAttachment: !" + jiraName + "!"
-			} else {
-				extraText += "\n\nAttachment: [^" + jiraName + "]"
-			}
-
-		}
-		if extraText == "" {
-			return
-		}
-
-		jiraComment.ID = commentAdded.ID
-		jiraComment.Body += extraText
-		_, err = client.UpdateComment(attach.IssueKey, &jiraComment)
-		if err != nil {
-			notifyOnFailedAttachment(ji, mattermostUserId, attach.IssueKey, err, "failed to completely update comment with attachments")
-		}
-	}()
+	go addCommentAttachments(ji, client, mattermostUserId, attach.IssueKey, commentAdded, jiraComment.Body, post.FileIds)
 
 	rootId := attach.PostId
 	if post.RootId != "" {
@@ -678,6 +682,38 @@ func httpAPIAttachCommentToIssue(ji Instance, w http.ResponseWriter, r *http.Req
 	return respondJSON(w, commentAdded)
 }
 
+// addCommentAttachments uploads the files of a post to an issue, and links
+// them from the comment that was made of the post.
+func addCommentAttachments(ji Instance, client Client, mattermostUserId, issueKey string, comment *jira.Comment, body string, fileIds []string) {
+	api := ji.GetPlugin().API
+	conf := ji.GetPlugin().getConfig()
+	extraText := ""
+	for _, fileId := range fileIds {
+		mattermostName, jiraName, mime, e := client.AddAttachment(api, issueKey, fileId, conf.maxAttachmentSize)
+		if e != nil {
+			notifyOnFailedAttachment(ji, mattermostUserId, issueKey, e, "file: %s", mattermostName)
+			continue
+		}
+		if isImageMIME(mime) || isEmbbedableMIME(mime) {
+			extraText += "\n\nAttachment: !" + jiraName + "!"
+		} else {
+			extraText += "\n\nAttachment: [^" + jiraName + "]"
+		}
+
+	}
+	if extraText == "" {
+		return
+	}
+
+	_, err := client.UpdateComment(issueKey, &jira.Comment{
+		ID:   comment.ID,
+		Body: body + extraText,
+	})
+	if err != nil {
+		notifyOnFailedAttachment(ji, mattermostUserId, issueKey, err, "failed to completely update comment with attachments")
+	}
+}
+
 func notifyOnFailedAttachment(ji Instance, mattermostUserId, issueKey string, err error, format string, args ...interface{}) {
 	msg := "Failed to attach to issue: " + issueKey + ", " + fmt.Sprintf(format, args...)
 
@@ -824,15 +860,10 @@ func (p *Plugin) getIssueAsSlackAttachment(ji Instance, jiraUser JIRAUser, issue
 		}
 	}
 
-	return parseIssue(client, issue)
+	return parseIssue(ji, client, issue)
 }
 
-func (p *Plugin) unassignJiraIssue(mmUserId, issueKey string) (string, error) {
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		return "", err
-	}
-
+func (p *Plugin) unassignJiraIssue(ji Instance, mmUserId, issueKey string) (string, error) {
 	jiraUser, err := ji.GetPlugin().userStore.LoadJIRAUser(ji, mmUserId)
 	if err != nil {
 		return "", err
@@ -865,12 +896,7 @@ func (p *Plugin) unassignJiraIssue(mmUserId, issueKey string) (string, error) {
 
 const MinUserSearchQueryLength = 3
 
-func (p *Plugin) assignJiraIssue(mmUserId, issueKey, userSearch string) (string, error) {
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		return "", err
-	}
-
+func (p *Plugin) assignJiraIssue(ji Instance, mmUserId, issueKey, userSearch string) (string, error) {
 	jiraUser, err := ji.GetPlugin().userStore.LoadJIRAUser(ji, mmUserId)
 	if err != nil {
 		return "", err
@@ -949,13 +975,30 @@ func (p *Plugin) assignJiraIssue(mmUserId, issueKey, userSearch string) (string,
 	return msg, nil
 }
 
-func (p *Plugin) transitionJiraIssue(mmUserId, issueKey, toState string) (string, error) {
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		p.errorf("transitionJiraIssue: failed to load current Jira instance: %v", err)
-		return "", errors.New("Failed to load current Jira instance. Please contact your system administrator.")
+// assignJiraIssueToSelf assigns an issue to the user of client.
+func assignJiraIssueToSelf(ji Instance, client Client, jiraUser JIRAUser, issueKey string) (string, error) {
+	// From Jira error: query parameters 'accountId' and 'username' are mutually exclusive.
+	user := jiraUser.User
+	if user.AccountID != "" {
+		user.Name = ""
+	}
+
+	if err := client.UpdateAssignee(issueKey, &user); err != nil {
+		if StatusCode(err) == http.StatusForbidden {
+			return "", errors.New("You do not have the appropriate permissions to perform this action. Please contact your Jira administrator.")
+		}
+		return "", err
 	}
 
+	permalink := fmt.Sprintf("%v/browse/%v", ji.GetURL(), issueKey)
+	return fmt.Sprintf("You were assigned to Jira issue [%s](%s)", issueKey, permalink), nil
+}
+
+// transitionJiraIssue transitions an issue to the state that matches
+// toState. fields are the values of the fields of the transition screen, by
+// field name or key. When required fields are missing, the error is a
+// *transitionFieldsError.
+func (p *Plugin) transitionJiraIssue(ji Instance, mmUserId, issueKey, toState string, fields map[string]string) (string, error) {
 	jiraUser, err := ji.GetPlugin().userStore.LoadJIRAUser(ji, mmUserId)
 	if err != nil {
 		return "", err
@@ -966,7 +1009,7 @@ func (p *Plugin) transitionJiraIssue(mmUserId, issueKey, toState string) (string
 		return "", err
 	}
 
-	transitions, err := client.GetTransitions(issueKey)
+	transitions, err := client.GetTransitionsWithFields(issueKey)
 	if err != nil {
 		return "", errors.New("We couldn't find the issue key. Please confirm the issue key and try again. You may not have permissions to access this issue.")
 	}
@@ -974,7 +1017,7 @@ func (p *Plugin) transitionJiraIssue(mmUserId, issueKey, toState string) (string
 		return "", errors.New("You do not have the appropriate permissions to perform this action. Please contact your Jira administrator.")
 	}
 
-	var transition jira.Transition
+	var transition transitionMeta
 	matchingStates := []string{}
 	availableStates := []string{}
 
@@ -1001,7 +1044,26 @@ func (p *Plugin) transitionJiraIssue(mmUserId, issueKey, toState string) (string
 			toState, strings.Join(matchingStates, ", "))
 	}
 
-	if err := client.DoTransition(issueKey, transition.ID); err != nil {
+	missing := transition.missingFields(fields)
+	if len(missing) > 0 {
+		return "", &transitionFieldsError{
+			IssueKey:   issueKey,
+			Transition: transition,
+			Missing:    missing,
+		}
+	}
+
+	if len(fields) == 0 {
+		err = client.DoTransition(issueKey, transition.ID)
+	} else {
+		var payload map[string]interface{}
+		payload, err = p.transitionPayload(ji, client, jiraUser, issueKey, transition, fields)
+		if err != nil {
+			return "", err
+		}
+		err = client.DoTransitionWithPayload(issueKey, payload)
+	}
+	if err != nil {
 		return "", err
 	}
 
@@ -0,0 +1,479 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	"github.com/trivago/tgo/tcontainer"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const createHelpText = "###### Create a Jira issue\n" +
+	"* `/jira create --project <key> --type <issue type> [options] \"<summary>\" [-- <description>]`\n" +
+	"Options:\n" +
+	"* `--priority <name>`\n" +
+	"* `--labels <label,label>`\n" +
+	"* `--assignee <me or user>`\n" +
+	"* `--components <name,name>`\n" +
+	"* `--field \"<field name>=<value>\"` - Set any other field, by its name. Can be repeated\n" +
+	"Values with spaces must be quoted. Everything after a standalone `--` is the description.\n"
+
+// createFlagFields maps the /jira create options to the Jira fields they set.
+var createFlagFields = map[string]string{
+	"priority":   "priority",
+	"labels":     "labels",
+	"label":      "labels",
+	"assignee":   "assignee",
+	"components": "components",
+	"component":  "components",
+}
+
+// createArgs are the parsed arguments of /jira create.
+type createArgs struct {
+	Project     string
+	IssueType   string
+	Summary     string
+	Description string
+
+	// Fields are the other fields to set, by field name or key. The
+	// values are converted according to the create metadata.
+	Fields map[string]string
+}
+
+// createMetaField is a field of an issue type in the create metadata.
+type createMetaField struct {
+	Key             string
+	Name            string `json:"name"`
+	Required        bool   `json:"required"`
+	HasDefaultValue bool   `json:"hasDefaultValue"`
+	Schema          struct {
+		Type   string `json:"type"`
+		Items  string `json:"items"`
+		System string `json:"system"`
+		Custom string `json:"custom"`
+	} `json:"schema"`
+	AllowedValues []struct {
+		Id    string `json:"id"`
+		Name  string `json:"name"`
+		Value string `json:"value"`
+		Key   string `json:"key"`
+	} `json:"allowedValues"`
+}
+
+func executeCreate(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) == 0 || args[0] == "--help" {
+		return p.responsef(header, createHelpText)
+	}
+
+	// The command is parsed again, the arguments may be quoted.
+	_, text := splitInstanceOption(header.Command)
+	text = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), "/jira"))
+	text = strings.TrimPrefix(text, "create")
+	create, err := parseCreateArgs(text)
+	if err != nil {
+		return p.responsef(header, "%v\n%s", err, createHelpText)
+	}
+
+	ji, resp := p.loadCommandInstance(header)
+	if resp != nil {
+		return resp
+	}
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, header.UserId)
+	if err != nil {
+		return p.responsef(header, "Your username is not connected to Jira. Please type `jira connect`.")
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+
+	msg, err := p.createIssueFromCommand(ji, client, jiraUser, header, create)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if msg != "" {
+		return p.responsef(header, "%s", msg)
+	}
+	return &model.CommandResponse{}
+}
+
+// createIssueFromCommand validates the arguments against the create metadata
+// of the project, and creates the issue. It returns a message for the user
+// when the issue can not be created from the command.
+func (p *Plugin) createIssueFromCommand(ji Instance, client Client, jiraUser JIRAUser, header *model.CommandArgs, create createArgs) (string, error) {
+	meta, err := client.GetCreateMeta(&jira.GetQueryOptions{
+		Expand:      "projects.issuetypes.fields",
+		ProjectKeys: create.Project,
+	})
+	if err != nil {
+		return "", errors.WithMessagef(err, "failed to get the create metadata of project %s", create.Project)
+	}
+	metaProject := meta.GetProjectWithKey(create.Project)
+	if metaProject == nil {
+		return fmt.Sprintf("Project `%s` was not found, or you can not create issues in it.", create.Project), nil
+	}
+	metaIssueType := metaProject.GetIssueTypeWithName(create.IssueType)
+	if metaIssueType == nil {
+		names := []string{}
+		for _, t := range metaProject.IssueTypes {
+			names = append(names, t.Name)
+		}
+		return fmt.Sprintf("%q is not an issue type of project %s. Please use one of: %s.",
+			create.IssueType, metaProject.Key, strings.Join(names, ", ")), nil
+	}
+	fields, err := getCreateMetaFields(metaIssueType)
+	if err != nil {
+		return "", err
+	}
+
+	issue := &jira.Issue{
+		Fields: &jira.IssueFields{
+			Project:     jira.Project{Key: metaProject.Key},
+			Type:        jira.IssueType{ID: metaIssueType.Id, Name: metaIssueType.Name},
+			Summary:     create.Summary,
+			Description: create.Description,
+			Unknowns:    tcontainer.NewMarshalMap(),
+		},
+	}
+
+	for name, value := range create.Fields {
+		field := findCreateMetaField(fields, name)
+		if field == nil {
+			return fmt.Sprintf("Field %q is not available for %s issues in project %s.",
+				name, metaIssueType.Name, metaProject.Key), nil
+		}
+		var v interface{}
+		v, err = p.createFieldValue(ji, client, jiraUser, metaProject.Key, *field, value)
+		if err != nil {
+			return fmt.Sprintf("Invalid value for %s: %v", field.Name, err), nil
+		}
+		issue.Fields.Unknowns[field.Key] = v
+	}
+
+	missing := []string{}
+	for _, field := range fields {
+		if !field.Required || field.HasDefaultValue {
+			continue
+		}
+		switch field.Key {
+		case "project", "issuetype", "summary":
+			continue
+		case "description":
+			if create.Description != "" {
+				continue
+			}
+		case "reporter":
+			if ji.GetType() == JIRATypeServer {
+				issue.Fields.Reporter = &jiraUser.User
+			}
+			continue
+		}
+		if _, ok := issue.Fields.Unknowns[field.Key]; !ok {
+			missing = append(missing, field.Name)
+		}
+	}
+	project := &jira.Project{ID: metaProject.Id, Key: metaProject.Key}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return requiredFieldsMessage(MakeCreateIssueURL(ji, project, issue), missing) +
+			"You can also set them with `--field \"<field name>=<value>\"`.", nil
+	}
+
+	created, err := client.CreateIssue(issue)
+	if err != nil {
+		if strings.Contains(err.Error(), "is required.") {
+			return fmt.Sprintf("Failed to create issue. Your Jira project requires fields the plugin does not yet support. "+
+				"[Please create your Jira issue manually](%s) or contact your Jira administrator.\n%v",
+				MakeCreateIssueURL(ji, project, issue), err), nil
+		}
+		return "", errors.WithMessage(err, "failed to create issue")
+	}
+
+	return "", p.postCreatedIssue(ji, client, jiraUser, header.UserId, header.ChannelId, header.RootId, created)
+}
+
+// getCreateMetaFields returns the fields of an issue type, sorted by key.
+func getCreateMetaFields(t *jira.MetaIssueType) ([]createMetaField, error) {
+	fields := []createMetaField{}
+	for key, v := range t.Fields {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		field := createMetaField{}
+		err = json.Unmarshal(data, &field)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to parse the create metadata of field %s", key)
+		}
+		field.Key = key
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	return fields, nil
+}
+
+// findCreateMetaField finds a field by its key, or by its name ignoring case.
+func findCreateMetaField(fields []createMetaField, name string) *createMetaField {
+	for i := range fields {
+		if fields[i].Key == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].Name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// createFieldValue converts a command argument to the value of a field, as
+// expected by the create issue API.
+func (p *Plugin) createFieldValue(ji Instance, client Client, jiraUser JIRAUser, projectKey string, field createMetaField, value string) (interface{}, error) {
+	if field.Schema.Type != "array" {
+		return p.createFieldItemValue(ji, client, jiraUser, projectKey, field, field.Schema.Type, value)
+	}
+
+	values := []interface{}{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		v, err := p.createFieldItemValue(ji, client, jiraUser, projectKey, field, field.Schema.Items, item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (p *Plugin) createFieldItemValue(ji Instance, client Client, jiraUser JIRAUser, projectKey string, field createMetaField, fieldType, value string) (interface{}, error) {
+	if len(field.AllowedValues) > 0 {
+		allowed := []string{}
+		for _, a := range field.AllowedValues {
+			name := a.Name
+			if name == "" {
+				name = a.Value
+			}
+			if strings.EqualFold(name, value) || a.Id == value {
+				return map[string]interface{}{"id": a.Id}, nil
+			}
+			allowed = append(allowed, name)
+		}
+		return nil, errors.Errorf("%q is not allowed. Please use one of: %s", value, strings.Join(allowed, ", "))
+	}
+
+	switch fieldType {
+	case "number":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.Errorf("%q is not a number", value)
+		}
+		return f, nil
+	case "user":
+		user, err := p.findCreateFieldUser(ji, client, jiraUser, projectKey, field, value)
+		if err != nil {
+			return nil, err
+		}
+		if user.AccountID != "" {
+			return map[string]interface{}{"accountId": user.AccountID}, nil
+		}
+		return map[string]interface{}{"name": user.Name}, nil
+	case "priority", "component", "version", "resolution":
+		return map[string]interface{}{"name": value}, nil
+	case "option":
+		return map[string]interface{}{"value": value}, nil
+	default:
+		return value, nil
+	}
+}
+
+// findCreateFieldUser finds the Jira user for a user field. "me" is the
+// user running the command, the assignee must be assignable in the project.
+func (p *Plugin) findCreateFieldUser(ji Instance, client Client, jiraUser JIRAUser, projectKey string, field createMetaField, query string) (*jira.User, error) {
+	if strings.EqualFold(query, "me") {
+		return &jiraUser.User, nil
+	}
+	if len(query) < MinUserSearchQueryLength {
+		return nil, errors.Errorf("`%s` contains less than %v characters", query, MinUserSearchQueryLength)
+	}
+
+	queryKey := "query"
+	if ji.GetType() == JIRATypeServer {
+		queryKey = "username"
+	}
+	params := map[string]string{
+		queryKey:     query,
+		"maxResults": "10",
+	}
+	endpoint := "2/user/search"
+	if field.Key == "assignee" {
+		endpoint = "2/user/assignable/search"
+		params["project"] = projectKey
+	}
+	users := []jira.User{}
+	err := client.RESTGet(endpoint, params, &users)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(users) {
+	case 0:
+		return nil, errors.Errorf("we couldn't find the user `%s`", query)
+	case 1:
+		return &users[0], nil
+	}
+	names := []string{}
+	for i, user := range users {
+		if strings.EqualFold(user.Name, query) || strings.EqualFold(user.EmailAddress, query) ||
+			strings.EqualFold(user.DisplayName, query) {
+			return &users[i], nil
+		}
+		names = append(names, user.DisplayName)
+	}
+	return nil, errors.Errorf("`%s` matches %d or more users, please specify a unique user: %s",
+		query, len(users), strings.Join(names, ", "))
+}
+
+// parseCreateArgs parses the arguments of /jira create. The summary is made
+// of the arguments that are not options, and the description of everything
+// after a standalone "--".
+func parseCreateArgs(text string) (createArgs, error) {
+	create := createArgs{
+		Fields: map[string]string{},
+	}
+	args, description, err := splitCreateArgs(text)
+	if err != nil {
+		return create, err
+	}
+	create.Description = description
+
+	summary := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg.quoted || !strings.HasPrefix(arg.text, "--") {
+			summary = append(summary, arg.text)
+			continue
+		}
+
+		name, value := strings.TrimPrefix(arg.text, "--"), ""
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value = name[:eq], name[eq+1:]
+		} else {
+			if i+1 >= len(args) {
+				return create, errors.Errorf("Missing value for `--%s`.", name)
+			}
+			i++
+			value = args[i].text
+		}
+		name = strings.ToLower(name)
+
+		switch name {
+		case "project":
+			create.Project = strings.ToUpper(value)
+		case "type":
+			create.IssueType = value
+		case "summary":
+			summary = append(summary, value)
+		case "description":
+			create.Description = value
+		case "field":
+			kv := strings.SplitN(value, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return create, errors.Errorf("Invalid field %q, please use `--field \"<field name>=<value>\"`.", value)
+			}
+			create.Fields[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		default:
+			key, ok := createFlagFields[name]
+			if !ok {
+				return create, errors.Errorf("Unknown option `--%s`.", name)
+			}
+			create.Fields[key] = value
+		}
+	}
+	create.Summary = strings.Join(summary, " ")
+
+	switch {
+	case create.Project == "":
+		return create, errors.New("Please specify a project with `--project`.")
+	case create.IssueType == "":
+		return create, errors.New("Please specify an issue type with `--type`.")
+	case create.Summary == "":
+		return create, errors.New("Please specify a summary.")
+	}
+	return create, nil
+}
+
+// createArg is an argument of /jira create. Quoted arguments are never
+// options.
+type createArg struct {
+	text   string
+	quoted bool
+}
+
+// splitCreateArgs splits the text in arguments, like a shell does with
+// single and double quotes, and returns the text after a standalone "--"
+// unchanged.
+func splitCreateArgs(text string) ([]createArg, string, error) {
+	args := []createArg{}
+	arg := strings.Builder{}
+	inArg := false
+	quoted := false
+	var quote rune
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == '\\' && quote == '"' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+				i++
+				arg.WriteRune(runes[i])
+			} else if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			// --type="New Feature" is still an option.
+			quoted = quoted || !inArg
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if !inArg {
+				continue
+			}
+			if arg.String() == "--" && !quoted {
+				return args, strings.TrimSpace(string(runes[i:])), nil
+			}
+			args = append(args, createArg{text: arg.String(), quoted: quoted})
+			arg.Reset()
+			inArg = false
+			quoted = false
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, "", errors.Errorf("Missing closing quote %c.", quote)
+	}
+	if inArg {
+		if arg.String() == "--" && !quoted {
+			return args, "", nil
+		}
+		args = append(args, createArg{text: arg.String(), quoted: quoted})
+	}
+	return args, "", nil
+}
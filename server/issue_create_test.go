@@ -0,0 +1,213 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trivago/tgo/tcontainer"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestParseCreateArgs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		text      string
+		expected  createArgs
+		expectErr string
+	}{
+		"all options": {
+			text: `--project mm --type Bug --priority High --labels a,b --assignee me "The summary" -- The *description*` + "\nsecond line",
+			expected: createArgs{
+				Project:     "MM",
+				IssueType:   "Bug",
+				Summary:     "The summary",
+				Description: "The *description*\nsecond line",
+				Fields: map[string]string{
+					"priority": "High",
+					"labels":   "a,b",
+					"assignee": "me",
+				},
+			},
+		},
+		"unquoted summary, equal signs and fields": {
+			text: `Fix the --project=MM build --type="New Feature" --field "Story Points=3" --field 'Team = Blue'`,
+			expected: createArgs{
+				Project:   "MM",
+				IssueType: "New Feature",
+				Summary:   "Fix the build",
+				Fields: map[string]string{
+					"Story Points": "3",
+					"Team":         "Blue",
+				},
+			},
+		},
+		"escaped quotes, quoted --": {
+			text: `--project MM --type Task "Say \"hi\"" "--"`,
+			expected: createArgs{
+				Project:   "MM",
+				IssueType: "Task",
+				Summary:   `Say "hi" --`,
+				Fields:    map[string]string{},
+			},
+		},
+		"missing project": {
+			text:      `--type Bug "Summary"`,
+			expectErr: "Please specify a project with `--project`.",
+		},
+		"missing summary": {
+			text:      `--project MM --type Bug -- description`,
+			expectErr: "Please specify a summary.",
+		},
+		"missing value": {
+			text:      `"Summary" --project MM --type`,
+			expectErr: "Missing value for `--type`.",
+		},
+		"unknown option": {
+			text:      `--project MM --type Bug --reporter me "Summary"`,
+			expectErr: "Unknown option `--reporter`.",
+		},
+		"invalid field": {
+			text:      `--project MM --type Bug --field Points "Summary"`,
+			expectErr: `Invalid field "Points", please use ` + "`--field \"<field name>=<value>\"`.",
+		},
+		"unclosed quote": {
+			text:      `--project MM --type Bug "Summary`,
+			expectErr: "Missing closing quote \".",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			create, err := parseCreateArgs(tc.text)
+			if tc.expectErr != "" {
+				require.NotNil(t, err)
+				assert.Equal(t, tc.expectErr, err.Error())
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.expected, create)
+		})
+	}
+}
+
+type createTestClient struct {
+	testClient
+	created *jira.Issue
+}
+
+func (client *createTestClient) GetCreateMeta(*jira.GetQueryOptions) (*jira.CreateMetaInfo, error) {
+	return &jira.CreateMetaInfo{
+		Projects: []*jira.MetaProject{{
+			Id:  "10000",
+			Key: "MM",
+			IssueTypes: []*jira.MetaIssueType{{
+				Id:   "1",
+				Name: "Bug",
+				Fields: tcontainer.MarshalMap{
+					"summary":   map[string]interface{}{"name": "Summary", "required": true, "schema": map[string]interface{}{"type": "string"}},
+					"issuetype": map[string]interface{}{"name": "Issue Type", "required": true, "schema": map[string]interface{}{"type": "issuetype"}},
+					"reporter":  map[string]interface{}{"name": "Reporter", "required": true, "schema": map[string]interface{}{"type": "user"}},
+					"priority": map[string]interface{}{"name": "Priority", "schema": map[string]interface{}{"type": "priority"},
+						"allowedValues": []interface{}{
+							map[string]interface{}{"id": "2", "name": "High"},
+							map[string]interface{}{"id": "3", "name": "Medium"},
+						}},
+					"labels":   map[string]interface{}{"name": "Labels", "schema": map[string]interface{}{"type": "array", "items": "string"}},
+					"assignee": map[string]interface{}{"name": "Assignee", "schema": map[string]interface{}{"type": "user"}},
+					"customfield_10100": map[string]interface{}{"name": "Story Points", "required": true,
+						"schema": map[string]interface{}{"type": "number"}},
+					"customfield_10200": map[string]interface{}{"name": "Severity", "required": true, "hasDefaultValue": true,
+						"schema": map[string]interface{}{"type": "option"}},
+				},
+			}},
+		}},
+	}, nil
+}
+
+func (client *createTestClient) CreateIssue(issue *jira.Issue) (*jira.Issue, error) {
+	client.created = issue
+	return nil, errors.New("not created in the test")
+}
+
+func TestCreateIssueFromCommand(t *testing.T) {
+	p := &Plugin{}
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+	jiraUser := JIRAUser{User: jira.User{AccountID: "account-id"}}
+	header := &model.CommandArgs{UserId: "userId", ChannelId: "channelId"}
+
+	for name, tc := range map[string]struct {
+		text          string
+		expectMessage string
+		expectErr     string
+		expectFields  tcontainer.MarshalMap
+	}{
+		"created": {
+			text:      `--project MM --type bug --priority high --labels a,b --assignee me --field "story points=3" "Summary"`,
+			expectErr: "failed to create issue: not created in the test",
+			expectFields: tcontainer.MarshalMap{
+				"priority":          map[string]interface{}{"id": "2"},
+				"labels":            []interface{}{"a", "b"},
+				"assignee":          map[string]interface{}{"accountId": "account-id"},
+				"customfield_10100": float64(3),
+			},
+		},
+		"unknown project": {
+			text:          `--project XX --type Bug "Summary"`,
+			expectMessage: "Project `XX` was not found, or you can not create issues in it.",
+		},
+		"unknown issue type": {
+			text:          `--project MM --type Epic "Summary"`,
+			expectMessage: `"Epic" is not an issue type of project MM. Please use one of: Bug.`,
+		},
+		"unknown field": {
+			text:          `--project MM --type Bug --components Server "Summary"`,
+			expectMessage: `Field "components" is not available for Bug issues in project MM.`,
+		},
+		"invalid priority": {
+			text:          `--project MM --type Bug --priority Low --field "Story Points=3" "Summary"`,
+			expectMessage: `Invalid value for Priority: "Low" is not allowed. Please use one of: High, Medium`,
+		},
+		"invalid number": {
+			text:          `--project MM --type Bug --field "Story Points=many" "Summary"`,
+			expectMessage: `Invalid value for Story Points: "many" is not a number`,
+		},
+		"missing required field": {
+			text: `--project MM --type Bug "Summary"`,
+			expectMessage: "[Please create your Jira issue manually](http://jiraTestInstanceURL.some/secure/CreateIssueDetails!init.jspa?description=&issuetype=1&pid=10000&summary=Summary). " +
+				"The project you tried to create an issue for has **required fields** this plugin does not yet support:\n" +
+				"- Story Points\n" +
+				"You can also set them with `--field \"<field name>=<value>\"`.",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			create, err := parseCreateArgs(tc.text)
+			require.Nil(t, err)
+			client := &createTestClient{}
+
+			msg, err := p.createIssueFromCommand(ji, client, jiraUser, header, create)
+			if tc.expectErr != "" {
+				require.NotNil(t, err)
+				assert.Equal(t, tc.expectErr, err.Error())
+			} else {
+				require.Nil(t, err)
+			}
+			assert.Equal(t, tc.expectMessage, msg)
+
+			if tc.expectFields == nil {
+				assert.Nil(t, client.created)
+				return
+			}
+			require.NotNil(t, client.created)
+			assert.Equal(t, "MM", client.created.Fields.Project.Key)
+			assert.Equal(t, "1", client.created.Fields.Type.ID)
+			assert.Equal(t, "Summary", client.created.Fields.Summary)
+			assert.Equal(t, tc.expectFields, client.created.Fields.Unknowns)
+		})
+	}
+}
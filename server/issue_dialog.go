@@ -0,0 +1,643 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/markdown"
+)
+
+// The interactive dialogs that act on an issue. They are opened by the slash
+// commands and the actions of the posts, so that they work on the mobile and
+// desktop apps without the webapp.
+const (
+	issueDialogComment    = "comment"
+	issueDialogAssign     = "assign"
+	issueDialogTransition = "transition"
+	issueDialogPriority   = "priority"
+)
+
+var issueDialogRoutes = map[string]string{
+	issueDialogComment:    routeAPIDialogComment,
+	issueDialogAssign:     routeAPIDialogAssign,
+	issueDialogTransition: routeAPIDialogTransition,
+	issueDialogPriority:   routeAPIDialogPriority,
+}
+
+const maxAssignableUserOptions = 20
+
+// issueDialogState is the state of the dialogs that act on an issue. PostId
+// is the post the dialog was opened from, if any. As the state is sent back
+// by the client, the post is checked before it is updated, see
+// updatePostActionField.
+type issueDialogState struct {
+	IssueKey string `json:"issue_key"`
+	PostId   string `json:"post_id,omitempty"`
+
+	// TransitionId is set when the transition dialog asks for the fields
+	// of a transition that was chosen already, Fields are the values that
+	// were given with it.
+	TransitionId string            `json:"transition_id,omitempty"`
+	Fields       map[string]string `json:"fields,omitempty"`
+}
+
+// issueDialogActions returns the menu of the posts that show an issue, which
+// opens the dialogs.
+func issueDialogActions(ji Instance, issueKey string) []*model.PostAction {
+	return []*model.PostAction{{
+		Name: "More actions",
+		Type: model.POST_ACTION_TYPE_SELECT,
+		Options: []*model.PostActionOptions{
+			{Text: "Add comment", Value: issueDialogComment},
+			{Text: "Assign", Value: issueDialogAssign},
+			{Text: "Transition", Value: issueDialogTransition},
+		},
+		Integration: &model.PostActionIntegration{
+			URL: pluginRouteURL(ji, routeAPIIssueDialog),
+			Context: map[string]interface{}{
+				"issueKey": issueKey,
+			},
+		},
+	}}
+}
+
+// openIssueDialog opens one of the dialogs that act on an issue.
+func (p *Plugin) openIssueDialog(ji Instance, client Client, name, triggerId string, state issueDialogState) error {
+	dialog, err := issueDialog(client, name, state)
+	if err != nil {
+		return err
+	}
+	appErr := p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: triggerId,
+		URL:       pluginRouteURL(ji, issueDialogRoutes[name]),
+		Dialog:    *dialog,
+	})
+	if appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func issueDialog(client Client, name string, state issueDialogState) (*model.Dialog, error) {
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	dialog := &model.Dialog{
+		CallbackId: name,
+		State:      string(stateBytes),
+	}
+
+	switch name {
+	case issueDialogComment:
+		dialog.Title = "Comment on " + state.IssueKey
+		dialog.SubmitLabel = "Comment"
+		dialog.Elements = []model.DialogElement{{
+			DisplayName: "Comment",
+			Name:        "comment",
+			Type:        "textarea",
+			MaxLength:   syncedCommentMaxLength,
+		}}
+		// The visibility is only offered when the roles can be read.
+		roles, err := getProjectRoleNames(client, issueProjectKey(state.IssueKey))
+		if err == nil && len(roles) > 0 {
+			options := []*model.PostActionOptions{}
+			for _, role := range roles {
+				options = append(options, &model.PostActionOptions{Text: role, Value: role})
+			}
+			dialog.Elements = append(dialog.Elements, model.DialogElement{
+				DisplayName: "Visible to",
+				Name:        "visibility",
+				Type:        "select",
+				Placeholder: "All users",
+				HelpText:    "Restrict the comment to the members of a project role.",
+				Optional:    true,
+				Options:     options,
+			})
+		}
+
+	case issueDialogAssign:
+		dialog.Title = "Assign " + state.IssueKey
+		dialog.SubmitLabel = "Assign"
+		users, err := client.SearchUsersAssignableToIssue(state.IssueKey, "", maxAssignableUserOptions)
+		if err == nil && len(users) > 0 {
+			options := []*model.PostActionOptions{}
+			for _, user := range users {
+				options = append(options, &model.PostActionOptions{Text: user.DisplayName, Value: jiraUserId(user)})
+			}
+			dialog.Elements = append(dialog.Elements, model.DialogElement{
+				DisplayName: "Assignee",
+				Name:        "assignee",
+				Type:        "select",
+				Optional:    true,
+				Options:     options,
+			})
+		}
+		dialog.Elements = append(dialog.Elements, model.DialogElement{
+			DisplayName: "Search for the assignee",
+			Name:        "search",
+			Type:        "text",
+			HelpText:    fmt.Sprintf("The name or email of the assignee, at least %v characters.", MinUserSearchQueryLength),
+			Optional:    true,
+		})
+
+	case issueDialogTransition:
+		transitions, err := client.GetTransitionsWithFields(state.IssueKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(transitions) == 0 {
+			return nil, RESTError{errors.New("no transitions"), http.StatusForbidden}
+		}
+		dialog.Title = "Transition " + state.IssueKey
+		dialog.SubmitLabel = "Transition"
+
+		if state.TransitionId != "" {
+			t := findTransitionMeta(transitions, state.TransitionId)
+			if t == nil {
+				return nil, errors.Errorf("transition %s is no longer available", state.TransitionId)
+			}
+			dialog.IntroductionText = fmt.Sprintf("Transitioning %s to **%s** requires these fields.", state.IssueKey, t.To.Name)
+			for _, field := range t.missingFields(state.Fields) {
+				if field.Key != transitionCommentField {
+					dialog.Elements = append(dialog.Elements, transitionFieldElement(field, false, ""))
+				}
+			}
+		} else {
+			options := []*model.PostActionOptions{}
+			for _, t := range transitions {
+				options = append(options, &model.PostActionOptions{Text: t.Name, Value: t.ID})
+			}
+			dialog.Elements = []model.DialogElement{{
+				DisplayName: "Transition",
+				Name:        "transition",
+				Type:        "select",
+				Options:     options,
+			}}
+
+			// The fields of all the transitions are shown, the ones
+			// required by the chosen transition are checked on submit.
+			fields := map[string]createMetaField{}
+			requiredBy := map[string][]string{}
+			for _, t := range transitions {
+				for _, field := range t.sortedFields() {
+					fields[field.Key] = field
+					if field.Required && !field.HasDefaultValue {
+						requiredBy[field.Key] = append(requiredBy[field.Key], t.Name)
+					}
+				}
+			}
+			keys := []string{}
+			for key := range fields {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if key == transitionCommentField {
+					continue
+				}
+				helpText := ""
+				if len(requiredBy[key]) > 0 {
+					helpText = "Required by: " + strings.Join(requiredBy[key], ", ")
+				}
+				dialog.Elements = append(dialog.Elements, transitionFieldElement(fields[key], true, helpText))
+			}
+		}
+		dialog.Elements = append(dialog.Elements, model.DialogElement{
+			DisplayName: "Comment",
+			Name:        transitionCommentField,
+			Type:        "textarea",
+			Optional:    true,
+			MaxLength:   syncedCommentMaxLength,
+		})
+
+	case issueDialogPriority:
+		priorities, err := client.GetPriorities()
+		if err != nil {
+			return nil, err
+		}
+		options := []*model.PostActionOptions{}
+		for _, priority := range priorities {
+			options = append(options, &model.PostActionOptions{Text: priority.Name, Value: priority.Name})
+		}
+		dialog.Title = "Change the priority of " + state.IssueKey
+		dialog.SubmitLabel = "Change"
+		dialog.Elements = []model.DialogElement{{
+			DisplayName: "Priority",
+			Name:        "priority",
+			Type:        "select",
+			Options:     options,
+		}}
+
+	default:
+		return nil, errors.Errorf("no dialog %q", name)
+	}
+	return dialog, nil
+}
+
+// transitionFieldElement returns the dialog element of a field of a
+// transition screen.
+func transitionFieldElement(field createMetaField, optional bool, helpText string) model.DialogElement {
+	element := model.DialogElement{
+		DisplayName: field.Name,
+		Name:        field.Key,
+		Type:        "text",
+		HelpText:    helpText,
+		Optional:    optional,
+	}
+	if len(field.AllowedValues) > 0 {
+		element.Type = "select"
+		for _, a := range field.AllowedValues {
+			name := a.Name
+			if name == "" {
+				name = a.Value
+			}
+			element.Options = append(element.Options, &model.PostActionOptions{Text: name, Value: a.Id})
+		}
+	} else if field.Schema.Type == "user" && helpText == "" {
+		element.HelpText = "The name or email of the user, or \"me\"."
+	}
+	return element
+}
+
+func findTransitionMeta(transitions []transitionMeta, id string) *transitionMeta {
+	for i := range transitions {
+		if transitions[i].ID == id {
+			return &transitions[i]
+		}
+	}
+	return nil
+}
+
+// issueProjectKey returns the key of the project of an issue.
+func issueProjectKey(issueKey string) string {
+	return strings.SplitN(issueKey, "-", 2)[0]
+}
+
+// getProjectRoleNames returns the sorted names of the roles of a project.
+func getProjectRoleNames(client Client, projectKey string) ([]string, error) {
+	roles := map[string]string{}
+	err := client.RESTGet("2/project/"+projectKey+"/role", nil, &roles)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// jiraUserId returns the ID that identifies a user in the options of the
+// dialogs: the account ID on Jira Cloud, and the username on Jira Server.
+func jiraUserId(user jira.User) string {
+	if user.AccountID != "" {
+		return user.AccountID
+	}
+	return user.Name
+}
+
+// httpAPIOpenIssueDialog handles the menu of the posts that show an issue.
+func httpAPIOpenIssueDialog(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
+	requestData := model.PostActionIntegrationRequestFromJson(r.Body)
+	if requestData == nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.New("Missing request data"))
+	}
+
+	plugin := ji.GetPlugin()
+	mattermostUserId, err := actionUserId(r, requestData.UserId)
+	if err != nil {
+		return respondErr(w, http.StatusUnauthorized, err)
+	}
+	replyf := func(format string, args ...interface{}) {
+		_ = plugin.API.SendEphemeralPost(mattermostUserId,
+			makePost(plugin.getUserID(), requestData.ChannelId, fmt.Sprintf(format, args...)))
+	}
+
+	issueKey, _ := requestData.Context["issueKey"].(string)
+	name, _ := requestData.Context["selected_option"].(string)
+	if issueDialogRoutes[name] == "" || issueKey == "" {
+		return respondErr(w, http.StatusBadRequest, errors.Errorf("invalid dialog %q", name))
+	}
+
+	jiraUser, err := plugin.userStore.LoadJIRAUser(ji, mattermostUserId)
+	if err != nil {
+		replyf("Your username is not connected to Jira. Please type `/jira connect`.")
+		return respondErr(w, http.StatusUnauthorized, err)
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		replyf("Failed to connect to Jira: %v", err)
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	err = plugin.openIssueDialog(ji, client, name, requestData.TriggerId, issueDialogState{IssueKey: issueKey})
+	if err != nil {
+		replyf("%s", issueDialogErrorMessage(name, issueKey, err))
+	}
+	return respondJSON(w, &model.PostActionIntegrationResponse{})
+}
+
+// issueDialogErrorMessage returns the message shown to the user when a
+// dialog failed to open or to act on an issue.
+func issueDialogErrorMessage(name, issueKey string, err error) string {
+	switch StatusCode(err) {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Sprintf("You do not have the appropriate permissions to %s %s. Please contact your Jira administrator.",
+			name, issueKey)
+	}
+	return fmt.Sprintf("Failed to %s %s: %v", name, issueKey, err)
+}
+
+// issueDialogSubmission is a submitted dialog, with the Jira user who
+// submitted it.
+type issueDialogSubmission struct {
+	*model.SubmitDialogRequest
+	State    issueDialogState
+	JiraUser JIRAUser
+	Client   Client
+}
+
+func (s issueDialogSubmission) value(name string) string {
+	v, _ := s.Submission[name].(string)
+	return strings.TrimSpace(v)
+}
+
+// updatePost shows the result of the dialog on the post it was opened from,
+// if any.
+func (s issueDialogSubmission) updatePost(ji Instance, title, value string) {
+	if s.State.PostId == "" {
+		return
+	}
+	ji.GetPlugin().updatePostActionField(s.ChannelId, s.State.PostId, title, value, false)
+}
+
+// httpAPIIssueDialog wraps the handlers of the dialogs that act on an issue.
+// The handlers return the response of the dialog, and a message for the user
+// when they succeed.
+func httpAPIIssueDialog(ji Instance, w http.ResponseWriter, r *http.Request,
+	submit func(ji Instance, s issueDialogSubmission) (*model.SubmitDialogResponse, string)) (int, error) {
+	request := model.SubmitDialogRequestFromJson(r.Body)
+	if request == nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.New("Missing request data"))
+	}
+	if request.Cancelled {
+		return http.StatusOK, nil
+	}
+
+	plugin := ji.GetPlugin()
+	_, err := actionUserId(r, request.UserId)
+	if err != nil {
+		return respondErr(w, http.StatusUnauthorized, err)
+	}
+	s := issueDialogSubmission{SubmitDialogRequest: request}
+	err = json.Unmarshal([]byte(request.State), &s.State)
+	if err != nil || s.State.IssueKey == "" {
+		return respondErr(w, http.StatusBadRequest, errors.New("invalid dialog state"))
+	}
+
+	s.JiraUser, err = plugin.userStore.LoadJIRAUser(ji, request.UserId)
+	if err != nil {
+		return respondJSON(w, &model.SubmitDialogResponse{
+			Error: "Your username is not connected to Jira. Please type `/jira connect`.",
+		})
+	}
+	s.Client, err = ji.GetClient(s.JiraUser)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	response, msg := submit(ji, s)
+	if msg != "" {
+		_ = plugin.API.SendEphemeralPost(request.UserId, makePost(plugin.getUserID(), request.ChannelId, msg))
+	}
+	return respondJSON(w, response)
+}
+
+func httpAPIDialogComment(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
+	return httpAPIIssueDialog(ji, w, r, submitCommentDialog)
+}
+
+func httpAPIDialogAssign(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
+	return httpAPIIssueDialog(ji, w, r, submitAssignDialog)
+}
+
+func httpAPIDialogTransition(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
+	return httpAPIIssueDialog(ji, w, r, submitTransitionDialog)
+}
+
+func httpAPIDialogPriority(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
+	return httpAPIIssueDialog(ji, w, r, submitPriorityDialog)
+}
+
+func submitCommentDialog(ji Instance, s issueDialogSubmission) (*model.SubmitDialogResponse, string) {
+	body := s.value("comment")
+	if body == "" {
+		return &model.SubmitDialogResponse{Errors: map[string]string{"comment": "Please write a comment."}}, ""
+	}
+	comment := &jira.Comment{Body: body}
+	if role := s.value("visibility"); role != "" {
+		comment.Visibility = jira.CommentVisibility{Type: "role", Value: role}
+	}
+
+	_, err := s.Client.AddComment(s.State.IssueKey, comment)
+	if err != nil {
+		return &model.SubmitDialogResponse{Error: issueDialogErrorMessage("comment on", s.State.IssueKey, err)}, ""
+	}
+
+	if s.State.PostId != "" {
+		commenter := s.JiraUser.DisplayName
+		if user, appErr := ji.GetPlugin().API.GetUser(s.UserId); appErr == nil {
+			commenter = "@" + user.Username
+		}
+		s.updatePost(ji, "Last comment", commenter+": "+markdown.Truncate(body, 200))
+	}
+	return &model.SubmitDialogResponse{}, fmt.Sprintf("Commented on [%s](%s/browse/%s).", s.State.IssueKey, ji.GetURL(), s.State.IssueKey)
+}
+
+func submitAssignDialog(ji Instance, s issueDialogSubmission) (*model.SubmitDialogResponse, string) {
+	issueKey := s.State.IssueKey
+	var user jira.User
+	switch search, assignee := s.value("search"), s.value("assignee"); {
+	case search != "":
+		if len(search) < MinUserSearchQueryLength {
+			return &model.SubmitDialogResponse{Errors: map[string]string{
+				"search": fmt.Sprintf("Please type at least %v characters.", MinUserSearchQueryLength),
+			}}, ""
+		}
+		users, err := s.Client.SearchUsersAssignableToIssue(issueKey, search, 10)
+		if err != nil {
+			return &model.SubmitDialogResponse{Error: issueDialogErrorMessage("assign", issueKey, err)}, ""
+		}
+		switch len(users) {
+		case 0:
+			return &model.SubmitDialogResponse{Errors: map[string]string{
+				"search": fmt.Sprintf("No user that can be assigned to %s matches %q.", issueKey, search),
+			}}, ""
+		case 1:
+			user = users[0]
+		default:
+			names := []string{}
+			for _, u := range users {
+				names = append(names, u.DisplayName)
+			}
+			return &model.SubmitDialogResponse{Errors: map[string]string{
+				"search": fmt.Sprintf("%q matches several users: %s. Please be more specific.", search, strings.Join(names, ", ")),
+			}}, ""
+		}
+
+	case assignee != "":
+		if typ := ji.GetType(); typ == JIRATypeCloud || typ == JIRATypeCloudOAuth {
+			user.AccountID = assignee
+		} else {
+			user.Name = assignee
+		}
+
+	default:
+		return &model.SubmitDialogResponse{Error: "Please select the assignee, or search for one."}, ""
+	}
+
+	// From Jira error: query parameters 'accountId' and 'username' are mutually exclusive.
+	if user.AccountID != "" {
+		user.Name = ""
+	}
+	err := s.Client.UpdateAssignee(issueKey, &user)
+	if err != nil {
+		return &model.SubmitDialogResponse{Error: issueDialogErrorMessage("assign", issueKey, err)}, ""
+	}
+
+	name := user.DisplayName
+	if name == "" {
+		// The user was selected from the options, get the name back.
+		issue, err := s.Client.GetIssue(issueKey, &jira.GetQueryOptions{Fields: "assignee"})
+		if err == nil && issue.Fields != nil && issue.Fields.Assignee != nil {
+			name = issue.Fields.Assignee.DisplayName
+		}
+	}
+	if name != "" {
+		s.updatePost(ji, "Assignee", name)
+	}
+	return &model.SubmitDialogResponse{}, fmt.Sprintf("`%s` assigned to Jira issue [%s](%s/browse/%s)", name, issueKey, ji.GetURL(), issueKey)
+}
+
+func submitTransitionDialog(ji Instance, s issueDialogSubmission) (*model.SubmitDialogResponse, string) {
+	issueKey := s.State.IssueKey
+	transitionId := s.State.TransitionId
+	if transitionId == "" {
+		transitionId = s.value("transition")
+	}
+	transitions, err := s.Client.GetTransitionsWithFields(issueKey)
+	if err != nil {
+		return &model.SubmitDialogResponse{Error: issueDialogErrorMessage("transition", issueKey, err)}, ""
+	}
+	t := findTransitionMeta(transitions, transitionId)
+	if t == nil {
+		return &model.SubmitDialogResponse{Error: "This transition is no longer available."}, ""
+	}
+
+	values := map[string]string{}
+	for name, value := range s.State.Fields {
+		values[name] = value
+	}
+	for _, field := range t.sortedFields() {
+		if v := s.value(field.Key); v != "" {
+			values[field.Key] = v
+		}
+	}
+	if comment := s.value(transitionCommentField); comment != "" {
+		values[transitionCommentField] = comment
+	}
+	missing := t.missingFields(values)
+	if len(missing) > 0 {
+		errs := map[string]string{}
+		for _, field := range missing {
+			errs[field.Key] = fmt.Sprintf("Required to transition to %s.", t.To.Name)
+		}
+		return &model.SubmitDialogResponse{Errors: errs}, ""
+	}
+
+	payload, err := ji.GetPlugin().transitionPayload(ji, s.Client, s.JiraUser, issueKey, *t, values)
+	if err != nil {
+		return &model.SubmitDialogResponse{Error: err.Error()}, ""
+	}
+	err = s.Client.DoTransitionWithPayload(issueKey, payload)
+	if err != nil {
+		return &model.SubmitDialogResponse{Error: issueDialogErrorMessage("transition", issueKey, err)}, ""
+	}
+
+	s.updatePost(ji, "Status", t.To.Name)
+	return &model.SubmitDialogResponse{}, fmt.Sprintf("[%s](%v/browse/%v) transitioned to `%s`",
+		issueKey, ji.GetURL(), issueKey, t.To.Name)
+}
+
+func submitPriorityDialog(ji Instance, s issueDialogSubmission) (*model.SubmitDialogResponse, string) {
+	priority := s.value("priority")
+	if priority == "" {
+		return &model.SubmitDialogResponse{Errors: map[string]string{"priority": "Please select a priority."}}, ""
+	}
+	err := s.Client.UpdateIssue(s.State.IssueKey, map[string]interface{}{
+		"fields": map[string]interface{}{
+			"priority": map[string]interface{}{"name": priority},
+		},
+	})
+	if err != nil {
+		return &model.SubmitDialogResponse{Error: issueDialogErrorMessage("change the priority of", s.State.IssueKey, err)}, ""
+	}
+
+	s.updatePost(ji, "Priority", priority)
+	return &model.SubmitDialogResponse{}, ""
+}
+
+// openTransitionFieldsDialog asks for the fields that a transition requires.
+// fields are the values that were given with the transition.
+func (p *Plugin) openTransitionFieldsDialog(ji Instance, jiraUser JIRAUser, triggerId string, fieldsErr *transitionFieldsError, fields map[string]string) error {
+	if triggerId == "" {
+		return errors.New("no trigger id to open the dialog")
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		return err
+	}
+	return p.openIssueDialog(ji, client, issueDialogTransition, triggerId, issueDialogState{
+		IssueKey:     fieldsErr.IssueKey,
+		TransitionId: fieldsErr.Transition.ID,
+		Fields:       fields,
+	})
+}
+
+// openIssueDialogFromCommand opens a dialog for the commands that were given
+// an issue key only.
+func (p *Plugin) openIssueDialogFromCommand(header *model.CommandArgs, name, issueKey string) *model.CommandResponse {
+	ji, resp := p.loadCommandInstance(header)
+	if resp != nil {
+		return resp
+	}
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, header.UserId)
+	if err != nil {
+		return p.responsef(header, "Your username is not connected to Jira. Please type `/jira connect`.")
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+
+	err = p.openIssueDialog(ji, client, name, header.TriggerId, issueDialogState{IssueKey: issueKey})
+	if err != nil {
+		return p.responsef(header, "%s", issueDialogErrorMessage(name, issueKey, err))
+	}
+	return &model.CommandResponse{}
+}
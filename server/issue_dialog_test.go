@@ -0,0 +1,374 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+type dialogTestClient struct {
+	testClient
+	assigned *jira.User
+	payload  map[string]interface{}
+}
+
+func (client *dialogTestClient) RESTGet(endpoint string, params map[string]string, dest interface{}) error {
+	var data interface{}
+	switch endpoint {
+	case "2/project/REAL/role":
+		data = map[string]string{
+			"Developers":     mockCurrentInstanceURL + "/rest/api/2/project/REAL/role/10001",
+			"Administrators": mockCurrentInstanceURL + "/rest/api/2/project/REAL/role/10002",
+		}
+	default:
+		return errors.New("not found")
+	}
+	b, _ := json.Marshal(data)
+	return json.Unmarshal(b, dest)
+}
+
+func (client *dialogTestClient) SearchUsersAssignableToIssue(issueKey, query string, maxResults int) ([]jira.User, error) {
+	users := []jira.User{}
+	for _, user := range []jira.User{
+		{Name: "jsmith", DisplayName: "John Smith"},
+		{Name: "jdoe", DisplayName: "Jane Doe"},
+		{Name: "rdoe", DisplayName: "Richard Doe"},
+	} {
+		if strings.Contains(user.DisplayName, query) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (client *dialogTestClient) UpdateAssignee(issueKey string, user *jira.User) error {
+	client.assigned = user
+	return nil
+}
+
+func (client *dialogTestClient) GetIssue(key string, options *jira.GetQueryOptions) (*jira.Issue, error) {
+	return &jira.Issue{Key: key, Fields: &jira.IssueFields{Assignee: &jira.User{DisplayName: "Jane Doe"}}}, nil
+}
+
+func (client *dialogTestClient) GetTransitionsWithFields(issueKey string) ([]transitionMeta, error) {
+	resolution := createMetaField{}
+	_ = json.Unmarshal([]byte(`{"name": "Resolution", "required": true, "schema": {"type": "resolution"},
+		"allowedValues": [{"id": "1", "name": "Done"}, {"id": "2", "name": "Won't Do"}]}`), &resolution)
+	return []transitionMeta{
+		{ID: "11", Name: "Start", To: jira.Status{Name: "In Progress"}},
+		{ID: "21", Name: "Resolve", To: jira.Status{Name: "Done"}, Fields: map[string]createMetaField{
+			"resolution": resolution,
+			"comment":    {Name: "Comment"},
+		}},
+	}, nil
+}
+
+func (client *dialogTestClient) DoTransitionWithPayload(issueKey string, payload map[string]interface{}) error {
+	client.payload = payload
+	return nil
+}
+
+func TestIssueDialog(t *testing.T) {
+	for name, tc := range map[string]struct {
+		dialog         string
+		transitionId   string
+		expectElements []string
+		expectOptions  map[string][]string
+	}{
+		"comment": {
+			dialog:         issueDialogComment,
+			expectElements: []string{"comment", "visibility"},
+			expectOptions:  map[string][]string{"visibility": {"Administrators", "Developers"}},
+		},
+		"assign": {
+			dialog:         issueDialogAssign,
+			expectElements: []string{"assignee", "search"},
+			expectOptions:  map[string][]string{"assignee": {"jsmith", "jdoe", "rdoe"}},
+		},
+		"transition": {
+			dialog:         issueDialogTransition,
+			expectElements: []string{"transition", "resolution", "comment"},
+			expectOptions:  map[string][]string{"transition": {"11", "21"}, "resolution": {"1", "2"}},
+		},
+		"fields of a transition": {
+			dialog:         issueDialogTransition,
+			transitionId:   "21",
+			expectElements: []string{"resolution", "comment"},
+			expectOptions:  map[string][]string{"resolution": {"1", "2"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			state := issueDialogState{IssueKey: "REAL-1", PostId: "postId", TransitionId: tc.transitionId}
+			dialog, err := issueDialog(&dialogTestClient{}, tc.dialog, state)
+			require.Nil(t, err)
+			assert.Equal(t, tc.dialog, dialog.CallbackId)
+			stateBytes, _ := json.Marshal(state)
+			assert.Equal(t, string(stateBytes), dialog.State)
+
+			elements := []string{}
+			for _, element := range dialog.Elements {
+				elements = append(elements, element.Name)
+				if element.Type != "select" {
+					continue
+				}
+				values := []string{}
+				for _, option := range element.Options {
+					values = append(values, option.Value)
+				}
+				assert.Equal(t, tc.expectOptions[element.Name], values)
+			}
+			assert.Equal(t, tc.expectElements, elements)
+		})
+	}
+}
+
+func TestIssueDialogForgedUser(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	p.userStore = mockUserStore{}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	open := &model.PostActionIntegrationRequest{
+		UserId: "userId",
+		Context: map[string]interface{}{
+			"issueKey":        "REAL-1",
+			"selected_option": issueDialogComment,
+		},
+	}
+	submit := &model.SubmitDialogRequest{
+		UserId:     "userId",
+		CallbackId: issueDialogComment,
+		State:      `{"issue_key": "REAL-1"}`,
+		Submission: map[string]interface{}{"comment": "Forged"},
+	}
+	for name, tc := range map[string]struct {
+		handler func(ji Instance, w http.ResponseWriter, r *http.Request) (int, error)
+		body    []byte
+		header  string
+	}{
+		"open, no header":        {handler: httpAPIOpenIssueDialog, body: open.ToJson()},
+		"open, another user":     {handler: httpAPIOpenIssueDialog, body: open.ToJson(), header: "attackerId"},
+		"submit, no header":      {handler: httpAPIDialogComment, body: submit.ToJson()},
+		"submit, another user":   {handler: httpAPIDialogComment, body: submit.ToJson(), header: "attackerId"},
+		"priority, another user": {handler: httpAPIDialogPriority, body: submit.ToJson(), header: "attackerId"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, routeAPIDialogComment, bytes.NewReader(tc.body))
+			if tc.header != "" {
+				r.Header.Set("Mattermost-User-Id", tc.header)
+			}
+			w := httptest.NewRecorder()
+			status, err := tc.handler(ji, w, r)
+			assert.NotNil(t, err)
+			assert.Equal(t, http.StatusUnauthorized, status)
+		})
+	}
+}
+
+func TestSubmitAssignDialog(t *testing.T) {
+	p := &Plugin{}
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	for name, tc := range map[string]struct {
+		submission     map[string]interface{}
+		expectResponse model.SubmitDialogResponse
+		expectMessage  string
+		expectAssigned *jira.User
+	}{
+		"search": {
+			submission:     map[string]interface{}{"search": "John Smith"},
+			expectMessage:  "`John Smith` assigned to Jira issue [REAL-1](http://jiraTestInstanceURL.some/browse/REAL-1)",
+			expectAssigned: &jira.User{Name: "jsmith", DisplayName: "John Smith"},
+		},
+		"search overrides the selection": {
+			submission:     map[string]interface{}{"search": "John", "assignee": "jdoe"},
+			expectMessage:  "`John Smith` assigned to Jira issue [REAL-1](http://jiraTestInstanceURL.some/browse/REAL-1)",
+			expectAssigned: &jira.User{Name: "jsmith", DisplayName: "John Smith"},
+		},
+		"selected": {
+			submission:     map[string]interface{}{"assignee": "jdoe"},
+			expectMessage:  "`Jane Doe` assigned to Jira issue [REAL-1](http://jiraTestInstanceURL.some/browse/REAL-1)",
+			expectAssigned: &jira.User{Name: "jdoe"},
+		},
+		"search too short": {
+			submission:     map[string]interface{}{"search": "Jo"},
+			expectResponse: model.SubmitDialogResponse{Errors: map[string]string{"search": "Please type at least 3 characters."}},
+		},
+		"several matches": {
+			submission: map[string]interface{}{"search": "Doe"},
+			expectResponse: model.SubmitDialogResponse{Errors: map[string]string{
+				"search": `"Doe" matches several users: Jane Doe, Richard Doe. Please be more specific.`,
+			}},
+		},
+		"no match": {
+			submission: map[string]interface{}{"search": "Nobody"},
+			expectResponse: model.SubmitDialogResponse{Errors: map[string]string{
+				"search": `No user that can be assigned to REAL-1 matches "Nobody".`,
+			}},
+		},
+		"nothing": {
+			submission:     map[string]interface{}{},
+			expectResponse: model.SubmitDialogResponse{Error: "Please select the assignee, or search for one."},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &dialogTestClient{}
+			response, msg := submitAssignDialog(ji, issueDialogSubmission{
+				SubmitDialogRequest: &model.SubmitDialogRequest{Submission: tc.submission},
+				State:               issueDialogState{IssueKey: "REAL-1"},
+				Client:              client,
+			})
+			assert.Equal(t, tc.expectResponse, *response)
+			assert.Equal(t, tc.expectMessage, msg)
+			assert.Equal(t, tc.expectAssigned, client.assigned)
+		})
+	}
+}
+
+func TestSubmitAssignDialogUpdatesPost(t *testing.T) {
+	for name, tc := range map[string]struct {
+		post         *model.Post
+		expectUpdate bool
+	}{
+		"post of the bot": {
+			post:         &model.Post{Id: "postId", UserId: "botUserId", ChannelId: "channelId"},
+			expectUpdate: true,
+		},
+		"post of the bot in another channel": {
+			post: &model.Post{Id: "postId", UserId: "botUserId", ChannelId: "otherChannelId"},
+		},
+		"post of another user": {
+			post: &model.Post{Id: "postId", UserId: "otherUserId", ChannelId: "channelId"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.updateConfig(func(conf *config) {
+				conf.botUserID = "botUserId"
+			})
+			p.currentInstanceStore = mockCurrentInstanceStore{p}
+			ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+			require.Nil(t, err)
+
+			model.ParseSlackAttachment(tc.post, []*model.SlackAttachment{{Pretext: "Test User created an issue"}})
+			api.On("GetPost", "postId").Return(tc.post, nil)
+			api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(tc.post, nil)
+			api.On("LogError", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+
+			response, _ := submitAssignDialog(ji, issueDialogSubmission{
+				SubmitDialogRequest: &model.SubmitDialogRequest{
+					ChannelId:  "channelId",
+					Submission: map[string]interface{}{"search": "John Smith"},
+				},
+				State:  issueDialogState{IssueKey: "REAL-1", PostId: "postId"},
+				Client: &dialogTestClient{},
+			})
+			assert.Equal(t, model.SubmitDialogResponse{}, *response)
+			if tc.expectUpdate {
+				api.AssertCalled(t, "UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+					fields := post.Attachments()[0].Fields
+					return len(fields) == 1 && fields[0].Title == "Assignee" && fields[0].Value == "John Smith"
+				}))
+			} else {
+				api.AssertNotCalled(t, "UpdatePost", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestSubmitTransitionDialog(t *testing.T) {
+	p := &Plugin{}
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	for name, tc := range map[string]struct {
+		state          issueDialogState
+		submission     map[string]interface{}
+		expectResponse model.SubmitDialogResponse
+		expectMessage  string
+		expectPayload  map[string]interface{}
+	}{
+		"transition": {
+			submission:    map[string]interface{}{"transition": "11"},
+			expectMessage: "[REAL-1](http://jiraTestInstanceURL.some/browse/REAL-1) transitioned to `In Progress`",
+			expectPayload: map[string]interface{}{
+				"transition": map[string]interface{}{"id": "11"},
+			},
+		},
+		"with resolution and comment": {
+			submission:    map[string]interface{}{"transition": "21", "resolution": "2", "comment": "Not needed"},
+			expectMessage: "[REAL-1](http://jiraTestInstanceURL.some/browse/REAL-1) transitioned to `Done`",
+			expectPayload: map[string]interface{}{
+				"transition": map[string]interface{}{"id": "21"},
+				"fields": map[string]interface{}{
+					"resolution": map[string]interface{}{"id": "2"},
+				},
+				"update": map[string]interface{}{
+					"comment": []interface{}{
+						map[string]interface{}{"add": map[string]interface{}{"body": "Not needed"}},
+					},
+				},
+			},
+		},
+		"missing resolution": {
+			submission:     map[string]interface{}{"transition": "21"},
+			expectResponse: model.SubmitDialogResponse{Errors: map[string]string{"resolution": "Required to transition to Done."}},
+		},
+		"fields given with the command": {
+			state:         issueDialogState{TransitionId: "21", Fields: map[string]string{"comment": "Fixed"}},
+			submission:    map[string]interface{}{"resolution": "1"},
+			expectMessage: "[REAL-1](http://jiraTestInstanceURL.some/browse/REAL-1) transitioned to `Done`",
+			expectPayload: map[string]interface{}{
+				"transition": map[string]interface{}{"id": "21"},
+				"fields": map[string]interface{}{
+					"resolution": map[string]interface{}{"id": "1"},
+				},
+				"update": map[string]interface{}{
+					"comment": []interface{}{
+						map[string]interface{}{"add": map[string]interface{}{"body": "Fixed"}},
+					},
+				},
+			},
+		},
+		"unavailable transition": {
+			submission:     map[string]interface{}{"transition": "31"},
+			expectResponse: model.SubmitDialogResponse{Error: "This transition is no longer available."},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &dialogTestClient{}
+			tc.state.IssueKey = "REAL-1"
+			response, msg := submitTransitionDialog(ji, issueDialogSubmission{
+				SubmitDialogRequest: &model.SubmitDialogRequest{Submission: tc.submission},
+				State:               tc.state,
+				Client:              client,
+			})
+			assert.Equal(t, tc.expectResponse, *response)
+			assert.Equal(t, tc.expectMessage, msg)
+			assert.Equal(t, tc.expectPayload, client.payload)
+		})
+	}
+}
@@ -5,19 +5,14 @@ package main
 
 import (
 	"fmt"
-	"regexp"
 	"strings"
 
 	jira "github.com/andygrunwald/go-jira"
 
 	"github.com/mattermost/mattermost-server/v5/model"
-)
-
-var jiraLinkWithTextRegex = regexp.MustCompile(`\[([^\[]+)\|([^\]]+)\]`)
 
-func parseJiraLinksToMarkdown(text string) string {
-	return jiraLinkWithTextRegex.ReplaceAllString(text, "[${1}](${2})")
-}
+	"github.com/mattermost/mattermost-plugin-jira/server/markdown"
+)
 
 func mdKeySummaryLink(issue *jira.Issue) string {
 	// Use Self URL only to extract the full hostname from it
@@ -34,7 +29,7 @@ func reporterSummary(issue *jira.Issue) string {
 	return reporterSummary
 }
 
-func getTransitionActions(client Client, issue *jira.Issue) ([]*model.PostAction, error) {
+func getTransitionActions(ji Instance, client Client, issue *jira.Issue) ([]*model.PostAction, error) {
 	var actions []*model.PostAction
 
 	ctx := map[string]interface{}{
@@ -42,7 +37,7 @@ func getTransitionActions(client Client, issue *jira.Issue) ([]*model.PostAction
 	}
 
 	integration := &model.PostActionIntegration{
-		URL:     fmt.Sprintf("/plugins/%s%s", manifest.Id, routeIssueTransition),
+		URL:     pluginRouteURL(ji, routeIssueTransition),
 		Context: ctx,
 	}
 
@@ -73,10 +68,9 @@ func getTransitionActions(client Client, issue *jira.Issue) ([]*model.PostAction
 	return actions, nil
 }
 
-func parseIssue(client Client, issue *jira.Issue) ([]*model.SlackAttachment, error) {
+func parseIssue(ji Instance, client Client, issue *jira.Issue) ([]*model.SlackAttachment, error) {
 	text := mdKeySummaryLink(issue)
-	desc := truncate(issue.Fields.Description, 3000)
-	desc = parseJiraLinksToMarkdown(desc)
+	desc := markdown.Truncate(markdown.FromJira(issue.Fields.Description), 3000)
 	if desc != "" {
 		text += "\n\n" + desc + "\n"
 	}
@@ -103,10 +97,11 @@ func parseIssue(client Client, issue *jira.Issue) ([]*model.SlackAttachment, err
 		Short: true,
 	})
 
-	actions, err := getTransitionActions(client, issue)
+	actions, err := getTransitionActions(ji, client, issue)
 	if err != nil {
 		return []*model.SlackAttachment{}, err
 	}
+	actions = append(actions, issueDialogActions(ji, issue.Key)...)
 
 	return []*model.SlackAttachment{
 		{
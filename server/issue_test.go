@@ -15,6 +15,7 @@ import (
 	"github.com/mattermost/mattermost-server/v5/plugin/plugintest/mock"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -42,6 +43,13 @@ func (client testClient) GetProject(key string) (*jira.Project, error) {
 	return nil, nil
 }
 
+func (client testClient) SearchIssues(jql string, options *jira.SearchOptions) ([]jira.Issue, error) {
+	if strings.Contains(jql, nonExistantProjectKey) {
+		return nil, errors.New("The value '" + nonExistantProjectKey + "' does not exist for the field 'project'.")
+	}
+	return nil, nil
+}
+
 func (client testClient) GetTransitions(issueKey string) ([]jira.Transition, error) {
 	if issueKey == nonExistantIssueKey {
 		return []jira.Transition{}, errors.New(noIssueFoundError)
@@ -56,6 +64,15 @@ func (client testClient) GetTransitions(issueKey string) ([]jira.Transition, err
 	}, nil
 }
 
+func (client testClient) GetTransitionsWithFields(issueKey string) ([]transitionMeta, error) {
+	transitions, err := client.GetTransitions(issueKey)
+	metas := []transitionMeta{}
+	for _, t := range transitions {
+		metas = append(metas, transitionMeta{ID: t.ID, Name: t.Name, To: t.To})
+	}
+	return metas, err
+}
+
 func (client testClient) DoTransition(issueKey string, transitionID string) error {
 	return nil
 }
@@ -74,6 +91,8 @@ func TestTransitionJiraIssue(t *testing.T) {
 	p := Plugin{}
 	p.userStore = getMockUserStoreKV()
 	p.currentInstanceStore = mockCurrentInstanceStore{&p}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
 
 	tests := map[string]struct {
 		issueKey    string
@@ -115,7 +134,7 @@ func TestTransitionJiraIssue(t *testing.T) {
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			actual, err := p.transitionJiraIssue("connected_user", tt.issueKey, tt.toState)
+			actual, err := p.transitionJiraIssue(ji, "connected_user", tt.issueKey, tt.toState, nil)
 			assert.Equal(t, tt.expectedMsg, actual)
 			if tt.expectedErr != nil {
 				assert.Error(t, tt.expectedErr, err)
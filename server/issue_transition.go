@@ -0,0 +1,178 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+)
+
+// transitionMeta is a transition of an issue, with the fields of its screen,
+// as returned with expand=transitions.fields.
+type transitionMeta struct {
+	ID     string                     `json:"id"`
+	Name   string                     `json:"name"`
+	To     jira.Status                `json:"to"`
+	Fields map[string]createMetaField `json:"fields"`
+}
+
+// transitionCommentField is the key of the comment on a transition screen.
+// It is set with the update of the transition, not as a field.
+const transitionCommentField = "comment"
+
+// sortedFields returns the fields of the screen of the transition, sorted by
+// key.
+func (t transitionMeta) sortedFields() []createMetaField {
+	fields := []createMetaField{}
+	for key, field := range t.Fields {
+		field.Key = key
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	return fields
+}
+
+// missingFields returns the required fields of the transition that have no
+// value, values are by field key or name.
+func (t transitionMeta) missingFields(values map[string]string) []createMetaField {
+	missing := []createMetaField{}
+	for _, field := range t.sortedFields() {
+		if !field.Required || field.HasDefaultValue {
+			continue
+		}
+		found := false
+		for name, value := range values {
+			if value != "" && (name == field.Key || strings.EqualFold(name, field.Name)) {
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, field)
+		}
+	}
+	return missing
+}
+
+// transitionFieldsError is returned when a transition requires fields that
+// were not given.
+type transitionFieldsError struct {
+	IssueKey   string
+	Transition transitionMeta
+	Missing    []createMetaField
+}
+
+func (e *transitionFieldsError) Error() string {
+	names := []string{}
+	for _, field := range e.Missing {
+		names = append(names, field.Name)
+	}
+	return fmt.Sprintf("Transitioning %s to `%s` requires: %s. Please set them with `--field \"<field name>=<value>\"`.",
+		e.IssueKey, e.Transition.To.Name, strings.Join(names, ", "))
+}
+
+// transitionPayload returns the body of the transition request, with the
+// values of the fields converted according to the transition metadata.
+func (p *Plugin) transitionPayload(ji Instance, client Client, jiraUser JIRAUser, issueKey string, t transitionMeta, values map[string]string) (map[string]interface{}, error) {
+	payload := map[string]interface{}{
+		"transition": map[string]interface{}{"id": t.ID},
+	}
+	fields := t.sortedFields()
+	fieldValues := map[string]interface{}{}
+	for name, value := range values {
+		if value == "" {
+			continue
+		}
+		if name == transitionCommentField {
+			payload["update"] = map[string]interface{}{
+				"comment": []interface{}{
+					map[string]interface{}{"add": map[string]interface{}{"body": value}},
+				},
+			}
+			continue
+		}
+
+		field := findCreateMetaField(fields, name)
+		if field == nil {
+			return nil, errors.Errorf("Field %q is not on the screen of the transition to `%s`.", name, t.To.Name)
+		}
+		v, err := p.createFieldValue(ji, client, jiraUser, issueProjectKey(issueKey), *field, value)
+		if err != nil {
+			return nil, errors.Errorf("Invalid value for %s: %v", field.Name, err)
+		}
+		fieldValues[field.Key] = v
+	}
+	if len(fieldValues) > 0 {
+		payload["fields"] = fieldValues
+	}
+	return payload, nil
+}
+
+// transitionArgs are the parsed arguments of /jira transition.
+type transitionArgs struct {
+	IssueKey string
+	ToState  string
+
+	// Fields are the fields of the transition screen, by field name or
+	// key, and the comment.
+	Fields map[string]string
+}
+
+// parseTransitionArgs parses the arguments of /jira transition. The state is
+// made of the arguments that are not options. "--field <name>=<value>" and
+// "--<name> <value>" set the fields of the transition screen, and everything
+// after a standalone "--" is the comment.
+func parseTransitionArgs(text string) (transitionArgs, error) {
+	transition := transitionArgs{
+		Fields: map[string]string{},
+	}
+	args, rest, err := splitCreateArgs(text)
+	if err != nil {
+		return transition, err
+	}
+	if rest != "" {
+		transition.Fields[transitionCommentField] = rest
+	}
+
+	state := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg.quoted || !strings.HasPrefix(arg.text, "--") {
+			if transition.IssueKey == "" {
+				transition.IssueKey = strings.ToUpper(arg.text)
+			} else {
+				state = append(state, arg.text)
+			}
+			continue
+		}
+
+		name, value := strings.TrimPrefix(arg.text, "--"), ""
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value = name[:eq], name[eq+1:]
+		} else {
+			if i+1 >= len(args) {
+				return transition, errors.Errorf("Missing value for `--%s`.", name)
+			}
+			i++
+			value = args[i].text
+		}
+
+		if strings.ToLower(name) == "field" {
+			kv := strings.SplitN(value, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return transition, errors.Errorf("Invalid field %q, please use `--field \"<field name>=<value>\"`.", value)
+			}
+			name, value = strings.TrimSpace(kv[0]), kv[1]
+		}
+		if strings.EqualFold(name, transitionCommentField) {
+			name = transitionCommentField
+		}
+		transition.Fields[name] = strings.TrimSpace(value)
+	}
+	transition.ToState = strings.Join(state, " ")
+	return transition, nil
+}
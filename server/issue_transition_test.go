@@ -0,0 +1,92 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTransitionArgs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		text      string
+		expected  transitionArgs
+		expectErr string
+	}{
+		"state only": {
+			text:     `mm-1 in progress`,
+			expected: transitionArgs{IssueKey: "MM-1", ToState: "in progress", Fields: map[string]string{}},
+		},
+		"fields and comment": {
+			text: `MM-1 done --resolution "Won't Do" --field "Fix Version/s=5.20" -- Not needed` + "\nanymore",
+			expected: transitionArgs{
+				IssueKey: "MM-1",
+				ToState:  "done",
+				Fields: map[string]string{
+					"resolution":    "Won't Do",
+					"Fix Version/s": "5.20",
+					"comment":       "Not needed\nanymore",
+				},
+			},
+		},
+		"comment option": {
+			text: `MM-1 --Comment=Fixed done`,
+			expected: transitionArgs{
+				IssueKey: "MM-1",
+				ToState:  "done",
+				Fields:   map[string]string{"comment": "Fixed"},
+			},
+		},
+		"missing value": {
+			text:      `MM-1 done --resolution`,
+			expectErr: "Missing value for `--resolution`.",
+		},
+		"invalid field": {
+			text:      `MM-1 done --field Resolution`,
+			expectErr: `Invalid field "Resolution", please use ` + "`--field \"<field name>=<value>\"`.",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			transition, err := parseTransitionArgs(tc.text)
+			if tc.expectErr != "" {
+				require.NotNil(t, err)
+				assert.Equal(t, tc.expectErr, err.Error())
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.expected, transition)
+		})
+	}
+}
+
+func TestTransitionMissingFields(t *testing.T) {
+	transition := transitionMeta{
+		ID: "21",
+		To: jira.Status{Name: "Done"},
+		Fields: map[string]createMetaField{
+			"resolution":        {Name: "Resolution", Required: true},
+			"fixVersions":       {Name: "Fix Version/s"},
+			"customfield_10100": {Name: "Root Cause", Required: true},
+			"customfield_10200": {Name: "Team", Required: true, HasDefaultValue: true},
+		},
+	}
+
+	missing := transition.missingFields(nil)
+	require.Len(t, missing, 2)
+	assert.Equal(t, "customfield_10100", missing[0].Key)
+	assert.Equal(t, "resolution", missing[1].Key)
+
+	missing = transition.missingFields(map[string]string{"root cause": "Typo", "resolution": ""})
+	require.Len(t, missing, 1)
+	assert.Equal(t, "resolution", missing[0].Key)
+
+	err := &transitionFieldsError{IssueKey: "MM-1", Transition: transition, Missing: missing}
+	assert.Equal(t, "Transitioning MM-1 to `Done` requires: Resolution. Please set them with `--field \"<field name>=<value>\"`.",
+		err.Error())
+
+	assert.Empty(t, transition.missingFields(map[string]string{"customfield_10100": "Typo", "Resolution": "Done"}))
+}
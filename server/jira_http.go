@@ -0,0 +1,149 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	goexpvar "expvar"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils"
+)
+
+const (
+	DefaultJiraMaxRetries = 3
+	MaxJiraMaxRetries     = 10
+
+	// The consecutive failures after which the requests to an instance are
+	// paused for CircuitBreakerCooldown.
+	DefaultCircuitBreakerThreshold = 10
+	CircuitBreakerCooldown         = 30 * time.Second
+
+	JiraRetryInitialBackoff = 500 * time.Millisecond
+	JiraRetryMaxBackoff     = 10 * time.Second
+
+	// Jira asking to retry later than this fails the request.
+	JiraMaxRetryAfter = 15 * time.Second
+
+	// The retries of a request wait this long at most in total, so that the
+	// commands and dialogs respond before they time out. The webhook events
+	// that still fail are retried later by the webhook queue.
+	JiraMaxRetryTime = 15 * time.Second
+)
+
+// parseJiraRetrySettings parses the JiraMaxRetries and
+// JiraCircuitBreakerThreshold settings, empty for the defaults.
+func parseJiraRetrySettings(maxRetries, threshold string) (int, int, error) {
+	retries, failures := DefaultJiraMaxRetries, DefaultCircuitBreakerThreshold
+	var err error
+	if maxRetries = strings.TrimSpace(maxRetries); maxRetries != "" {
+		retries, err = strconv.Atoi(maxRetries)
+		if err != nil || retries < 0 || retries > MaxJiraMaxRetries {
+			return 0, 0, errors.Errorf("the number of retries must be between 0 and %d, not %q",
+				MaxJiraMaxRetries, maxRetries)
+		}
+	}
+	if threshold = strings.TrimSpace(threshold); threshold != "" {
+		failures, err = strconv.Atoi(threshold)
+		if err != nil || failures < 0 {
+			return 0, 0, errors.Errorf("the circuit breaker threshold must be a number of failures, not %q", threshold)
+		}
+	}
+	return retries, failures, nil
+}
+
+// circuitBreakers are the circuit breakers of the Jira instances, by URL.
+type circuitBreakers struct {
+	lock     sync.Mutex
+	breakers map[string]*utils.CircuitBreaker
+}
+
+// get returns the circuit breaker of an instance, configured with
+// threshold.
+func (cbs *circuitBreakers) get(instanceURL string, threshold int) *utils.CircuitBreaker {
+	cbs.lock.Lock()
+	defer cbs.lock.Unlock()
+
+	if cbs.breakers == nil {
+		cbs.breakers = map[string]*utils.CircuitBreaker{}
+	}
+	breaker := cbs.breakers[instanceURL]
+	if breaker == nil {
+		breaker = utils.NewCircuitBreaker(threshold, CircuitBreakerCooldown)
+		cbs.breakers[instanceURL] = breaker
+	} else {
+		breaker.Configure(threshold, CircuitBreakerCooldown)
+	}
+	return breaker
+}
+
+// status returns the state of the circuit breakers that were used, by
+// instance URL.
+func (cbs *circuitBreakers) status() map[string]utils.CircuitBreakerStatus {
+	cbs.lock.Lock()
+	defer cbs.lock.Unlock()
+
+	status := map[string]utils.CircuitBreakerStatus{}
+	for instanceURL, breaker := range cbs.breakers {
+		status[instanceURL] = breaker.Status()
+	}
+	return status
+}
+
+// instanceStatus returns the state of the circuit breaker of an instance.
+func (cbs *circuitBreakers) instanceStatus(instanceURL string) utils.CircuitBreakerStatus {
+	cbs.lock.Lock()
+	breaker := cbs.breakers[instanceURL]
+	cbs.lock.Unlock()
+	if breaker == nil {
+		return utils.CircuitBreakerStatus{State: utils.CircuitClosed}
+	}
+	return breaker.Status()
+}
+
+// statusText lists the state of the circuit breakers, as markdown.
+func (cbs *circuitBreakers) statusText() string {
+	status := cbs.status()
+	instanceURLs := []string{}
+	for instanceURL := range status {
+		instanceURLs = append(instanceURLs, instanceURL)
+	}
+	sort.Strings(instanceURLs)
+	text := ""
+	for _, instanceURL := range instanceURLs {
+		text += " * " + instanceURL + ": " + status[instanceURL].String() + "\n"
+	}
+	return text
+}
+
+func initCircuitBreakersCounter(cbs *circuitBreakers) {
+	goexpvar.Publish("jira/circuit_breakers", goexpvar.Func(func() interface{} {
+		return cbs.status()
+	}))
+}
+
+// wrapJiraHTTPClient retries the requests of httpClient to a Jira instance
+// that are rate limited, or fail because Jira is unavailable, and stops
+// them while Jira keeps failing.
+func (p *Plugin) wrapJiraHTTPClient(instanceURL string, httpClient *http.Client) *http.Client {
+	conf := p.getConfig()
+	return utils.WrapHTTPClientWithRetries(httpClient, utils.RetryPolicy{
+		MaxRetries:     conf.jiraMaxRetries,
+		InitialBackoff: JiraRetryInitialBackoff,
+		MaxBackoff:     JiraRetryMaxBackoff,
+		MaxRetryAfter:  JiraMaxRetryAfter,
+		MaxRetryTime:   JiraMaxRetryTime,
+		OnRetry: func(req *http.Request, attempt int, resp *http.Response, err error) {
+			if conf.stats != nil {
+				conf.stats.EnsureEndpoint(endpointNameFromRequest(req)+"/retry").Record(0, 0, 0, err != nil, false)
+			}
+		},
+	}, p.circuitBreakers.get(instanceURL, conf.circuitBreakerThreshold))
+}
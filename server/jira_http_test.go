@@ -0,0 +1,48 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils"
+)
+
+func TestParseJiraRetrySettings(t *testing.T) {
+	retries, threshold, err := parseJiraRetrySettings("", "")
+	require.Nil(t, err)
+	assert.Equal(t, DefaultJiraMaxRetries, retries)
+	assert.Equal(t, DefaultCircuitBreakerThreshold, threshold)
+
+	retries, threshold, err = parseJiraRetrySettings("0", "0")
+	require.Nil(t, err)
+	assert.Equal(t, 0, retries)
+	assert.Equal(t, 0, threshold)
+
+	for _, tc := range [][2]string{{"-1", ""}, {"100", ""}, {"", "never"}} {
+		_, _, err = parseJiraRetrySettings(tc[0], tc[1])
+		assert.NotNil(t, err, tc)
+	}
+}
+
+func TestCircuitBreakers(t *testing.T) {
+	cbs := circuitBreakers{}
+	assert.Equal(t, utils.CircuitClosed, cbs.instanceStatus(mockCurrentInstanceURL).State)
+	assert.Equal(t, "", cbs.statusText())
+
+	breaker := cbs.get(mockCurrentInstanceURL, 1)
+	assert.Equal(t, breaker, cbs.get(mockCurrentInstanceURL, 1))
+	breaker.Failure()
+	assert.Equal(t, utils.CircuitOpen, cbs.instanceStatus(mockCurrentInstanceURL).State)
+	assert.Contains(t, cbs.statusText(), " * "+mockCurrentInstanceURL+": open until ")
+
+	// A threshold of 0 disables it.
+	cbs.get(mockCurrentInstanceURL, 0).Success()
+	breaker.Failure()
+	assert.Nil(t, breaker.Allow())
+	assert.Equal(t, " * "+mockCurrentInstanceURL+": closed, 1 consecutive failures\n", cbs.statusText())
+}
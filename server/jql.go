@@ -0,0 +1,722 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+)
+
+// JQL is a parsed JQL expression that can be evaluated locally against the
+// issue in a webhook payload. Only the subset of JQL that can be answered
+// from the payload is supported: the =, !=, in, not in, ~, !~, is [not] EMPTY
+// operators, AND/OR/NOT and parentheses. Values are compared
+// case-insensitively, and a trailing ORDER BY clause is ignored.
+type JQL struct {
+	root jqlNode
+	src  string
+
+	// The expression without its ORDER BY clause.
+	condition string
+}
+
+type jqlNode interface {
+	matches(issue *jira.Issue) bool
+}
+
+type jqlAnd struct{ left, right jqlNode }
+type jqlOr struct{ left, right jqlNode }
+type jqlNot struct{ expr jqlNode }
+
+type jqlClause struct {
+	field    string
+	operator string
+	values   []string
+}
+
+const (
+	jqlOpEquals      = "="
+	jqlOpNotEquals   = "!="
+	jqlOpIn          = "in"
+	jqlOpNotIn       = "not in"
+	jqlOpContains    = "~"
+	jqlOpNotContains = "!~"
+	jqlOpIsEmpty     = "is empty"
+	jqlOpIsNotEmpty  = "is not empty"
+)
+
+// ParseJQL parses a JQL expression. The returned error describes the first
+// problem found, in a form suitable to show to the user.
+func ParseJQL(src string) (*JQL, error) {
+	tokens, err := lexJQL(src)
+	if err != nil {
+		return nil, err
+	}
+	parser := jqlParser{tokens: tokens}
+	if parser.atEnd() {
+		return nil, errors.New("JQL is empty")
+	}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	condition := src
+	if parser.peekKeyword("order") {
+		// Sorting is meaningless for a single issue, skip the rest.
+		condition = strings.TrimSpace(string([]rune(src)[:parser.peek().pos]))
+		parser.pos = len(parser.tokens)
+	}
+	if !parser.atEnd() {
+		return nil, errors.Errorf("unexpected %q at position %d", parser.peek().text, parser.peek().pos)
+	}
+	return &JQL{root: root, src: src, condition: condition}, nil
+}
+
+func (q *JQL) Matches(issue *jira.Issue) bool {
+	if q == nil || q.root == nil || issue == nil {
+		return false
+	}
+	return q.root.matches(issue)
+}
+
+func (q *JQL) String() string {
+	return q.src
+}
+
+// Condition returns the expression without its ORDER BY clause, to combine
+// it with other clauses.
+func (q *JQL) Condition() string {
+	return q.condition
+}
+
+// Projects returns the projects the issues matching q must be in, and false
+// if q can match the issues of any project.
+func (q *JQL) Projects() (StringSet, bool) {
+	if q == nil || q.root == nil {
+		return nil, false
+	}
+	return jqlProjects(q.root)
+}
+
+// MaxJQLCacheEntries is the number of parsed expressions kept, past which
+// the cache starts over.
+const MaxJQLCacheEntries = 1000
+
+// jqlCache keeps the parsed JQL of the subscriptions, so that it isn't
+// parsed again for every webhook event.
+type jqlCache struct {
+	lock   sync.Mutex
+	parsed map[string]*JQL
+}
+
+func (c *jqlCache) set(jql *JQL) {
+	if jql == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.parsed == nil || len(c.parsed) >= MaxJQLCacheEntries {
+		c.parsed = map[string]*JQL{}
+	}
+	c.parsed[jql.src] = jql
+}
+
+// get returns the parsed src, parsing it if it isn't cached, e.g. after a
+// restart.
+func (c *jqlCache) get(src string) (*JQL, error) {
+	c.lock.Lock()
+	jql := c.parsed[src]
+	c.lock.Unlock()
+	if jql != nil {
+		return jql, nil
+	}
+	jql, err := ParseJQL(src)
+	if err != nil {
+		return nil, err
+	}
+	c.set(jql)
+	return jql, nil
+}
+
+func jqlProjects(node jqlNode) (StringSet, bool) {
+	switch n := node.(type) {
+	case jqlClause:
+		if n.field == "project" && (n.operator == jqlOpEquals || n.operator == jqlOpIn) {
+			projects := NewStringSet()
+			for _, value := range n.values {
+				projects = projects.Add(strings.ToUpper(value))
+			}
+			return projects, true
+		}
+	case jqlAnd:
+		left, leftOK := jqlProjects(n.left)
+		right, rightOK := jqlProjects(n.right)
+		switch {
+		case leftOK && rightOK:
+			return left.Intersection(right), true
+		case leftOK:
+			return left, true
+		case rightOK:
+			return right, true
+		}
+	case jqlOr:
+		left, leftOK := jqlProjects(n.left)
+		right, rightOK := jqlProjects(n.right)
+		if leftOK && rightOK {
+			return left.Union(right), true
+		}
+	}
+	return nil, false
+}
+
+func (n jqlAnd) matches(issue *jira.Issue) bool {
+	return n.left.matches(issue) && n.right.matches(issue)
+}
+
+func (n jqlOr) matches(issue *jira.Issue) bool {
+	return n.left.matches(issue) || n.right.matches(issue)
+}
+
+func (n jqlNot) matches(issue *jira.Issue) bool {
+	return !n.expr.matches(issue)
+}
+
+func (c jqlClause) matches(issue *jira.Issue) bool {
+	actual := jqlFieldValues(issue, c.field)
+
+	switch c.operator {
+	case jqlOpIsEmpty:
+		return len(actual) == 0
+	case jqlOpIsNotEmpty:
+		return len(actual) != 0
+	}
+
+	// As in Jira, fields with no value never match the other operators,
+	// including the negative ones.
+	if len(actual) == 0 {
+		return false
+	}
+
+	switch c.operator {
+	case jqlOpEquals, jqlOpIn:
+		return jqlContainsAny(actual, c.values)
+	case jqlOpNotEquals, jqlOpNotIn:
+		return !jqlContainsAny(actual, c.values)
+	case jqlOpContains:
+		return jqlTextContains(actual, c.values[0])
+	case jqlOpNotContains:
+		return !jqlTextContains(actual, c.values[0])
+	}
+	return false
+}
+
+func jqlContainsAny(actual, expected []string) bool {
+	for _, a := range actual {
+		for _, e := range expected {
+			if strings.EqualFold(a, e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jqlTextContains approximates Jira's text search: every word of the query
+// must appear in one of the values. A trailing * matches any word suffix.
+func jqlTextContains(actual []string, query string) bool {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return false
+	}
+	for _, a := range actual {
+		text := strings.ToLower(a)
+		found := true
+		for _, w := range words {
+			if !strings.Contains(text, strings.TrimSuffix(w, "*")) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+var reJQLCustomField = regexp.MustCompile(`^(?:customfield_|cf\[)(\d+)\]?$`)
+
+// jqlFields lists the supported system fields, with their aliases.
+var jqlFields = map[string]string{
+	"assignee":        "assignee",
+	"affectedversion": "affectedversion",
+	"component":       "component",
+	"components":      "component",
+	"creator":         "creator",
+	"description":     "description",
+	"environment":     "environment",
+	"fixversion":      "fixversion",
+	"id":              "id",
+	"issue":           "key",
+	"issuekey":        "key",
+	"issuetype":       "issuetype",
+	"key":             "key",
+	"labels":          "labels",
+	"priority":        "priority",
+	"project":         "project",
+	"reporter":        "reporter",
+	"resolution":      "resolution",
+	"status":          "status",
+	"summary":         "summary",
+	"text":            "text",
+	"type":            "issuetype",
+}
+
+func normalizeJQLField(name string) (string, error) {
+	lower := strings.ToLower(name)
+	if field, ok := jqlFields[lower]; ok {
+		return field, nil
+	}
+	if m := reJQLCustomField.FindStringSubmatch(lower); m != nil {
+		return "customfield_" + m[1], nil
+	}
+	return "", errors.Errorf("field %q is not supported, use one of %s, or customfield_NNNNN", name, supportedJQLFields())
+}
+
+func supportedJQLFields() string {
+	names := []string{}
+	for name, field := range jqlFields {
+		if name == field {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func jqlUserValues(u *jira.User) []string {
+	if u == nil {
+		return nil
+	}
+	return jqlNonEmpty(u.Name, u.Key, u.AccountID, u.DisplayName, u.EmailAddress)
+}
+
+func jqlNonEmpty(values ...string) []string {
+	result := []string{}
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func jqlFieldValues(issue *jira.Issue, field string) []string {
+	f := issue.Fields
+	if f == nil {
+		f = &jira.IssueFields{}
+	}
+
+	switch field {
+	case "assignee":
+		return jqlUserValues(f.Assignee)
+	case "reporter":
+		return jqlUserValues(f.Reporter)
+	case "creator":
+		return jqlUserValues(f.Creator)
+	case "affectedversion":
+		result := []string{}
+		for _, v := range f.AffectsVersions {
+			result = append(result, jqlNonEmpty(v.Name, v.ID)...)
+		}
+		return result
+	case "fixversion":
+		result := []string{}
+		for _, v := range f.FixVersions {
+			result = append(result, jqlNonEmpty(v.Name, v.ID)...)
+		}
+		return result
+	case "component":
+		result := []string{}
+		for _, c := range f.Components {
+			result = append(result, jqlNonEmpty(c.Name, c.ID)...)
+		}
+		return result
+	case "description":
+		return jqlNonEmpty(f.Description)
+	case "environment":
+		return jqlNonEmpty(jqlEnvironment(f))
+	case "summary":
+		return jqlNonEmpty(f.Summary)
+	case "text":
+		return jqlNonEmpty(f.Summary, f.Description, jqlEnvironment(f))
+	case "id":
+		return jqlNonEmpty(issue.ID)
+	case "key":
+		return jqlNonEmpty(issue.Key)
+	case "issuetype":
+		return jqlNonEmpty(f.Type.Name, f.Type.ID)
+	case "labels":
+		return jqlNonEmpty(f.Labels...)
+	case "priority":
+		if f.Priority == nil {
+			return nil
+		}
+		return jqlNonEmpty(f.Priority.Name, f.Priority.ID)
+	case "project":
+		return jqlNonEmpty(f.Project.Key, f.Project.Name, f.Project.ID)
+	case "resolution":
+		if f.Resolution == nil {
+			return nil
+		}
+		return jqlNonEmpty(f.Resolution.Name, f.Resolution.ID)
+	case "status":
+		if f.Status == nil {
+			return nil
+		}
+		return jqlNonEmpty(f.Status.Name, f.Status.ID)
+	}
+
+	if strings.HasPrefix(field, "customfield_") {
+		v, ok := f.Unknowns.Value(field)
+		if !ok {
+			return nil
+		}
+		return jqlCustomFieldValues(v)
+	}
+	return nil
+}
+
+// jqlEnvironment returns the environment field, which go-jira does not map.
+func jqlEnvironment(f *jira.IssueFields) string {
+	env, _ := f.Unknowns.Value("environment")
+	s, _ := env.(string)
+	return s
+}
+
+func jqlCustomFieldValues(v interface{}) []string {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return jqlNonEmpty(value)
+	case float64:
+		return []string{strconv.FormatFloat(value, 'f', -1, 64)}
+	case bool:
+		return []string{strconv.FormatBool(value)}
+	case []interface{}:
+		result := []string{}
+		for _, elem := range value {
+			result = append(result, jqlCustomFieldValues(elem)...)
+		}
+		return result
+	case map[string]interface{}:
+		// Select lists, users, and other objects: match on any identifying
+		// attribute.
+		result := []string{}
+		for _, key := range []string{"value", "name", "id", "key", "accountId", "displayName"} {
+			if s, ok := value[key].(string); ok && s != "" {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+type jqlTokenKind int
+
+const (
+	jqlTokenWord jqlTokenKind = iota
+	jqlTokenString
+	jqlTokenOperator
+	jqlTokenLParen
+	jqlTokenRParen
+	jqlTokenComma
+)
+
+type jqlToken struct {
+	kind jqlTokenKind
+	text string
+	pos  int
+}
+
+const jqlSpecialChars = "=!~<>(),\"'"
+
+func lexJQL(src string) ([]jqlToken, error) {
+	tokens := []jqlToken{}
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			i++
+		case r == '(':
+			tokens = append(tokens, jqlToken{jqlTokenLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, jqlToken{jqlTokenRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, jqlToken{jqlTokenComma, ",", i})
+			i++
+		case r == '=' || r == '~':
+			tokens = append(tokens, jqlToken{jqlTokenOperator, string(r), i})
+			i++
+		case r == '!':
+			if i+1 < len(runes) && (runes[i+1] == '=' || runes[i+1] == '~') {
+				tokens = append(tokens, jqlToken{jqlTokenOperator, string(runes[i : i+2]), i})
+				i += 2
+				continue
+			}
+			return nil, errors.Errorf("unexpected %q at position %d", string(r), i)
+		case r == '<' || r == '>':
+			return nil, errors.Errorf("operator %q at position %d is not supported", string(r), i)
+		case r == '"' || r == '\'':
+			start := i
+			quote := r
+			value := []rune{}
+			i++
+			for ; i < len(runes) && runes[i] != quote; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value = append(value, runes[i])
+			}
+			if i >= len(runes) {
+				return nil, errors.Errorf("unterminated string starting at position %d", start)
+			}
+			i++
+			tokens = append(tokens, jqlToken{jqlTokenString, string(value), start})
+		default:
+			start := i
+			for i < len(runes) && !strings.ContainsRune(jqlSpecialChars, runes[i]) &&
+				runes[i] != ' ' && runes[i] != '\t' && runes[i] != '\n' && runes[i] != '\r' {
+				i++
+			}
+			tokens = append(tokens, jqlToken{jqlTokenWord, string(runes[start:i]), start})
+		}
+	}
+	return tokens, nil
+}
+
+type jqlParser struct {
+	tokens []jqlToken
+	pos    int
+}
+
+func (p *jqlParser) atEnd() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *jqlParser) peek() jqlToken {
+	if p.atEnd() {
+		return jqlToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *jqlParser) peekKeyword(keyword string) bool {
+	t := p.peek()
+	return !p.atEnd() && t.kind == jqlTokenWord && strings.EqualFold(t.text, keyword)
+}
+
+func (p *jqlParser) errorf(format string, args ...interface{}) error {
+	if p.atEnd() {
+		return errors.New(fmt.Sprintf(format, args...) + " at the end of the query")
+	}
+	return errors.New(fmt.Sprintf(format, args...) + fmt.Sprintf(" at position %d", p.peek().pos))
+}
+
+func (p *jqlParser) parseOr() (jqlNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = jqlOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *jqlParser) parseAnd() (jqlNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = jqlAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *jqlParser) parseNot() (jqlNode, error) {
+	if p.peekKeyword("not") {
+		p.pos++
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return jqlNot{expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *jqlParser) parsePrimary() (jqlNode, error) {
+	if p.atEnd() {
+		return nil, p.errorf("expected a clause")
+	}
+	if p.peek().kind == jqlTokenLParen {
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.atEnd() || p.peek().kind != jqlTokenRParen {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return expr, nil
+	}
+	return p.parseClause()
+}
+
+func (p *jqlParser) parseClause() (jqlNode, error) {
+	t := p.peek()
+	if t.kind != jqlTokenWord && t.kind != jqlTokenString {
+		return nil, p.errorf("expected a field name")
+	}
+	field, err := normalizeJQLField(t.text)
+	if err != nil {
+		return nil, err
+	}
+	p.pos++
+
+	clause := jqlClause{field: field}
+	switch {
+	case p.atEnd():
+		return nil, p.errorf("expected an operator after %q", t.text)
+
+	case p.peek().kind == jqlTokenOperator:
+		clause.operator = p.peek().text
+		p.pos++
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		clause.values = []string{value}
+
+	case p.peekKeyword("in"):
+		p.pos++
+		clause.operator = jqlOpIn
+		clause.values, err = p.parseList()
+		if err != nil {
+			return nil, err
+		}
+
+	case p.peekKeyword("not"):
+		p.pos++
+		if !p.peekKeyword("in") {
+			return nil, p.errorf("expected IN after NOT")
+		}
+		p.pos++
+		clause.operator = jqlOpNotIn
+		clause.values, err = p.parseList()
+		if err != nil {
+			return nil, err
+		}
+
+	case p.peekKeyword("is"):
+		p.pos++
+		clause.operator = jqlOpIsEmpty
+		if p.peekKeyword("not") {
+			p.pos++
+			clause.operator = jqlOpIsNotEmpty
+		}
+		if !p.peekKeyword("empty") && !p.peekKeyword("null") {
+			return nil, p.errorf("expected EMPTY or NULL after IS")
+		}
+		p.pos++
+
+	default:
+		return nil, p.errorf("operator %q is not supported", p.peek().text)
+	}
+
+	if (clause.operator == jqlOpContains || clause.operator == jqlOpNotContains) &&
+		!isJQLTextField(clause.field) {
+		return nil, errors.Errorf("operator %s can only be used with text fields, not %q", clause.operator, t.text)
+	}
+	return clause, nil
+}
+
+func isJQLTextField(field string) bool {
+	switch field {
+	case "summary", "description", "environment", "text":
+		return true
+	}
+	return strings.HasPrefix(field, "customfield_")
+}
+
+func (p *jqlParser) parseValue() (string, error) {
+	if p.atEnd() {
+		return "", p.errorf("expected a value")
+	}
+	t := p.peek()
+	if t.kind != jqlTokenWord && t.kind != jqlTokenString {
+		return "", p.errorf("expected a value")
+	}
+	p.pos++
+	if t.kind == jqlTokenWord {
+		if !p.atEnd() && p.peek().kind == jqlTokenLParen {
+			return "", errors.Errorf("function %s() at position %d is not supported", t.text, t.pos)
+		}
+		if strings.EqualFold(t.text, "empty") || strings.EqualFold(t.text, "null") {
+			return "", errors.Errorf("use IS EMPTY instead of comparing to %s at position %d", t.text, t.pos)
+		}
+	}
+	return t.text, nil
+}
+
+func (p *jqlParser) parseList() ([]string, error) {
+	if p.atEnd() || p.peek().kind != jqlTokenLParen {
+		return nil, p.errorf("expected (")
+	}
+	p.pos++
+	values := []string{}
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if p.atEnd() {
+			return nil, p.errorf("expected )")
+		}
+		if p.peek().kind == jqlTokenRParen {
+			p.pos++
+			return values, nil
+		}
+		if p.peek().kind != jqlTokenComma {
+			return nil, p.errorf("expected , or )")
+		}
+		p.pos++
+	}
+}
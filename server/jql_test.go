@@ -0,0 +1,121 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trivago/tgo/tcontainer"
+)
+
+func TestJQLMatches(t *testing.T) {
+	issue := &jira.Issue{
+		ID:  "10040",
+		Key: "TES-41",
+		Fields: &jira.IssueFields{
+			Summary:     "Unit test summary",
+			Description: "Unit test description, not that long",
+			Type:        jira.IssueType{ID: "10001", Name: "Story"},
+			Project:     jira.Project{ID: "10000", Key: "TES", Name: "Test"},
+			Status:      &jira.Status{ID: "10001", Name: "To Do"},
+			Priority:    &jira.Priority{ID: "2", Name: "High"},
+			Labels:      []string{"test-label", "backend"},
+			Reporter:    &jira.User{Name: "admin", DisplayName: "Test Admin"},
+			Components:  []*jira.Component{{ID: "10100", Name: "API"}},
+			Unknowns: tcontainer.MarshalMap{
+				"customfield_10050": map[string]interface{}{"value": "Red", "id": "10200"},
+				"customfield_10051": "some text value",
+			},
+		},
+	}
+
+	for jql, expected := range map[string]bool{
+		"project = TES":                                true,
+		"project = tes":                                true,
+		"Project = \"Test\"":                           true,
+		"project != TES":                               false,
+		"project in (FOO, TES)":                        true,
+		"project not in (FOO, BAR)":                    true,
+		"issuetype = Story AND status = 'To Do'":       true,
+		"type = Bug OR priority = High":                true,
+		"type = Bug OR priority = Low":                 false,
+		"NOT (type = Bug OR priority = Low)":           true,
+		"not type = Story":                             false,
+		"labels = backend":                             true,
+		"labels in (frontend)":                         false,
+		"labels not in (frontend)":                     true,
+		"labels is not EMPTY":                          true,
+		"assignee is EMPTY":                            true,
+		"assignee is null":                             true,
+		"assignee != admin":                            false,
+		"reporter = admin AND reporter = 'Test Admin'": true,
+		"component = API":                              true,
+		"components in (10100)":                        true,
+		"resolution is EMPTY":                          true,
+		"fixVersion is EMPTY":                          true,
+		"key = TES-41":                                 true,
+		"issuekey in (TES-1, TES-2)":                   false,
+		"summary ~ \"unit summary\"":                   true,
+		"summary ~ test*":                              true,
+		"summary ~ missing":                            false,
+		"summary !~ missing":                           true,
+		"text ~ description":                           true,
+		"cf[10050] = red":                              true,
+		"customfield_10050 in (Blue, 10200)":           true,
+		"customfield_10051 ~ text":                     true,
+		"customfield_10052 is EMPTY":                   true,
+		"project = TES AND (labels = x OR labels = backend) ORDER BY created DESC": true,
+		"project = TES or project = FOO and labels = x":                            true,
+	} {
+		t.Run(jql, func(t *testing.T) {
+			q, err := ParseJQL(jql)
+			require.Nil(t, err)
+			assert.Equal(t, expected, q.Matches(issue))
+		})
+	}
+}
+
+func TestParseJQLErrors(t *testing.T) {
+	for _, jql := range []string{
+		"",
+		"project",
+		"project =",
+		"project = TES AND",
+		"(project = TES",
+		"project = TES)",
+		"project in TES",
+		"project in (TES,",
+		"project is TES",
+		"project not TES",
+		"unknownfield = x",
+		"created > -1d",
+		"assignee = currentUser()",
+		"assignee = EMPTY",
+		"project ~ TES",
+		"summary ~ \"unterminated",
+		"project = TES BOGUS",
+	} {
+		t.Run(jql, func(t *testing.T) {
+			_, err := ParseJQL(jql)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestJQLCache(t *testing.T) {
+	c := jqlCache{}
+	jql, err := ParseJQL("project = PROJ")
+	require.Nil(t, err)
+	c.set(jql)
+
+	cached, err := c.get("project = PROJ")
+	require.Nil(t, err)
+	assert.True(t, jql == cached)
+
+	_, err = c.get("project = ")
+	assert.NotNil(t, err)
+}
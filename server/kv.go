@@ -10,6 +10,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
@@ -25,14 +27,25 @@ const (
 	prefixJIRAInstance     = "jira_instance_"
 	prefixOneTimeSecret    = "ots_" // + unique key that will be deleted after the first verification
 	prefixStats            = "stats_"
+	prefixUserInstances    = "user_instances_"
+	prefixWebhookQueue     = "webhook_queue_"
+	prefixWebhookDead      = "webhook_dead_"
+	keyWebhookQueueIndex   = "index_webhook_queue"
 )
 
+// The queued webhook messages are indexed in shards, as each of them is
+// added and removed by the webhook workers of all the servers at once.
+const webhookQueueIndexShards = 64
+
+var webhookQueueIndex = keyIndex{key: keyWebhookQueueIndex, prefix: prefixWebhookQueue, shards: webhookQueueIndexShards}
+
 type Store interface {
 	CurrentInstanceStore
 	InstanceStore
 	UserStore
 	SecretsStore
 	OTSStore
+	WebhookQueueStore
 }
 
 type SecretsStore interface {
@@ -60,6 +73,7 @@ type UserStore interface {
 	LoadMattermostUserId(ji Instance, jiraUserName string) (string, error)
 	LoadJIRAUserByAccountId(ji Instance, accountId string) (JIRAUser, error)
 	DeleteUserInfo(ji Instance, mattermostUserId string) error
+	LoadUserInstances(mattermostUserId string) ([]string, error)
 	CountUsers() (int, error)
 }
 
@@ -70,6 +84,17 @@ type OTSStore interface {
 	OneTimeLoadOauth1aTemporaryCredentials(mmUserId string) (*OAuth1aTemporaryCredentials, error)
 }
 
+type WebhookQueueStore interface {
+	StoreWebhookMessage(msg *webhookMessage) error
+	ClaimWebhookMessage(id string, until time.Time) (*webhookMessage, error)
+	DeleteWebhookMessage(id string) error
+	LoadWebhookMessages() ([]*webhookMessage, error)
+	StoreDeadWebhookMessage(msg *webhookMessage) error
+	LoadDeadWebhookMessage(id string) (*webhookMessage, error)
+	DeleteDeadWebhookMessage(id string) error
+	LoadDeadWebhookMessages() ([]*webhookMessage, error)
+}
+
 // Number of items to retrieve in KVList operations, made a variable so
 // that tests can manipulate
 var listPerPage = 100
@@ -263,12 +288,10 @@ func (store store) DeleteJiraInstance(key string) (returnErr error) {
 	}
 	store.plugin.debugf("Deleted: from known Jira instances: %s", key)
 
-	// Remove the current instance if it matches the deleted
+	// Remove the current instance if it matches the deleted, and make
+	// another known instance (if any) the default.
 	current, err := store.LoadCurrentJIRAInstance()
-	if err != nil {
-		return err
-	}
-	if current.GetURL() == key {
+	if err == nil && current.GetURL() == key {
 		appErr := store.plugin.API.KVDelete(keyCurrentJIRAInstance)
 		if appErr != nil {
 			return appErr
@@ -279,6 +302,22 @@ func (store store) DeleteJiraInstance(key string) (returnErr error) {
 			conf.currentInstanceExpires = time.Time{}
 		})
 		store.plugin.debugf("Deleted: current Jira instance")
+
+		if len(known) > 0 {
+			keys := []string{}
+			for k := range known {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			ji, err := store.LoadJIRAInstance(keys[0])
+			if err != nil {
+				return err
+			}
+			err = store.StoreCurrentJIRAInstance(ji)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -351,6 +390,16 @@ func (store store) loadJIRAInstance(fullkey string) (Instance, error) {
 		jci.Init(store.plugin)
 		return &jci, nil
 
+	case JIRATypeCloudOAuth:
+		jci := jiraCloudOAuthInstance{}
+		err = json.Unmarshal(data, &jci)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to unmarshal stored Instance "+fullkey)
+		}
+		jci.PluginVersion = manifest.Version
+		jci.Init(store.plugin)
+		return &jci, nil
+
 	case JIRATypeServer:
 		jsi.PluginVersion = manifest.Version
 		jsi.Init(store.plugin)
@@ -407,6 +456,13 @@ func (store store) StoreUserInfo(ji Instance, mattermostUserId string, jiraUser
 		return err
 	}
 
+	err = store.updateUserInstances(mattermostUserId, func(urls StringSet) StringSet {
+		return urls.Add(ji.GetURL())
+	})
+	if err != nil {
+		return err
+	}
+
 	store.plugin.debugf("Stored: Jira user, keys:\n\t%s (%s): %+v\n\t%s (%s): %s",
 		keyWithInstance(ji, mattermostUserId), mattermostUserId, jiraUser,
 		keyWithInstance(ji, jiraUser.Key()), jiraUser.Key(), mattermostUserId)
@@ -476,12 +532,46 @@ func (store store) DeleteUserInfo(ji Instance, mattermostUserId string) (returnE
 		return appErr
 	}
 
+	err = store.updateUserInstances(mattermostUserId, func(urls StringSet) StringSet {
+		return urls.Subtract(ji.GetURL())
+	})
+	if err != nil {
+		return err
+	}
+
 	store.plugin.debugf("Deleted: user, keys: %s(%s), %s(%s)",
 		mattermostUserId, keyWithInstance(ji, mattermostUserId),
 		jiraUser.Key(), keyWithInstance(ji, jiraUser.Key()))
 	return nil
 }
 
+// LoadUserInstances returns the URLs of the Jira instances the user is
+// connected to, in no particular order.
+func (store store) LoadUserInstances(mattermostUserId string) ([]string, error) {
+	urls := NewStringSet()
+	err := store.get(hashkey(prefixUserInstances, mattermostUserId), &urls)
+	if err != nil {
+		return nil, errors.WithMessage(err,
+			fmt.Sprintf("failed to load Jira instances for mattermostUserId:%s", mattermostUserId))
+	}
+	return urls.Elems(), nil
+}
+
+// updateUserInstances updates the list of the Jira instances of the user
+// atomically, since the user may connect to several instances at once.
+func (store store) updateUserInstances(mattermostUserId string, update func(urls StringSet) StringSet) error {
+	return store.plugin.atomicModify(hashkey(prefixUserInstances, mattermostUserId), func(initialBytes []byte) ([]byte, error) {
+		urls := NewStringSet()
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &urls)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(update(urls))
+	})
+}
+
 var reHexKeyFormat = regexp.MustCompile("^[[:xdigit:]]{32}$")
 
 func (store store) CountUsers() (int, error) {
@@ -660,3 +750,190 @@ func (store store) OneTimeLoadOauth1aTemporaryCredentials(mmUserId string) (*OAu
 	}
 	return &credentials, nil
 }
+
+func (store store) StoreWebhookMessage(msg *webhookMessage) error {
+	err := store.set(prefixWebhookQueue+msg.Id, msg)
+	if err != nil {
+		return err
+	}
+	return store.plugin.addToKeyIndex(webhookQueueIndex, prefixWebhookQueue+msg.Id)
+}
+
+// ClaimWebhookMessage atomically marks a queued message as being processed
+// until the given time, so that it is not picked up by another server. It
+// returns nil if the message is gone or not yet due.
+func (store store) ClaimWebhookMessage(id string, until time.Time) (msg *webhookMessage, returnErr error) {
+	defer func() {
+		if returnErr == nil {
+			return
+		}
+		returnErr = errors.WithMessagef(returnErr, "failed to claim webhook message %q", id)
+	}()
+
+	key := prefixWebhookQueue + id
+	data, appErr := store.plugin.API.KVGet(key)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if data == nil {
+		return nil, nil
+	}
+	msg = &webhookMessage{}
+	err := json.Unmarshal(data, msg)
+	if err != nil {
+		return nil, err
+	}
+	if msg.NextAttempt.After(time.Now()) {
+		return nil, nil
+	}
+
+	msg.NextAttempt = until
+	claimed, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	ok, appErr := store.plugin.API.KVCompareAndSet(key, data, claimed)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if !ok {
+		return nil, nil
+	}
+	return msg, nil
+}
+
+func (store store) DeleteWebhookMessage(id string) error {
+	appErr := store.plugin.API.KVDelete(prefixWebhookQueue + id)
+	if appErr != nil {
+		return errors.WithMessagef(appErr, "failed to delete webhook message %q", id)
+	}
+	return store.plugin.removeFromKeyIndex(webhookQueueIndex, prefixWebhookQueue+id)
+}
+
+func (store store) LoadWebhookMessages() ([]*webhookMessage, error) {
+	index, err := store.plugin.loadKeyIndex(webhookQueueIndex)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load queued webhook messages")
+	}
+	msgs, deleted, err := store.loadWebhookMessages(index.Elems())
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load queued webhook messages")
+	}
+	if len(deleted) > 0 {
+		err = store.plugin.removeFromKeyIndex(webhookQueueIndex, deleted...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+func (store store) StoreDeadWebhookMessage(msg *webhookMessage) error {
+	return store.set(prefixWebhookDead+msg.Id, msg)
+}
+
+func (store store) LoadDeadWebhookMessage(id string) (*webhookMessage, error) {
+	data, appErr := store.plugin.API.KVGet(prefixWebhookDead + id)
+	if appErr != nil {
+		return nil, errors.WithMessagef(appErr, "failed to load dead webhook message %q", id)
+	}
+	if data == nil {
+		return nil, errors.Errorf("dead webhook message %q not found", id)
+	}
+	msg := &webhookMessage{}
+	err := json.Unmarshal(data, msg)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to load dead webhook message %q", id)
+	}
+	return msg, nil
+}
+
+func (store store) DeleteDeadWebhookMessage(id string) error {
+	appErr := store.plugin.API.KVDelete(prefixWebhookDead + id)
+	if appErr != nil {
+		return errors.WithMessagef(appErr, "failed to delete dead webhook message %q", id)
+	}
+	return nil
+}
+
+func (store store) LoadDeadWebhookMessages() ([]*webhookMessage, error) {
+	keys, err := store.plugin.listKeys(prefixWebhookDead)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load dead webhook messages")
+	}
+	msgs, _, err := store.loadWebhookMessages(keys)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load dead webhook messages")
+	}
+	return msgs, nil
+}
+
+// loadWebhookMessages loads the messages stored at keys, and returns the keys
+// of the ones deleted since listed.
+func (store store) loadWebhookMessages(keys []string) ([]*webhookMessage, []string, error) {
+	msgs := []*webhookMessage{}
+	deleted := []string{}
+	for _, key := range keys {
+		data, appErr := store.plugin.API.KVGet(key)
+		if appErr != nil {
+			return nil, nil, appErr
+		}
+		if data == nil {
+			deleted = append(deleted, key)
+			continue
+		}
+		msg := &webhookMessage{}
+		err := json.Unmarshal(data, msg)
+		if err != nil {
+			return nil, nil, errors.WithMessagef(err, "failed to unmarshal %q", key)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].Created.Before(msgs[j].Created)
+	})
+	return msgs, deleted, nil
+}
+
+// claimKey claims a lock, shared by the servers of a cluster, that expires
+// after ttl if it isn't released. It returns the claim to release, or nil if
+// the lock is held by someone else.
+func (p *Plugin) claimKey(key string, ttl time.Duration) ([]byte, error) {
+	claim := []byte(strconv.FormatInt(time.Now().Add(ttl).UnixNano(), 10))
+	ok, appErr := p.API.KVCompareAndSet(key, nil, claim)
+	if appErr != nil {
+		return nil, errors.WithMessagef(appErr, "failed to claim %q", key)
+	}
+	if ok {
+		return claim, nil
+	}
+
+	// Take over an expired claim.
+	held, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return nil, errors.WithMessagef(appErr, "failed to claim %q", key)
+	}
+	until, err := strconv.ParseInt(string(held), 10, 64)
+	if held != nil && err == nil && time.Now().UnixNano() < until {
+		return nil, nil
+	}
+	ok, appErr = p.API.KVCompareAndSet(key, held, claim)
+	if appErr != nil {
+		return nil, errors.WithMessagef(appErr, "failed to claim %q", key)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return claim, nil
+}
+
+// releaseKey releases a claim of claimKey, unless it expired and was taken
+// over since.
+func (p *Plugin) releaseKey(key string, claim []byte) error {
+	_, appErr := p.API.KVCompareAndDelete(key, claim)
+	if appErr != nil {
+		return errors.WithMessagef(appErr, "failed to release %q", key)
+	}
+	return nil
+}
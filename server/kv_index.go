@@ -0,0 +1,198 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// keyIndex lists the keys of the records that start with prefix, so that the
+// periodic jobs find them without scanning the whole KV store.
+//
+// The index is split into shards by the hash of the keys, so that the records
+// stored at the same time don't all update the same value. The value at key
+// marks whether the shards list all the records. It is reset when a shard
+// fails to be updated, and until it is set again the index is completed with
+// a scan of the KV store when it is loaded. The records stored before the
+// index existed are found the same way.
+type keyIndex struct {
+	key    string
+	prefix string
+
+	// 0 for a single shard.
+	shards int
+}
+
+// keyIndexComplete marks an index that lists all the records.
+const keyIndexComplete = "complete"
+
+func (index keyIndex) numShards() int {
+	if index.shards < 1 {
+		return 1
+	}
+	return index.shards
+}
+
+func (index keyIndex) shardKeys() []string {
+	keys := []string{}
+	for i := 0; i < index.numShards(); i++ {
+		keys = append(keys, fmt.Sprintf("%s_%d", index.key, i))
+	}
+	return keys
+}
+
+// shardKey returns the shard that lists key.
+func (index keyIndex) shardKey(key string) string {
+	return fmt.Sprintf("%s_%d", index.key, crc32.ChecksumIEEE([]byte(key))%uint32(index.numShards()))
+}
+
+// byShard groups keys by the shard they are listed in.
+func (index keyIndex) byShard(keys []string) map[string][]string {
+	grouped := map[string][]string{}
+	for _, key := range keys {
+		shardKey := index.shardKey(key)
+		grouped[shardKey] = append(grouped[shardKey], key)
+	}
+	return grouped
+}
+
+func (p *Plugin) addToKeyIndex(index keyIndex, keys ...string) error {
+	for shardKey, shardKeys := range index.byShard(keys) {
+		// Most records are stored several times, skip the write if they are
+		// indexed already.
+		data, appErr := p.API.KVGet(shardKey)
+		if appErr == nil && data != nil {
+			shard := NewStringSet()
+			if json.Unmarshal(data, &shard) == nil && shard.ContainsAll(shardKeys...) {
+				continue
+			}
+		}
+		shardKeys := shardKeys
+		err := p.modifyKeyIndexShard(shardKey, func(shard StringSet) StringSet {
+			return shard.Add(shardKeys...)
+		})
+		if err != nil {
+			// The records are found by the next scan instead.
+			appErr = p.API.KVSet(index.key, []byte(model.NewId()))
+			if appErr != nil {
+				return errors.WithMessagef(appErr, "failed to reset the index %q after: %v", index.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeFromKeyIndex removes keys from the index. The keys that fail to be
+// removed are only loaded again, so the records must be checked anyway.
+func (p *Plugin) removeFromKeyIndex(index keyIndex, keys ...string) error {
+	for shardKey, shardKeys := range index.byShard(keys) {
+		shardKeys := shardKeys
+		err := p.modifyKeyIndexShard(shardKey, func(shard StringSet) StringSet {
+			return shard.Subtract(shardKeys...)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadKeyIndex returns the keys in the index, which may include the keys of
+// records deleted since.
+func (p *Plugin) loadKeyIndex(index keyIndex) (StringSet, error) {
+	marker, appErr := p.API.KVGet(index.key)
+	if appErr != nil {
+		return nil, errors.WithMessagef(appErr, "failed to load the index %q", index.key)
+	}
+
+	keys := NewStringSet()
+	if string(marker) != keyIndexComplete {
+		found, err := p.listKeys(index.prefix)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to scan the records of the index %q", index.key)
+		}
+		keys = keys.Add(found...)
+
+		complete := true
+		for shardKey, shardKeys := range index.byShard(found) {
+			shardKeys := shardKeys
+			err = p.modifyKeyIndexShard(shardKey, func(shard StringSet) StringSet {
+				return shard.Add(shardKeys...)
+			})
+			if err != nil {
+				complete = false
+			}
+		}
+		// Unless the index was reset since it was scanned, it is complete.
+		if complete {
+			_, appErr = p.API.KVCompareAndSet(index.key, marker, []byte(keyIndexComplete))
+			if appErr != nil {
+				return nil, errors.WithMessagef(appErr, "failed to update the index %q", index.key)
+			}
+		}
+	}
+
+	for _, shardKey := range index.shardKeys() {
+		data, appErr := p.API.KVGet(shardKey)
+		if appErr != nil {
+			return nil, errors.WithMessagef(appErr, "failed to load the index %q", shardKey)
+		}
+		if data == nil {
+			continue
+		}
+		shard := NewStringSet()
+		err := json.Unmarshal(data, &shard)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to load the index %q", shardKey)
+		}
+		keys = keys.Union(shard)
+	}
+	return keys, nil
+}
+
+func (p *Plugin) modifyKeyIndexShard(shardKey string, modify func(StringSet) StringSet) error {
+	err := p.atomicModify(shardKey, func(initialBytes []byte) ([]byte, error) {
+		shard := NewStringSet()
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &shard)
+			if err != nil {
+				return nil, err
+			}
+		}
+		keys := modify(shard).Elems()
+		sort.Strings(keys)
+		return json.Marshal(keys)
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "failed to update the index %q", shardKey)
+	}
+	return nil
+}
+
+// listKeys scans the KV store for the keys that start with prefix.
+func (p *Plugin) listKeys(prefix string) ([]string, error) {
+	found := []string{}
+	for i := 0; ; i++ {
+		keys, appErr := p.API.KVList(i, listPerPage)
+		if appErr != nil {
+			return nil, appErr
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				found = append(found, key)
+			}
+		}
+		if len(keys) < listPerPage {
+			return found, nil
+		}
+	}
+}
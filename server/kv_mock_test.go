@@ -93,6 +93,9 @@ func (store mockUserStore) LoadJIRAUserByAccountId(ji Instance, accoundId string
 func (store mockUserStore) DeleteUserInfo(ji Instance, mattermostUserId string) error {
 	return nil
 }
+func (store mockUserStore) LoadUserInstances(mattermostUserId string) ([]string, error) {
+	return nil, nil
+}
 func (store mockUserStore) CountUsers() (int, error) {
 	return 0, nil
 }
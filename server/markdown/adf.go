@@ -0,0 +1,265 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markdown
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// adfNode is a node of an Atlassian Document Format document, see
+// https://developer.atlassian.com/cloud/jira/platform/apis/document/structure/
+type adfNode struct {
+	Type    string                 `json:"type"`
+	Text    string                 `json:"text,omitempty"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+	Marks   []adfNode              `json:"marks,omitempty"`
+	Content []adfNode              `json:"content,omitempty"`
+}
+
+// FromADF converts an Atlassian Document Format document to Markdown.
+func FromADF(data []byte) (string, error) {
+	doc := adfNode{}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to parse the document")
+	}
+	if doc.Type != "doc" {
+		return "", errors.Errorf("not a document: %q", doc.Type)
+	}
+	return adfBlocks(doc.Content, "\n\n"), nil
+}
+
+// FromJira converts a Jira text field, in wiki markup or in the Atlassian
+// Document Format, to Markdown.
+func FromJira(text string) string {
+	if strings.HasPrefix(strings.TrimSpace(text), "{") {
+		if md, err := FromADF([]byte(text)); err == nil {
+			return md
+		}
+	}
+	return FromWiki(text)
+}
+
+func adfBlocks(nodes []adfNode, sep string) string {
+	blocks := []string{}
+	for _, n := range nodes {
+		if block := adfBlock(n); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	return strings.Join(blocks, sep)
+}
+
+func adfBlock(n adfNode) string {
+	switch n.Type {
+	case "paragraph":
+		return adfInline(n.Content)
+	case "heading":
+		level := int(n.attrFloat("level"))
+		if level < 1 || level > 6 {
+			level = 1
+		}
+		return strings.Repeat("#", level) + " " + adfInline(n.Content)
+	case "bulletList", "orderedList":
+		return adfList(n)
+	case "codeBlock":
+		return "```" + n.attrString("language") + "\n" + adfPlainText(n.Content) + "\n```"
+	case "blockquote":
+		return prefixLines(adfBlocks(n.Content, "\n\n"), "> ", "> ")
+	case "rule":
+		return "---"
+	case "table":
+		return adfTable(n)
+	case "expand", "nestedExpand":
+		title := n.attrString("title")
+		if title == "" {
+			return adfBlocks(n.Content, "\n\n")
+		}
+		return "**" + title + "**\n" + adfBlocks(n.Content, "\n\n")
+	case "mediaSingle", "mediaGroup":
+		return adfInline(n.Content)
+	case "text", "hardBreak", "mention", "emoji", "inlineCard", "status", "date", "media":
+		return adfInline([]adfNode{n})
+	default:
+		// panel, blockCard, unknown nodes: their content
+		if url := n.attrString("url"); url != "" && len(n.Content) == 0 {
+			return url
+		}
+		return adfBlocks(n.Content, "\n\n")
+	}
+}
+
+func adfList(n adfNode) string {
+	order := 1
+	if start := n.attrFloat("order"); start > 0 {
+		order = int(start)
+	}
+	items := []string{}
+	for i, item := range n.Content {
+		marker := "- "
+		if n.Type == "orderedList" {
+			marker = strconv.Itoa(order+i) + ". "
+		}
+		// Nested lists continue the item, without blank lines.
+		text := adfBlocks(item.Content, "\n")
+		items = append(items, prefixLines(text, marker, strings.Repeat(" ", len(marker))))
+	}
+	return strings.Join(items, "\n")
+}
+
+func adfTable(n adfNode) string {
+	rows := []string{}
+	for i, row := range n.Content {
+		cells := []string{}
+		for _, cell := range row.Content {
+			text := adfBlocks(cell.Content, " ")
+			text = strings.ReplaceAll(text, "\n", " ")
+			cells = append(cells, strings.ReplaceAll(text, "|", `\|`))
+		}
+		rows = append(rows, "| "+strings.Join(cells, " | ")+" |")
+		if i == 0 {
+			// Markdown tables need a header, the first row is used as one.
+			rows = append(rows, strings.TrimSpace(strings.Repeat("| --- ", len(cells)))+" |")
+		}
+	}
+	return strings.Join(rows, "\n")
+}
+
+func adfInline(nodes []adfNode) string {
+	out := strings.Builder{}
+	for _, n := range nodes {
+		switch n.Type {
+		case "text":
+			out.WriteString(adfMarks(n.Text, n.Marks))
+		case "hardBreak":
+			out.WriteString("\n")
+		case "mention":
+			// Kept as the wiki mention, the plugin resolves the account ids.
+			if id := n.attrString("id"); id != "" {
+				out.WriteString("[~accountid:" + id + "]")
+			} else {
+				out.WriteString(strings.TrimPrefix(n.attrString("text"), "@"))
+			}
+		case "emoji":
+			if text := n.attrString("text"); text != "" {
+				out.WriteString(text)
+			} else {
+				out.WriteString(n.attrString("shortName"))
+			}
+		case "inlineCard":
+			out.WriteString(n.attrString("url"))
+		case "status":
+			out.WriteString("`" + n.attrString("text") + "`")
+		case "date":
+			ms, err := strconv.ParseInt(n.attrString("timestamp"), 10, 64)
+			if err == nil {
+				out.WriteString(time.Unix(ms/1000, 0).UTC().Format("2006-01-02"))
+			}
+		case "media":
+			if n.attrString("type") == "external" && n.attrString("url") != "" {
+				out.WriteString("![" + n.attrString("alt") + "](" + n.attrString("url") + ")")
+			} else if alt := n.attrString("alt"); alt != "" {
+				out.WriteString("_" + alt + "_")
+			} else {
+				out.WriteString("_attachment_")
+			}
+		default:
+			out.WriteString(adfInline(n.Content))
+		}
+	}
+	return out.String()
+}
+
+func adfMarks(text string, marks []adfNode) string {
+	if text == "" {
+		return ""
+	}
+	// Markdown does not allow spaces inside the delimiters.
+	core := strings.TrimSpace(text)
+	if core == "" {
+		return text
+	}
+	lead := text[:strings.Index(text, core)]
+	trail := text[len(lead)+len(core):]
+
+	code := false
+	for _, mark := range marks {
+		if mark.Type == "code" {
+			code = true
+		}
+	}
+	if code {
+		core = "`" + core + "`"
+	}
+	for _, mark := range marks {
+		switch mark.Type {
+		case "strong":
+			if !code {
+				core = "**" + core + "**"
+			}
+		case "em":
+			if !code {
+				core = "_" + core + "_"
+			}
+		case "strike":
+			core = "~~" + core + "~~"
+		case "link":
+			if href := mark.attrString("href"); href != "" {
+				core = "[" + core + "](" + href + ")"
+			}
+		}
+	}
+	return lead + core + trail
+}
+
+func adfPlainText(nodes []adfNode) string {
+	out := strings.Builder{}
+	for _, n := range nodes {
+		switch n.Type {
+		case "text":
+			out.WriteString(n.Text)
+		case "hardBreak":
+			out.WriteString("\n")
+		default:
+			out.WriteString(adfPlainText(n.Content))
+		}
+	}
+	return out.String()
+}
+
+func (n adfNode) attrString(name string) string {
+	switch v := n.Attrs[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (n adfNode) attrFloat(name string) float64 {
+	v, _ := n.Attrs[name].(float64)
+	return v
+}
+
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		lines[i] = strings.TrimRight(prefix+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
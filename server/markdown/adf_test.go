@@ -0,0 +1,77 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromADF(t *testing.T) {
+	doc := `{"version": 1, "type": "doc", "content": [
+		{"type": "heading", "attrs": {"level": 2}, "content": [{"type": "text", "text": "Title"}]},
+		{"type": "paragraph", "content": [
+			{"type": "text", "text": "Hello "},
+			{"type": "mention", "attrs": {"id": "5d0a", "text": "@Jane"}},
+			{"type": "text", "text": ", this is "},
+			{"type": "text", "text": "bold ", "marks": [{"type": "strong"}]},
+			{"type": "text", "text": "code", "marks": [{"type": "code"}, {"type": "strong"}]},
+			{"type": "text", "text": " and "},
+			{"type": "text", "text": "a link", "marks": [{"type": "link", "attrs": {"href": "https://example.com"}}]},
+			{"type": "hardBreak"},
+			{"type": "status", "attrs": {"text": "DONE"}},
+			{"type": "text", "text": " on "},
+			{"type": "date", "attrs": {"timestamp": "1577836800000"}}
+		]},
+		{"type": "bulletList", "content": [
+			{"type": "listItem", "content": [
+				{"type": "paragraph", "content": [{"type": "text", "text": "one"}]},
+				{"type": "orderedList", "content": [
+					{"type": "listItem", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "nested"}]}]}
+				]}
+			]},
+			{"type": "listItem", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "two"}]}]}
+		]},
+		{"type": "codeBlock", "attrs": {"language": "go"}, "content": [{"type": "text", "text": "x := *y"}]},
+		{"type": "blockquote", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "quoted"}]}]},
+		{"type": "rule"},
+		{"type": "table", "content": [
+			{"type": "tableRow", "content": [
+				{"type": "tableHeader", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "A"}]}]},
+				{"type": "tableHeader", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "B"}]}]}
+			]},
+			{"type": "tableRow", "content": [
+				{"type": "tableCell", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "1|2"}]}]},
+				{"type": "tableCell", "content": [{"type": "paragraph", "content": [{"type": "emoji", "attrs": {"shortName": ":smile:", "text": "😄"}}]}]}
+			]}
+		]},
+		{"type": "mediaSingle", "content": [{"type": "media", "attrs": {"type": "file", "id": "abc", "alt": "screenshot.png"}}]},
+		{"type": "unknownNode", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "kept"}]}]}
+	]}`
+
+	md, err := FromADF([]byte(doc))
+	require.Nil(t, err)
+	assert.Equal(t, "## Title\n\n"+
+		"Hello [~accountid:5d0a], this is **bold** `code` and [a link](https://example.com)\n`DONE` on 2020-01-01\n\n"+
+		"- one\n  1. nested\n- two\n\n"+
+		"```go\nx := *y\n```\n\n"+
+		"> quoted\n\n"+
+		"---\n\n"+
+		"| A | B |\n| --- | --- |\n| 1\\|2 | 😄 |\n\n"+
+		"_screenshot.png_\n\n"+
+		"kept", md)
+
+	_, err = FromADF([]byte(`{"type": "paragraph"}`))
+	require.Error(t, err)
+	_, err = FromADF([]byte(`not json`))
+	require.Error(t, err)
+}
+
+func TestFromJira(t *testing.T) {
+	assert.Equal(t, "**wiki**", FromJira("*wiki*"))
+	assert.Equal(t, "**adf**", FromJira(`{"type": "doc", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "adf", "marks": [{"type": "strong"}]}]}]}`))
+	assert.Equal(t, "```\n{\"not\": \"adf\"}\n```", FromJira("{code}\n{\"not\": \"adf\"}\n{code}"))
+}
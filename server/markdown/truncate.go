@@ -0,0 +1,68 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markdown
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// The inline elements that are not cut through.
+var reMarkdownAtom = regexp.MustCompile(`!?\[[^\]\n]*\]\([^)\n]*\)` +
+	`|` + "`[^`\n]+`" +
+	`|\[~[^\]\s]+\]` +
+	`|(?:https?|ftp)://[^\s)\]]+`)
+
+// Truncate shortens Markdown text to at most max bytes, ending it with "...".
+// It does not cut through a character, a link or an inline code span, and it
+// closes a code block left open.
+func Truncate(text string, max int) string {
+	if max < 0 || len(text) <= max {
+		return text
+	}
+	const ellipsis = "..."
+	const fence = "\n```"
+
+	cut := max - len(ellipsis)
+	if cut < 0 {
+		cut = 0
+	}
+	if isInCodeBlock(text[:cut]) {
+		cut -= len(fence)
+		if cut < 0 {
+			cut = 0
+		}
+	}
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	for _, loc := range reMarkdownAtom.FindAllStringIndex(text, -1) {
+		if loc[0] >= cut {
+			break
+		}
+		if cut < loc[1] {
+			cut = loc[0]
+			break
+		}
+	}
+
+	truncated := text[:cut] + ellipsis
+	if isInCodeBlock(truncated) {
+		truncated += fence
+	}
+	return truncated
+}
+
+// isInCodeBlock returns true if text ends inside a fenced code block.
+func isInCodeBlock(text string) bool {
+	in := false
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimLeft(line, "> ")
+		if strings.HasPrefix(line, "```") {
+			in = !in
+		}
+	}
+	return in
+}
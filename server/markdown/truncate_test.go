@@ -0,0 +1,37 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		in  string
+		max int
+		out string
+	}{
+		"short":          {"short", 10, "short"},
+		"no limit":       {"not short at all", -1, "not short at all"},
+		"plain":          {"0123456789abcdef", 10, "0123456..."},
+		"multibyte":      {"ééééé", 8, "éé..."},
+		"link":           {"see [the docs](https://example.com/docs) now", 20, "see ..."},
+		"inline code":    {"run `make test` now", 12, "run ..."},
+		"mention":        {"ping [~accountid:5d0a] now", 12, "ping ..."},
+		"after the link": {"[a](b) and more text", 12, "[a](b) an..."},
+		"code block": {
+			"text\n```\nline one\nline two\n```\n",
+			20,
+			"text\n```\nline...\n```",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			out := Truncate(tc.in, tc.max)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
@@ -0,0 +1,352 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+// Package markdown converts the Jira wiki markup, and the Atlassian Document
+// Format of Jira Cloud, to Mattermost Markdown.
+package markdown
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	reWikiCode     = regexp.MustCompile(`^\s*\{(code|noformat)(?::([^}]*))?\}(.*)$`)
+	reWikiHeading  = regexp.MustCompile(`^\s*h([1-6])\.\s+(.*)$`)
+	reWikiBq       = regexp.MustCompile(`^\s*bq\.\s+(.*)$`)
+	reWikiList     = regexp.MustCompile(`^\s*([*#-]+)\s+(.*)$`)
+	reWikiRule     = regexp.MustCompile(`^\s*-{4,}\s*$`)
+	reWikiPanel    = regexp.MustCompile(`\{panel(?::([^}]*))?\}`)
+	reWikiColor    = regexp.MustCompile(`\{color(?::[^}]*)?\}`)
+	reWikiCitation = regexp.MustCompile(`\?\?([^?\n]+)\?\?`)
+
+	// The inline elements that are converted as a whole, and not formatted.
+	reWikiInline = regexp.MustCompile(`\{\{(.+?)\}\}` +
+		`|` + "`[^`\n]+`" +
+		`|\[~[^\]\s]+\]` +
+		`|\[([^\[\]|]*)\|([^\[\]]+)\]` +
+		`|\[((?:https?|mailto|ftp):[^\[\]\s]+)\]` +
+		`|!([^\s!|]+\.[[:alnum:]]+|https?://[^\s!|]+)(?:\|[^!\n]*)?!` +
+		`|(?:https?|ftp)://[^\s\[\]]+`)
+)
+
+// FromWiki converts Jira wiki markup to Markdown.
+func FromWiki(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	c := wikiConverter{}
+	for _, line := range strings.Split(text, "\n") {
+		c.line(line)
+	}
+	if c.codeEnd != "" {
+		c.emit("```")
+	}
+	return strings.Join(c.out, "\n")
+}
+
+type wikiConverter struct {
+	out []string
+
+	// The closing tag of the current code block, if any
+	codeEnd string
+	inQuote bool
+	inTable bool
+}
+
+func (c *wikiConverter) emit(line string) {
+	if c.inQuote {
+		line = strings.TrimRight("> "+line, " ")
+	}
+	c.out = append(c.out, line)
+}
+
+// separate makes sure that a block starts after an empty line, so that it
+// does not continue the previous paragraph.
+func (c *wikiConverter) separate() {
+	if len(c.out) > 0 && strings.TrimRight(c.out[len(c.out)-1], "> ") != "" {
+		c.emit("")
+	}
+}
+
+func (c *wikiConverter) line(line string) {
+	if c.codeEnd != "" {
+		end := strings.Index(line, c.codeEnd)
+		if end < 0 {
+			c.emit(line)
+			return
+		}
+		if strings.TrimSpace(line[:end]) != "" {
+			c.emit(line[:end])
+		}
+		c.emit("```")
+		line = line[end+len(c.codeEnd):]
+		c.codeEnd = ""
+		if strings.TrimSpace(line) == "" {
+			return
+		}
+	}
+
+	if m := reWikiCode.FindStringSubmatch(line); m != nil {
+		c.inTable = false
+		c.separate()
+		c.emit("```" + codeLanguage(m[1], m[2]))
+		c.codeEnd = "{" + m[1] + "}"
+		if m[3] != "" {
+			c.line(m[3])
+		}
+		return
+	}
+
+	// {quote} can open and close anywhere on a line.
+	if strings.Contains(line, "{quote}") {
+		for i, part := range strings.Split(line, "{quote}") {
+			if i > 0 {
+				c.inQuote = !c.inQuote
+			}
+			if strings.TrimSpace(part) != "" {
+				c.line(part)
+			}
+		}
+		return
+	}
+
+	if m := reWikiPanel.FindStringSubmatch(line); m != nil {
+		line = reWikiPanel.ReplaceAllString(line, "")
+		if title := panelTitle(m[1]); title != "" {
+			c.emit("**" + title + "**")
+		}
+		if strings.TrimSpace(line) == "" {
+			return
+		}
+	}
+
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "|") {
+		c.tableRow(trimmed)
+		return
+	}
+	c.inTable = false
+
+	switch {
+	case reWikiRule.MatchString(line):
+		c.separate()
+		c.emit("---")
+	case reWikiHeading.MatchString(line):
+		m := reWikiHeading.FindStringSubmatch(line)
+		c.emit(strings.Repeat("#", int(m[1][0]-'0')) + " " + convertWikiInline(m[2]))
+	case reWikiBq.MatchString(line):
+		m := reWikiBq.FindStringSubmatch(line)
+		c.emit("> " + convertWikiInline(m[1]))
+	case reWikiList.MatchString(line):
+		m := reWikiList.FindStringSubmatch(line)
+		c.emit(listPrefix(m[1]) + convertWikiInline(m[2]))
+	default:
+		c.emit(convertWikiInline(line))
+	}
+}
+
+func (c *wikiConverter) tableRow(row string) {
+	header := strings.HasPrefix(row, "||")
+	cells := splitTableRow(row)
+	for i, cell := range cells {
+		cells[i] = strings.ReplaceAll(convertWikiInline(strings.TrimSpace(cell)), "|", `\|`)
+	}
+
+	if !c.inTable {
+		// Markdown tables need a header, the first row is used as one.
+		c.separate()
+		c.emit("| " + strings.Join(cells, " | ") + " |")
+		c.emit(strings.TrimSpace(strings.Repeat("| --- ", len(cells))) + " |")
+		c.inTable = true
+		return
+	}
+	if header {
+		for i, cell := range cells {
+			if cell != "" {
+				cells[i] = "**" + cell + "**"
+			}
+		}
+	}
+	c.emit("| " + strings.Join(cells, " | ") + " |")
+}
+
+// splitTableRow splits a table row on the | and || separators that are not
+// part of a link.
+func splitTableRow(row string) []string {
+	row = strings.TrimSpace(row)
+	row = strings.TrimLeft(row, "|")
+	if strings.HasSuffix(row, "|") {
+		row = strings.TrimRight(row, "|")
+	}
+
+	cells := []string{}
+	depth := 0
+	start := 0
+	for i := 0; i < len(row); i++ {
+		switch row[i] {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			if depth > 0 {
+				depth--
+			}
+		case '|':
+			if depth > 0 {
+				continue
+			}
+			cells = append(cells, row[start:i])
+			if i+1 < len(row) && row[i+1] == '|' {
+				i++
+			}
+			start = i + 1
+		}
+	}
+	return append(cells, row[start:])
+}
+
+func listPrefix(markers string) string {
+	indent := ""
+	for _, m := range markers[:len(markers)-1] {
+		if m == '#' {
+			indent += "   "
+		} else {
+			indent += "  "
+		}
+	}
+	if markers[len(markers)-1] == '#' {
+		return indent + "1. "
+	}
+	return indent + "- "
+}
+
+// codeLanguage returns the language of {code:java} or
+// {code:title=Example.java|language=java}.
+func codeLanguage(tag, params string) string {
+	if tag != "code" {
+		return ""
+	}
+	for _, param := range strings.Split(params, "|") {
+		kv := strings.SplitN(param, "=", 2)
+		switch {
+		case len(kv) == 1:
+			return strings.ToLower(strings.TrimSpace(kv[0]))
+		case strings.TrimSpace(kv[0]) == "language":
+			return strings.ToLower(strings.TrimSpace(kv[1]))
+		}
+	}
+	return ""
+}
+
+func panelTitle(params string) string {
+	for _, param := range strings.Split(params, "|") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "title" {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return ""
+}
+
+func convertWikiInline(text string) string {
+	out := strings.Builder{}
+	last := 0
+	for _, loc := range reWikiInline.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(formatWikiText(text[last:loc[0]]))
+		last = loc[1]
+		group := func(n int) string {
+			if loc[2*n] < 0 {
+				return ""
+			}
+			return text[loc[2*n]:loc[2*n+1]]
+		}
+		match := text[loc[0]:loc[1]]
+
+		switch {
+		case loc[2] >= 0:
+			// {{monospaced}}
+			out.WriteString("`" + group(1) + "`")
+		case loc[4] >= 0:
+			// [title|url]
+			title, url := group(2), strings.TrimSpace(group(3))
+			if !isURL(url) {
+				out.WriteString(formatWikiText(title))
+				continue
+			}
+			if title == "" {
+				title = url
+			}
+			out.WriteString("[" + title + "](" + url + ")")
+		case loc[8] >= 0:
+			// [url]
+			out.WriteString(group(4))
+		case loc[10] >= 0:
+			// !image.png! or !https://example.com/image.png|thumbnail!
+			image := group(5)
+			if isURL(image) {
+				out.WriteString("![" + image[strings.LastIndex(image, "/")+1:] + "](" + image + ")")
+			} else {
+				out.WriteString("_" + image + "_")
+			}
+		default:
+			// `code`, [~mention] and plain URLs are kept
+			out.WriteString(match)
+		}
+	}
+	out.WriteString(formatWikiText(text[last:]))
+	return out.String()
+}
+
+func isURL(s string) bool {
+	for _, scheme := range []string{"http://", "https://", "ftp://", "mailto:"} {
+		if strings.HasPrefix(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatWikiText(text string) string {
+	if text == "" {
+		return ""
+	}
+	text = reWikiColor.ReplaceAllString(text, "")
+	text = strings.ReplaceAll(text, `\\`, "\n")
+	text = reWikiCitation.ReplaceAllString(text, "_${1}_")
+	text = replaceDelimited(text, '*', "**")
+	text = replaceDelimited(text, '-', "~~")
+	text = replaceDelimited(text, '+', "")
+	return text
+}
+
+// replaceDelimited replaces the wiki formatting delimiters around a phrase,
+// like *bold*, with the Markdown ones.
+func replaceDelimited(text string, delim byte, md string) string {
+	if strings.IndexByte(text, delim) < 0 {
+		return text
+	}
+	out := strings.Builder{}
+	for i := 0; i < len(text); i++ {
+		if text[i] != delim || (i > 0 && isWordChar(text[i-1])) ||
+			i+1 >= len(text) || text[i+1] == ' ' || text[i+1] == delim {
+			out.WriteByte(text[i])
+			continue
+		}
+		end := -1
+		for j := i + 1; j < len(text) && text[j] != '\n'; j++ {
+			if text[j] == delim && text[j-1] != ' ' && (j+1 == len(text) || !isWordChar(text[j+1])) {
+				end = j
+				break
+			}
+		}
+		if end < 0 {
+			out.WriteByte(text[i])
+			continue
+		}
+		out.WriteString(md + text[i+1:end] + md)
+		i = end
+	}
+	return out.String()
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromWiki(t *testing.T) {
+	for name, tc := range map[string]struct {
+		in, out string
+	}{
+		"plain":            {"Just text, not *that* long", "Just text, not **that** long"},
+		"windows newlines": {"a\r\nb", "a\nb"},
+		"headings":         {"h1. Title\nh3. Sub *title*", "# Title\n### Sub **title**"},
+		"inline": {
+			"*bold* _italic_ -strike- +under+ {{mono *not bold*}} ??cite??",
+			"**bold** _italic_ ~~strike~~ under `mono *not bold*` _cite_",
+		},
+		"not formatting": {
+			"2*3*4 is a-b-c, - dash - and snake_case_name",
+			"2*3*4 is a-b-c, - dash - and snake_case_name",
+		},
+		"links": {
+			"See [the docs|https://example.com/a-b-c] or [https://example.com] and [~jdoe] [~accountid:5d0a]",
+			"See [the docs](https://example.com/a-b-c) or https://example.com and [~jdoe] [~accountid:5d0a]",
+		},
+		"plain URL is not formatted": {"https://example.com/-a-/*b*", "https://example.com/-a-/*b*"},
+		"anchor link":                {"[top|#anchor]", "top"},
+		"images": {
+			"!screenshot.png|thumbnail! and !https://example.com/logo.png!",
+			"_screenshot.png_ and ![logo.png](https://example.com/logo.png)",
+		},
+		"not an image": {"Wow! It works!", "Wow! It works!"},
+		"lists": {
+			"* one\n** nested\n* two\n# first\n## nested first\n#* nested bullet",
+			"- one\n  - nested\n- two\n1. first\n   1. nested first\n   - nested bullet",
+		},
+		"code": {
+			"Before\n{code:java}\nint *a* = 1;\n{code}\nAfter",
+			"Before\n\n```java\nint *a* = 1;\n```\nAfter",
+		},
+		"code with params": {
+			"{code:title=Foo.go|language=Go}x := 1{code}",
+			"```go\nx := 1\n```",
+		},
+		"noformat":          {"{noformat}\n*raw*\n{noformat}", "```\n*raw*\n```"},
+		"unterminated code": {"{code}\nx", "```\nx\n```"},
+		"quote": {
+			"{quote}\nquoted *text*\nsecond line\n{quote}\nafter",
+			"> quoted **text**\n> second line\nafter",
+		},
+		"inline quote": {"{quote}quoted{quote}", "> quoted"},
+		"bq":           {"bq. quoted", "> quoted"},
+		"table": {
+			"Text\n||Name||Link||\n|one|[link|https://example.com]|\n|two *2*| |",
+			"Text\n\n| Name | Link |\n| --- | --- |\n| one | [link](https://example.com) |\n| two **2** |  |",
+		},
+		"table without header": {"|a|b|", "| a | b |\n| --- | --- |"},
+		"rule":                 {"above\n----\nbelow", "above\n\n---\nbelow"},
+		"panel and color": {
+			"{panel:title=Note}\n{color:red}careful{color}\n{panel}",
+			"**Note**\ncareful",
+		},
+		"line break": {`one\\two`, "one\ntwo"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.out, FromWiki(tc.in))
+		})
+	}
+}
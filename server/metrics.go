@@ -0,0 +1,147 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/expvar"
+)
+
+const (
+	metricsPrefix = "jira_plugin_"
+
+	// How long the expensive metrics are cached for.
+	MetricsCacheDuration = 5 * time.Minute
+)
+
+// metricsCache holds the metrics that scan the KV store.
+type metricsCache struct {
+	lock      sync.Mutex
+	updatedAt time.Time
+
+	connectedUsers int
+
+	// By instance URL, then by channel Id
+	subscriptions map[string]map[string]int
+}
+
+// get returns the cached metrics, updated if they are older than
+// MetricsCacheDuration.
+func (c *metricsCache) get(p *Plugin, now time.Time) (int, map[string]map[string]int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.updatedAt.IsZero() && now.Sub(c.updatedAt) < MetricsCacheDuration {
+		return c.connectedUsers, c.subscriptions, nil
+	}
+
+	connectedUsers, err := p.userStore.CountUsers()
+	if err != nil {
+		return 0, nil, errors.WithMessage(err, "failed to count the connected users")
+	}
+	subscriptions := map[string]map[string]int{}
+	known, err := p.instanceStore.LoadKnownJIRAInstances()
+	if err != nil {
+		return 0, nil, err
+	}
+	for instanceURL := range known {
+		ji, err := p.instanceStore.LoadJIRAInstance(instanceURL)
+		if err != nil {
+			continue
+		}
+		subs, err := p.getSubscriptions(ji)
+		if err != nil {
+			return 0, nil, err
+		}
+		byChannel := map[string]int{}
+		for channelId, ids := range subs.Channel.IdByChannelId {
+			if ids.Len() > 0 {
+				byChannel[channelId] = ids.Len()
+			}
+		}
+		subscriptions[instanceURL] = byChannel
+	}
+
+	c.connectedUsers, c.subscriptions, c.updatedAt = connectedUsers, subscriptions, now
+	return connectedUsers, subscriptions, nil
+}
+
+// httpMetrics renders the stats, and the state of the plugin, in the
+// Prometheus text exposition format.
+func httpMetrics(p *Plugin, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return respondErr(w, http.StatusMethodNotAllowed,
+			errors.New("method "+r.Method+" is not allowed, must be GET"))
+	}
+	status, err := authorizeStatsRequest(p, r)
+	if err != nil {
+		return respondErr(w, status, err)
+	}
+
+	out := &bytes.Buffer{}
+	err = p.writeMetrics(out, time.Now())
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, err = w.Write(out.Bytes())
+	if err != nil {
+		return http.StatusInternalServerError, errors.WithMessage(err, "failed to write response")
+	}
+	return http.StatusOK, nil
+}
+
+func (p *Plugin) writeMetrics(out *bytes.Buffer, now time.Time) error {
+	if stats := p.getConfig().stats; stats != nil {
+		err := stats.WritePrometheus(out, metricsPrefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	queued, bufferSize, busy, maxProcs := p.webhookPool.status()
+	pw := expvar.NewPrometheusWriter(out)
+	pw.Header(metricsPrefix+"webhook_queue_length", "gauge", "The number of webhook events waiting for a worker.")
+	pw.Sample(metricsPrefix+"webhook_queue_length", nil, float64(queued))
+	pw.Header(metricsPrefix+"webhook_queue_capacity", "gauge", "The number of webhook events that can wait for a worker.")
+	pw.Sample(metricsPrefix+"webhook_queue_capacity", nil, float64(bufferSize))
+	pw.Header(metricsPrefix+"webhook_workers_busy", "gauge", "The number of webhook workers processing an event.")
+	pw.Sample(metricsPrefix+"webhook_workers_busy", nil, float64(busy))
+	pw.Header(metricsPrefix+"webhook_workers", "gauge", "The number of webhook workers.")
+	pw.Sample(metricsPrefix+"webhook_workers", nil, float64(maxProcs))
+
+	connectedUsers, subscriptions, err := p.metricsCache.get(p, now)
+	if err != nil {
+		return err
+	}
+	pw.Header(metricsPrefix+"connected_users", "gauge", "The number of Mattermost users connected to Jira.")
+	pw.Sample(metricsPrefix+"connected_users", nil, float64(connectedUsers))
+
+	pw.Header(metricsPrefix+"channel_subscriptions", "gauge", "The number of subscriptions of each channel.")
+	instanceURLs := []string{}
+	for instanceURL := range subscriptions {
+		instanceURLs = append(instanceURLs, instanceURL)
+	}
+	sort.Strings(instanceURLs)
+	for _, instanceURL := range instanceURLs {
+		channelIds := []string{}
+		for channelId := range subscriptions[instanceURL] {
+			channelIds = append(channelIds, channelId)
+		}
+		sort.Strings(channelIds)
+		for _, channelId := range channelIds {
+			pw.Sample(metricsPrefix+"channel_subscriptions",
+				[][2]string{{"instance", instanceURL}, {"channel_id", channelId}},
+				float64(subscriptions[instanceURL][channelId]))
+		}
+	}
+	return pw.Err()
+}
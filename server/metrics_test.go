@@ -0,0 +1,98 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/expvar"
+)
+
+type mockInstanceStoreKnown struct {
+	mockInstanceStore
+}
+
+func (store mockInstanceStoreKnown) LoadKnownJIRAInstances() (map[string]string, error) {
+	return map[string]string{otherInstanceURL: JIRATypeServer}, nil
+}
+
+type mockUserStoreCount struct {
+	mockUserStore
+	count int
+}
+
+func (store *mockUserStoreCount) CountUsers() (int, error) {
+	store.count++
+	return 12, nil
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	userStore := &mockUserStoreCount{}
+	p.userStore = userStore
+	p.instanceStore = mockInstanceStoreKnown{mockInstanceStore{plugin: p}}
+	p.webhookPool = newWebhookWorkerPool(p)
+	p.webhookPool.resize(0, 10)
+	p.webhookPool.getQueue() <- &webhookMessage{}
+	p.webhookPool.busy = 2
+	p.updateConfig(func(conf *config) {
+		conf.StatsSecret = "somesecret"
+		conf.stats = expvar.NewUnpublishedStats(nil)
+		conf.stats.EnsureEndpoint("api/v2/stats").Record(10, 10, time.Millisecond, false, false)
+	})
+
+	ji, err := p.instanceStore.LoadJIRAInstance(otherInstanceURL)
+	require.Nil(t, err)
+	channelId := model.NewId()
+	subs := NewSubscriptions()
+	subs.Channel.add(&ChannelSubscription{Id: model.NewId(), ChannelId: channelId})
+	subs.Channel.add(&ChannelSubscription{Id: model.NewId(), ChannelId: channelId})
+	data, err := json.Marshal(subs)
+	require.Nil(t, err)
+	api.On("KVGet", keyWithInstance(ji, JIRA_SUBSCRIPTIONS_KEY)).Return(data, nil)
+	api.On("GetUser", mock.Anything).Return(&model.User{Roles: model.SYSTEM_USER_ROLE_ID}, nil)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Mattermost-User-Id", model.NewId())
+		p.serveHTTP(&plugin.Context{}, w, r)
+		return w
+	}
+
+	w := get("/metrics")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = get("/metrics?secret=wrong")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = get("/metrics?secret=somesecret")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	text := w.Body.String()
+	assert.Contains(t, text, `jira_plugin_requests_total{endpoint="api/v2/stats"} 1`+"\n")
+	assert.Contains(t, text, "jira_plugin_webhook_queue_length 1\n")
+	assert.Contains(t, text, "jira_plugin_webhook_queue_capacity 10\n")
+	assert.Contains(t, text, "jira_plugin_webhook_workers_busy 2\n")
+	assert.Contains(t, text, "jira_plugin_webhook_workers 0\n")
+	assert.Contains(t, text, "jira_plugin_connected_users 12\n")
+	assert.Contains(t, text, `jira_plugin_channel_subscriptions{instance="`+otherInstanceURL+`",channel_id="`+channelId+`"} 2`+"\n")
+
+	// The users are not counted again until the cache expires.
+	w = get("/metrics?secret=somesecret")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, userStore.count)
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	prefixDeferredNotifications   = "deferred_dm_"
+	keyDeferredNotificationsIndex = "index_deferred_dm"
+
+	// How often the deferred notifications are checked, and sent if due.
+	DeferredNotificationsCheckInterval = time.Minute
+
+	// How long a server has to send the notifications it claimed, before
+	// another server may send them.
+	DeferredNotificationsClaimTimeout = 5 * time.Minute
+
+	// Notifications past this count are only counted.
+	DeferredNotificationsMax = 100
+)
+
+var deferredNotificationsIndex = keyIndex{key: keyDeferredNotificationsIndex, prefix: prefixDeferredNotifications}
+
+// deferredNotifications are the DMs to a user held by the quiet hours or
+// the batching of the user settings.
+type deferredNotifications struct {
+	InstanceURL      string                 `json:"instance_url"`
+	MattermostUserId string                 `json:"mattermost_user_id"`
+	Since            time.Time              `json:"since"`
+	Notifications    []deferredNotification `json:"notifications"`
+	Dropped          int                    `json:"dropped,omitempty"`
+
+	// Set while a server sends the notifications, until they may be sent
+	// by another server.
+	Sending time.Time `json:"sending,omitempty"`
+}
+
+type deferredNotification struct {
+	Message  string    `json:"message"`
+	PostType string    `json:"post_type,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+func deferredNotificationsKey(instanceURL, mattermostUserId string) string {
+	return hashkey(prefixDeferredNotifications, instanceURL+"/"+mattermostUserId)
+}
+
+// deferNotification holds a DM to mattermostUserId until it is due.
+func (p *Plugin) deferNotification(ji Instance, mattermostUserId, message, postType string) error {
+	n := deferredNotification{
+		Message:  message,
+		PostType: postType,
+		Time:     time.Now(),
+	}
+	err := p.atomicModify(deferredNotificationsKey(ji.GetURL(), mattermostUserId), func(initialBytes []byte) ([]byte, error) {
+		d := deferredNotifications{
+			InstanceURL:      ji.GetURL(),
+			MattermostUserId: mattermostUserId,
+		}
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &d)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// The batch starts with its first notification.
+		if len(d.Notifications) == 0 && d.Dropped == 0 {
+			d.Since = n.Time
+		}
+		if len(d.Notifications) < DeferredNotificationsMax {
+			d.Notifications = append(d.Notifications, n)
+		} else {
+			d.Dropped++
+		}
+		return json.Marshal(&d)
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "failed to defer a notification to user %s", mattermostUserId)
+	}
+	return p.addToKeyIndex(deferredNotificationsIndex, deferredNotificationsKey(ji.GetURL(), mattermostUserId))
+}
+
+// due returns whether the deferred notifications of a user with settings
+// are sent at now.
+func (d deferredNotifications) due(settings *UserSettings, now time.Time) bool {
+	if settings == nil {
+		return true
+	}
+	if settings.QuietHours != nil {
+		end, _ := settings.QuietHours.until(now)
+		if !end.IsZero() {
+			return false
+		}
+	}
+	if settings.BatchMinutes > 0 {
+		return !d.Since.Add(time.Duration(settings.BatchMinutes) * time.Minute).After(now)
+	}
+	return true
+}
+
+// sendDueNotifications sends the deferred notifications that are due at
+// now. They are claimed atomically, so only one server sends each of them.
+func (p *Plugin) sendDueNotifications(now time.Time) {
+	index, err := p.loadKeyIndex(deferredNotificationsIndex)
+	if err != nil {
+		p.errorf("Failed to list deferred notifications, err: %v", err)
+		return
+	}
+	for _, key := range index.Elems() {
+		err := p.sendNotificationsIfDue(key, now)
+		if err != nil {
+			p.errorf("Failed to send deferred notifications %s, err: %v", key, err)
+		}
+	}
+}
+
+func (p *Plugin) sendNotificationsIfDue(key string, now time.Time) error {
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return appErr
+	}
+	if data == nil {
+		return p.removeFromKeyIndex(deferredNotificationsIndex, key)
+	}
+	d := deferredNotifications{}
+	err := json.Unmarshal(data, &d)
+	if err != nil {
+		return err
+	}
+	if len(d.Notifications) == 0 {
+		return nil
+	}
+
+	ji, err := p.loadInstance(d.InstanceURL)
+	if err != nil {
+		return err
+	}
+	// The notifications of users who disconnected are dropped. When the user
+	// fails to be loaded, they are kept for the next tick.
+	var settings *UserSettings
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, d.MattermostUserId)
+	switch err {
+	case nil:
+		settings = jiraUser.Settings
+	case ErrUserNotFound:
+	default:
+		return err
+	}
+	if !d.due(settings, now) {
+		return nil
+	}
+
+	// The notifications are claimed while they are sent, and only cleared
+	// once they are sent, so that a failed post is retried. Users who
+	// disconnected or turned the notifications off in the meantime don't
+	// get them.
+	wanted := settings.wantsNotification("", "")
+	var taken deferredNotifications
+	claimed := false
+	err = p.atomicModify(key, func(initialBytes []byte) ([]byte, error) {
+		claimed = false
+		taken = deferredNotifications{}
+		if initialBytes == nil {
+			return nil, errors.New("deferred notifications were deleted")
+		}
+		err := json.Unmarshal(initialBytes, &taken)
+		if err != nil {
+			return nil, err
+		}
+		if !taken.Since.Equal(d.Since) || taken.Sending.After(now) {
+			// Already sent, or being sent, by another server.
+			return initialBytes, nil
+		}
+		if !wanted {
+			return json.Marshal(&deferredNotifications{
+				InstanceURL:      taken.InstanceURL,
+				MattermostUserId: taken.MattermostUserId,
+				Since:            now,
+			})
+		}
+		claimed = true
+		taken.Sending = now.Add(DeferredNotificationsClaimTimeout)
+		return json.Marshal(&taken)
+	})
+	if err != nil || !claimed {
+		return err
+	}
+
+	appErr = p.postDeferredNotifications(&taken)
+
+	err = p.atomicModify(key, func(initialBytes []byte) ([]byte, error) {
+		if initialBytes == nil {
+			return nil, nil
+		}
+		current := deferredNotifications{}
+		err := json.Unmarshal(initialBytes, &current)
+		if err != nil {
+			return nil, err
+		}
+		if !current.Since.Equal(taken.Since) {
+			return initialBytes, nil
+		}
+		current.Sending = time.Time{}
+		if appErr != nil {
+			return json.Marshal(&current)
+		}
+		// Keep the notifications deferred while sending, for the next batch.
+		current.Since = now
+		if len(current.Notifications) > len(taken.Notifications) {
+			current.Notifications = current.Notifications[len(taken.Notifications):]
+			current.Since = current.Notifications[0].Time
+		} else {
+			current.Notifications = nil
+		}
+		current.Dropped -= taken.Dropped
+		if current.Dropped < 0 {
+			current.Dropped = 0
+		}
+		return json.Marshal(&current)
+	})
+	if appErr != nil {
+		return appErr
+	}
+	return err
+}
+
+func (p *Plugin) postDeferredNotifications(d *deferredNotifications) *model.AppError {
+	channel, appErr := p.API.GetDirectChannel(d.MattermostUserId, p.getUserID())
+	if appErr != nil {
+		return appErr
+	}
+	post := &model.Post{
+		UserId:    p.getUserID(),
+		ChannelId: channel.Id,
+		Message:   renderDeferredNotifications(d),
+	}
+	if len(d.Notifications) == 1 && d.Dropped == 0 {
+		post.Type = d.Notifications[0].PostType
+	}
+	_, appErr = p.API.CreatePost(post)
+	return appErr
+}
+
+func renderDeferredNotifications(d *deferredNotifications) string {
+	if len(d.Notifications) == 1 && d.Dropped == 0 {
+		return d.Notifications[0].Message
+	}
+
+	messages := []string{
+		fmt.Sprintf("##### %d Jira notifications", len(d.Notifications)+d.Dropped),
+	}
+	for _, n := range d.Notifications {
+		messages = append(messages, n.Message)
+	}
+	if d.Dropped > 0 {
+		messages = append(messages, fmt.Sprintf("_%d more notification(s) are not shown._", d.Dropped))
+	}
+	return strings.Join(messages, "\n\n")
+}
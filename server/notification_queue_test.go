@@ -0,0 +1,207 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+func TestQuietHoursUntil(t *testing.T) {
+	// Wednesday
+	now := time.Date(2020, 1, 15, 23, 30, 0, 0, time.UTC)
+
+	for name, tc := range map[string]struct {
+		quietHours  QuietHours
+		expected    time.Time
+		expectedErr bool
+	}{
+		"over midnight, before midnight": {
+			quietHours: QuietHours{Start: "22:00", End: "07:00"},
+			expected:   time.Date(2020, 1, 16, 7, 0, 0, 0, time.UTC),
+		},
+		"over midnight, after midnight": {
+			quietHours: QuietHours{Start: "18:00", End: "08:00", Timezone: "America/New_York"},
+			expected:   time.Date(2020, 1, 16, 13, 0, 0, 0, time.UTC),
+		},
+		"same day": {
+			quietHours: QuietHours{Start: "23:00", End: "23:45"},
+			expected:   time.Date(2020, 1, 15, 23, 45, 0, 0, time.UTC),
+		},
+		"outside": {
+			quietHours: QuietHours{Start: "12:00", End: "14:00"},
+		},
+		"ends now": {
+			quietHours: QuietHours{Start: "22:00", End: "23:30"},
+		},
+		"bad time": {
+			quietHours:  QuietHours{Start: "10pm", End: "07:00"},
+			expectedErr: true,
+		},
+		"bad timezone": {
+			quietHours:  QuietHours{Start: "22:00", End: "07:00", Timezone: "Mars/Olympus"},
+			expectedErr: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			end, err := tc.quietHours.until(now)
+			if tc.expectedErr {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.True(t, tc.expected.Equal(end), "expected %v, got %v", tc.expected, end)
+		})
+	}
+}
+
+func TestUserSettingsWantsNotification(t *testing.T) {
+	settings := &UserSettings{
+		Notifications:  true,
+		DisabledEvents: NewStringSet(notifyMentioned),
+		MutedProjects:  NewStringSet("MUTED"),
+	}
+	assert.True(t, settings.wantsNotification(notifyAssigned, "TES"))
+	assert.True(t, settings.wantsNotification("", "TES"))
+	assert.False(t, settings.wantsNotification(notifyMentioned, "TES"))
+	assert.False(t, settings.wantsNotification(notifyAssigned, "MUTED"))
+	assert.False(t, settings.wantsNotification("", "MUTED"))
+
+	settings.Notifications = false
+	assert.False(t, settings.wantsNotification(notifyAssigned, "TES"))
+	assert.False(t, (*UserSettings)(nil).wantsNotification(notifyAssigned, "TES"))
+}
+
+type mockUserStoreLoadErr struct {
+	mockUserStore
+	err error
+}
+
+func (store mockUserStoreLoadErr) LoadJIRAUser(ji Instance, mattermostUserId string) (JIRAUser, error) {
+	return JIRAUser{}, store.err
+}
+
+func TestSendNotificationsIfDue(t *testing.T) {
+	since := time.Date(2020, 1, 15, 9, 30, 0, 0, time.UTC)
+	key := deferredNotificationsKey(mockCurrentInstanceURL, mockUserIDWithNotifications)
+	deferredBytes, err := json.Marshal(&deferredNotifications{
+		InstanceURL:      mockCurrentInstanceURL,
+		MattermostUserId: mockUserIDWithNotifications,
+		Since:            since,
+		Notifications: []deferredNotification{
+			{Message: "first", PostType: PostTypeMention, Time: since},
+			{Message: "second", PostType: PostTypeComment, Time: since.Add(time.Minute)},
+		},
+	})
+	require.Nil(t, err)
+
+	for name, tc := range map[string]struct {
+		settings      UserSettings
+		loadErr       error
+		now           time.Time
+		postErr       *model.AppError
+		expectedPost  bool
+		expectedClear bool
+	}{
+		"batch not due": {
+			settings: UserSettings{Notifications: true, BatchMinutes: 30},
+			now:      since.Add(20 * time.Minute),
+		},
+		"batch due": {
+			settings:      UserSettings{Notifications: true, BatchMinutes: 30},
+			now:           since.Add(30 * time.Minute),
+			expectedPost:  true,
+			expectedClear: true,
+		},
+		"post failed": {
+			settings:     UserSettings{Notifications: true, BatchMinutes: 30},
+			now:          since.Add(30 * time.Minute),
+			postErr:      &model.AppError{Message: "failed"},
+			expectedPost: true,
+		},
+		"quiet hours": {
+			settings: UserSettings{Notifications: true, QuietHours: &QuietHours{Start: "09:00", End: "10:00"}},
+			now:      since.Add(20 * time.Minute),
+		},
+		"quiet hours ended": {
+			settings:      UserSettings{Notifications: true, QuietHours: &QuietHours{Start: "09:00", End: "10:00"}},
+			now:           since.Add(40 * time.Minute),
+			expectedPost:  true,
+			expectedClear: true,
+		},
+		"notifications turned off": {
+			settings:      UserSettings{Notifications: false},
+			now:           since.Add(40 * time.Minute),
+			expectedClear: true,
+		},
+		"disconnected": {
+			loadErr:       ErrUserNotFound,
+			now:           since.Add(40 * time.Minute),
+			expectedClear: true,
+		},
+		"failed to load the user": {
+			loadErr: errors.New("failed"),
+			now:     since.Add(40 * time.Minute),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.currentInstanceStore = mockCurrentInstanceStore{p}
+			p.userStore = mockUserStoreKV{kv: map[string]JIRAUser{
+				mockUserIDWithNotifications: {Settings: &tc.settings},
+			}}
+			if tc.loadErr != nil {
+				p.userStore = mockUserStoreLoadErr{err: tc.loadErr}
+			}
+
+			kv := map[string][]byte{key: deferredBytes}
+			api.On("KVGet", mock.Anything).Return(func(key string) []byte {
+				return kv[key]
+			}, nil)
+			api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				kv[args.String(0)] = args.Get(2).([]byte)
+			}).Return(true, nil)
+			api.On("GetDirectChannel", mockUserIDWithNotifications, mock.Anything).Return(&model.Channel{Id: "dmChannelId"}, nil)
+			api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+				return post.ChannelId == "dmChannelId" && post.Type == "" &&
+					post.Message == "##### 2 Jira notifications\n\nfirst\n\nsecond"
+			})).Return(&model.Post{}, tc.postErr)
+
+			err := p.sendNotificationsIfDue(key, tc.now)
+			if tc.postErr != nil || (tc.loadErr != nil && tc.loadErr != ErrUserNotFound) {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+			}
+			if tc.expectedPost {
+				api.AssertCalled(t, "CreatePost", mock.Anything)
+			} else {
+				api.AssertNotCalled(t, "CreatePost", mock.Anything)
+			}
+
+			d := deferredNotifications{}
+			require.Nil(t, json.Unmarshal(kv[key], &d))
+			assert.True(t, d.Sending.IsZero())
+			if tc.expectedClear {
+				assert.Len(t, d.Notifications, 0)
+				assert.True(t, d.Since.Equal(tc.now))
+			} else {
+				// Kept for later, or for another attempt.
+				assert.Len(t, d.Notifications, 2)
+				assert.True(t, d.Since.Equal(since))
+			}
+		})
+	}
+}
@@ -0,0 +1,474 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	// The template for the events that have no template of their own.
+	templateDefaultEvent = "default"
+
+	defaultNotificationColor = "#95b7d0"
+)
+
+// NotificationTemplate customizes the posts of an event type. The headline
+// and text are Go text/templates executed with notificationTemplateData.
+// Empty parts use the built-in format.
+type NotificationTemplate struct {
+	Headline string `json:"headline,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Color    string `json:"color,omitempty"`
+}
+
+// NotificationTemplates are the templates by event type, or
+// templateDefaultEvent.
+type NotificationTemplates map[string]NotificationTemplate
+
+// notificationTemplateData is available to the templates.
+type notificationTemplateData struct {
+	// The event type, and all the event types of a merged update
+	Event  string
+	Events []string
+
+	Issue     jira.Issue
+	IssueURL  string
+	IssueLink string
+	User      jira.User
+	Comment   jira.Comment
+	ChangeLog interface{}
+
+	// The built-in headline and text
+	Headline string
+	Text     string
+}
+
+var reNotificationColor = regexp.MustCompile(`^#[[:xdigit:]]{6}$`)
+
+var notificationTemplateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"truncate": func(max int, s string) string {
+		return truncate(s, max)
+	},
+	"user": func(u jira.User) string {
+		return mdUser(&u)
+	},
+}
+
+// MaxNotificationTemplateCacheEntries is the number of parsed templates
+// kept, past which the cache starts over. The templates previewed or
+// rejected would otherwise accumulate.
+const MaxNotificationTemplateCacheEntries = 1000
+
+// Parsed templates are cached by their source.
+var notificationTemplateCache = struct {
+	lock   sync.Mutex
+	parsed map[string]*template.Template
+}{}
+
+func parseNotificationTemplate(src string) (*template.Template, error) {
+	notificationTemplateCache.lock.Lock()
+	t := notificationTemplateCache.parsed[src]
+	notificationTemplateCache.lock.Unlock()
+	if t != nil {
+		return t, nil
+	}
+	t, err := template.New("notification").Funcs(notificationTemplateFuncs).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	notificationTemplateCache.lock.Lock()
+	defer notificationTemplateCache.lock.Unlock()
+	if notificationTemplateCache.parsed == nil || len(notificationTemplateCache.parsed) >= MaxNotificationTemplateCacheEntries {
+		notificationTemplateCache.parsed = map[string]*template.Template{}
+	}
+	notificationTemplateCache.parsed[src] = t
+	return t, nil
+}
+
+func (t NotificationTemplate) validate() error {
+	if t.Color != "" && !reNotificationColor.MatchString(t.Color) {
+		return errors.Errorf("color must be like %s, not %q", defaultNotificationColor, t.Color)
+	}
+	// Executing against the sample catches the references to missing data.
+	wh := sampleTemplateWebhook(eventUpdatedStatus)
+	_, _, _, err := wh.render(t)
+	return err
+}
+
+func (tt NotificationTemplates) validate() error {
+	for event, t := range tt {
+		if event != templateDefaultEvent && !allEvents.ContainsAny(event) && !strings.HasPrefix(event, "event_updated_") {
+			return errors.Errorf("unknown event type %q", event)
+		}
+		if err := t.validate(); err != nil {
+			return errors.WithMessagef(err, "invalid template for %s", event)
+		}
+	}
+	return nil
+}
+
+// forEvents returns the template of the first of events that has one, with
+// its empty parts taken from the default template.
+func (tt NotificationTemplates) forEvents(events StringSet) NotificationTemplate {
+	names := events.Elems()
+	sort.Strings(names)
+	for _, event := range names {
+		if t, ok := tt[event]; ok {
+			return t.Override(tt[templateDefaultEvent])
+		}
+	}
+	return tt[templateDefaultEvent]
+}
+
+// Override returns t with its empty parts taken from defaults.
+func (t NotificationTemplate) Override(defaults NotificationTemplate) NotificationTemplate {
+	if t.Headline == "" {
+		t.Headline = defaults.Headline
+	}
+	if t.Text == "" {
+		t.Text = defaults.Text
+	}
+	if t.Color == "" {
+		t.Color = defaults.Color
+	}
+	return t
+}
+
+// notificationTemplate returns the template for wh: the subscription's,
+// falling back to the ones set by the administrators.
+func (p *Plugin) notificationTemplate(wh *webhook, subTemplates NotificationTemplates) NotificationTemplate {
+	events := wh.Events()
+	return subTemplates.forEvents(events).Override(p.getConfig().notificationTemplates.forEvents(events))
+}
+
+func (wh *webhook) templateData() notificationTemplateData {
+	jwh := wh.JiraWebhook
+	events := wh.Events().Elems()
+	sort.Strings(events)
+	data := notificationTemplateData{
+		Events:    events,
+		Issue:     jwh.Issue,
+		IssueLink: jwh.mdKeySummaryLink(),
+		User:      jwh.User,
+		Comment:   jwh.Comment,
+		ChangeLog: jwh.ChangeLog.Items,
+		Headline:  wh.headline,
+		Text:      wh.text,
+	}
+	if len(events) > 0 {
+		data.Event = events[0]
+	}
+	if pos := strings.LastIndex(jwh.Issue.Self, "/rest/api"); pos >= 0 {
+		data.IssueURL = jwh.Issue.Self[:pos] + "/browse/" + jwh.Issue.Key
+	}
+	return data
+}
+
+// render returns the headline, text and color of the posts of wh.
+func (wh *webhook) render(t NotificationTemplate) (headline, text, color string, err error) {
+	headline, text, color = wh.headline, wh.text, defaultNotificationColor
+	if t.Color != "" {
+		color = t.Color
+	}
+	if t.Headline == "" && t.Text == "" {
+		return headline, text, color, nil
+	}
+
+	data := wh.templateData()
+	execute := func(src string) (string, error) {
+		tmpl, err := parseNotificationTemplate(src)
+		if err != nil {
+			return "", err
+		}
+		buf := &bytes.Buffer{}
+		err = tmpl.Execute(buf, data)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(buf.String()), nil
+	}
+
+	if t.Headline != "" {
+		headline, err = execute(t.Headline)
+		if err != nil {
+			return "", "", "", errors.WithMessage(err, "headline")
+		}
+	}
+	if t.Text != "" {
+		text, err = execute(t.Text)
+		if err != nil {
+			return "", "", "", errors.WithMessage(err, "text")
+		}
+	}
+	return headline, text, color, nil
+}
+
+// sampleTemplateWebhook is used to validate and preview the templates.
+func sampleTemplateWebhook(event string) *webhook {
+	user := jira.User{Name: "jdoe", DisplayName: "Jane Doe", AccountID: "5d0000000000000000000001"}
+	jwh := &JiraWebhook{
+		WebhookEvent: "jira:issue_updated",
+		User:         user,
+		Issue: jira.Issue{
+			ID:   "10000",
+			Key:  "PROJ-1",
+			Self: "https://jira.example.com/rest/api/2/issue/10000",
+			Fields: &jira.IssueFields{
+				Summary:     "Sample issue summary",
+				Description: "Sample issue description.",
+				Type:        jira.IssueType{Name: "Story"},
+				Project:     jira.Project{Key: "PROJ", Name: "Project"},
+				Status:      &jira.Status{Name: "In Progress"},
+				Priority:    &jira.Priority{Name: "High"},
+				Labels:      []string{"sample"},
+				Assignee:    &user,
+				Reporter:    &user,
+			},
+		},
+		Comment: jira.Comment{
+			Body:   "Sample comment.",
+			Author: user,
+		},
+	}
+	jwh.ChangeLog.Items = append(jwh.ChangeLog.Items, struct {
+		From       string
+		FromString string
+		To         string
+		ToString   string
+		Field      string
+		FieldId    string
+		FieldType  string `json:"fieldtype"`
+	}{FromString: "To Do", ToString: "In Progress", Field: "status", FieldId: "status", FieldType: "jira"})
+
+	wh := newWebhook(jwh, event, "**updated** %s from %q to %q on", "status", "To Do", "In Progress")
+	wh.text = jwh.mdIssueDescription()
+	return wh
+}
+
+func parseNotificationTemplates(src string) (NotificationTemplates, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, nil
+	}
+	tt := NotificationTemplates{}
+	err := json.Unmarshal([]byte(src), &tt)
+	if err != nil {
+		return nil, err
+	}
+	err = tt.validate()
+	if err != nil {
+		return nil, err
+	}
+	return tt, nil
+}
+
+const templateHelpText = "###### Notification templates\n" +
+	"Templates are Go [text/templates](https://golang.org/pkg/text/template/), with access to `.Event`, `.Issue`, `.IssueURL`, `.IssueLink`, `.User`, `.Comment`, `.ChangeLog`, and the built-in `.Headline` and `.Text`. " +
+	"Use `default` as the event type for the events without a template of their own.\n" +
+	"* `/jira template list` - Show the notification templates\n" +
+	"* `/jira template set <event-type> <headline|text|color> <template>` - Set a part of the template of an event type\n" +
+	"* `/jira template unset <event-type> [headline|text|color]` - Remove a template, or a part of it\n" +
+	"* `/jira template preview <event-type> [headline template]` - Preview the template of an event type, or the given headline template, with a sample issue\n"
+
+func executeTemplate(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	return p.responsef(header, templateHelpText)
+}
+
+func executeTemplateList(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if resp := p.authorizeTemplateCommand(header); resp != nil {
+		return resp
+	}
+	tt := p.getConfig().notificationTemplates
+	if len(tt) == 0 {
+		return p.responsef(header, "No notification templates are set, the built-in format is used.")
+	}
+	events := []string{}
+	for event := range tt {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	text := "Notification templates:\n"
+	for _, event := range events {
+		t := tt[event]
+		text += fmt.Sprintf("* `%s`\n", event)
+		if t.Headline != "" {
+			text += fmt.Sprintf("  * headline: `%s`\n", t.Headline)
+		}
+		if t.Text != "" {
+			text += fmt.Sprintf("  * text: `%s`\n", t.Text)
+		}
+		if t.Color != "" {
+			text += fmt.Sprintf("  * color: `%s`\n", t.Color)
+		}
+	}
+	return p.responsef(header, text)
+}
+
+func executeTemplateSet(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if resp := p.authorizeTemplateCommand(header); resp != nil {
+		return resp
+	}
+	if len(args) < 3 {
+		return p.responsef(header, templateHelpText)
+	}
+	event, part, value := args[0], args[1], strings.Join(args[2:], " ")
+
+	err := p.updateNotificationTemplates(func(tt NotificationTemplates) error {
+		t := tt[event]
+		switch part {
+		case "headline":
+			t.Headline = value
+		case "text":
+			t.Text = value
+		case "color":
+			t.Color = value
+		default:
+			return errors.Errorf("unknown template part %q", part)
+		}
+		tt[event] = t
+		return nil
+	})
+	if err != nil {
+		return p.responsef(header, "Failed to set the template: %v", err)
+	}
+	return p.responsef(header, "Set the %s template for `%s`.", part, event)
+}
+
+func executeTemplateUnset(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if resp := p.authorizeTemplateCommand(header); resp != nil {
+		return resp
+	}
+	if len(args) != 1 && len(args) != 2 {
+		return p.responsef(header, templateHelpText)
+	}
+	event := args[0]
+
+	err := p.updateNotificationTemplates(func(tt NotificationTemplates) error {
+		t, ok := tt[event]
+		if !ok {
+			return errors.Errorf("there is no template for %q", event)
+		}
+		if len(args) == 1 {
+			delete(tt, event)
+			return nil
+		}
+		switch args[1] {
+		case "headline":
+			t.Headline = ""
+		case "text":
+			t.Text = ""
+		case "color":
+			t.Color = ""
+		default:
+			return errors.Errorf("unknown template part %q", args[1])
+		}
+		if t == (NotificationTemplate{}) {
+			delete(tt, event)
+		} else {
+			tt[event] = t
+		}
+		return nil
+	})
+	if err != nil {
+		return p.responsef(header, "Failed to remove the template: %v", err)
+	}
+	return p.responsef(header, "Removed the template for `%s`.", event)
+}
+
+func executeTemplatePreview(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if resp := p.authorizeTemplateCommand(header); resp != nil {
+		return resp
+	}
+	if len(args) < 1 {
+		return p.responsef(header, templateHelpText)
+	}
+
+	wh := sampleTemplateWebhook(args[0])
+	t := p.notificationTemplate(wh, nil)
+	if len(args) > 1 {
+		t.Headline = strings.Join(args[1:], " ")
+	}
+	headline, text, color, err := wh.render(t)
+	if err != nil {
+		return p.responsef(header, "Invalid template: %v", err)
+	}
+
+	post := &model.Post{
+		UserId:    p.getUserID(),
+		ChannelId: header.ChannelId,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Color:    color,
+		Fallback: headline,
+		Pretext:  headline,
+		Text:     text,
+	}})
+	_ = p.API.SendEphemeralPost(header.UserId, post)
+	return &model.CommandResponse{}
+}
+
+func (p *Plugin) authorizeTemplateCommand(header *model.CommandArgs) *model.CommandResponse {
+	authorized, err := authorizedSysAdmin(p, header.UserId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if !authorized {
+		return p.responsef(header, "`/jira template` can only be run by a system administrator.")
+	}
+	return nil
+}
+
+// updateNotificationTemplates modifies the templates in the plugin settings.
+// The change is picked up, on every server, by OnConfigurationChange.
+func (p *Plugin) updateNotificationTemplates(modify func(tt NotificationTemplates) error) error {
+	tt := NotificationTemplates{}
+	for event, t := range p.getConfig().notificationTemplates {
+		tt[event] = t
+	}
+	err := modify(tt)
+	if err != nil {
+		return err
+	}
+	err = tt.validate()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(tt, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	pluginConfig := p.API.GetPluginConfig()
+	if pluginConfig == nil {
+		pluginConfig = map[string]interface{}{}
+	}
+	pluginConfig["notificationtemplates"] = string(data)
+	appErr := p.API.SavePluginConfig(pluginConfig)
+	if appErr != nil {
+		return appErr
+	}
+
+	p.updateConfig(func(conf *config) {
+		conf.NotificationTemplates = string(data)
+		conf.notificationTemplates = tt
+	})
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+func TestNotificationTemplateRender(t *testing.T) {
+	data, err := getJiraTestData("webhook-issue-created.json")
+	require.Nil(t, err)
+	w, err := ParseWebhook(data)
+	require.Nil(t, err)
+	wh := w.(*webhook)
+
+	for name, tc := range map[string]struct {
+		template         NotificationTemplate
+		expectedHeadline string
+		expectedText     string
+		expectedColor    string
+		expectedErr      bool
+	}{
+		"built-in": {
+			expectedHeadline: wh.headline,
+			expectedText:     wh.text,
+			expectedColor:    defaultNotificationColor,
+		},
+		"headline": {
+			template:         NotificationTemplate{Headline: "{{upper .Issue.Key}} {{.Event}} by {{user .User}}"},
+			expectedHeadline: "TES-41 event_created by Test User",
+			expectedText:     wh.text,
+			expectedColor:    defaultNotificationColor,
+		},
+		"text and color": {
+			template:         NotificationTemplate{Text: "{{truncate 10 .Issue.Fields.Summary}}", Color: "#00875a"},
+			expectedHeadline: wh.headline,
+			expectedText:     "Unit te...",
+			expectedColor:    "#00875a",
+		},
+		"built-in headline in the template": {
+			template:         NotificationTemplate{Headline: ":tada: {{.Headline}}"},
+			expectedHeadline: ":tada: " + wh.headline,
+			expectedText:     wh.text,
+			expectedColor:    defaultNotificationColor,
+		},
+		"execution error": {
+			template:    NotificationTemplate{Headline: "{{.Issue.Fields.NoSuchField}}"},
+			expectedErr: true,
+		},
+		"parse error": {
+			template:    NotificationTemplate{Text: "{{.Issue"},
+			expectedErr: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			headline, text, color, err := wh.render(tc.template)
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.expectedHeadline, headline)
+			assert.Equal(t, tc.expectedText, text)
+			assert.Equal(t, tc.expectedColor, color)
+		})
+	}
+}
+
+func TestNotificationTemplatesValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		templates   NotificationTemplates
+		expectedErr bool
+	}{
+		"none": {},
+		"valid": {
+			templates: NotificationTemplates{
+				templateDefaultEvent:           {Headline: "{{.IssueLink}}"},
+				eventCreated:                   {Color: "#ABCDEF"},
+				"event_updated_customfield_10": {Text: "{{range .ChangeLog}}{{.Field}}{{end}}"},
+			},
+		},
+		"unknown event": {
+			templates:   NotificationTemplates{"event_whatever": {Headline: "x"}},
+			expectedErr: true,
+		},
+		"bad color": {
+			templates:   NotificationTemplates{eventCreated: {Color: "red"}},
+			expectedErr: true,
+		},
+		"bad template": {
+			templates:   NotificationTemplates{eventCreated: {Headline: "{{.NoSuchField}}"}},
+			expectedErr: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.templates.validate()
+			if tc.expectedErr {
+				require.Error(t, err)
+			} else {
+				require.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestNotificationTemplateFallback(t *testing.T) {
+	data, err := getJiraTestData("webhook-issue-created.json")
+	require.Nil(t, err)
+	w, err := ParseWebhook(data)
+	require.Nil(t, err)
+	wh := w.(*webhook)
+
+	p := &Plugin{}
+	p.SetAPI(&plugintest.API{})
+	p.updateConfig(func(conf *config) {
+		conf.notificationTemplates = NotificationTemplates{
+			templateDefaultEvent: {Headline: "admin default", Color: "#000000"},
+			eventCreated:         {Text: "admin created"},
+		}
+	})
+
+	tmpl := p.notificationTemplate(wh, nil)
+	assert.Equal(t, NotificationTemplate{Headline: "admin default", Text: "admin created", Color: "#000000"}, tmpl)
+
+	tmpl = p.notificationTemplate(wh, NotificationTemplates{
+		templateDefaultEvent: {Headline: "subscription default"},
+	})
+	assert.Equal(t, NotificationTemplate{Headline: "subscription default", Text: "admin created", Color: "#000000"}, tmpl)
+
+	post, err := wh.newPost(p, nil, "channelId", "botUserId", &ChannelSubscription{Templates: NotificationTemplates{
+		eventCreated: {Headline: "{{.Issue.Key}} created", Color: "#00875a"},
+	}})
+	require.Nil(t, err)
+	attachments := post.Attachments()
+	require.Len(t, attachments, 1)
+	assert.Equal(t, "TES-41 created", attachments[0].Pretext)
+	assert.Equal(t, "admin created", attachments[0].Text)
+	assert.Equal(t, "#00875a", attachments[0].Color)
+}
+
+func TestNotificationTemplateCache(t *testing.T) {
+	first, err := parseNotificationTemplate("{{.Issue.Key}} first")
+	require.Nil(t, err)
+	again, err := parseNotificationTemplate("{{.Issue.Key}} first")
+	require.Nil(t, err)
+	assert.True(t, first == again, "parsed templates must be cached")
+
+	// The cache is bounded.
+	for i := 0; i < MaxNotificationTemplateCacheEntries+10; i++ {
+		_, err = parseNotificationTemplate(fmt.Sprintf("{{.Issue.Key}} %d", i))
+		require.Nil(t, err)
+	}
+	assert.LessOrEqual(t, len(notificationTemplateCache.parsed), MaxNotificationTemplateCacheEntries)
+}
@@ -0,0 +1,325 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	JIRA_PERSONAL_SUBSCRIPTIONS_KEY      = "jirapersonalsub_"
+	JIRA_PERSONAL_SUBSCRIPTION_USERS_KEY = "jirapersonalsubusers"
+
+	MAX_PERSONAL_SUBSCRIPTIONS_PER_USER = 25
+)
+
+// PersonalSubscription is a subscription of a single user, delivered to them
+// by DM.
+type PersonalSubscription struct {
+	Id      string              `json:"id"`
+	Name    string              `json:"name"`
+	Filters SubscriptionFilters `json:"filters"`
+
+	// Only the issues the user is watching. This is not available in the
+	// webhook payload, and is checked with the user's Jira client.
+	Watching bool `json:"watching,omitempty"`
+}
+
+func personalSubscriptionsKey(ji Instance, mattermostUserId string) string {
+	return keyWithInstance(ji, JIRA_PERSONAL_SUBSCRIPTIONS_KEY+mattermostUserId)
+}
+
+func (p *Plugin) getPersonalSubscriptions(ji Instance, mattermostUserId string) ([]PersonalSubscription, error) {
+	data, appErr := p.API.KVGet(personalSubscriptionsKey(ji, mattermostUserId))
+	if appErr != nil {
+		return nil, errors.WithMessage(appErr, "failed to load personal subscriptions")
+	}
+	subs := []PersonalSubscription{}
+	if data == nil {
+		return subs, nil
+	}
+	err := json.Unmarshal(data, &subs)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load personal subscriptions")
+	}
+	return subs, nil
+}
+
+func (p *Plugin) getPersonalSubscriptionUsers(ji Instance) (StringSet, error) {
+	data, appErr := p.API.KVGet(keyWithInstance(ji, JIRA_PERSONAL_SUBSCRIPTION_USERS_KEY))
+	if appErr != nil {
+		return nil, errors.WithMessage(appErr, "failed to load the users with personal subscriptions")
+	}
+	users := NewStringSet()
+	if data == nil {
+		return users, nil
+	}
+	err := json.Unmarshal(data, &users)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load the users with personal subscriptions")
+	}
+	return users, nil
+}
+
+// modifyPersonalSubscriptions atomically updates the subscriptions of a user,
+// and the index of users with subscriptions.
+func (p *Plugin) modifyPersonalSubscriptions(ji Instance, mattermostUserId string, modify func(subs []PersonalSubscription) ([]PersonalSubscription, error)) error {
+	var hasSubs bool
+	err := p.atomicModify(personalSubscriptionsKey(ji, mattermostUserId), func(initialBytes []byte) ([]byte, error) {
+		subs := []PersonalSubscription{}
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &subs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		subs, err := modify(subs)
+		if err != nil {
+			return nil, err
+		}
+		hasSubs = len(subs) > 0
+		return json.Marshal(subs)
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.atomicModify(keyWithInstance(ji, JIRA_PERSONAL_SUBSCRIPTION_USERS_KEY), func(initialBytes []byte) ([]byte, error) {
+		users := NewStringSet()
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &users)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if hasSubs {
+			users = users.Add(mattermostUserId)
+		} else {
+			users = users.Subtract(mattermostUserId)
+		}
+		return json.Marshal(users)
+	})
+}
+
+func (p *Plugin) addPersonalSubscription(ji Instance, mattermostUserId string, sub PersonalSubscription) error {
+	if sub.Filters.JQL != "" {
+		if _, err := ParseJQL(sub.Filters.JQL); err != nil {
+			return errors.Errorf("Invalid JQL: %v.", err)
+		}
+	}
+	if sub.Filters.Events.Len() == 0 {
+		return errors.New("Please provide at least one event type.")
+	}
+
+	return p.modifyPersonalSubscriptions(ji, mattermostUserId, func(subs []PersonalSubscription) ([]PersonalSubscription, error) {
+		if len(subs) >= MAX_PERSONAL_SUBSCRIPTIONS_PER_USER {
+			return nil, errors.Errorf("You can have at most %d personal subscriptions.", MAX_PERSONAL_SUBSCRIPTIONS_PER_USER)
+		}
+		return append(subs, sub), nil
+	})
+}
+
+// getPersonalNotifications returns the DM notifications for the personal
+// subscriptions matching wh.
+func (p *Plugin) getPersonalNotifications(ji Instance, wh *webhook) ([]webhookNotification, error) {
+	if wh.headline == "" {
+		return nil, nil
+	}
+	users, err := p.getPersonalSubscriptionUsers(ji)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := []webhookNotification{}
+	for _, mattermostUserId := range users.Elems() {
+		subs, err := p.getPersonalSubscriptions(ji, mattermostUserId)
+		if err != nil {
+			p.errorf("getPersonalNotifications: %v", err)
+			continue
+		}
+
+		var matched *PersonalSubscription
+		for i, sub := range subs {
+			if !p.matchesSubsciptionFilters(wh, sub.Filters) {
+				continue
+			}
+			// Prefer the subscriptions that don't require the watchers check.
+			if matched == nil || matched.Watching && !sub.Watching {
+				matched = &subs[i]
+			}
+		}
+		if matched == nil {
+			continue
+		}
+
+		notifications = append(notifications, webhookNotification{
+			mattermostUserId: mattermostUserId,
+			watching:         matched.Watching,
+			message:          fmt.Sprintf("%s\n_Personal subscription: %s_", wh.headline, matched.Name),
+			commentSelf:      wh.Comment.Self,
+		})
+	}
+	return notifications, nil
+}
+
+// getWatchers returns the watchers of an issue. go-jira's GetWatchers looks
+// up each watcher by name, which Jira Cloud doesn't support.
+func getWatchers(client Client, issueKey string) ([]jira.User, error) {
+	watchers := struct {
+		Watchers []jira.User `json:"watchers"`
+	}{}
+	err := client.RESTGet(fmt.Sprintf("2/issue/%s/watchers", issueKey), nil, &watchers)
+	if err != nil {
+		return nil, err
+	}
+	return watchers.Watchers, nil
+}
+
+func isWatching(client Client, issueKey string, jiraUser JIRAUser) (bool, error) {
+	watchers, err := getWatchers(client, issueKey)
+	if err != nil {
+		return false, err
+	}
+	for _, w := range watchers {
+		if (w.AccountID != "" && w.AccountID == jiraUser.AccountID) || (w.Name != "" && w.Name == jiraUser.Name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+const watchHelpText = "###### Personal subscriptions, delivered by DM:\n" +
+	"* `/jira watch project <project-key> [label]` - Watch all issues in a project, optionally only those with a label\n" +
+	"* `/jira watch reported` - Watch the issues you reported\n" +
+	"* `/jira watch watching` - Watch the issues you are a watcher of in Jira\n" +
+	"* `/jira watch jql <JQL>` - Watch the issues matching a JQL query\n" +
+	"* `/jira watch list` - List your personal subscriptions\n" +
+	"* `/jira watch remove <number or all>` - Remove a personal subscription\n"
+
+func executeWatch(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) == 0 {
+		return p.responsef(header, watchHelpText)
+	}
+
+	ji, jiraUser, errResponse := p.watchUserInstance(header)
+	if errResponse != nil {
+		return errResponse
+	}
+
+	sub := PersonalSubscription{
+		Id: model.NewId(),
+		Filters: SubscriptionFilters{
+			Events:     allEvents,
+			Projects:   NewStringSet(),
+			IssueTypes: NewStringSet(),
+		},
+	}
+	switch {
+	case args[0] == "project" && (len(args) == 2 || len(args) == 3):
+		projectKey := strings.ToUpper(args[1])
+		sub.Name = "project " + projectKey
+		sub.Filters.Projects = NewStringSet(projectKey)
+		if len(args) == 3 {
+			sub.Name += " with label " + args[2]
+			sub.Filters.Fields = []FieldFilter{{
+				Key:       "labels",
+				Inclusion: FILTER_INCLUDE_ANY,
+				Values:    NewStringSet(args[2]),
+			}}
+		}
+	case args[0] == "reported" && len(args) == 1:
+		sub.Name = "issues I reported"
+		sub.Filters.JQL = fmt.Sprintf("reporter = %q", jiraUser.Key())
+	case args[0] == "watching" && len(args) == 1:
+		sub.Name = "issues I am watching"
+		sub.Watching = true
+	case args[0] == "jql" && len(args) > 1:
+		sub.Filters.JQL = strings.Join(args[1:], " ")
+		sub.Name = "`" + sub.Filters.JQL + "`"
+	default:
+		return p.responsef(header, watchHelpText)
+	}
+
+	err := p.addPersonalSubscription(ji, header.UserId, sub)
+	if err != nil {
+		return p.responsef(header, "%v", errors.Cause(err))
+	}
+	notifications := ""
+	if jiraUser.Settings == nil || !jiraUser.Settings.Notifications {
+		notifications = " Your notifications are off, use `/jira settings notifications on` to receive them."
+	}
+	return p.responsef(header, "You are now watching %s.%s", sub.Name, notifications)
+}
+
+func executeWatchList(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) != 0 {
+		return p.responsef(header, watchHelpText)
+	}
+	ji, _, errResponse := p.watchUserInstance(header)
+	if errResponse != nil {
+		return errResponse
+	}
+
+	subs, err := p.getPersonalSubscriptions(ji, header.UserId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if len(subs) == 0 {
+		return p.responsef(header, "You have no personal subscriptions. Use `/jira watch` to add one.")
+	}
+	text := "Your personal subscriptions:\n"
+	for i, sub := range subs {
+		text += fmt.Sprintf("%d. %s\n", i+1, sub.Name)
+	}
+	return p.responsef(header, text)
+}
+
+func executeWatchRemove(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) != 1 {
+		return p.responsef(header, watchHelpText)
+	}
+	ji, _, errResponse := p.watchUserInstance(header)
+	if errResponse != nil {
+		return errResponse
+	}
+
+	removed := 0
+	err := p.modifyPersonalSubscriptions(ji, header.UserId, func(subs []PersonalSubscription) ([]PersonalSubscription, error) {
+		if args[0] == "all" {
+			removed = len(subs)
+			return []PersonalSubscription{}, nil
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > len(subs) {
+			return nil, errors.Errorf("%q is not a number from `/jira watch list`.", args[0])
+		}
+		removed = 1
+		return append(subs[:n-1], subs[n:]...), nil
+	})
+	if err != nil {
+		return p.responsef(header, "Failed to remove the personal subscription: %v", errors.Cause(err))
+	}
+	return p.responsef(header, "Removed %d personal subscription(s).", removed)
+}
+
+func (p *Plugin) watchUserInstance(header *model.CommandArgs) (Instance, JIRAUser, *model.CommandResponse) {
+	ji, resp := p.loadCommandInstance(header)
+	if resp != nil {
+		return nil, JIRAUser{}, resp
+	}
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, header.UserId)
+	if err != nil {
+		return nil, JIRAUser{}, p.responsef(header, "Your username is not connected to Jira. Please type `jira connect`. %v", err)
+	}
+	return ji, jiraUser, nil
+}
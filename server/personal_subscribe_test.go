@@ -0,0 +1,125 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+func TestGetPersonalNotifications(t *testing.T) {
+	data, err := getJiraTestData("webhook-issue-created.json")
+	require.Nil(t, err)
+	w, err := ParseWebhook(data)
+	require.Nil(t, err)
+	wh := w.(*webhook)
+
+	allFilters := SubscriptionFilters{
+		Events:     allEvents,
+		Projects:   NewStringSet(),
+		IssueTypes: NewStringSet(),
+	}
+	withProject := func(project string) SubscriptionFilters {
+		f := allFilters
+		f.Projects = NewStringSet(project)
+		return f
+	}
+	withJQL := func(jql string) SubscriptionFilters {
+		f := allFilters
+		f.JQL = jql
+		return f
+	}
+
+	userSubs := map[string][]PersonalSubscription{
+		"user1": {
+			{Name: "project TES", Filters: withProject("TES")},
+		},
+		"user2": {
+			{Name: "project OTHER", Filters: withProject("OTHER")},
+		},
+		"user3": {
+			{Name: "issues I am watching", Filters: allFilters, Watching: true},
+			{Name: "issues I reported", Filters: withJQL(`reporter = "admin"`)},
+		},
+		"user4": {
+			{Name: "issues I am watching", Filters: allFilters, Watching: true},
+		},
+	}
+
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	users := NewStringSet()
+	for userId, subs := range userSubs {
+		users = users.Add(userId)
+		subsBytes, err := json.Marshal(subs)
+		require.Nil(t, err)
+		api.On("KVGet", personalSubscriptionsKey(ji, userId)).Return(subsBytes, nil)
+	}
+	usersBytes, err := json.Marshal(users)
+	require.Nil(t, err)
+	api.On("KVGet", keyWithInstance(ji, JIRA_PERSONAL_SUBSCRIPTION_USERS_KEY)).Return(usersBytes, nil)
+
+	notifications, err := p.getPersonalNotifications(ji, wh)
+	require.Nil(t, err)
+
+	byUser := map[string]webhookNotification{}
+	for _, n := range notifications {
+		byUser[n.mattermostUserId] = n
+	}
+	require.Len(t, byUser, 3)
+	assert.Contains(t, byUser["user1"].message, "Personal subscription: project TES")
+	assert.False(t, byUser["user3"].watching, "prefers the subscription that needs no watchers check")
+	assert.Contains(t, byUser["user3"].message, "Personal subscription: issues I reported")
+	assert.True(t, byUser["user4"].watching)
+}
+
+func TestModifyPersonalSubscriptions(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	subsKey := personalSubscriptionsKey(ji, "user1")
+	usersKey := keyWithInstance(ji, JIRA_PERSONAL_SUBSCRIPTION_USERS_KEY)
+	api.On("KVGet", subsKey).Return(nil, nil)
+	api.On("KVGet", usersKey).Return(nil, nil)
+	api.On("KVCompareAndSet", subsKey, []byte(nil), mock.MatchedBy(func(data []byte) bool {
+		subs := []PersonalSubscription{}
+		_ = json.Unmarshal(data, &subs)
+		return len(subs) == 1 && subs[0].Name == "issues I reported"
+	})).Return(true, nil)
+	api.On("KVCompareAndSet", usersKey, []byte(nil), []byte(`["user1"]`)).Return(true, nil)
+
+	err = p.addPersonalSubscription(ji, "user1", PersonalSubscription{
+		Name: "issues I reported",
+		Filters: SubscriptionFilters{
+			Events: allEvents,
+			JQL:    `reporter = "admin"`,
+		},
+	})
+	require.Nil(t, err)
+	api.AssertExpectations(t)
+
+	err = p.addPersonalSubscription(ji, "user1", PersonalSubscription{
+		Name: "bad JQL",
+		Filters: SubscriptionFilters{
+			Events: allEvents,
+			JQL:    "reporter = ",
+		},
+	})
+	require.Error(t, err)
+}
@@ -33,10 +33,7 @@ const (
 
 	autolinkPluginId = "mattermost-autolink"
 
-	// Move these two to the plugin settings if admins need to adjust them.
-	WebhookMaxProcsPerServer = 20
-	WebhookBufferSize        = 10000
-	PluginRepo               = "https://github.com/mattermost/mattermost-plugin-jira"
+	PluginRepo = "https://github.com/mattermost/mattermost-plugin-jira"
 )
 
 var BuildHash = ""
@@ -71,6 +68,32 @@ type externalConfig struct {
 
 	// Hide issue descriptions and comments in Webhook and Subscription messages
 	HideDecriptionComment bool
+
+	// Post the subscription updates of an issue as replies to the first post
+	// about it in the channel
+	ThreadIssueUpdates bool
+
+	// Show the latest status and assignee of the issue on the thread root post
+	UpdateThreadRootPost bool
+
+	// JSON of the notification templates by event type
+	NotificationTemplates string
+
+	// Maximum number of subscription posts to a channel within
+	// SubscriptionRateLimitWindow minutes. Further updates are collapsed into
+	// a single post. Empty or 0 for no limit.
+	SubscriptionRateLimit       string
+	SubscriptionRateLimitWindow string
+
+	// Number of webhook workers, and of webhook events waiting for them, on
+	// each server. Empty for the defaults.
+	WebhookMaxProcsPerServer string
+	WebhookBufferSize        string
+
+	// Number of retries of the failed requests to Jira, and of consecutive
+	// failures after which the requests are paused. Empty for the defaults.
+	JiraMaxRetries              string
+	JiraCircuitBreakerThreshold string
 }
 
 const currentInstanceTTL = 1 * time.Second
@@ -92,6 +115,20 @@ type config struct {
 	// Maximum attachment size allowed to be uploaded to Jira
 	maxAttachmentSize utils.ByteSize
 
+	// Parsed NotificationTemplates
+	notificationTemplates NotificationTemplates
+
+	// Parsed SubscriptionRateLimit and SubscriptionRateLimitWindow
+	rateLimit SubscriptionRateLimit
+
+	// Parsed WebhookMaxProcsPerServer and WebhookBufferSize
+	webhookMaxProcs   int
+	webhookBufferSize int
+
+	// Parsed JiraMaxRetries and JiraCircuitBreakerThreshold
+	jiraMaxRetries          int
+	circuitBreakerThreshold int
+
 	stats             *expvar.Stats
 	statsStopAutosave chan bool
 }
@@ -108,6 +145,7 @@ type Plugin struct {
 	userStore            UserStore
 	otsStore             OTSStore
 	secretsStore         SecretsStore
+	webhookQueueStore    WebhookQueueStore
 
 	// Active workflows store
 	workflowTriggerStore *TriggerStore
@@ -118,8 +156,23 @@ type Plugin struct {
 	// templates are loaded on startup
 	templates map[string]*template.Template
 
-	// channel to distribute work to the webhook processors
-	webhookQueue chan []byte
+	// distributes work to the webhook processors
+	webhookPool *webhookWorkerPool
+
+	// The responses of Jira that rarely change
+	clientCache *clientCache
+
+	// By Jira instance URL
+	circuitBreakers circuitBreakers
+
+	// The metrics that are too expensive to compute on every scrape
+	metricsCache metricsCache
+
+	// The JQL of the subscriptions, parsed when they are saved
+	jqlCache jqlCache
+
+	// Closed on deactivate, to stop the periodic jobs
+	stopPeriodicJobs chan struct{}
 }
 
 func (p *Plugin) getConfig() config {
@@ -154,10 +207,41 @@ func (p *Plugin) OnConfigurationChange() error {
 		}
 	}
 
+	notificationTemplates, err := parseNotificationTemplates(ec.NotificationTemplates)
+	if err != nil {
+		return errors.WithMessage(err, "failed to load notification templates")
+	}
+
+	rateLimit, err := parseSubscriptionRateLimit(ec.SubscriptionRateLimit, ec.SubscriptionRateLimitWindow)
+	if err != nil {
+		return errors.WithMessage(err, "failed to load the subscription rate limit")
+	}
+
+	webhookMaxProcs, webhookBufferSize, err := parseWebhookPoolSettings(ec.WebhookMaxProcsPerServer, ec.WebhookBufferSize)
+	if err != nil {
+		return errors.WithMessage(err, "failed to load the webhook worker settings")
+	}
+
+	jiraMaxRetries, circuitBreakerThreshold, err := parseJiraRetrySettings(ec.JiraMaxRetries, ec.JiraCircuitBreakerThreshold)
+	if err != nil {
+		return errors.WithMessage(err, "failed to load the Jira retry settings")
+	}
+
 	p.updateConfig(func(conf *config) {
 		conf.externalConfig = ec
 		conf.maxAttachmentSize = maxAttachmentSize
+		conf.notificationTemplates = notificationTemplates
+		conf.rateLimit = rateLimit
+		conf.webhookMaxProcs = webhookMaxProcs
+		conf.webhookBufferSize = webhookBufferSize
+		conf.jiraMaxRetries = jiraMaxRetries
+		conf.circuitBreakerThreshold = circuitBreakerThreshold
 	})
+
+	// Resize the webhook workers once they are started.
+	if p.webhookPool != nil {
+		p.webhookPool.resize(webhookMaxProcs, webhookBufferSize)
+	}
 	return nil
 }
 
@@ -195,6 +279,8 @@ func (p *Plugin) OnActivate() error {
 	p.userStore = store
 	p.secretsStore = store
 	p.otsStore = store
+	p.webhookQueueStore = store
+	p.clientCache = newClientCache()
 
 	templates, err := p.loadTemplates(filepath.Join(bundlePath, "assets", "templates"))
 	if err != nil {
@@ -207,13 +293,27 @@ func (p *Plugin) OnActivate() error {
 		return errors.WithMessage(err, "OnActivate: failed to register command")
 	}
 
-	// Create our queue of webhook events waiting to be processed.
-	p.webhookQueue = make(chan []byte, WebhookBufferSize)
+	// Create our queue of webhook events waiting to be processed, and spin
+	// up our webhook workers.
+	conf := p.getConfig()
+	p.webhookPool = newWebhookWorkerPool(p)
+	p.webhookPool.resize(conf.webhookMaxProcs, conf.webhookBufferSize)
 
-	// Spin up our webhook workers.
-	for i := 0; i < WebhookMaxProcsPerServer; i++ {
-		go webhookWorker{i, p, p.webhookQueue}.work()
-	}
+	// Pick up the webhook events persisted before a restart, and retry the
+	// failed ones.
+	p.stopPeriodicJobs = make(chan struct{})
+	go p.runPeriodically(WebhookRetryInterval, p.retryWebhookMessages)
+
+	// Post the scheduled digests of the channel subscriptions.
+	go p.runPeriodically(DigestCheckInterval, func() {
+		p.sendDueDigests(time.Now())
+	})
+
+	// Send the notifications held by the quiet hours and batching of the
+	// user settings.
+	go p.runPeriodically(DeferredNotificationsCheckInterval, func() {
+		p.sendDueNotifications(time.Now())
+	})
 
 	p.workflowTriggerStore = NewTriggerStore()
 
@@ -249,6 +349,30 @@ func (p *Plugin) OnActivate() error {
 	return nil
 }
 
+func (p *Plugin) OnDeactivate() error {
+	if p.stopPeriodicJobs != nil {
+		close(p.stopPeriodicJobs)
+	}
+	return nil
+}
+
+// runPeriodically runs job now, and then every interval, until the plugin is
+// deactivated.
+func (p *Plugin) runPeriodically(interval time.Duration, job func()) {
+	stop := p.stopPeriodicJobs
+	job()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			job()
+		case <-stop:
+			return
+		}
+	}
+}
+
 func (p *Plugin) AddAutolinksForCloudInstance(jci *jiraCloudInstance) error {
 	client, err := jci.getJIRAClientForBot()
 	if err != nil {
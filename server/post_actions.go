@@ -0,0 +1,212 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// The actions that can be added to the posts of a channel subscription.
+const (
+	postActionAssign     = "assign"
+	postActionTransition = "transition"
+	postActionComment    = "comment"
+	postActionWatch      = "watch"
+	postActionPriority   = "priority"
+)
+
+var postActionNames = map[string]string{
+	postActionAssign:     "Assign to me",
+	postActionTransition: "Transition",
+	postActionComment:    "Comment",
+	postActionWatch:      "Watch",
+	postActionPriority:   "Change priority",
+}
+
+// allPostActions is the order of the buttons on a post.
+var allPostActions = []string{
+	postActionAssign,
+	postActionTransition,
+	postActionComment,
+	postActionWatch,
+	postActionPriority,
+}
+
+func validatePostActions(actions StringSet) error {
+	for _, action := range actions.Elems() {
+		if postActionNames[action] == "" {
+			return errors.Errorf("unknown action %q", action)
+		}
+	}
+	return nil
+}
+
+// postActions returns the buttons for the actions on an issue.
+func postActions(ji Instance, issueKey string, actions StringSet) []*model.PostAction {
+	buttons := []*model.PostAction{}
+	for _, action := range allPostActions {
+		if !actions.ContainsAny(action) {
+			continue
+		}
+		buttons = append(buttons, &model.PostAction{
+			Name: postActionNames[action],
+			Type: model.POST_ACTION_TYPE_BUTTON,
+			Integration: &model.PostActionIntegration{
+				URL: pluginRouteURL(ji, routeAPIPostAction),
+				Context: map[string]interface{}{
+					"action":   action,
+					"issueKey": issueKey,
+				},
+			},
+		})
+	}
+	return buttons
+}
+
+// pluginRouteURL returns the URL of a plugin route, as used by the actions
+// and dialogs, that runs with the Jira instance ji.
+func pluginRouteURL(ji Instance, route string) string {
+	return fmt.Sprintf("/plugins/%s%s?%s", manifest.Id, route, url.Values{argInstanceURL: {ji.GetURL()}}.Encode())
+}
+
+// postActionErrorMessage returns the message shown to the user when an
+// action failed.
+func postActionErrorMessage(action, issueKey string, err error) string {
+	switch StatusCode(err) {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Sprintf("You do not have the appropriate permissions to %s %s. Please contact your Jira administrator.",
+			strings.ToLower(postActionNames[action]), issueKey)
+	}
+	return fmt.Sprintf("Failed to %s %s: %v", strings.ToLower(postActionNames[action]), issueKey, err)
+}
+
+// actionUserId returns the user who sent an action or a dialog request.
+// The user in the body is only trusted when it is the one that Mattermost
+// authenticated, in the Mattermost-User-Id header.
+func actionUserId(r *http.Request, bodyUserId string) (string, error) {
+	mattermostUserId := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserId == "" || mattermostUserId != bodyUserId {
+		return "", errors.New("user not authorized")
+	}
+	return mattermostUserId, nil
+}
+
+// httpAPIPostAction handles the buttons of the subscription posts. The
+// actions that need more input open a dialog.
+func httpAPIPostAction(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
+	requestData := model.PostActionIntegrationRequestFromJson(r.Body)
+	if requestData == nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.New("Missing request data"))
+	}
+
+	plugin := ji.GetPlugin()
+	mattermostUserId, err := actionUserId(r, requestData.UserId)
+	if err != nil {
+		return respondErr(w, http.StatusUnauthorized, err)
+	}
+	replyf := func(format string, args ...interface{}) {
+		_ = plugin.API.SendEphemeralPost(mattermostUserId,
+			makePost(plugin.getUserID(), requestData.ChannelId, fmt.Sprintf(format, args...)))
+	}
+
+	action, _ := requestData.Context["action"].(string)
+	issueKey, _ := requestData.Context["issueKey"].(string)
+	if postActionNames[action] == "" || issueKey == "" {
+		return respondErr(w, http.StatusBadRequest, errors.Errorf("invalid action %q", action))
+	}
+
+	jiraUser, err := plugin.userStore.LoadJIRAUser(ji, mattermostUserId)
+	if err != nil {
+		replyf("Your username is not connected to Jira. Please type `/jira connect`.")
+		return respondErr(w, http.StatusUnauthorized, err)
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		replyf("Failed to connect to Jira: %v", err)
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	state := issueDialogState{
+		IssueKey: issueKey,
+		PostId:   requestData.PostId,
+	}
+	switch action {
+	case postActionAssign:
+		_, err = assignJiraIssueToSelf(ji, client, jiraUser, issueKey)
+		if err != nil {
+			replyf("%s", postActionErrorMessage(action, issueKey, err))
+			return respondJSON(w, &model.PostActionIntegrationResponse{})
+		}
+		assignee := jiraUser.DisplayName
+		if assignee == "" {
+			assignee = "@" + requestData.UserName
+		}
+		plugin.updatePostActionField(requestData.ChannelId, state.PostId, "Assignee", assignee, false)
+
+	case postActionWatch:
+		err = client.AddWatcher(issueKey, &jiraUser.User)
+		if err != nil {
+			replyf("%s", postActionErrorMessage(action, issueKey, err))
+			return respondJSON(w, &model.PostActionIntegrationResponse{})
+		}
+		plugin.updatePostActionField(requestData.ChannelId, state.PostId, "Watched by", "@"+requestData.UserName, true)
+		replyf("You are now watching %s.", issueKey)
+
+	default:
+		// The dialogs of the actions are the ones of the "More actions" menu.
+		err = plugin.openIssueDialog(ji, client, action, requestData.TriggerId, state)
+		if err != nil {
+			replyf("%s", postActionErrorMessage(action, issueKey, err))
+		}
+	}
+
+	return respondJSON(w, &model.PostActionIntegrationResponse{})
+}
+
+// updatePostActionField shows the result of an action on the post it was
+// made from. With appendValue, value is added to the current value of the
+// field. As postId comes from the request, only the posts of the bot in
+// channelId, the channel of the request, are updated.
+func (p *Plugin) updatePostActionField(channelId, postId, title, value string, appendValue bool) {
+	post, appErr := p.API.GetPost(postId)
+	if appErr != nil {
+		p.errorf("Failed to load post %s to show the result of an action, err: %v", postId, appErr)
+		return
+	}
+	if post.UserId != p.getUserID() || post.ChannelId != channelId {
+		p.errorf("Not showing the result of an action on post %s, which is not a post of the plugin in channel %s", postId, channelId)
+		return
+	}
+	attachments := post.Attachments()
+	if len(attachments) == 0 {
+		return
+	}
+	attachment := attachments[0]
+	if appendValue {
+		for _, field := range attachment.Fields {
+			current, _ := field.Value.(string)
+			if field.Title == title && current != "" {
+				if strings.Contains(current, value) {
+					return
+				}
+				value = current + ", " + value
+			}
+		}
+	}
+	attachment.Fields = setSlackAttachmentField(attachment.Fields, title, value)
+
+	model.ParseSlackAttachment(post, attachments)
+	_, appErr = p.API.UpdatePost(post)
+	if appErr != nil {
+		p.errorf("Failed to update post %s with the result of an action, err: %v", postId, appErr)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+func (client testClient) UpdateAssignee(issueKey string, user *jira.User) error {
+	if issueKey == noPermissionsIssueKey {
+		return RESTError{errors.New("forbidden"), http.StatusForbidden}
+	}
+	return nil
+}
+
+func (client testClient) AddWatcher(issueKey string, user *jira.User) error {
+	if issueKey == noPermissionsIssueKey {
+		return RESTError{errors.New("unauthorized"), http.StatusUnauthorized}
+	}
+	return nil
+}
+
+func TestNewPostActions(t *testing.T) {
+	p := &Plugin{}
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	for name, tc := range map[string]struct {
+		file          string
+		actions       StringSet
+		expectActions []string
+	}{
+		"no actions": {
+			file: "webhook-issue-created.json",
+		},
+		"all actions, in order": {
+			file:          "webhook-issue-created.json",
+			actions:       NewStringSet(postActionPriority, postActionWatch, postActionComment, postActionTransition, postActionAssign),
+			expectActions: []string{"Assign to me", "Transition", "Comment", "Watch", "Change priority"},
+		},
+		"deleted issue": {
+			file:    "webhook-issue-deleted.json",
+			actions: NewStringSet(postActionAssign),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := getJiraTestData(tc.file)
+			require.Nil(t, err)
+			w, err := ParseWebhook(data)
+			require.Nil(t, err)
+			wh := w.(*webhook)
+
+			post, err := wh.newPost(p, ji, "channelId", "botUserId", &ChannelSubscription{Actions: tc.actions})
+			require.Nil(t, err)
+
+			names := []string{}
+			for _, attachment := range post.Attachments() {
+				for _, action := range attachment.Actions {
+					names = append(names, action.Name)
+					assert.Equal(t, wh.Issue.Key, action.Integration.Context["issueKey"])
+					assert.Equal(t, "/plugins/jira/api/v2/post-action?instance_url=http%3A%2F%2FjiraTestInstanceURL.some",
+						action.Integration.URL)
+				}
+			}
+			assert.Equal(t, len(tc.expectActions), len(names))
+			for i := range tc.expectActions {
+				assert.Equal(t, tc.expectActions[i], names[i])
+			}
+		})
+	}
+}
+
+func TestValidatePostActions(t *testing.T) {
+	assert.Nil(t, validatePostActions(nil))
+	assert.Nil(t, validatePostActions(NewStringSet(postActionAssign, postActionPriority)))
+	assert.NotNil(t, validatePostActions(NewStringSet(postActionAssign, "delete")))
+}
+
+func TestHttpAPIPostActionForgedUser(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	p.userStore = mockUserStore{}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	for name, header := range map[string]string{
+		"no header":      "",
+		"another header": "attackerId",
+	} {
+		t.Run(name, func(t *testing.T) {
+			request := &model.PostActionIntegrationRequest{
+				UserId:    "userId",
+				ChannelId: "channelId",
+				PostId:    "postId",
+				Context: map[string]interface{}{
+					"action":   postActionAssign,
+					"issueKey": existingIssueKey,
+				},
+			}
+			r := httptest.NewRequest(http.MethodPost, routeAPIPostAction, bytes.NewReader(request.ToJson()))
+			if header != "" {
+				r.Header.Set("Mattermost-User-Id", header)
+			}
+			w := httptest.NewRecorder()
+			status, err := httpAPIPostAction(ji, w, r)
+			assert.NotNil(t, err)
+			assert.Equal(t, http.StatusUnauthorized, status)
+			api.AssertNotCalled(t, "GetPost", mock.Anything)
+		})
+	}
+}
+
+func TestHttpAPIPostAction(t *testing.T) {
+	const postId = "postId"
+	for name, tc := range map[string]struct {
+		action          string
+		issueKey        string
+		existingField   *model.SlackAttachmentField
+		postUserId      string
+		postChannelId   string
+		expectField     *model.SlackAttachmentField
+		expectEphemeral string
+	}{
+		"assign": {
+			action:      postActionAssign,
+			issueKey:    existingIssueKey,
+			expectField: &model.SlackAttachmentField{Title: "Assignee", Value: "@user", Short: true},
+		},
+		"assign, no permissions": {
+			action:          postActionAssign,
+			issueKey:        noPermissionsIssueKey,
+			expectEphemeral: "Failed to assign to me SUDO-1: You do not have the appropriate permissions to perform this action. Please contact your Jira administrator.",
+		},
+		"watch": {
+			action:          postActionWatch,
+			issueKey:        existingIssueKey,
+			existingField:   &model.SlackAttachmentField{Title: "Watched by", Value: "@other", Short: true},
+			expectField:     &model.SlackAttachmentField{Title: "Watched by", Value: "@other, @user", Short: true},
+			expectEphemeral: "You are now watching REAL-1.",
+		},
+		"assign, post of another user": {
+			action:     postActionAssign,
+			issueKey:   existingIssueKey,
+			postUserId: "otherUserId",
+		},
+		"watch, post in another channel": {
+			action:          postActionWatch,
+			issueKey:        existingIssueKey,
+			postChannelId:   "otherChannelId",
+			expectEphemeral: "You are now watching REAL-1.",
+		},
+		"watch, no permissions": {
+			action:          postActionWatch,
+			issueKey:        noPermissionsIssueKey,
+			expectEphemeral: "You do not have the appropriate permissions to watch SUDO-1. Please contact your Jira administrator.",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.updateConfig(func(conf *config) {
+				conf.botUserID = "botUserId"
+			})
+			p.currentInstanceStore = mockCurrentInstanceStore{p}
+			p.userStore = mockUserStore{}
+			ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+			require.Nil(t, err)
+
+			attachment := &model.SlackAttachment{Pretext: "Test User created an issue"}
+			if tc.existingField != nil {
+				attachment.Fields = []*model.SlackAttachmentField{tc.existingField}
+			}
+			post := &model.Post{Id: postId, UserId: "botUserId", ChannelId: "channelId"}
+			if tc.postUserId != "" {
+				post.UserId = tc.postUserId
+			}
+			if tc.postChannelId != "" {
+				post.ChannelId = tc.postChannelId
+			}
+			model.ParseSlackAttachment(post, []*model.SlackAttachment{attachment})
+			api.On("GetPost", postId).Return(post, nil)
+			api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(post, nil)
+			api.On("SendEphemeralPost", "userId", mock.AnythingOfType("*model.Post")).Return(nil)
+			api.On("LogError", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+
+			request := &model.PostActionIntegrationRequest{
+				UserId:    "userId",
+				UserName:  "user",
+				ChannelId: "channelId",
+				PostId:    postId,
+				Context: map[string]interface{}{
+					"action":   tc.action,
+					"issueKey": tc.issueKey,
+				},
+			}
+			r := httptest.NewRequest(http.MethodPost, routeAPIPostAction, bytes.NewReader(request.ToJson()))
+			r.Header.Set("Mattermost-User-Id", "userId")
+			w := httptest.NewRecorder()
+			status, err := httpAPIPostAction(ji, w, r)
+			require.Nil(t, err)
+			assert.Equal(t, http.StatusOK, status)
+
+			if tc.expectField != nil {
+				api.AssertCalled(t, "UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+					fields := post.Attachments()[0].Fields
+					return len(fields) == 1 && assert.ObjectsAreEqual(tc.expectField, fields[0])
+				}))
+			} else {
+				api.AssertNotCalled(t, "UpdatePost", mock.Anything)
+			}
+			if tc.expectEphemeral != "" {
+				api.AssertCalled(t, "SendEphemeralPost", "userId", mock.MatchedBy(func(post *model.Post) bool {
+					return post.Message == tc.expectEphemeral
+				}))
+			} else {
+				api.AssertNotCalled(t, "SendEphemeralPost", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	prefixRateLimit = "rate_limit_"
+
+	// The window of the rate limit, if not configured.
+	DefaultRateLimitWindowMinutes = 5
+
+	// The issue keys of the collapsed updates past this count are not
+	// listed in the JQL search.
+	RateLimitMaxIssueKeys = 100
+)
+
+// SubscriptionRateLimit limits the posts of the subscriptions of a channel.
+// Once Posts are posted in the channel within WindowMinutes, the further
+// updates of the subscription are collapsed into a single post by project,
+// until the window ends.
+type SubscriptionRateLimit struct {
+	// 0 for no limit.
+	Posts         int `json:"posts"`
+	WindowMinutes int `json:"window_minutes,omitempty"`
+}
+
+// parseSubscriptionRateLimit parses the rate limit of the plugin settings.
+func parseSubscriptionRateLimit(posts, windowMinutes string) (SubscriptionRateLimit, error) {
+	l := SubscriptionRateLimit{}
+	var err error
+	if posts = strings.TrimSpace(posts); posts != "" {
+		l.Posts, err = strconv.Atoi(posts)
+		if err != nil {
+			return l, errors.Errorf("the number of posts must be a number, not %q", posts)
+		}
+	}
+	if windowMinutes = strings.TrimSpace(windowMinutes); windowMinutes != "" {
+		l.WindowMinutes, err = strconv.Atoi(windowMinutes)
+		if err != nil {
+			return l, errors.Errorf("the window must be a number of minutes, not %q", windowMinutes)
+		}
+	}
+	return l, l.validate()
+}
+
+func (l SubscriptionRateLimit) validate() error {
+	if l.Posts < 0 {
+		return errors.New("the number of posts can't be negative")
+	}
+	if l.WindowMinutes < 0 {
+		return errors.New("the window can't be negative")
+	}
+	return nil
+}
+
+func (l SubscriptionRateLimit) window() time.Duration {
+	if l.WindowMinutes == 0 {
+		return DefaultRateLimitWindowMinutes * time.Minute
+	}
+	return time.Duration(l.WindowMinutes) * time.Minute
+}
+
+func (l SubscriptionRateLimit) String() string {
+	return fmt.Sprintf("%d posts per %d minutes", l.Posts, int(l.window()/time.Minute))
+}
+
+// channelRateLimit counts the posts of the subscriptions of a channel in the
+// current window. The subscriptions with a limit share the count, and each
+// one is held to its own limit. A window lasts the window of the
+// subscription whose post started it.
+type channelRateLimit struct {
+	WindowEnd time.Time `json:"window_end"`
+	Posts     int       `json:"posts"`
+
+	// By project key
+	Collapsed map[string]*collapsedUpdates `json:"collapsed,omitempty"`
+}
+
+// collapsedUpdates are the updates to a project that were not posted
+// because the channel was over its rate limit.
+type collapsedUpdates struct {
+	Project   string   `json:"project"`
+	PostId    string   `json:"post_id,omitempty"`
+	Count     int      `json:"count"`
+	IssueKeys []string `json:"issue_keys,omitempty"`
+	Authors   []string `json:"authors,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// collapsedPostPending marks the collapsed updates whose post is being
+// created.
+const collapsedPostPending = "pending"
+
+func rateLimitKey(instanceURL, channelId string) string {
+	return hashkey(prefixRateLimit, instanceURL+"/"+channelId)
+}
+
+// rateLimit returns the rate limit of the posts of sub, the global one
+// unless sub overrides it.
+func (p *Plugin) rateLimit(sub *ChannelSubscription) SubscriptionRateLimit {
+	if sub != nil && sub.RateLimit != nil {
+		return *sub.RateLimit
+	}
+	return p.getConfig().rateLimit
+}
+
+// collapseChannelPost counts a post of wh by sub in its channel. If the
+// channel is over the rate limit of sub, it returns the updated collapsed
+// updates to post instead, and whether the caller is the one to create their
+// post. The posts of the subscriptions without a limit are not counted.
+func (p *Plugin) collapseChannelPost(ji Instance, wh *webhook, sub *ChannelSubscription, now time.Time) (*collapsedUpdates, bool, error) {
+	limit := p.rateLimit(sub)
+	if limit.Posts == 0 {
+		return nil, false, nil
+	}
+
+	project := "?"
+	if wh.Issue.Fields != nil && wh.Issue.Fields.Project.Key != "" {
+		project = wh.Issue.Fields.Project.Key
+	}
+	var author string
+	var collapsed *collapsedUpdates
+	var create bool
+	err := p.atomicModify(rateLimitKey(ji.GetURL(), sub.ChannelId), func(initialBytes []byte) ([]byte, error) {
+		collapsed, create = nil, false
+		state := channelRateLimit{}
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &state)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !now.Before(state.WindowEnd) {
+			state = channelRateLimit{WindowEnd: now.Add(limit.window())}
+		}
+		if state.Posts < limit.Posts {
+			state.Posts++
+			return json.Marshal(&state)
+		}
+
+		if author == "" {
+			author = p.collapsedUpdatesAuthor(ji, wh)
+		}
+		if state.Collapsed == nil {
+			state.Collapsed = map[string]*collapsedUpdates{}
+		}
+		c := state.Collapsed[project]
+		if c == nil {
+			c = &collapsedUpdates{Project: project}
+			state.Collapsed[project] = c
+		}
+		c.Count++
+		c.addIssueKey(wh.Issue.Key)
+		c.addAuthor(author)
+		if c.PostId == "" {
+			c.PostId = collapsedPostPending
+			create = true
+		}
+		copied := *c
+		collapsed = &copied
+		return json.Marshal(&state)
+	})
+	if err != nil {
+		return nil, false, errors.WithMessagef(err, "failed to count the posts in channel %s", sub.ChannelId)
+	}
+	return collapsed, create, nil
+}
+
+func (c *collapsedUpdates) addIssueKey(issueKey string) {
+	if issueKey == "" {
+		return
+	}
+	for _, key := range c.IssueKeys {
+		if key == issueKey {
+			return
+		}
+	}
+	if len(c.IssueKeys) >= RateLimitMaxIssueKeys {
+		c.Truncated = true
+		return
+	}
+	c.IssueKeys = append(c.IssueKeys, issueKey)
+}
+
+func (c *collapsedUpdates) addAuthor(author string) {
+	for _, a := range c.Authors {
+		if a == author {
+			return
+		}
+	}
+	c.Authors = append(c.Authors, author)
+}
+
+// collapsedUpdatesAuthor returns the @username of the author of wh if they
+// are connected, their Jira name otherwise.
+func (p *Plugin) collapsedUpdatesAuthor(ji Instance, wh *webhook) string {
+	author := wh.author()
+	key := author.AccountID
+	if key == "" {
+		key = author.Name
+	}
+	if mattermostUserId, err := p.userStore.LoadMattermostUserId(ji, key); err == nil {
+		if user, appErr := p.API.GetUser(mattermostUserId); appErr == nil {
+			return "@" + user.Username
+		}
+	}
+	if author.DisplayName != "" {
+		return author.DisplayName
+	}
+	if author.Name != "" {
+		return author.Name
+	}
+	return "an unknown user"
+}
+
+// postCollapsedUpdates creates or updates the post of the collapsed updates
+// of sub in its channel.
+func (p *Plugin) postCollapsedUpdates(ji Instance, sub *ChannelSubscription, c *collapsedUpdates, create bool) error {
+	conf := p.getConfig()
+	if conf.stats != nil {
+		conf.stats.EnsureEndpoint("jira/subscribe/collapsed").Record(0, 0, 0, false, true)
+	}
+
+	message := renderCollapsedUpdates(ji, c)
+	if !create {
+		if c.PostId == collapsedPostPending {
+			// The post is being created, and will be updated by the next
+			// update.
+			return nil
+		}
+		post, appErr := p.API.GetPost(c.PostId)
+		if appErr != nil {
+			return appErr
+		}
+		post.Message = message
+		_, appErr = p.API.UpdatePost(post)
+		if appErr != nil {
+			return appErr
+		}
+		return nil
+	}
+
+	post, appErr := p.API.CreatePost(&model.Post{
+		UserId:    p.getUserID(),
+		ChannelId: sub.ChannelId,
+		Message:   message,
+	})
+	postId := ""
+	if appErr == nil {
+		postId = post.Id
+	}
+	// Without a post, the next update creates it.
+	err := p.atomicModify(rateLimitKey(ji.GetURL(), sub.ChannelId), func(initialBytes []byte) ([]byte, error) {
+		state := channelRateLimit{}
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &state)
+			if err != nil {
+				return nil, err
+			}
+		}
+		current := state.Collapsed[c.Project]
+		if current == nil || current.PostId != collapsedPostPending {
+			// A new window started in the meantime.
+			return initialBytes, nil
+		}
+		current.PostId = postId
+		return json.Marshal(&state)
+	})
+	if appErr != nil {
+		return appErr
+	}
+	return err
+}
+
+func renderCollapsedUpdates(ji Instance, c *collapsedUpdates) string {
+	jql := fmt.Sprintf("project = %q ORDER BY updated DESC", c.Project)
+	if len(c.IssueKeys) > 0 && !c.Truncated {
+		keys := append([]string{}, c.IssueKeys...)
+		sort.Strings(keys)
+		jql = fmt.Sprintf("key in (%s) ORDER BY updated DESC", strings.Join(keys, ", "))
+	}
+	link := fmt.Sprintf("%s/issues/?jql=%s", ji.GetURL(), url.QueryEscape(jql))
+
+	updates := "updates"
+	if c.Count == 1 {
+		updates = "update"
+	}
+	change := "bulk change"
+	if len(c.Authors) > 1 {
+		change = "bulk changes"
+	}
+	return fmt.Sprintf("[%d more %s to %s](%s) (%s by %s)",
+		c.Count, updates, c.Project, link, change, strings.Join(c.Authors, ", "))
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+func TestCollapseChannelPost(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	p.userStore = mockUserStore{}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	kv := map[string][]byte{}
+	writes := 0
+	api.On("KVGet", mock.Anything).Return(func(key string) []byte {
+		return kv[key]
+	}, nil)
+	api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		writes++
+		kv[args.String(0)] = args.Get(2).([]byte)
+	}).Return(true, nil)
+	api.On("GetUser", "testMattermostUserId012345").Return(&model.User{Username: "bulk.editor"}, nil)
+
+	newWebhook := func(issueKey string) *webhook {
+		return &webhook{JiraWebhook: &JiraWebhook{
+			User: jira.User{Name: "editor"},
+			Issue: jira.Issue{
+				Key:    issueKey,
+				Fields: &jira.IssueFields{Project: jira.Project{Key: "PROJ"}},
+			},
+		}}
+	}
+
+	limit := SubscriptionRateLimit{Posts: 2, WindowMinutes: 10}
+	sub := &ChannelSubscription{Id: "subId", ChannelId: "channelId", RateLimit: &limit}
+	now := time.Date(2020, 1, 15, 9, 30, 0, 0, time.UTC)
+	for i, issueKey := range []string{"PROJ-1", "PROJ-2"} {
+		collapsed, _, err := p.collapseChannelPost(ji, newWebhook(issueKey), sub, now.Add(time.Duration(i)*time.Minute))
+		require.Nil(t, err)
+		assert.Nil(t, collapsed, "posts under the limit are not collapsed")
+	}
+
+	collapsed, create, err := p.collapseChannelPost(ji, newWebhook("PROJ-3"), sub, now.Add(2*time.Minute))
+	require.Nil(t, err)
+	require.NotNil(t, collapsed)
+	assert.True(t, create)
+	assert.Equal(t, 1, collapsed.Count)
+
+	collapsed, create, err = p.collapseChannelPost(ji, newWebhook("PROJ-4"), sub, now.Add(3*time.Minute))
+	require.Nil(t, err)
+	require.NotNil(t, collapsed)
+	assert.False(t, create, "the post is created once")
+	assert.Equal(t, 2, collapsed.Count)
+	assert.Equal(t, []string{"PROJ-3", "PROJ-4"}, collapsed.IssueKeys)
+	assert.Equal(t, []string{"@bulk.editor"}, collapsed.Authors)
+
+	jql := url.QueryEscape("key in (PROJ-3, PROJ-4) ORDER BY updated DESC")
+	assert.Equal(t, "[2 more updates to PROJ]("+mockCurrentInstanceURL+"/issues/?jql="+jql+") (bulk change by @bulk.editor)",
+		renderCollapsedUpdates(ji, collapsed))
+
+	// A new window
+	collapsed, _, err = p.collapseChannelPost(ji, newWebhook("PROJ-5"), sub, now.Add(10*time.Minute))
+	require.Nil(t, err)
+	assert.Nil(t, collapsed)
+
+	// The other subscriptions of the channel share the count, each with its
+	// own limit, those of other channels have their own.
+	otherLimit := SubscriptionRateLimit{Posts: 2, WindowMinutes: 10}
+	other := &ChannelSubscription{Id: "otherSubId", ChannelId: "channelId", RateLimit: &otherLimit}
+	collapsed, _, err = p.collapseChannelPost(ji, newWebhook("PROJ-6"), other, now.Add(11*time.Minute))
+	require.Nil(t, err)
+	assert.Nil(t, collapsed)
+	collapsed, _, err = p.collapseChannelPost(ji, newWebhook("PROJ-7"), other, now.Add(12*time.Minute))
+	require.Nil(t, err)
+	assert.NotNil(t, collapsed, "over the limit of the channel")
+	collapsed, _, err = p.collapseChannelPost(ji, newWebhook("PROJ-8"), &ChannelSubscription{Id: "subId", ChannelId: "otherChannelId", RateLimit: &limit}, now.Add(12*time.Minute))
+	require.Nil(t, err)
+	assert.Nil(t, collapsed)
+
+	// Without a limit, the posts are not counted.
+	before := writes
+	collapsed, _, err = p.collapseChannelPost(ji, newWebhook("PROJ-9"), &ChannelSubscription{Id: "unlimitedSubId", ChannelId: "channelId"}, now.Add(10*time.Minute))
+	require.Nil(t, err)
+	assert.Nil(t, collapsed)
+	assert.Equal(t, before, writes)
+}
+
+func TestParseSubscriptionRateLimit(t *testing.T) {
+	l, err := parseSubscriptionRateLimit(" 20 ", "")
+	require.Nil(t, err)
+	assert.Equal(t, SubscriptionRateLimit{Posts: 20}, l)
+	assert.Equal(t, "20 posts per 5 minutes", l.String())
+
+	l, err = parseSubscriptionRateLimit("", "")
+	require.Nil(t, err)
+	assert.Equal(t, SubscriptionRateLimit{}, l)
+
+	_, err = parseSubscriptionRateLimit("many", "5")
+	assert.NotNil(t, err)
+	_, err = parseSubscriptionRateLimit("-1", "5")
+	assert.NotNil(t, err)
+}
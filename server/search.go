@@ -0,0 +1,448 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	JIRA_SAVED_SEARCHES_KEY = "jirasavedsearch_"
+
+	MAX_SAVED_SEARCHES_PER_USER = 25
+
+	searchPageSize = 10
+)
+
+const (
+	searchActionPage   = "page"
+	searchActionView   = "view"
+	searchActionAssign = "assign"
+
+	// Shows the transitions of an issue, fetched only for the issue they
+	// are asked for.
+	searchActionTransitions = "transitions"
+)
+
+const searchHelpText = "###### Search Jira issues\n" +
+	"* `/jira search <JQL or text>` - Search the issues matching a JQL query, or containing a text\n" +
+	"* `/jira search @<name>` - Run a saved search\n" +
+	"* `/jira search save <name> <JQL>` - Save a search, or replace the saved search with the same name\n" +
+	"* `/jira search list` - List your saved searches\n" +
+	"* `/jira search delete <name>` - Delete a saved search\n"
+
+var (
+	reSavedSearchName = regexp.MustCompile(`^[[:alnum:]_-]+$`)
+
+	// reJQLQuery matches the operators and keywords that make a search
+	// text a JQL query.
+	reJQLQuery = regexp.MustCompile(`(?i)[=~<>]` +
+		`|\border\s+by\b` +
+		`|\b\w+\s+(not\s+)?in\s*\(` +
+		`|\b\w+\s+is\s+(not\s+)?(empty|null)\b`)
+)
+
+// SavedSearch is a JQL query saved by a user, to run it with
+// /jira search @<name>.
+type SavedSearch struct {
+	Name string `json:"name"`
+	JQL  string `json:"jql"`
+}
+
+func savedSearchesKey(ji Instance, mattermostUserId string) string {
+	return keyWithInstance(ji, JIRA_SAVED_SEARCHES_KEY+mattermostUserId)
+}
+
+func (p *Plugin) getSavedSearches(ji Instance, mattermostUserId string) ([]SavedSearch, error) {
+	data, appErr := p.API.KVGet(savedSearchesKey(ji, mattermostUserId))
+	if appErr != nil {
+		return nil, errors.WithMessage(appErr, "failed to load saved searches")
+	}
+	searches := []SavedSearch{}
+	if data == nil {
+		return searches, nil
+	}
+	err := json.Unmarshal(data, &searches)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load saved searches")
+	}
+	return searches, nil
+}
+
+func (p *Plugin) modifySavedSearches(ji Instance, mattermostUserId string, modify func(searches []SavedSearch) ([]SavedSearch, error)) error {
+	return p.atomicModify(savedSearchesKey(ji, mattermostUserId), func(initialBytes []byte) ([]byte, error) {
+		searches := []SavedSearch{}
+		if initialBytes != nil {
+			err := json.Unmarshal(initialBytes, &searches)
+			if err != nil {
+				return nil, err
+			}
+		}
+		searches, err := modify(searches)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(searches)
+	})
+}
+
+// searchQueryJQL returns the JQL for a search text, that is either a JQL
+// query, or a text to look for.
+func searchQueryJQL(text string) string {
+	if reJQLQuery.MatchString(text) {
+		return text
+	}
+	return textSearchJQL(text)
+}
+
+func executeSearch(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) == 0 {
+		return p.responsef(header, searchHelpText)
+	}
+	ji, jiraUser, errResponse := p.watchUserInstance(header)
+	if errResponse != nil {
+		return errResponse
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+
+	text := strings.Join(args, " ")
+	jql := searchQueryJQL(text)
+	if strings.HasPrefix(text, "@") && len(args) == 1 {
+		name := text[1:]
+		searches, err := p.getSavedSearches(ji, header.UserId)
+		if err != nil {
+			return p.responsef(header, "%v", err)
+		}
+		jql = ""
+		for _, s := range searches {
+			if strings.EqualFold(s.Name, name) {
+				jql = s.JQL
+			}
+		}
+		if jql == "" {
+			return p.responsef(header, "You have no saved search named `%s`. Use `/jira search list` to list them.", name)
+		}
+	}
+
+	post, err := p.searchResultsPost(ji, client, header.ChannelId, header.RootId, jql, 0, "")
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	_ = p.API.SendEphemeralPost(header.UserId, post)
+	return &model.CommandResponse{}
+}
+
+func executeSearchSave(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) < 2 {
+		return p.responsef(header, searchHelpText)
+	}
+	name := strings.TrimPrefix(args[0], "@")
+	if !reSavedSearchName.MatchString(name) {
+		return p.responsef(header, "`%s` is not a valid name. Please use letters, digits, `-` and `_`.", name)
+	}
+	search := SavedSearch{
+		Name: name,
+		JQL:  strings.Join(args[1:], " "),
+	}
+
+	ji, jiraUser, errResponse := p.watchUserInstance(header)
+	if errResponse != nil {
+		return errResponse
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	_, err = client.SearchIssues(search.JQL, &jira.SearchOptions{MaxResults: 1, Fields: []string{"key"}})
+	if err != nil {
+		return p.responsef(header, "Invalid JQL: %v", err)
+	}
+
+	err = p.modifySavedSearches(ji, header.UserId, func(searches []SavedSearch) ([]SavedSearch, error) {
+		for i, s := range searches {
+			if strings.EqualFold(s.Name, search.Name) {
+				searches[i] = search
+				return searches, nil
+			}
+		}
+		if len(searches) >= MAX_SAVED_SEARCHES_PER_USER {
+			return nil, errors.Errorf("You can have at most %d saved searches.", MAX_SAVED_SEARCHES_PER_USER)
+		}
+		return append(searches, search), nil
+	})
+	if err != nil {
+		return p.responsef(header, "Failed to save the search: %v", errors.Cause(err))
+	}
+	return p.responsef(header, "Saved the search `%s`, use `/jira search @%s` to run it.", search.JQL, search.Name)
+}
+
+func executeSearchList(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) != 0 {
+		return p.responsef(header, searchHelpText)
+	}
+	ji, _, errResponse := p.watchUserInstance(header)
+	if errResponse != nil {
+		return errResponse
+	}
+
+	searches, err := p.getSavedSearches(ji, header.UserId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if len(searches) == 0 {
+		return p.responsef(header, "You have no saved searches. Use `/jira search save <name> <JQL>` to add one.")
+	}
+	text := "Your saved searches:\n"
+	for _, s := range searches {
+		text += fmt.Sprintf("* `@%s` - `%s`\n", s.Name, s.JQL)
+	}
+	return p.responsef(header, "%s", text)
+}
+
+func executeSearchDelete(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) != 1 {
+		return p.responsef(header, searchHelpText)
+	}
+	ji, _, errResponse := p.watchUserInstance(header)
+	if errResponse != nil {
+		return errResponse
+	}
+
+	name := strings.TrimPrefix(args[0], "@")
+	err := p.modifySavedSearches(ji, header.UserId, func(searches []SavedSearch) ([]SavedSearch, error) {
+		for i, s := range searches {
+			if strings.EqualFold(s.Name, name) {
+				return append(searches[:i], searches[i+1:]...), nil
+			}
+		}
+		return nil, errors.Errorf("You have no saved search named `%s`.", name)
+	})
+	if err != nil {
+		return p.responsef(header, "Failed to delete the search: %v", errors.Cause(err))
+	}
+	return p.responsef(header, "Deleted the saved search `%s`.", name)
+}
+
+// searchResultsPost returns an ephemeral post with a page of the issues
+// matching jql, with actions on each issue and buttons to change pages. The
+// transitions are only listed for the issue transitionsOf, if any, the other
+// issues get a button to list theirs.
+func (p *Plugin) searchResultsPost(ji Instance, client Client, channelId, rootId, jql string, start int, transitionsOf string) (*model.Post, error) {
+	// One more issue tells if there is a next page.
+	issues, err := client.SearchIssues(jql, &jira.SearchOptions{
+		StartAt:    start,
+		MaxResults: searchPageSize + 1,
+		Fields:     []string{"summary", "status", "assignee", "priority"},
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to search issues")
+	}
+	hasNext := len(issues) > searchPageSize
+	if hasNext {
+		issues = issues[:searchPageSize]
+	}
+
+	post := &model.Post{
+		UserId:    p.getUserID(),
+		ChannelId: channelId,
+		RootId:    rootId,
+		ParentId:  rootId,
+	}
+	if len(issues) == 0 {
+		post.Message = fmt.Sprintf("No issues found for `%s`.", jql)
+		return post, nil
+	}
+	post.Message = fmt.Sprintf("Issues %d to %d found for `%s`:", start+1, start+len(issues), jql)
+
+	integration := func(action string, ctx map[string]interface{}) *model.PostActionIntegration {
+		ctx["action"] = action
+		ctx["jql"] = jql
+		ctx["start"] = strconv.Itoa(start)
+		ctx["rootId"] = rootId
+		return &model.PostActionIntegration{
+			URL:     pluginRouteURL(ji, routeAPISearchAction),
+			Context: ctx,
+		}
+	}
+
+	attachments := []*model.SlackAttachment{}
+	for i := range issues {
+		issue := &issues[i]
+		actions := []*model.PostAction{
+			{
+				Name:        "View",
+				Type:        model.POST_ACTION_TYPE_BUTTON,
+				Integration: integration(searchActionView, map[string]interface{}{"issueKey": issue.Key}),
+			},
+			{
+				Name:        "Assign to me",
+				Type:        model.POST_ACTION_TYPE_BUTTON,
+				Integration: integration(searchActionAssign, map[string]interface{}{"issueKey": issue.Key}),
+			},
+		}
+		if issue.Fields != nil && issue.Fields.Status != nil {
+			var transitions []*model.PostAction
+			if issue.Key == transitionsOf {
+				transitions, _ = getTransitionActions(ji, client, issue)
+			}
+			if len(transitions) == 0 {
+				transitions = []*model.PostAction{{
+					Name:        "Transition",
+					Type:        model.POST_ACTION_TYPE_BUTTON,
+					Integration: integration(searchActionTransitions, map[string]interface{}{"issueKey": issue.Key}),
+				}}
+			}
+			actions = append(actions, transitions...)
+		}
+		attachments = append(attachments, &model.SlackAttachment{
+			Color:   defaultNotificationColor,
+			Text:    searchResultText(issue),
+			Actions: actions,
+		})
+	}
+
+	pages := []*model.PostAction{}
+	if start > 0 {
+		prev := start - searchPageSize
+		if prev < 0 {
+			prev = 0
+		}
+		pages = append(pages, &model.PostAction{
+			Name:        "Prev",
+			Type:        model.POST_ACTION_TYPE_BUTTON,
+			Integration: integration(searchActionPage, map[string]interface{}{"page": strconv.Itoa(prev)}),
+		})
+	}
+	if hasNext {
+		pages = append(pages, &model.PostAction{
+			Name:        "Next",
+			Type:        model.POST_ACTION_TYPE_BUTTON,
+			Integration: integration(searchActionPage, map[string]interface{}{"page": strconv.Itoa(start + searchPageSize)}),
+		})
+	}
+	if len(pages) > 0 {
+		attachments = append(attachments, &model.SlackAttachment{
+			Actions: pages,
+		})
+	}
+
+	post.AddProp("attachments", attachments)
+	return post, nil
+}
+
+func searchResultText(issue *jira.Issue) string {
+	text := mdKeySummaryLink(issue)
+	if issue.Fields == nil {
+		return text
+	}
+	details := []string{}
+	if issue.Fields.Priority != nil {
+		details = append(details, "Priority: "+issue.Fields.Priority.Name)
+	}
+	if issue.Fields.Assignee != nil {
+		details = append(details, "Assignee: "+issue.Fields.Assignee.DisplayName)
+	} else {
+		details = append(details, "Unassigned")
+	}
+	return text + "\n" + strings.Join(details, ", ")
+}
+
+// httpAPISearchAction handles the buttons of the /jira search results.
+func httpAPISearchAction(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
+	requestData := model.PostActionIntegrationRequestFromJson(r.Body)
+	if requestData == nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.New("Missing request data"))
+	}
+
+	plugin := ji.GetPlugin()
+	jiraBotID := plugin.getUserID()
+	channelID := requestData.ChannelId
+	mattermostUserId, err := actionUserId(r, requestData.UserId)
+	if err != nil {
+		return respondErr(w, http.StatusUnauthorized, err)
+	}
+	replyf := func(format string, args ...interface{}) {
+		_ = plugin.API.SendEphemeralPost(mattermostUserId, makePost(jiraBotID, channelID, fmt.Sprintf(format, args...)))
+	}
+
+	action, _ := requestData.Context["action"].(string)
+	jql, _ := requestData.Context["jql"].(string)
+	issueKey, _ := requestData.Context["issueKey"].(string)
+	rootId, _ := requestData.Context["rootId"].(string)
+	start, err := strconv.Atoi(fmt.Sprint(requestData.Context["start"]))
+	if err != nil || jql == "" {
+		replyf("The search results are no longer valid, please search again.")
+		return respondErr(w, http.StatusBadRequest, errors.New("invalid search context"))
+	}
+
+	jiraUser, err := plugin.userStore.LoadJIRAUser(ji, mattermostUserId)
+	if err != nil {
+		replyf("Your username is not connected to Jira. Please type `/jira connect`.")
+		return respondErr(w, http.StatusUnauthorized, err)
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		replyf("Failed to connect to Jira: %v", err)
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	transitionsOf := ""
+	switch action {
+	case searchActionPage:
+		start, err = strconv.Atoi(fmt.Sprint(requestData.Context["page"]))
+		if err != nil {
+			return respondErr(w, http.StatusBadRequest, errors.New("invalid search page"))
+		}
+
+	case searchActionView:
+		attachment, err := plugin.getIssueAsSlackAttachment(ji, jiraUser, issueKey)
+		if err != nil {
+			replyf("%v", err)
+			return respondErr(w, http.StatusInternalServerError, err)
+		}
+		post := makePost(jiraBotID, channelID, "")
+		post.AddProp("attachments", attachment)
+		_ = plugin.API.SendEphemeralPost(mattermostUserId, post)
+		return respondJSON(w, &model.PostActionIntegrationResponse{})
+
+	case searchActionAssign:
+		msg, err := assignJiraIssueToSelf(ji, client, jiraUser, issueKey)
+		if err != nil {
+			replyf("Failed to assign %s: %v", issueKey, err)
+			return respondErr(w, http.StatusInternalServerError, err)
+		}
+		replyf("%s", msg)
+
+	case searchActionTransitions:
+		transitionsOf = issueKey
+
+	default:
+		return respondErr(w, http.StatusBadRequest, errors.Errorf("unknown search action %q", action))
+	}
+
+	// Show the new page, or the current one with the changes.
+	post, err := plugin.searchResultsPost(ji, client, channelID, rootId, jql, start, transitionsOf)
+	if err != nil {
+		replyf("%v", err)
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	post.Id = requestData.PostId
+	plugin.API.UpdateEphemeralPost(mattermostUserId, post)
+	return respondJSON(w, &model.PostActionIntegrationResponse{})
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestSearchQueryJQL(t *testing.T) {
+	for text, expected := range map[string]string{
+		"project = MM AND status = Open": "project = MM AND status = Open",
+		"summary ~ login":                "summary ~ login",
+		"assignee in (currentUser())":    "assignee in (currentUser())",
+		"assignee is EMPTY":              "assignee is EMPTY",
+		"created > -1d":                  "created > -1d",
+		"order by created":               "order by created",
+		"login fails":                    `text ~ "login fails" OR text ~ "login fails*"`,
+		"what is this":                   `text ~ "what is this" OR text ~ "what is this*"`,
+		`the "quoted" text`:              `text ~ "the \"quoted\" text" OR text ~ "the \"quoted\" text*"`,
+	} {
+		t.Run(text, func(t *testing.T) {
+			assert.Equal(t, expected, searchQueryJQL(text))
+		})
+	}
+}
+
+type searchTestClient struct {
+	testClient
+	total       int
+	transitions map[string]int
+}
+
+func (client searchTestClient) GetTransitions(issueKey string) ([]jira.Transition, error) {
+	client.transitions[issueKey]++
+	return client.testClient.GetTransitions(issueKey)
+}
+
+func (client searchTestClient) SearchIssues(jql string, options *jira.SearchOptions) ([]jira.Issue, error) {
+	issues := []jira.Issue{}
+	for i := options.StartAt; i < client.total && len(issues) < options.MaxResults; i++ {
+		issues = append(issues, jira.Issue{
+			Key:  fmt.Sprintf("TEST-%d", i+1),
+			Self: mockCurrentInstanceURL + "/rest/api/2/issue/1",
+			Fields: &jira.IssueFields{
+				Summary: "Summary",
+				Status:  &jira.Status{Name: "To Do"},
+			},
+		})
+	}
+	return issues, nil
+}
+
+func TestSearchResultsPost(t *testing.T) {
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {
+		conf.botUserID = "botUserId"
+	})
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	for name, tc := range map[string]struct {
+		total         int
+		start         int
+		transitionsOf string
+		expectMessage string
+		expectIssues  int
+		expectPages   []string
+	}{
+		"no issues": {
+			expectMessage: "No issues found for `project = TEST`.",
+		},
+		"single page": {
+			total:         3,
+			transitionsOf: "TEST-2",
+			expectMessage: "Issues 1 to 3 found for `project = TEST`:",
+			expectIssues:  3,
+		},
+		"first page": {
+			total:         25,
+			expectMessage: "Issues 1 to 10 found for `project = TEST`:",
+			expectIssues:  10,
+			expectPages:   []string{"Next"},
+		},
+		"middle page": {
+			total:         25,
+			start:         10,
+			expectMessage: "Issues 11 to 20 found for `project = TEST`:",
+			expectIssues:  10,
+			expectPages:   []string{"Prev", "Next"},
+		},
+		"last page": {
+			total:         25,
+			start:         20,
+			expectMessage: "Issues 21 to 25 found for `project = TEST`:",
+			expectIssues:  5,
+			expectPages:   []string{"Prev"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := searchTestClient{total: tc.total, transitions: map[string]int{}}
+			post, err := p.searchResultsPost(ji, client, "channelId", "rootId", "project = TEST", tc.start, tc.transitionsOf)
+			require.Nil(t, err)
+			assert.Equal(t, tc.expectMessage, post.Message)
+			assert.Equal(t, "botUserId", post.UserId)
+			assert.Equal(t, "rootId", post.RootId)
+
+			attachments, _ := post.Props["attachments"].([]*model.SlackAttachment)
+			issues := attachments
+			pages := []string{}
+			if len(tc.expectPages) > 0 {
+				require.NotEmpty(t, attachments)
+				issues = attachments[:len(attachments)-1]
+				for _, action := range attachments[len(attachments)-1].Actions {
+					pages = append(pages, action.Name)
+					assert.Equal(t, searchActionPage, action.Integration.Context["action"])
+					assert.Equal(t, "project = TEST", action.Integration.Context["jql"])
+				}
+			}
+			assert.Len(t, issues, tc.expectIssues)
+			assert.Equal(t, len(tc.expectPages), len(pages))
+			for i := range tc.expectPages {
+				assert.Equal(t, tc.expectPages[i], pages[i])
+			}
+
+			for i, attachment := range issues {
+				key := fmt.Sprintf("TEST-%d", tc.start+i+1)
+				assert.Contains(t, attachment.Text, "/browse/"+key+")")
+				require.Len(t, attachment.Actions, 3)
+				assert.Equal(t, "View", attachment.Actions[0].Name)
+				assert.Equal(t, key, attachment.Actions[0].Integration.Context["issueKey"])
+				assert.Equal(t, "Assign to me", attachment.Actions[1].Name)
+				if key == tc.transitionsOf {
+					assert.Equal(t, "Transition issue", attachment.Actions[2].Name)
+				} else {
+					assert.Equal(t, "Transition", attachment.Actions[2].Name)
+					assert.Equal(t, searchActionTransitions, attachment.Actions[2].Integration.Context["action"])
+					assert.Equal(t, key, attachment.Actions[2].Integration.Context["issueKey"])
+				}
+			}
+
+			// The transitions are only fetched for the issue they are
+			// asked for.
+			if tc.transitionsOf != "" {
+				assert.Equal(t, map[string]int{tc.transitionsOf: 1}, client.transitions)
+			} else {
+				assert.Empty(t, client.transitions)
+			}
+		})
+	}
+}
+
+func TestHttpAPISearchActionForgedUser(t *testing.T) {
+	p := &Plugin{}
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	p.userStore = mockUserStore{}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	request := &model.PostActionIntegrationRequest{
+		UserId: "userId",
+		Context: map[string]interface{}{
+			"action": searchActionTransitions,
+		},
+	}
+	r := httptest.NewRequest(http.MethodPost, routeAPISearchAction, bytes.NewReader(request.ToJson()))
+	r.Header.Set("Mattermost-User-Id", "attackerId")
+	w := httptest.NewRecorder()
+	status, err := httpAPISearchAction(ji, w, r)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
@@ -1,12 +1,45 @@
 package main
 
-import "github.com/mattermost/mattermost-server/v5/model"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
 
 const (
 	settingOn  = "on"
 	settingOff = "off"
 )
 
+// The kinds of notifications, each can be turned off in the user settings.
+const (
+	notifyAssigned  = "assigned"
+	notifyMentioned = "mentioned"
+	notifyCommented = "commented"
+	notifyStatus    = "status"
+)
+
+var notificationEvents = []string{
+	notifyAssigned,
+	notifyMentioned,
+	notifyCommented,
+	notifyStatus,
+}
+
+const settingsHelpText = "###### Settings:\n" +
+	"* `/jira settings` - Display your settings\n" +
+	"* `/jira settings notifications <on|off>` - Turn all the notifications by DM on or off\n" +
+	"* `/jira settings events <event> <on|off>` - Turn one kind of notification on or off. The events are: " +
+	"`assigned` to an issue, `mentioned` in a comment, `commented` on the issues you are assigned to, reported or watch, and `status` changes of the issues you reported or watch\n" +
+	"* `/jira settings mute <project-key>` - Stop the notifications about the issues of a project. `/jira settings unmute <project-key>` resumes them\n" +
+	"* `/jira settings quiet-hours <HH:MM> <HH:MM>` - Hold the notifications between two times, in your timezone, and send them afterwards. `/jira settings quiet-hours off` removes them\n" +
+	"* `/jira settings batch <minutes>` - Send the notifications together, at most once every number of minutes. `/jira settings batch off` sends them right away\n"
+
+// MaxBatchMinutes is the longest notifications can be batched for.
+const MaxBatchMinutes = 24 * 60
+
 func (p *Plugin) settingsNotifications(header *model.CommandArgs, ji Instance, mattermostUserId string, jiraUser JIRAUser, args []string) *model.CommandResponse {
 	const helpText = "`/jira settings notifications [value]`\n* Invalid value. Accepted values are: `on` or `off`."
 
@@ -45,3 +78,100 @@ func (p *Plugin) settingsNotifications(header *model.CommandArgs, ji Instance, m
 
 	return p.responsef(header, "Settings updated. Notifications %s.", notifications)
 }
+
+func (p *Plugin) settingsEvents(header *model.CommandArgs, ji Instance, mattermostUserId string, jiraUser JIRAUser, args []string) *model.CommandResponse {
+	if len(args) != 3 || (args[2] != settingOn && args[2] != settingOff) {
+		return p.responsef(header, settingsHelpText)
+	}
+	event := strings.ToLower(args[1])
+	known := false
+	for _, e := range notificationEvents {
+		known = known || e == event
+	}
+	if !known {
+		return p.responsef(header, "Unknown event %q. The events are: %s.", args[1], strings.Join(notificationEvents, ", "))
+	}
+
+	settings := jiraUser.settings()
+	if args[2] == settingOn {
+		settings.DisabledEvents = settings.DisabledEvents.Subtract(event)
+	} else {
+		settings.DisabledEvents = settings.DisabledEvents.Add(event)
+	}
+	return p.storeUserSettings(header, ji, mattermostUserId, jiraUser, fmt.Sprintf("Notifications for %s %s.", event, args[2]))
+}
+
+func (p *Plugin) settingsMute(header *model.CommandArgs, ji Instance, mattermostUserId string, jiraUser JIRAUser, args []string) *model.CommandResponse {
+	if len(args) != 2 {
+		return p.responsef(header, settingsHelpText)
+	}
+	projectKey := strings.ToUpper(args[1])
+
+	settings := jiraUser.settings()
+	var message string
+	if args[0] == settingsMute {
+		settings.MutedProjects = settings.MutedProjects.Add(projectKey)
+		message = fmt.Sprintf("Notifications for %s muted.", projectKey)
+	} else {
+		if !settings.MutedProjects.ContainsAny(projectKey) {
+			return p.responsef(header, "%s is not muted.", projectKey)
+		}
+		settings.MutedProjects = settings.MutedProjects.Subtract(projectKey)
+		message = fmt.Sprintf("Notifications for %s unmuted.", projectKey)
+	}
+	return p.storeUserSettings(header, ji, mattermostUserId, jiraUser, message)
+}
+
+func (p *Plugin) settingsQuietHours(header *model.CommandArgs, ji Instance, mattermostUserId string, jiraUser JIRAUser, args []string) *model.CommandResponse {
+	settings := jiraUser.settings()
+	switch {
+	case len(args) == 2 && args[1] == settingOff:
+		settings.QuietHours = nil
+		return p.storeUserSettings(header, ji, mattermostUserId, jiraUser, "Quiet hours off.")
+
+	case len(args) == 3:
+		quietHours := &QuietHours{Start: args[1], End: args[2]}
+		user, appErr := p.API.GetUser(mattermostUserId)
+		if appErr != nil {
+			return p.responsef(header, "Failed to load your timezone: %v", appErr)
+		}
+		quietHours.Timezone = user.GetPreferredTimezone()
+		if err := quietHours.validate(); err != nil {
+			return p.responsef(header, "Invalid quiet hours: %v.", err)
+		}
+		settings.QuietHours = quietHours
+		return p.storeUserSettings(header, ji, mattermostUserId, jiraUser,
+			fmt.Sprintf("Quiet hours set from %s. The notifications are sent when they end.", quietHours))
+
+	default:
+		return p.responsef(header, settingsHelpText)
+	}
+}
+
+func (p *Plugin) settingsBatch(header *model.CommandArgs, ji Instance, mattermostUserId string, jiraUser JIRAUser, args []string) *model.CommandResponse {
+	if len(args) != 2 {
+		return p.responsef(header, settingsHelpText)
+	}
+	settings := jiraUser.settings()
+	if args[1] == settingOff {
+		settings.BatchMinutes = 0
+		return p.storeUserSettings(header, ji, mattermostUserId, jiraUser, "Notifications are sent right away.")
+	}
+
+	minutes, err := strconv.Atoi(args[1])
+	if err != nil || minutes < 1 || minutes > MaxBatchMinutes {
+		return p.responsef(header, "The batch interval must be a number of minutes between 1 and %d, or `off`.", MaxBatchMinutes)
+	}
+	settings.BatchMinutes = minutes
+	return p.storeUserSettings(header, ji, mattermostUserId, jiraUser,
+		fmt.Sprintf("Notifications are sent together, at most once every %d minutes.", minutes))
+}
+
+// storeUserSettings saves the settings of jiraUser, and replies with message.
+func (p *Plugin) storeUserSettings(header *model.CommandArgs, ji Instance, mattermostUserId string, jiraUser JIRAUser, message string) *model.CommandResponse {
+	if err := p.userStore.StoreUserInfo(ji, mattermostUserId, jiraUser); err != nil {
+		p.errorf("storeUserSettings, err: %v", err)
+		return p.responsef(header, "Could not store new settings. Please contact your system administrator. error: %v", err)
+	}
+	return p.responsef(header, "Settings updated. %s", message)
+}
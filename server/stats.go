@@ -44,6 +44,8 @@ func (p *Plugin) initStats() {
 
 	initUserCounter(p.currentInstanceStore, p.userStore)
 	initUptime()
+	initClientCacheCounter(p.clientCache)
+	initCircuitBreakersCounter(&p.circuitBreakers)
 
 	p.startAutosaveStats()
 }
@@ -55,17 +57,9 @@ func httpAPIStats(p *Plugin, w http.ResponseWriter, r *http.Request) (int, error
 	}
 	conf := p.getConfig()
 
-	isAdmin, err := authorizedSysAdmin(p, r.Header.Get("Mattermost-User-Id"))
-	if !isAdmin {
-		if conf.StatsSecret == "" {
-			return respondErr(w, http.StatusForbidden,
-				errors.New("Access forbidden: must be authenticated as an admin, or provide the stats API secret."))
-		}
-		var status int
-		status, err = verifyHTTPSecret(conf.StatsSecret, r.FormValue("secret"))
-		if err != nil {
-			return respondErr(w, status, err)
-		}
+	status, err := authorizeStatsRequest(p, r)
+	if err != nil {
+		return respondErr(w, status, err)
 	}
 	if conf.stats == nil {
 		return respondErr(w, http.StatusNotFound, errors.New("No stats available"))
@@ -89,6 +83,21 @@ func httpAPIStats(p *Plugin, w http.ResponseWriter, r *http.Request) (int, error
 	return http.StatusOK, nil
 }
 
+// authorizeStatsRequest allows the requests of the admins, and the ones with
+// the stats API secret.
+func authorizeStatsRequest(p *Plugin, r *http.Request) (int, error) {
+	isAdmin, _ := authorizedSysAdmin(p, r.Header.Get("Mattermost-User-Id"))
+	if isAdmin {
+		return http.StatusOK, nil
+	}
+	secret := p.getConfig().StatsSecret
+	if secret == "" {
+		return http.StatusForbidden,
+			errors.New("Access forbidden: must be authenticated as an admin, or provide the stats API secret.")
+	}
+	return verifyHTTPSecret(secret, r.FormValue("secret"))
+}
+
 func (p *Plugin) startAutosaveStats() {
 	stop := make(chan bool)
 	go func() {
@@ -42,6 +42,7 @@ type SubscriptionFilters struct {
 	Projects   StringSet     `json:"projects"`
 	IssueTypes StringSet     `json:"issue_types"`
 	Fields     []FieldFilter `json:"fields"`
+	JQL        string        `json:"jql,omitempty"`
 }
 
 type ChannelSubscription struct {
@@ -49,6 +50,17 @@ type ChannelSubscription struct {
 	ChannelId string              `json:"channel_id"`
 	Filters   SubscriptionFilters `json:"filters"`
 	Name      string              `json:"name"`
+	Digest    *SubscriptionDigest `json:"digest,omitempty"`
+
+	// Override the notification templates set by the administrators
+	Templates NotificationTemplates `json:"templates,omitempty"`
+
+	// The buttons added to the posts, see allPostActions
+	Actions StringSet `json:"actions,omitempty"`
+
+	// Overrides the rate limit of the posts to the channel set by the
+	// administrators
+	RateLimit *SubscriptionRateLimit `json:"rate_limit,omitempty"`
 }
 
 type ChannelSubscriptions struct {
@@ -115,6 +127,12 @@ func (p *Plugin) getUserID() string {
 }
 
 func (p *Plugin) matchesSubsciptionFilters(wh *webhook, filters SubscriptionFilters) bool {
+	return p.subscriptionFiltersMismatch(wh, filters) == ""
+}
+
+// subscriptionFiltersMismatch returns why filters reject wh, or an empty
+// string if wh matches them.
+func (p *Plugin) subscriptionFiltersMismatch(wh *webhook, filters SubscriptionFilters) string {
 	webhookEvents := wh.Events()
 	foundEvent := false
 	eventTypes := filters.Events
@@ -129,24 +147,23 @@ func (p *Plugin) matchesSubsciptionFilters(wh *webhook, filters SubscriptionFilt
 	}
 
 	if !foundEvent {
-		return false
+		events := webhookEvents.Elems()
+		sort.Strings(events)
+		return fmt.Sprintf("event %s is not subscribed to", strings.Join(events, ", "))
 	}
 
 	if filters.IssueTypes.Len() != 0 && !filters.IssueTypes.ContainsAny(wh.JiraWebhook.Issue.Fields.Type.ID) {
-		return false
+		return fmt.Sprintf("issue type %s is not subscribed to", wh.JiraWebhook.Issue.Fields.Type.Name)
 	}
 
 	if filters.Projects.Len() != 0 && !filters.Projects.ContainsAny(wh.JiraWebhook.Issue.Fields.Project.Key) {
-		return false
+		return fmt.Sprintf("project %s is not subscribed to", wh.JiraWebhook.Issue.Fields.Project.Key)
 	}
 
-	validFilter := true
-
 	for _, field := range filters.Fields {
 		// Broken filter, values must be provided
 		if field.Inclusion == "" || (field.Values.Len() == 0 && field.Inclusion != FILTER_EMPTY) {
-			validFilter = false
-			break
+			return fmt.Sprintf("field filter %s has no values", field.Key)
 		}
 
 		value := getIssueFieldValue(&wh.JiraWebhook.Issue, field.Key)
@@ -157,41 +174,72 @@ func (p *Plugin) matchesSubsciptionFilters(wh *webhook, filters SubscriptionFilt
 			(field.Inclusion == FILTER_INCLUDE_ALL && !containsAll) ||
 			(field.Inclusion == FILTER_EXCLUDE_ANY && containsAny) ||
 			(field.Inclusion == FILTER_EMPTY && value.Len() > 0) {
-			validFilter = false
-			break
+			return fmt.Sprintf("field filter %s (%s) excluded it", field.Key, field.Inclusion)
 		}
 	}
 
-	if !validFilter {
-		return false
+	if filters.JQL != "" {
+		// Broken filter, JQL is validated when the subscription is saved
+		jql, err := p.jqlCache.get(filters.JQL)
+		if err != nil {
+			return fmt.Sprintf("invalid JQL: %v", err)
+		}
+		if !jql.Matches(&wh.JiraWebhook.Issue) {
+			return "the JQL doesn't match"
+		}
 	}
 
-	return true
+	return ""
 }
 
-func (p *Plugin) getChannelsSubscribed(wh *webhook) (StringSet, error) {
-	subs, err := p.getSubscriptions()
+func (p *Plugin) getChannelsSubscribed(ji Instance, wh *webhook) (StringSet, error) {
+	channelSubs, _, err := p.getSubscriptionsMatched(ji, wh)
 	if err != nil {
 		return nil, err
 	}
-
 	channelIds := NewStringSet()
-	subIds := subs.Channel.ById
-	for _, sub := range subIds {
-		if p.matchesSubsciptionFilters(wh, sub.Filters) {
-			channelIds = channelIds.Add(sub.ChannelId)
-		}
+	for channelId := range channelSubs {
+		channelIds = channelIds.Add(channelId)
 	}
-
 	return channelIds, nil
 }
 
-func (p *Plugin) getSubscriptions() (*Subscriptions, error) {
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+// getSubscriptionsMatched returns the subscriptions to post wh for right
+// away, by channel, and the digest subscriptions to buffer it for. A channel
+// gets each event once: not in a digest if it is posted right away, and in a
+// single digest otherwise. Of several matching subscriptions, the one with the
+// lowest Id is used.
+func (p *Plugin) getSubscriptionsMatched(ji Instance, wh *webhook) (map[string]ChannelSubscription, []ChannelSubscription, error) {
+	subs, err := p.getSubscriptions(ji)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	channelSubs := map[string]ChannelSubscription{}
+	digestSubs := map[string]ChannelSubscription{}
+	for _, sub := range subs.Channel.ById {
+		if !p.matchesSubsciptionFilters(wh, sub.Filters) {
+			continue
+		}
+		matched := channelSubs
+		if sub.Digest != nil {
+			matched = digestSubs
+		}
+		if prev, ok := matched[sub.ChannelId]; !ok || sub.Id < prev.Id {
+			matched[sub.ChannelId] = sub
+		}
+	}
+
+	digests := []ChannelSubscription{}
+	for channelId, sub := range digestSubs {
+		if _, ok := channelSubs[channelId]; !ok {
+			digests = append(digests, sub)
+		}
+	}
+	return channelSubs, digests, nil
+}
+
+func (p *Plugin) getSubscriptions(ji Instance) (*Subscriptions, error) {
 	subKey := keyWithInstance(ji, JIRA_SUBSCRIPTIONS_KEY)
 	data, appErr := p.API.KVGet(subKey)
 	if appErr != nil {
@@ -200,8 +248,8 @@ func (p *Plugin) getSubscriptions() (*Subscriptions, error) {
 	return SubscriptionsFromJson(data)
 }
 
-func (p *Plugin) getSubscriptionsForChannel(channelId string) ([]ChannelSubscription, error) {
-	subs, err := p.getSubscriptions()
+func (p *Plugin) getSubscriptionsForChannel(ji Instance, channelId string) ([]ChannelSubscription, error) {
+	subs, err := p.getSubscriptions(ji)
 	if err != nil {
 		return nil, err
 	}
@@ -214,8 +262,8 @@ func (p *Plugin) getSubscriptionsForChannel(channelId string) ([]ChannelSubscrip
 	return channelSubscriptions, nil
 }
 
-func (p *Plugin) getChannelSubscription(subscriptionId string) (*ChannelSubscription, error) {
-	subs, err := p.getSubscriptions()
+func (p *Plugin) getChannelSubscription(ji Instance, subscriptionId string) (*ChannelSubscription, error) {
+	subs, err := p.getSubscriptions(ji)
 	if err != nil {
 		return nil, err
 	}
@@ -228,12 +276,7 @@ func (p *Plugin) getChannelSubscription(subscriptionId string) (*ChannelSubscrip
 	return &subscription, nil
 }
 
-func (p *Plugin) removeChannelSubscription(subscriptionId string) error {
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		return err
-	}
-
+func (p *Plugin) removeChannelSubscription(ji Instance, subscriptionId string) error {
 	subKey := keyWithInstance(ji, JIRA_SUBSCRIPTIONS_KEY)
 	return p.atomicModify(subKey, func(initialBytes []byte) ([]byte, error) {
 		subs, err := SubscriptionsFromJson(initialBytes)
@@ -257,12 +300,7 @@ func (p *Plugin) removeChannelSubscription(subscriptionId string) error {
 	})
 }
 
-func (p *Plugin) addChannelSubscription(newSubscription *ChannelSubscription, client Client) error {
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		return err
-	}
-
+func (p *Plugin) addChannelSubscription(ji Instance, newSubscription *ChannelSubscription, client Client) error {
 	subKey := keyWithInstance(ji, JIRA_SUBSCRIPTIONS_KEY)
 	return p.atomicModify(subKey, func(initialBytes []byte) ([]byte, error) {
 		subs, err := SubscriptionsFromJson(initialBytes)
@@ -270,9 +308,9 @@ func (p *Plugin) addChannelSubscription(newSubscription *ChannelSubscription, cl
 			return nil, err
 		}
 
-		err = p.validateSubscription(newSubscription, client)
+		err = p.validateSubscription(ji, newSubscription, client)
 		if err != nil {
-			return nil, err
+			return nil, RESTError{err, http.StatusBadRequest}
 		}
 
 		newSubscription.Id = model.NewId()
@@ -287,7 +325,7 @@ func (p *Plugin) addChannelSubscription(newSubscription *ChannelSubscription, cl
 	})
 }
 
-func (p *Plugin) validateSubscription(subscription *ChannelSubscription, client Client) error {
+func (p *Plugin) validateSubscription(ji Instance, subscription *ChannelSubscription, client Client) error {
 	if len(subscription.Name) == 0 {
 		return errors.New("Please provide a name for the subscription.")
 	}
@@ -300,16 +338,46 @@ func (p *Plugin) validateSubscription(subscription *ChannelSubscription, client
 		return errors.New("Please provide at least one event type.")
 	}
 
-	if len(subscription.Filters.IssueTypes) == 0 {
-		return errors.New("Please provide at least one issue type.")
+	// A JQL expression can select the projects and issue types on its own.
+	var jql *JQL
+	if subscription.Filters.JQL != "" {
+		var err error
+		jql, err = ParseJQL(subscription.Filters.JQL)
+		if err != nil {
+			return errors.Errorf("Invalid JQL: %v.", err)
+		}
+	} else {
+		if len(subscription.Filters.IssueTypes) == 0 {
+			return errors.New("Please provide at least one issue type.")
+		}
+
+		if (len(subscription.Filters.Projects)) == 0 {
+			return errors.New("Please provide a project identifier.")
+		}
+	}
+
+	if subscription.Digest != nil {
+		if err := subscription.Digest.validate(); err != nil {
+			return errors.Errorf("Invalid digest schedule: %v.", err)
+		}
+	}
+
+	if err := subscription.Templates.validate(); err != nil {
+		return errors.Errorf("Invalid notification templates: %v.", err)
 	}
 
-	if (len(subscription.Filters.Projects)) == 0 {
-		return errors.New("Please provide a project identifier.")
+	if err := validatePostActions(subscription.Actions); err != nil {
+		return errors.Errorf("Invalid post actions: %v.", err)
+	}
+
+	if subscription.RateLimit != nil {
+		if err := subscription.RateLimit.validate(); err != nil {
+			return errors.Errorf("Invalid rate limit: %v.", err)
+		}
 	}
 
 	channelId := subscription.ChannelId
-	subs, err := p.getSubscriptionsForChannel(channelId)
+	subs, err := p.getSubscriptionsForChannel(ji, channelId)
 	if err != nil {
 		return err
 	}
@@ -320,21 +388,40 @@ func (p *Plugin) validateSubscription(subscription *ChannelSubscription, client
 		}
 	}
 
-	projectKey := subscription.Filters.Projects.Elems()[0]
-	_, err = client.GetProject(projectKey)
-	if err != nil {
-		return errors.WithMessagef(err, "failed to get project %q", projectKey)
+	// The user must be able to see all the projects the subscription can
+	// post the issues of.
+	projects := subscription.Filters.Projects
+	if jql != nil {
+		if projects.Len() == 0 {
+			var ok bool
+			projects, ok = jql.Projects()
+			if !ok {
+				return errors.New("Please select the projects, or restrict the JQL to projects with `project in (...)`.")
+			}
+			if projects.Len() == 0 {
+				return errors.New("The JQL can't match the issues of any project.")
+			}
+		}
+
+		// Jira checks the JQL with the permissions of the user.
+		_, err = client.SearchIssues(subscription.Filters.JQL, &jira.SearchOptions{MaxResults: 1})
+		if err != nil {
+			return errors.WithMessage(err, "Jira rejected the JQL")
+		}
 	}
 
+	for _, projectKey := range projects.Elems() {
+		_, err = client.GetProject(projectKey)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to get project %q", projectKey)
+		}
+	}
+
+	p.jqlCache.set(jql)
 	return nil
 }
 
-func (p *Plugin) editChannelSubscription(modifiedSubscription *ChannelSubscription, client Client) error {
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		return err
-	}
-
+func (p *Plugin) editChannelSubscription(ji Instance, modifiedSubscription *ChannelSubscription, client Client) error {
 	subKey := keyWithInstance(ji, JIRA_SUBSCRIPTIONS_KEY)
 	return p.atomicModify(subKey, func(initialBytes []byte) ([]byte, error) {
 		subs, err := SubscriptionsFromJson(initialBytes)
@@ -347,9 +434,9 @@ func (p *Plugin) editChannelSubscription(modifiedSubscription *ChannelSubscripti
 			return nil, errors.New("Existing subscription does not exist.")
 		}
 
-		err = p.validateSubscription(modifiedSubscription, client)
+		err = p.validateSubscription(ji, modifiedSubscription, client)
 		if err != nil {
-			return nil, err
+			return nil, RESTError{err, http.StatusBadRequest}
 		}
 
 		subs.Channel.remove(&oldSub)
@@ -375,13 +462,13 @@ type SubsGroupedByChannel struct {
 	SubIds    []string
 }
 
-func (p *Plugin) listChannelSubscriptions(teamId string) (string, error) {
-	subs, err := p.getSubscriptions()
+func (p *Plugin) listChannelSubscriptions(ji Instance, teamId string) (string, error) {
+	subs, err := p.getSubscriptions(ji)
 	if err != nil {
 		return "", err
 	}
 
-	sortedSubs, err := p.getSortedSubscriptions()
+	sortedSubs, err := p.getSortedSubscriptions(ji)
 	if err != nil {
 		return "", err
 	}
@@ -421,7 +508,20 @@ func (p *Plugin) listChannelSubscriptions(teamId string) (string, error) {
 				if sub.Name != "" {
 					subName = sub.Name
 				}
-				rows = append(rows, fmt.Sprintf("  * %s - %s", sub.Filters.Projects.Elems()[0], subName))
+				selector := ""
+				switch {
+				case sub.Filters.Projects.Len() > 0:
+					selector = sub.Filters.Projects.Elems()[0]
+				case sub.Filters.JQL != "":
+					selector = fmt.Sprintf("`%s`", sub.Filters.JQL)
+				}
+				if sub.Digest != nil {
+					subName += fmt.Sprintf(" (%s digest)", sub.Digest.Schedule)
+				}
+				if sub.RateLimit != nil && sub.RateLimit.Posts > 0 {
+					subName += fmt.Sprintf(" (at most %s)", sub.RateLimit)
+				}
+				rows = append(rows, fmt.Sprintf("  * %s - %s", selector, subName))
 
 			}
 		}
@@ -430,8 +530,8 @@ func (p *Plugin) listChannelSubscriptions(teamId string) (string, error) {
 	return strings.Join(rows, "\n"), nil
 }
 
-func (p *Plugin) getSortedSubscriptions() ([]SubsGroupedByTeam, error) {
-	subs, err := p.getSubscriptions()
+func (p *Plugin) getSortedSubscriptions(ji Instance) ([]SubsGroupedByTeam, error) {
+	subs, err := p.getSubscriptions(ji)
 	if err != nil {
 		return nil, err
 	}
@@ -559,7 +659,7 @@ func inAllowedGroup(inGroups []*jira.UserGroup, allowedGroups []string) bool {
 
 // hasPermissionToManageSubscription checks if MM user has permission to manage subscriptions in given channel.
 // returns nil if the user has permission and a descriptive error otherwise.
-func (p *Plugin) hasPermissionToManageSubscription(userId, channelId string) error {
+func (p *Plugin) hasPermissionToManageSubscription(ji Instance, userId, channelId string) error {
 	cfg := p.getConfig()
 
 	switch cfg.RolesAllowedToEditJiraSubscriptions {
@@ -592,11 +692,6 @@ func (p *Plugin) hasPermissionToManageSubscription(userId, channelId string) err
 	}
 
 	if cfg.GroupsAllowedToEditJiraSubscriptions != "" {
-		ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-		if err != nil {
-			return errors.Wrap(err, "could not load jira instance")
-		}
-
 		jiraUser, err := p.userStore.LoadJIRAUser(ji, userId)
 		if err != nil {
 			return errors.Wrap(err, "could not load jira user")
@@ -700,17 +795,17 @@ func httpSubscribeWebhook(p *Plugin, w http.ResponseWriter, r *http.Request) (st
 		return respondErr(w, http.StatusInternalServerError, err)
 	}
 
-	// If there is space in the queue, immediately return a 200; we will process the webhook event async.
-	// If the queue is full, return a 503; we will not process that webhook event.
-	select {
-	case p.webhookQueue <- bb:
-		return http.StatusOK, nil
-	default:
+	// The webhook event is persisted and processed async, so return a 200
+	// right away. If it can neither be persisted nor queued, return a 503; we
+	// will not process that webhook event.
+	if !p.enqueueWebhookMessage(newWebhookMessage(r.FormValue(argInstanceURL), bb)) {
 		return respondErr(w, http.StatusServiceUnavailable, nil)
 	}
+	return http.StatusOK, nil
 }
 
-func httpChannelCreateSubscription(p *Plugin, w http.ResponseWriter, r *http.Request, mattermostUserId string) (int, error) {
+func httpChannelCreateSubscription(ji Instance, w http.ResponseWriter, r *http.Request, mattermostUserId string) (int, error) {
+	p := ji.GetPlugin()
 	subscription := ChannelSubscription{}
 	err := json.NewDecoder(r.Body).Decode(&subscription)
 	if err != nil {
@@ -730,18 +825,13 @@ func httpChannelCreateSubscription(p *Plugin, w http.ResponseWriter, r *http.Req
 			errors.New("Not a member of the channel specified"))
 	}
 
-	err = p.hasPermissionToManageSubscription(mattermostUserId, subscription.ChannelId)
+	err = p.hasPermissionToManageSubscription(ji, mattermostUserId, subscription.ChannelId)
 	if err != nil {
 		return respondErr(w, http.StatusForbidden,
 			errors.Wrap(err, "you don't have permission to manage subscriptions"))
 	}
 
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		return respondErr(w, http.StatusInternalServerError, err)
-	}
-
-	jiraUser, err := ji.GetPlugin().userStore.LoadJIRAUser(ji, mattermostUserId)
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, mattermostUserId)
 	if err != nil {
 		return respondErr(w, http.StatusInternalServerError, err)
 	}
@@ -751,7 +841,7 @@ func httpChannelCreateSubscription(p *Plugin, w http.ResponseWriter, r *http.Req
 		return respondErr(w, http.StatusInternalServerError, err)
 	}
 
-	err = p.addChannelSubscription(&subscription, client)
+	err = p.addChannelSubscription(ji, &subscription, client)
 	if err != nil {
 		return respondErr(w, http.StatusInternalServerError, err)
 	}
@@ -769,7 +859,8 @@ func httpChannelCreateSubscription(p *Plugin, w http.ResponseWriter, r *http.Req
 	return http.StatusOK, nil
 }
 
-func httpChannelEditSubscription(p *Plugin, w http.ResponseWriter, r *http.Request, mattermostUserId string) (int, error) {
+func httpChannelEditSubscription(ji Instance, w http.ResponseWriter, r *http.Request, mattermostUserId string) (int, error) {
+	p := ji.GetPlugin()
 	subscription := ChannelSubscription{}
 	err := json.NewDecoder(r.Body).Decode(&subscription)
 	if err != nil {
@@ -783,7 +874,7 @@ func httpChannelEditSubscription(p *Plugin, w http.ResponseWriter, r *http.Reque
 			fmt.Errorf("Channel subscription invalid"))
 	}
 
-	err = p.hasPermissionToManageSubscription(mattermostUserId, subscription.ChannelId)
+	err = p.hasPermissionToManageSubscription(ji, mattermostUserId, subscription.ChannelId)
 	if err != nil {
 		return respondErr(w, http.StatusForbidden,
 			errors.Wrap(err, "you don't have permission to manage subscriptions"))
@@ -795,12 +886,7 @@ func httpChannelEditSubscription(p *Plugin, w http.ResponseWriter, r *http.Reque
 			errors.New("Not a member of the channel specified"))
 	}
 
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		return respondErr(w, http.StatusInternalServerError, err)
-	}
-
-	jiraUser, err := ji.GetPlugin().userStore.LoadJIRAUser(ji, mattermostUserId)
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, mattermostUserId)
 	if err != nil {
 		return respondErr(w, http.StatusInternalServerError, err)
 	}
@@ -810,7 +896,7 @@ func httpChannelEditSubscription(p *Plugin, w http.ResponseWriter, r *http.Reque
 		return respondErr(w, http.StatusInternalServerError, err)
 	}
 
-	err = p.editChannelSubscription(&subscription, client)
+	err = p.editChannelSubscription(ji, &subscription, client)
 	if err != nil {
 		return respondErr(w, http.StatusInternalServerError, err)
 	}
@@ -828,20 +914,21 @@ func httpChannelEditSubscription(p *Plugin, w http.ResponseWriter, r *http.Reque
 	return http.StatusOK, nil
 }
 
-func httpChannelDeleteSubscription(p *Plugin, w http.ResponseWriter, r *http.Request, mattermostUserId string) (int, error) {
+func httpChannelDeleteSubscription(ji Instance, w http.ResponseWriter, r *http.Request, mattermostUserId string) (int, error) {
+	p := ji.GetPlugin()
 	subscriptionId := strings.TrimPrefix(r.URL.Path, routeAPISubscriptionsChannel+"/")
 	if len(subscriptionId) != 26 {
 		return respondErr(w, http.StatusBadRequest,
 			errors.New("bad subscription id"))
 	}
 
-	subscription, err := p.getChannelSubscription(subscriptionId)
+	subscription, err := p.getChannelSubscription(ji, subscriptionId)
 	if err != nil {
 		return respondErr(w, http.StatusBadRequest,
 			errors.Wrap(err, "bad subscription id"))
 	}
 
-	err = p.hasPermissionToManageSubscription(mattermostUserId, subscription.ChannelId)
+	err = p.hasPermissionToManageSubscription(ji, mattermostUserId, subscription.ChannelId)
 	if err != nil {
 		return respondErr(w, http.StatusForbidden,
 			errors.Wrap(err, "you don't have permission to manage subscriptions"))
@@ -853,7 +940,7 @@ func httpChannelDeleteSubscription(p *Plugin, w http.ResponseWriter, r *http.Req
 			errors.New("Not a member of the channel specified"))
 	}
 
-	err = p.removeChannelSubscription(subscriptionId)
+	err = p.removeChannelSubscription(ji, subscriptionId)
 	if err != nil {
 		return respondErr(w, http.StatusInternalServerError,
 			errors.Wrap(err, "unable to remove channel subscription"))
@@ -864,11 +951,7 @@ func httpChannelDeleteSubscription(p *Plugin, w http.ResponseWriter, r *http.Req
 		return code, err
 	}
 
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		return http.StatusInternalServerError, err
-	}
-	jiraUser, err := ji.GetPlugin().userStore.LoadJIRAUser(ji, mattermostUserId)
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, mattermostUserId)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
@@ -880,7 +963,8 @@ func httpChannelDeleteSubscription(p *Plugin, w http.ResponseWriter, r *http.Req
 	return http.StatusOK, nil
 }
 
-func httpChannelGetSubscriptions(p *Plugin, w http.ResponseWriter, r *http.Request, mattermostUserId string) (int, error) {
+func httpChannelGetSubscriptions(ji Instance, w http.ResponseWriter, r *http.Request, mattermostUserId string) (int, error) {
+	p := ji.GetPlugin()
 	channelId := strings.TrimPrefix(r.URL.Path, routeAPISubscriptionsChannel+"/")
 	if len(channelId) != 26 {
 		return respondErr(w, http.StatusBadRequest,
@@ -892,12 +976,12 @@ func httpChannelGetSubscriptions(p *Plugin, w http.ResponseWriter, r *http.Reque
 			errors.New("Not a member of the channel specified"))
 	}
 
-	if err := p.hasPermissionToManageSubscription(mattermostUserId, channelId); err != nil {
+	if err := p.hasPermissionToManageSubscription(ji, mattermostUserId, channelId); err != nil {
 		return respondErr(w, http.StatusForbidden,
 			errors.Wrap(err, "you don't have permission to manage subscriptions"))
 	}
 
-	subscriptions, err := p.getSubscriptionsForChannel(channelId)
+	subscriptions, err := p.getSubscriptionsForChannel(ji, channelId)
 	if err != nil {
 		return respondErr(w, http.StatusInternalServerError,
 			errors.Wrap(err, "unable to get channel subscriptions"))
@@ -912,15 +996,20 @@ func httpChannelSubscriptions(p *Plugin, w http.ResponseWriter, r *http.Request)
 		return respondErr(w, http.StatusUnauthorized, errors.New("not authorized"))
 	}
 
+	ji, err := p.loadUserInstance(mattermostUserId, r.URL.Query().Get(argInstanceURL))
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
 	switch r.Method {
 	case http.MethodPost:
-		return httpChannelCreateSubscription(p, w, r, mattermostUserId)
+		return httpChannelCreateSubscription(ji, w, r, mattermostUserId)
 	case http.MethodDelete:
-		return httpChannelDeleteSubscription(p, w, r, mattermostUserId)
+		return httpChannelDeleteSubscription(ji, w, r, mattermostUserId)
 	case http.MethodGet:
-		return httpChannelGetSubscriptions(p, w, r, mattermostUserId)
+		return httpChannelGetSubscriptions(ji, w, r, mattermostUserId)
 	case http.MethodPut:
-		return httpChannelEditSubscription(p, w, r, mattermostUserId)
+		return httpChannelEditSubscription(ji, w, r, mattermostUserId)
 	default:
 		return respondErr(w, http.StatusMethodNotAllowed, fmt.Errorf("Request: "+r.Method+" is not allowed."))
 	}
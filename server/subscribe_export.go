@@ -0,0 +1,536 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	exportFormatJSON = "json"
+	exportFormatYAML = "yaml"
+)
+
+// SubscriptionsExport is the file the channel subscriptions of an instance
+// are exported to, and imported from. The channels are referenced by name, so
+// that it can be imported on another Mattermost server.
+type SubscriptionsExport struct {
+	PluginVersion string                 `json:"plugin_version"`
+	InstanceURL   string                 `json:"instance_url"`
+	ExportedAt    time.Time              `json:"exported_at"`
+	Subscriptions []ExportedSubscription `json:"subscriptions"`
+}
+
+type ExportedSubscription struct {
+	Team      string                 `json:"team"`
+	Channel   string                 `json:"channel"`
+	Name      string                 `json:"name"`
+	Filters   SubscriptionFilters    `json:"filters"`
+	Digest    *SubscriptionDigest    `json:"digest,omitempty"`
+	Templates NotificationTemplates  `json:"templates,omitempty"`
+	Actions   StringSet              `json:"actions,omitempty"`
+	RateLimit *SubscriptionRateLimit `json:"rate_limit,omitempty"`
+}
+
+func (e ExportedSubscription) String() string {
+	return fmt.Sprintf("~%s (%s): %q", e.Channel, e.Team, e.Name)
+}
+
+func (e ExportedSubscription) channelSubscription(channelId string) *ChannelSubscription {
+	return &ChannelSubscription{
+		ChannelId: channelId,
+		Name:      e.Name,
+		Filters:   e.Filters,
+		Digest:    e.Digest,
+		Templates: e.Templates,
+		Actions:   e.Actions,
+		RateLimit: e.RateLimit,
+	}
+}
+
+// exportSubscriptions returns the channel subscriptions of ji, sorted by
+// team, channel and name. The subscriptions of the channels that no longer
+// exist are left out.
+func (p *Plugin) exportSubscriptions(ji Instance) (*SubscriptionsExport, error) {
+	subs, err := p.getSubscriptions(ji)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load the subscriptions")
+	}
+
+	teamNames := map[string]string{}
+	export := &SubscriptionsExport{
+		PluginVersion: subs.PluginVersion,
+		InstanceURL:   ji.GetURL(),
+		ExportedAt:    time.Now().UTC(),
+		Subscriptions: []ExportedSubscription{},
+	}
+	for channelId, ids := range subs.Channel.IdByChannelId {
+		if ids.Len() == 0 {
+			continue
+		}
+		channel, appErr := p.API.GetChannel(channelId)
+		if appErr != nil {
+			p.errorf("exportSubscriptions: skipping the subscriptions of channel %s: %v", channelId, appErr)
+			continue
+		}
+		teamName, ok := teamNames[channel.TeamId]
+		if !ok {
+			team, appErr := p.API.GetTeam(channel.TeamId)
+			if appErr != nil {
+				p.errorf("exportSubscriptions: skipping the subscriptions of channel %s: %v", channelId, appErr)
+				continue
+			}
+			teamName = team.Name
+			teamNames[channel.TeamId] = teamName
+		}
+
+		for _, id := range ids.Elems() {
+			sub, ok := subs.Channel.ById[id]
+			if !ok {
+				continue
+			}
+			export.Subscriptions = append(export.Subscriptions, ExportedSubscription{
+				Team:      teamName,
+				Channel:   channel.Name,
+				Name:      sub.Name,
+				Filters:   sub.Filters,
+				Digest:    sub.Digest,
+				Templates: sub.Templates,
+				Actions:   sub.Actions,
+				RateLimit: sub.RateLimit,
+			})
+		}
+	}
+
+	sort.Slice(export.Subscriptions, func(i, j int) bool {
+		a, b := export.Subscriptions[i], export.Subscriptions[j]
+		if a.Team != b.Team {
+			return a.Team < b.Team
+		}
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		return a.Name < b.Name
+	})
+	return export, nil
+}
+
+// marshalSubscriptionsExport encodes export in format, JSON or YAML. The YAML
+// uses the same keys as the JSON.
+func marshalSubscriptionsExport(export *SubscriptionsExport, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case exportFormatJSON, "":
+		return data, nil
+	case exportFormatYAML:
+		var v interface{}
+		err = json.Unmarshal(data, &v)
+		if err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(v)
+	default:
+		return nil, errors.Errorf("unknown format %q, must be %s or %s", format, exportFormatJSON, exportFormatYAML)
+	}
+}
+
+// parseSubscriptionsExport decodes an export, in JSON or YAML.
+func parseSubscriptionsExport(data []byte) (*SubscriptionsExport, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("the subscriptions file is empty")
+	}
+	if data[0] != '{' {
+		var v interface{}
+		err := yaml.Unmarshal(data, &v)
+		if err != nil {
+			return nil, errors.WithMessage(err, "the subscriptions file is neither JSON nor YAML")
+		}
+		data, err = json.Marshal(yamlToJSON(v))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	export := &SubscriptionsExport{}
+	err := json.Unmarshal(data, export)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse the subscriptions file")
+	}
+	return export, nil
+}
+
+// yamlToJSON converts the maps decoded from YAML, which may have non-string
+// keys, to maps that can be encoded as JSON.
+func yamlToJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, value := range v {
+			m[fmt.Sprint(key)] = yamlToJSON(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = yamlToJSON(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// SubscriptionsImportResult reports what happened to each subscription of an
+// import.
+type SubscriptionsImportResult struct {
+	Imported []string `json:"imported"`
+
+	// Subscriptions with the same name as an existing subscription of the
+	// channel are not imported.
+	Conflicts []string `json:"conflicts"`
+	Errors    []string `json:"errors"`
+}
+
+func (r SubscriptionsImportResult) String() string {
+	text := fmt.Sprintf("Imported %d subscription(s), %d conflict(s), %d error(s).",
+		len(r.Imported), len(r.Conflicts), len(r.Errors))
+	for _, list := range []struct {
+		title string
+		items []string
+	}{
+		{"Imported", r.Imported},
+		{"Conflicts", r.Conflicts},
+		{"Errors", r.Errors},
+	} {
+		if len(list.items) == 0 {
+			continue
+		}
+		text += fmt.Sprintf("\n###### %s:\n", list.title)
+		for _, item := range list.items {
+			text += fmt.Sprintf("* %s\n", item)
+		}
+	}
+	return text
+}
+
+// importSubscriptions adds the subscriptions of export to ji. Each one is
+// validated by addChannelSubscription, using client to check its project.
+func (p *Plugin) importSubscriptions(ji Instance, export *SubscriptionsExport, client Client) *SubscriptionsImportResult {
+	result := &SubscriptionsImportResult{
+		Imported:  []string{},
+		Conflicts: []string{},
+		Errors:    []string{},
+	}
+	for _, e := range export.Subscriptions {
+		channel, appErr := p.API.GetChannelByNameForTeamName(e.Team, e.Channel, false)
+		if appErr != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: channel not found", e))
+			continue
+		}
+
+		existing, err := p.getSubscriptionsForChannel(ji, channel.Id)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", e, err))
+			continue
+		}
+		conflict := ""
+		for _, sub := range existing {
+			if sub.Name != e.Name {
+				continue
+			}
+			conflict = fmt.Sprintf("%s: a different subscription with this name already exists", e)
+			if sameSubscription(e.channelSubscription(channel.Id), &sub) {
+				conflict = fmt.Sprintf("%s: already exists", e)
+			}
+		}
+		if conflict != "" {
+			result.Conflicts = append(result.Conflicts, conflict)
+			continue
+		}
+
+		sub := e.channelSubscription(channel.Id)
+		err = p.addChannelSubscription(ji, sub, client)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", e, errors.Cause(err)))
+			continue
+		}
+		result.Imported = append(result.Imported, e.String())
+	}
+	return result
+}
+
+// sameSubscription returns whether a and b are the same but for their Id.
+func sameSubscription(a, b *ChannelSubscription) bool {
+	aa, bb := *a, *b
+	aa.Id, bb.Id = "", ""
+	aData, err := json.Marshal(&aa)
+	if err != nil {
+		return false
+	}
+	bData, err := json.Marshal(&bb)
+	if err != nil {
+		return false
+	}
+	var aValue, bValue interface{}
+	if json.Unmarshal(aData, &aValue) != nil || json.Unmarshal(bData, &bValue) != nil {
+		return false
+	}
+	return fmt.Sprint(normalizeJSONSets(aValue)) == fmt.Sprint(normalizeJSONSets(bValue))
+}
+
+// normalizeJSONSets sorts the arrays of strings, the StringSets are encoded
+// in no particular order.
+func normalizeJSONSets(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalizeJSONSets(value)
+		}
+		return v
+	case []interface{}:
+		strs := []string{}
+		for i, value := range v {
+			v[i] = normalizeJSONSets(value)
+			if s, ok := value.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		if len(strs) == len(v) {
+			sort.Strings(strs)
+			return strs
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func executeSubscribeExport(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	authorized, err := authorizedSysAdmin(p, header.UserId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if !authorized {
+		return p.responsef(header, "`/jira subscribe export` can only be run by a system administrator.")
+	}
+
+	format := exportFormatJSON
+	if len(args) > 0 && (args[0] == exportFormatJSON || args[0] == exportFormatYAML) {
+		format = args[0]
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		return p.help(header)
+	}
+	instanceURL, err := instanceURLArg(p, header, args)
+	if err != nil {
+		return p.responsef(header, err.Error())
+	}
+	ji, err := p.loadInstance(instanceURL)
+	if err != nil {
+		return p.responsef(header, "Failed to load Jira instance: %v", err)
+	}
+
+	export, err := p.exportSubscriptions(ji)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	data, err := marshalSubscriptionsExport(export, format)
+	if err != nil {
+		return p.responsef(header, "Failed to export the subscriptions: %v", err)
+	}
+
+	// Files are not attached to ephemeral posts, the export is sent by DM.
+	channel, appErr := p.API.GetDirectChannel(header.UserId, p.getUserID())
+	if appErr != nil {
+		return p.responsef(header, "Failed to send the export: %v", appErr)
+	}
+	filename := fmt.Sprintf("jira-subscriptions-%s.%s", export.ExportedAt.Format("2006-01-02"), format)
+	fileInfo, appErr := p.API.UploadFile(data, channel.Id, filename)
+	if appErr != nil {
+		return p.responsef(header, "Failed to upload the export: %v", appErr)
+	}
+	_, appErr = p.API.CreatePost(&model.Post{
+		UserId:    p.getUserID(),
+		ChannelId: channel.Id,
+		Message:   fmt.Sprintf("The %d channel subscriptions of %s.", len(export.Subscriptions), ji.GetURL()),
+		FileIds:   []string{fileInfo.Id},
+	})
+	if appErr != nil {
+		return p.responsef(header, "Failed to send the export: %v", appErr)
+	}
+
+	return p.responsef(header, "Exported %d subscription(s). The file was sent to you by direct message. "+
+		"It can be imported with `/jira subscribe import <link to the message>`.", len(export.Subscriptions))
+}
+
+func executeSubscribeImport(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	authorized, err := authorizedSysAdmin(p, header.UserId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if !authorized {
+		return p.responsef(header, "`/jira subscribe import` can only be run by a system administrator.")
+	}
+	if len(args) == 0 {
+		return p.help(header)
+	}
+
+	// The subscriptions are either pasted in the command, or attached to a
+	// post it links to.
+	var data []byte
+	if m := rePermalinkPostId.FindStringSubmatch(args[0]); len(args) == 1 && m != nil {
+		data, err = p.subscriptionsFileOfPost(m[1])
+		if err != nil {
+			return p.responsef(header, "%v", err)
+		}
+	} else {
+		_, text := splitInstanceOption(header.Command)
+		text = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), "/jira"))
+		text = strings.TrimSpace(strings.TrimPrefix(text, "subscribe"))
+		text = strings.TrimPrefix(text, "import")
+		data = []byte(strings.Trim(strings.TrimSpace(text), "`"))
+	}
+
+	export, err := parseSubscriptionsExport(data)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+
+	ji, err := p.loadImportInstance(export, "")
+	if err != nil {
+		return p.responsef(header, "Failed to load Jira instance: %v", err)
+	}
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, header.UserId)
+	if err != nil {
+		return p.responsef(header, "Your username is not connected to Jira. Please type `jira connect`. %v", err)
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+
+	return p.responsef(header, "%s", p.importSubscriptions(ji, export, client).String())
+}
+
+// loadImportInstance returns the Jira instance the subscriptions of export
+// are imported to: the one they were exported from. instanceURL, if not
+// empty, must be that instance.
+func (p *Plugin) loadImportInstance(export *SubscriptionsExport, instanceURL string) (Instance, error) {
+	if export.InstanceURL != "" {
+		if instanceURL != "" && instanceURL != export.InstanceURL {
+			return nil, errors.Errorf("the subscriptions were exported from %s, not %s", export.InstanceURL, instanceURL)
+		}
+		instanceURL = export.InstanceURL
+	}
+	return p.loadInstance(instanceURL)
+}
+
+// subscriptionsFileOfPost returns the content of the file attached to postId.
+func (p *Plugin) subscriptionsFileOfPost(postId string) ([]byte, error) {
+	post, appErr := p.API.GetPost(postId)
+	if appErr != nil {
+		return nil, errors.WithMessage(appErr, "failed to load the post")
+	}
+	if len(post.FileIds) != 1 {
+		return nil, errors.New("the post must have the subscriptions file attached, and no other file")
+	}
+	data, appErr := p.API.GetFile(post.FileIds[0])
+	if appErr != nil {
+		return nil, errors.WithMessage(appErr, "failed to load the subscriptions file")
+	}
+	return data, nil
+}
+
+func httpAPISubscriptionsExport(p *Plugin, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return respondErr(w, http.StatusMethodNotAllowed,
+			errors.New("method "+r.Method+" is not allowed, must be GET"))
+	}
+	isAdmin, err := authorizedSysAdmin(p, r.Header.Get("Mattermost-User-Id"))
+	if err != nil || !isAdmin {
+		return respondErr(w, http.StatusForbidden,
+			errors.New("Access forbidden: must be authenticated as an admin."))
+	}
+
+	ji, err := p.loadInstance(r.URL.Query().Get(argInstanceURL))
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	export, err := p.exportSubscriptions(ji)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	format := r.URL.Query().Get("format")
+	data, err := marshalSubscriptionsExport(export, format)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest, err)
+	}
+
+	if format == exportFormatYAML {
+		w.Header().Set("Content-Type", "application/x-yaml")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return http.StatusInternalServerError, errors.WithMessage(err, "failed to write response")
+	}
+	return http.StatusOK, nil
+}
+
+func httpAPISubscriptionsImport(p *Plugin, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodPost {
+		return respondErr(w, http.StatusMethodNotAllowed,
+			errors.New("method "+r.Method+" is not allowed, must be POST"))
+	}
+	mattermostUserId := r.Header.Get("Mattermost-User-Id")
+	isAdmin, err := authorizedSysAdmin(p, mattermostUserId)
+	if err != nil || !isAdmin {
+		return respondErr(w, http.StatusForbidden,
+			errors.New("Access forbidden: must be authenticated as an admin."))
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.WithMessage(err, "failed to read the request"))
+	}
+	export, err := parseSubscriptionsExport(data)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest, err)
+	}
+
+	ji, err := p.loadImportInstance(export, r.URL.Query().Get(argInstanceURL))
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest, err)
+	}
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, mattermostUserId)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.WithMessage(err, "the admin must be connected to Jira to check the projects"))
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	return respondJSON(w, p.importSubscriptions(ji, export, client))
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+func TestSubscriptionsExportImport(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	p.userStore = mockUserStore{}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	kv := map[string][]byte{}
+	api.On("KVGet", mock.Anything).Return(func(key string) []byte {
+		return kv[key]
+	}, nil)
+	api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		kv[args.String(0)] = args.Get(2).([]byte)
+	}).Return(true, nil)
+
+	channelId := model.NewId()
+	api.On("GetChannel", channelId).Return(&model.Channel{Id: channelId, TeamId: "teamId", Name: "town-square"}, nil)
+	api.On("GetTeam", "teamId").Return(&model.Team{Id: "teamId", Name: "ops"}, nil)
+	api.On("GetChannelByNameForTeamName", "ops", "town-square", false).Return(&model.Channel{Id: channelId}, nil)
+	api.On("GetChannelByNameForTeamName", "ops", "deleted", false).Return(nil, &model.AppError{Message: "not found"})
+
+	bugs := &ChannelSubscription{
+		ChannelId: channelId,
+		Name:      "Bugs",
+		Filters: SubscriptionFilters{
+			Events: NewStringSet("event_created", "event_updated_status"),
+			JQL:    "project = PROJ AND issuetype = Bug",
+		},
+		Actions:   NewStringSet("assign", "comment"),
+		RateLimit: &SubscriptionRateLimit{Posts: 10},
+	}
+	require.Nil(t, p.addChannelSubscription(ji, bugs, testClient{}))
+
+	export, err := p.exportSubscriptions(ji)
+	require.Nil(t, err)
+	require.Len(t, export.Subscriptions, 1)
+	assert.Equal(t, mockCurrentInstanceURL, export.InstanceURL)
+	assert.Equal(t, "ops", export.Subscriptions[0].Team)
+	assert.Equal(t, "town-square", export.Subscriptions[0].Channel)
+	assert.Equal(t, "Bugs", export.Subscriptions[0].Name)
+
+	for _, format := range []string{exportFormatJSON, exportFormatYAML} {
+		data, err := marshalSubscriptionsExport(export, format)
+		require.Nil(t, err)
+		parsed, err := parseSubscriptionsExport(data)
+		require.Nil(t, err, format)
+		assert.Equal(t, export.Subscriptions, parsed.Subscriptions, format)
+	}
+	_, err = marshalSubscriptionsExport(export, "xml")
+	assert.NotNil(t, err)
+
+	stories := export.Subscriptions[0]
+	stories.Name = "Stories"
+	stories.Filters.JQL = "project = PROJ AND issuetype = Story"
+	changed := export.Subscriptions[0]
+	changed.Filters.Events = NewStringSet("event_created")
+	missing := export.Subscriptions[0]
+	missing.Channel = "deleted"
+	invalid := stories
+	invalid.Name = "No events"
+	invalid.Filters.Events = nil
+	export.Subscriptions = append(export.Subscriptions, stories, changed, missing, invalid)
+
+	data, err := json.Marshal(export)
+	require.Nil(t, err)
+	parsed, err := parseSubscriptionsExport(data)
+	require.Nil(t, err)
+	result := p.importSubscriptions(ji, parsed, testClient{})
+	assert.Equal(t, []string{`~town-square (ops): "Stories"`}, result.Imported)
+	assert.Equal(t, []string{
+		`~town-square (ops): "Bugs": already exists`,
+		`~town-square (ops): "Bugs": a different subscription with this name already exists`,
+	}, result.Conflicts)
+	assert.Equal(t, []string{
+		`~deleted (ops): "Bugs": channel not found`,
+		`~town-square (ops): "No events": Please provide at least one event type.`,
+	}, result.Errors)
+
+	subs, err := p.getSubscriptionsForChannel(ji, channelId)
+	require.Nil(t, err)
+	assert.Len(t, subs, 2)
+}
+
+func TestLoadImportInstance(t *testing.T) {
+	p := &Plugin{}
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	p.instanceStore = mockInstanceStore{plugin: p}
+
+	for name, tc := range map[string]struct {
+		exportedFrom string
+		instanceURL  string
+		expectedURL  string
+		expectedErr  bool
+	}{
+		"default instance": {
+			exportedFrom: mockCurrentInstanceURL,
+			expectedURL:  mockCurrentInstanceURL,
+		},
+		"other instance": {
+			exportedFrom: otherInstanceURL,
+			expectedURL:  otherInstanceURL,
+		},
+		"explicit instance": {
+			exportedFrom: otherInstanceURL,
+			instanceURL:  otherInstanceURL,
+			expectedURL:  otherInstanceURL,
+		},
+		"explicit instance of an older export": {
+			instanceURL: otherInstanceURL,
+			expectedURL: otherInstanceURL,
+		},
+		"another instance than exported from": {
+			exportedFrom: mockCurrentInstanceURL,
+			instanceURL:  otherInstanceURL,
+			expectedErr:  true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ji, err := p.loadImportInstance(&SubscriptionsExport{InstanceURL: tc.exportedFrom}, tc.instanceURL)
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.expectedURL, ji.GetURL())
+		})
+	}
+}
@@ -0,0 +1,395 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	DefaultSubscriptionPreviewDays = 7
+	MaxSubscriptionPreviewDays     = 30
+
+	// The issues past this count, of the most recently updated ones, are
+	// not checked.
+	SubscriptionPreviewMaxIssues = 50
+
+	// The events past this count are not listed by the command.
+	SubscriptionPreviewMaxListed = 25
+
+	// The format of the times of the Jira REST API.
+	jiraTimeLayout = "2006-01-02T15:04:05.000-0700"
+)
+
+// SubscriptionPreview is what a subscription would have posted for the
+// recent events of the issues.
+type SubscriptionPreview struct {
+	Days   int                        `json:"days"`
+	Issues int                        `json:"issues"`
+	Events []SubscriptionPreviewEvent `json:"events"`
+
+	// The post of the most recent matching event, if any.
+	Post *model.Post `json:"post,omitempty"`
+}
+
+type SubscriptionPreviewEvent struct {
+	IssueKey string    `json:"issue_key"`
+	Events   []string  `json:"events"`
+	Time     time.Time `json:"time"`
+	Matched  bool      `json:"matched"`
+
+	// Why the subscription rejected the event.
+	Reason string `json:"reason,omitempty"`
+}
+
+func (p *SubscriptionPreview) matched() int {
+	n := 0
+	for _, e := range p.Events {
+		if e.Matched {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *SubscriptionPreview) String() string {
+	text := fmt.Sprintf("Of the %d event(s) of the %d issue(s) updated in the last %d days, %d would have been posted. "+
+		"The filters are checked against the current fields of the issues.\n",
+		len(p.Events), p.Issues, p.Days, p.matched())
+	for i, e := range p.Events {
+		if i == SubscriptionPreviewMaxListed {
+			text += fmt.Sprintf("* _%d more event(s) are not shown._\n", len(p.Events)-i)
+			break
+		}
+		result := "posted"
+		if !e.Matched {
+			result = "rejected: " + e.Reason
+		}
+		text += fmt.Sprintf("* %s %s `%s` - %s\n",
+			e.Time.UTC().Format("2006-01-02 15:04"), e.IssueKey, strings.Join(e.Events, "`, `"), result)
+	}
+	return text
+}
+
+// previewSubscription runs the filters of sub against the events of the
+// issues updated in the last days, searched with client.
+func (p *Plugin) previewSubscription(ji Instance, client Client, sub *ChannelSubscription, days int, now time.Time) (*SubscriptionPreview, error) {
+	clauses := []string{}
+	if sub.Filters.Projects.Len() > 0 {
+		projects := sub.Filters.Projects.Elems()
+		sort.Strings(projects)
+		for i := range projects {
+			projects[i] = strconv.Quote(projects[i])
+		}
+		clauses = append(clauses, fmt.Sprintf("project in (%s)", strings.Join(projects, ", ")))
+	}
+	// The JQL of the subscription narrows the search, so that the issues it
+	// doesn't match don't take the place of those it does.
+	if sub.Filters.JQL != "" {
+		q, err := p.jqlCache.get(sub.Filters.JQL)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid JQL")
+		}
+		clauses = append(clauses, "("+q.Condition()+")")
+	}
+	clauses = append(clauses, fmt.Sprintf("updated >= -%dd", days))
+	jql := strings.Join(clauses, " AND ") + " ORDER BY updated DESC"
+	issues, err := client.SearchIssues(jql, &jira.SearchOptions{
+		MaxResults: SubscriptionPreviewMaxIssues,
+		Expand:     "changelog",
+		Fields:     []string{"*all"},
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to search the recent issues")
+	}
+
+	preview := &SubscriptionPreview{
+		Days:   days,
+		Issues: len(issues),
+		Events: []SubscriptionPreviewEvent{},
+	}
+	since := now.Add(-time.Duration(days) * 24 * time.Hour)
+	var latest *webhook
+	var latestTime time.Time
+	for i := range issues {
+		for _, event := range previewWebhooks(&issues[i], since) {
+			events := event.wh.Events().Elems()
+			sort.Strings(events)
+			reason := p.subscriptionFiltersMismatch(event.wh, sub.Filters)
+			preview.Events = append(preview.Events, SubscriptionPreviewEvent{
+				IssueKey: issues[i].Key,
+				Events:   events,
+				Time:     event.time,
+				Matched:  reason == "",
+				Reason:   reason,
+			})
+			if reason == "" && (latest == nil || event.time.After(latestTime)) {
+				latest, latestTime = event.wh, event.time
+			}
+		}
+	}
+	sort.SliceStable(preview.Events, func(i, j int) bool {
+		return preview.Events[i].Time.After(preview.Events[j].Time)
+	})
+
+	if latest != nil {
+		preview.Post, err = latest.newPost(p, ji, sub.ChannelId, p.getUserID(), sub)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to render the post")
+		}
+	}
+	return preview, nil
+}
+
+type previewWebhook struct {
+	wh   *webhook
+	time time.Time
+}
+
+// previewWebhooks returns the webhooks Jira would have sent for issue since
+// a time: for its creation, the changes of its changelog, and its comments.
+func previewWebhooks(issue *jira.Issue, since time.Time) []previewWebhook {
+	if issue.Fields == nil {
+		return nil
+	}
+	var webhooks []previewWebhook
+	add := func(wh Webhook, t time.Time) {
+		if w, ok := wh.(*webhook); ok && w != nil {
+			webhooks = append(webhooks, previewWebhook{wh: w, time: t})
+		}
+	}
+
+	created := time.Time(issue.Fields.Created)
+	if !created.Before(since) {
+		add(parseWebhookCreated(&JiraWebhook{
+			WebhookEvent: "jira:issue_created",
+			Issue:        *issue,
+			User:         reporterOrEmpty(issue),
+		}), created)
+	}
+
+	if issue.Changelog != nil {
+		for _, history := range issue.Changelog.Histories {
+			t, err := time.Parse(jiraTimeLayout, history.Created)
+			if err != nil || t.Before(since) {
+				continue
+			}
+			jwh := &JiraWebhook{
+				WebhookEvent:       "jira:issue_updated",
+				IssueEventTypeName: "issue_updated",
+				Issue:              *issue,
+				User:               history.Author,
+			}
+			if !setWebhookChangeLog(jwh, history.Items) {
+				continue
+			}
+			add(parseWebhookChangeLog(jwh), t)
+		}
+	}
+
+	if issue.Fields.Comments != nil {
+		for _, comment := range issue.Fields.Comments.Comments {
+			t, err := time.Parse(jiraTimeLayout, comment.Created)
+			if err != nil || t.Before(since) {
+				continue
+			}
+			wh, err := parseWebhookCommentCreated(&JiraWebhook{
+				WebhookEvent: "comment_created",
+				Issue:        *issue,
+				Comment:      *comment,
+			})
+			if err == nil {
+				add(wh, t)
+			}
+		}
+	}
+	return webhooks
+}
+
+func reporterOrEmpty(issue *jira.Issue) jira.User {
+	if issue.Fields.Reporter != nil {
+		return *issue.Fields.Reporter
+	}
+	return jira.User{}
+}
+
+// setWebhookChangeLog sets the changelog of jwh to the items of a changelog
+// history.
+func setWebhookChangeLog(jwh *JiraWebhook, items []jira.ChangelogItems) bool {
+	converted := []map[string]string{}
+	for _, item := range items {
+		converted = append(converted, map[string]string{
+			"field":      item.Field,
+			"fieldtype":  item.FieldType,
+			"from":       changelogValue(item.From),
+			"fromString": item.FromString,
+			"to":         changelogValue(item.To),
+			"toString":   item.ToString,
+		})
+	}
+	data, err := json.Marshal(map[string]interface{}{"items": converted})
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, &jwh.ChangeLog) == nil
+}
+
+func changelogValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// parseSubscribeTestArgs returns the subscription name, and the number of
+// days, of "/jira subscribe test <name> [--days <days>]". The days are an
+// option, as the subscription names may end with a number.
+func parseSubscribeTestArgs(args []string) (string, int, error) {
+	days := DefaultSubscriptionPreviewDays
+	nameArgs := []string{}
+	for i := 0; i < len(args); i++ {
+		if args[i] != "--days" {
+			nameArgs = append(nameArgs, args[i])
+			continue
+		}
+		if i+1 == len(args) {
+			return "", 0, errors.New("Please specify the number of days after --days.")
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return "", 0, errors.Errorf("The number of days must be a number, not %q.", args[i+1])
+		}
+		days = n
+		i++
+	}
+	if days < 1 || days > MaxSubscriptionPreviewDays {
+		return "", 0, errors.Errorf("The number of days must be between 1 and %d.", MaxSubscriptionPreviewDays)
+	}
+	if len(nameArgs) == 0 {
+		return "", 0, errors.New("Please specify the name of the subscription.")
+	}
+	return strings.Join(nameArgs, " "), days, nil
+}
+
+func executeSubscribeTest(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) == 0 {
+		return p.help(header)
+	}
+	name, days, err := parseSubscribeTestArgs(args)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+
+	ji, resp := p.loadCommandInstance(header)
+	if resp != nil {
+		return resp
+	}
+	if err = p.hasPermissionToManageSubscription(ji, header.UserId, header.ChannelId); err != nil {
+		return p.responsef(header, "You don't have permission to manage the subscriptions of this channel.")
+	}
+	subs, err := p.getSubscriptionsForChannel(ji, header.ChannelId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	var sub *ChannelSubscription
+	for i := range subs {
+		if subs[i].Name == name {
+			sub = &subs[i]
+		}
+	}
+	if sub == nil {
+		return p.responsef(header, "This channel has no subscription named %q.", name)
+	}
+
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, header.UserId)
+	if err != nil {
+		return p.responsef(header, "Your username is not connected to Jira. Please type `jira connect`. %v", err)
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	preview, err := p.previewSubscription(ji, client, sub, days, time.Now())
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+
+	p.responsef(header, "###### Subscription %q\n%s", sub.Name, preview.String())
+	if preview.Post != nil {
+		post := preview.Post
+		post.ChannelId = header.ChannelId
+		post.Message = "_Preview of the post of the most recent event:_\n" + post.Message
+		p.API.SendEphemeralPost(header.UserId, post)
+	}
+	return &model.CommandResponse{}
+}
+
+func httpAPISubscriptionPreview(p *Plugin, w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodPost {
+		return respondErr(w, http.StatusMethodNotAllowed,
+			errors.New("method "+r.Method+" is not allowed, must be POST"))
+	}
+	mattermostUserId := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserId == "" {
+		return respondErr(w, http.StatusUnauthorized, errors.New("not authorized"))
+	}
+
+	// The subscription being edited, saved or not.
+	req := struct {
+		Subscription ChannelSubscription `json:"subscription"`
+		Days         int                 `json:"days"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.WithMessage(err, "failed to decode incoming request"))
+	}
+	if req.Days == 0 {
+		req.Days = DefaultSubscriptionPreviewDays
+	}
+	if req.Days < 0 || req.Days > MaxSubscriptionPreviewDays {
+		return respondErr(w, http.StatusBadRequest,
+			errors.Errorf("days must be between 1 and %d", MaxSubscriptionPreviewDays))
+	}
+
+	ji, err := p.loadUserInstance(mattermostUserId, r.URL.Query().Get(argInstanceURL))
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	channelId := req.Subscription.ChannelId
+	if _, appErr := p.API.GetChannelMember(channelId, mattermostUserId); appErr != nil {
+		return respondErr(w, http.StatusForbidden,
+			errors.New("Not a member of the channel specified"))
+	}
+	if err = p.hasPermissionToManageSubscription(ji, mattermostUserId, channelId); err != nil {
+		return respondErr(w, http.StatusForbidden,
+			errors.Wrap(err, "you don't have permission to manage subscriptions"))
+	}
+
+	jiraUser, err := p.userStore.LoadJIRAUser(ji, mattermostUserId)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	client, err := ji.GetClient(jiraUser)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	preview, err := p.previewSubscription(ji, client, &req.Subscription, req.Days, time.Now())
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	return respondJSON(w, preview)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+type previewTestClient struct {
+	testClient
+	issues []jira.Issue
+	jql    string
+}
+
+func (client *previewTestClient) SearchIssues(jql string, options *jira.SearchOptions) ([]jira.Issue, error) {
+	client.jql = jql
+	return client.issues, nil
+}
+
+func TestPreviewSubscription(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	p.userStore = mockUserStore{}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+
+	now := time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC)
+	project := jira.Project{Key: "PROJ"}
+	client := &previewTestClient{issues: []jira.Issue{
+		{
+			ID:  "10100",
+			Key: "PROJ-1",
+			Fields: &jira.IssueFields{
+				Type:     jira.IssueType{ID: "10001", Name: "Bug"},
+				Project:  project,
+				Summary:  "Crash on start",
+				Created:  jira.Time(time.Date(2020, 1, 14, 9, 0, 0, 0, time.UTC)),
+				Reporter: &jira.User{Name: "reporter"},
+				Comments: &jira.Comments{Comments: []*jira.Comment{{
+					ID:           "1",
+					Body:         "Reproduced",
+					Created:      "2020-01-15T09:00:00.000+0000",
+					UpdateAuthor: jira.User{Name: "dev"},
+				}}},
+			},
+			Changelog: &jira.Changelog{Histories: []jira.ChangelogHistory{
+				{
+					Author:  jira.User{Name: "dev"},
+					Created: "2020-01-14T10:00:00.000+0000",
+					Items:   []jira.ChangelogItems{{Field: "status", FromString: "Open", ToString: "In Progress", From: "1", To: 3}},
+				},
+				{
+					Author:  jira.User{Name: "dev"},
+					Created: "2019-12-01T10:00:00.000+0000",
+					Items:   []jira.ChangelogItems{{Field: "priority", FromString: "Low", ToString: "High"}},
+				},
+			}},
+		},
+		{
+			ID:  "10101",
+			Key: "PROJ-2",
+			Fields: &jira.IssueFields{
+				Type:    jira.IssueType{ID: "10002", Name: "Story"},
+				Project: project,
+				Created: jira.Time(time.Date(2020, 1, 14, 11, 0, 0, 0, time.UTC)),
+			},
+		},
+	}}
+
+	sub := &ChannelSubscription{
+		ChannelId: model.NewId(),
+		Name:      "Bugs",
+		Filters: SubscriptionFilters{
+			Events:     NewStringSet(eventCreated, eventUpdatedStatus),
+			Projects:   NewStringSet("PROJ"),
+			IssueTypes: NewStringSet("10001"),
+		},
+	}
+	preview, err := p.previewSubscription(ji, client, sub, 7, now)
+	require.Nil(t, err)
+	assert.Equal(t, `project in ("PROJ") AND updated >= -7d ORDER BY updated DESC`, client.jql)
+	assert.Equal(t, 2, preview.Issues)
+	assert.Equal(t, []SubscriptionPreviewEvent{
+		{
+			IssueKey: "PROJ-1",
+			Events:   []string{eventCreatedComment},
+			Time:     time.Date(2020, 1, 15, 9, 0, 0, 0, time.UTC),
+			Reason:   "event event_created_comment is not subscribed to",
+		},
+		{
+			IssueKey: "PROJ-2",
+			Events:   []string{eventCreated},
+			Time:     time.Date(2020, 1, 14, 11, 0, 0, 0, time.UTC),
+			Reason:   "issue type Story is not subscribed to",
+		},
+		{
+			IssueKey: "PROJ-1",
+			Events:   []string{eventUpdatedStatus},
+			Time:     time.Date(2020, 1, 14, 10, 0, 0, 0, time.UTC),
+			Matched:  true,
+		},
+		{
+			IssueKey: "PROJ-1",
+			Events:   []string{eventCreated},
+			Time:     time.Date(2020, 1, 14, 9, 0, 0, 0, time.UTC),
+			Matched:  true,
+		},
+	}, normalizePreviewTimes(preview.Events))
+
+	require.NotNil(t, preview.Post)
+	assert.Equal(t, sub.ChannelId, preview.Post.ChannelId)
+	assert.Contains(t, preview.Post.Message, "In Progress")
+
+	// The JQL of the subscription narrows the search.
+	sub.Filters.JQL = `priority = High ORDER BY created`
+	_, err = p.previewSubscription(ji, client, sub, 7, now)
+	require.Nil(t, err)
+	assert.Equal(t, `project in ("PROJ") AND (priority = High) AND updated >= -7d ORDER BY updated DESC`, client.jql)
+}
+
+func normalizePreviewTimes(events []SubscriptionPreviewEvent) []SubscriptionPreviewEvent {
+	for i := range events {
+		events[i].Time = events[i].Time.UTC()
+	}
+	return events
+}
+
+func TestParseSubscribeTestArgs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args         []string
+		expectedName string
+		expectedDays int
+		expectedErr  bool
+	}{
+		"name":                      {args: []string{"Bugs"}, expectedName: "Bugs", expectedDays: DefaultSubscriptionPreviewDays},
+		"name ending with a number": {args: []string{"Sprint", "5"}, expectedName: "Sprint 5", expectedDays: DefaultSubscriptionPreviewDays},
+		"days":                      {args: []string{"Sprint", "5", "--days", "3"}, expectedName: "Sprint 5", expectedDays: 3},
+		"days first":                {args: []string{"--days", "3", "Bugs"}, expectedName: "Bugs", expectedDays: 3},
+		"missing days":              {args: []string{"Bugs", "--days"}, expectedErr: true},
+		"bad days":                  {args: []string{"Bugs", "--days", "three"}, expectedErr: true},
+		"too many days":             {args: []string{"Bugs", "--days", "1000"}, expectedErr: true},
+		"missing name":              {args: []string{"--days", "3"}, expectedErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			name, days, err := parseSubscribeTestArgs(tc.args)
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.expectedName, name)
+			assert.Equal(t, tc.expectedDays, days)
+		})
+	}
+}
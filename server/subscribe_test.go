@@ -8,9 +8,11 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
 
+	jira "github.com/andygrunwald/go-jira"
 	"github.com/stretchr/testify/require"
 
 	"github.com/mattermost/mattermost-server/v5/model"
@@ -254,7 +256,10 @@ func TestListChannelSubscriptions(t *testing.T) {
 				return true
 			})).Return(nil)
 
-			actual, err := p.listChannelSubscriptions(team1.Id)
+			ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+			require.Nil(t, err)
+
+			actual, err := p.listChannelSubscriptions(ji, team1.Id)
 			assert.Nil(t, err)
 			assert.NotNil(t, actual)
 
@@ -1003,6 +1008,79 @@ func TestGetChannelsSubscribed(t *testing.T) {
 			}),
 			ChannelIds: []string{},
 		},
+		"JQL matches, no project selected": {
+			WebhookTestData: "webhook-issue-created.json",
+			Subs: withExistingChannelSubscriptions([]ChannelSubscription{
+				ChannelSubscription{
+					Id:        model.NewId(),
+					ChannelId: "sampleChannelId",
+					Filters: SubscriptionFilters{
+						Events:     NewStringSet("event_created"),
+						Projects:   NewStringSet(),
+						IssueTypes: NewStringSet(),
+						JQL:        `project = TES AND priority in (High, Highest) AND labels = "test-label"`,
+					},
+				},
+			}),
+			ChannelIds: []string{"sampleChannelId"},
+		},
+		"JQL does not match": {
+			WebhookTestData: "webhook-issue-created.json",
+			Subs: withExistingChannelSubscriptions([]ChannelSubscription{
+				ChannelSubscription{
+					Id:        model.NewId(),
+					ChannelId: "sampleChannelId",
+					Filters: SubscriptionFilters{
+						Events:     NewStringSet("event_created"),
+						Projects:   NewStringSet("TES"),
+						IssueTypes: NewStringSet("10001"),
+						JQL:        "status != \"To Do\" OR assignee is not EMPTY",
+					},
+				},
+			}),
+			ChannelIds: []string{},
+		},
+		"digest subscription": {
+			WebhookTestData: "webhook-issue-created.json",
+			Subs: withExistingChannelSubscriptions([]ChannelSubscription{
+				ChannelSubscription{
+					Id:        model.NewId(),
+					ChannelId: "sampleChannelId",
+					Filters: SubscriptionFilters{
+						Events:     NewStringSet("event_created"),
+						Projects:   NewStringSet("TES"),
+						IssueTypes: NewStringSet("10001"),
+					},
+					Digest: &SubscriptionDigest{Schedule: DigestHourly},
+				},
+				ChannelSubscription{
+					Id:        model.NewId(),
+					ChannelId: "otherChannelId",
+					Filters: SubscriptionFilters{
+						Events:     NewStringSet("event_created"),
+						Projects:   NewStringSet("TES"),
+						IssueTypes: NewStringSet("10001"),
+					},
+				},
+			}),
+			ChannelIds: []string{"otherChannelId"},
+		},
+		"JQL invalid": {
+			WebhookTestData: "webhook-issue-created.json",
+			Subs: withExistingChannelSubscriptions([]ChannelSubscription{
+				ChannelSubscription{
+					Id:        model.NewId(),
+					ChannelId: "sampleChannelId",
+					Filters: SubscriptionFilters{
+						Events:     NewStringSet("event_created"),
+						Projects:   NewStringSet("TES"),
+						IssueTypes: NewStringSet("10001"),
+						JQL:        "project = ",
+					},
+				},
+			}),
+			ChannelIds: []string{},
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			api := &plugintest.API{}
@@ -1033,7 +1111,10 @@ func TestGetChannelsSubscribed(t *testing.T) {
 			wh, err := ParseWebhook(bb)
 			assert.Nil(t, err)
 
-			actual, err := p.getChannelsSubscribed(wh.(*webhook))
+			ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+			require.Nil(t, err)
+
+			actual, err := p.getChannelsSubscribed(ji, wh.(*webhook))
 			assert.Nil(t, err)
 
 			assert.Equal(t, len(tc.ChannelIds), len(actual))
@@ -1043,3 +1124,86 @@ func TestGetChannelsSubscribed(t *testing.T) {
 		})
 	}
 }
+
+// projectsTestClient records the projects the subscriptions are checked
+// against.
+type projectsTestClient struct {
+	testClient
+	projects []string
+}
+
+func (client *projectsTestClient) GetProject(key string) (*jira.Project, error) {
+	client.projects = append(client.projects, key)
+	return client.testClient.GetProject(key)
+}
+
+func TestValidateJQLSubscription(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+	api.On("KVGet", mock.Anything).Return(nil, nil)
+
+	for name, tc := range map[string]struct {
+		jql              string
+		projects         StringSet
+		expectedProjects []string
+		expectedErr      bool
+	}{
+		"projects of the JQL": {
+			jql:              "project in (PROJ, other) AND issuetype = Bug",
+			expectedProjects: []string{"OTHER", "PROJ"},
+		},
+		"projects of one branch of an AND": {
+			jql:              "(project = PROJ OR project = OTHER) AND (issuetype = Bug OR priority = High)",
+			expectedProjects: []string{"OTHER", "PROJ"},
+		},
+		"selected projects": {
+			jql:              "issuetype = Bug",
+			projects:         NewStringSet("PROJ"),
+			expectedProjects: []string{"PROJ"},
+		},
+		"any project": {
+			jql:         "issuetype = Bug",
+			expectedErr: true,
+		},
+		"any project in a branch of an OR": {
+			jql:         "project = PROJ OR issuetype = Bug",
+			expectedErr: true,
+		},
+		"excluded projects": {
+			jql:         "project != PROJ",
+			expectedErr: true,
+		},
+		"no project": {
+			jql:         "project = PROJ AND project = OTHER",
+			expectedErr: true,
+		},
+		"project the user can't see": {
+			jql:         "project in (PROJ, " + nonExistantProjectKey + ")",
+			expectedErr: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &projectsTestClient{}
+			err := p.validateSubscription(ji, &ChannelSubscription{
+				ChannelId: model.NewId(),
+				Name:      "JQL",
+				Filters: SubscriptionFilters{
+					Events:   NewStringSet("event_created"),
+					Projects: tc.projects,
+					JQL:      tc.jql,
+				},
+			}, client)
+			if tc.expectedErr {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			sort.Strings(client.projects)
+			assert.Equal(t, tc.expectedProjects, client.projects)
+		})
+	}
+}
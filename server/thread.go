@@ -0,0 +1,138 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	prefixIssueThread = "issue_thread_"
+
+	// An issue quiet for this long starts a new thread on its next update.
+	IssueThreadExpiry = 30 * 24 * time.Hour
+)
+
+func issueThreadKey(instanceURL, channelId, issueKey string) string {
+	return hashkey(prefixIssueThread, instanceURL+"/"+channelId+"/"+issueKey)
+}
+
+// postToChannelThread posts wh to a channel as a reply to the first post
+// made in that channel about the same issue. It falls back to a top-level
+// post, that becomes the thread root, if there is none.
+func (p *Plugin) postToChannelThread(ji Instance, wh *webhook, channelId, fromUserId string, sub *ChannelSubscription) (*model.Post, int, error) {
+	post, err := wh.newPost(p, ji, channelId, fromUserId, sub)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	conf := p.getConfig()
+	issueKey := wh.JiraWebhook.Issue.Key
+	if !conf.ThreadIssueUpdates || ji == nil || issueKey == "" {
+		created, appErr := p.API.CreatePost(post)
+		if appErr != nil {
+			return nil, appErr.StatusCode, appErr
+		}
+		return created, http.StatusOK, nil
+	}
+
+	key := issueThreadKey(ji.GetURL(), channelId, issueKey)
+	root := p.loadIssueThreadRoot(key)
+	if root != nil {
+		post.RootId = root.Id
+		created, appErr := p.API.CreatePost(post)
+		if appErr == nil {
+			p.storeIssueThreadRoot(key, root.Id)
+			if conf.UpdateThreadRootPost {
+				p.updateIssueThreadRoot(root, wh)
+			}
+			return created, http.StatusOK, nil
+		}
+		// The root may have been deleted in the meantime, start a new thread.
+		p.errorf("Failed to reply to the thread of %s, starting a new one, err: %v", issueKey, appErr)
+		post.RootId = ""
+	}
+
+	created, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		return nil, appErr.StatusCode, appErr
+	}
+	p.storeIssueThreadRoot(key, created.Id)
+	return created, http.StatusOK, nil
+}
+
+// loadIssueThreadRoot returns the root post of an issue thread, or nil if
+// there is none, or if it was deleted.
+func (p *Plugin) loadIssueThreadRoot(key string) *model.Post {
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil || len(data) == 0 {
+		return nil
+	}
+	root, appErr := p.API.GetPost(string(data))
+	if appErr != nil || root.DeleteAt != 0 {
+		return nil
+	}
+	return root
+}
+
+// storeIssueThreadRoot records the root post of an issue thread, extending
+// its expiry.
+func (p *Plugin) storeIssueThreadRoot(key, postId string) {
+	appErr := p.API.KVSetWithExpiry(key, []byte(postId), int64(IssueThreadExpiry/time.Second))
+	if appErr != nil {
+		p.errorf("Failed to store issue thread root post %s, err: %v", postId, appErr)
+	}
+}
+
+// updateIssueThreadRoot shows the latest status and assignee of the issue on
+// the attachment of the thread root post.
+func (p *Plugin) updateIssueThreadRoot(root *model.Post, wh *webhook) {
+	issue := wh.JiraWebhook.Issue
+	if issue.Fields == nil {
+		return
+	}
+	status := ""
+	if issue.Fields.Status != nil {
+		status = issue.Fields.Status.Name
+	}
+
+	attachments := root.Attachments()
+	if len(attachments) == 0 {
+		attachments = []*model.SlackAttachment{{
+			Color:    defaultNotificationColor,
+			Fallback: root.Message,
+			Pretext:  root.Message,
+		}}
+		root.Message = ""
+	}
+	attachment := attachments[0]
+	attachment.Fields = setSlackAttachmentField(attachment.Fields, "Status", status)
+	attachment.Fields = setSlackAttachmentField(attachment.Fields, "Assignee", wh.JiraWebhook.mdIssueAssignee())
+
+	model.ParseSlackAttachment(root, attachments)
+	_, appErr := p.API.UpdatePost(root)
+	if appErr != nil {
+		p.errorf("Failed to update root post %s of the %s thread, err: %v", root.Id, issue.Key, appErr)
+	}
+}
+
+func setSlackAttachmentField(fields []*model.SlackAttachmentField, title, value string) []*model.SlackAttachmentField {
+	if value == "" {
+		return fields
+	}
+	for _, field := range fields {
+		if field.Title == title {
+			field.Value = value
+			return fields
+		}
+	}
+	return append(fields, &model.SlackAttachmentField{
+		Title: title,
+		Value: value,
+		Short: true,
+	})
+}
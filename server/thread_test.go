@@ -0,0 +1,113 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+func TestPostToChannelThread(t *testing.T) {
+	data, err := getJiraTestData("webhook-issue-created.json")
+	require.Nil(t, err)
+	w, err := ParseWebhook(data)
+	require.Nil(t, err)
+	wh := w.(*webhook)
+
+	key := issueThreadKey(mockCurrentInstanceURL, "channelId", "TES-41")
+	rootPost := &model.Post{Id: "rootPostId", ChannelId: "channelId", Message: "root"}
+
+	for name, tc := range map[string]struct {
+		threads        bool
+		updateRoot     bool
+		existingRootId string
+		rootDeleted    bool
+		expectedRootId string
+	}{
+		"threads disabled": {
+			existingRootId: rootPost.Id,
+		},
+		"first post starts a thread": {
+			threads: true,
+		},
+		"reply to the thread": {
+			threads:        true,
+			existingRootId: rootPost.Id,
+			expectedRootId: rootPost.Id,
+		},
+		"reply to the thread, and update the root": {
+			threads:        true,
+			updateRoot:     true,
+			existingRootId: rootPost.Id,
+			expectedRootId: rootPost.Id,
+		},
+		"root was deleted": {
+			threads:        true,
+			existingRootId: rootPost.Id,
+			rootDeleted:    true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.updateConfig(func(conf *config) {
+				conf.ThreadIssueUpdates = tc.threads
+				conf.UpdateThreadRootPost = tc.updateRoot
+			})
+			p.currentInstanceStore = mockCurrentInstanceStore{p}
+			p.userStore = mockUserStore{}
+			ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+			require.Nil(t, err)
+
+			var kvValue []byte
+			if tc.existingRootId != "" {
+				kvValue = []byte(tc.existingRootId)
+			}
+			api.On("KVGet", key).Return(kvValue, nil)
+			root := *rootPost
+			if tc.rootDeleted {
+				root.DeleteAt = 1
+			}
+			api.On("GetPost", rootPost.Id).Return(&root, nil)
+			api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(
+				func(post *model.Post) *model.Post {
+					created := *post
+					created.Id = "newPostId"
+					return &created
+				}, nil)
+			api.On("KVSetWithExpiry", key, mock.Anything, int64(30*24*60*60)).Return(nil)
+			api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&root, nil)
+
+			post, _, err := p.postToChannelThread(ji, wh, "channelId", "botUserId", nil)
+			require.Nil(t, err)
+			assert.Equal(t, tc.expectedRootId, post.RootId)
+
+			if !tc.threads {
+				api.AssertNotCalled(t, "KVGet", key)
+				return
+			}
+			if tc.expectedRootId == "" {
+				api.AssertCalled(t, "KVSetWithExpiry", key, []byte("newPostId"), int64(30*24*60*60))
+			} else {
+				api.AssertCalled(t, "KVSetWithExpiry", key, []byte(rootPost.Id), int64(30*24*60*60))
+			}
+			if tc.updateRoot {
+				api.AssertCalled(t, "UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+					attachments := post.Attachments()
+					return len(attachments) == 1 && len(attachments[0].Fields) == 2 &&
+						attachments[0].Fields[0].Value == "To Do"
+				}))
+			} else {
+				api.AssertNotCalled(t, "UpdatePost", mock.Anything)
+			}
+		})
+	}
+}
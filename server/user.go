@@ -6,9 +6,13 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	jira "github.com/andygrunwald/go-jira"
 	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
 
 	"github.com/mattermost/mattermost-server/v5/model"
 )
@@ -21,8 +25,9 @@ const (
 type JIRAUser struct {
 	jira.User
 	PluginVersion      string
-	Oauth1AccessToken  string `json:",omitempty"`
-	Oauth1AccessSecret string `json:",omitempty"`
+	Oauth1AccessToken  string        `json:",omitempty"`
+	Oauth1AccessSecret string        `json:",omitempty"`
+	OAuth2Token        *oauth2.Token `json:",omitempty"`
 	Settings           *UserSettings
 }
 
@@ -34,8 +39,31 @@ func (u JIRAUser) Key() string {
 	}
 }
 
+// settings returns the settings of u, to modify them.
+func (u *JIRAUser) settings() *UserSettings {
+	if u.Settings == nil {
+		u.Settings = &UserSettings{}
+	}
+	return u.Settings
+}
+
 type UserSettings struct {
 	Notifications bool `json:"notifications"`
+
+	// DisabledEvents are the kinds of notifications the user turned off. All
+	// of them are sent by default.
+	DisabledEvents StringSet `json:"disabled_events,omitempty"`
+
+	// MutedProjects are the keys of the projects that never send
+	// notifications.
+	MutedProjects StringSet `json:"muted_projects,omitempty"`
+
+	// QuietHours defer the notifications until they end.
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+
+	// BatchMinutes, if set, sends the notifications together, at most once
+	// every BatchMinutes.
+	BatchMinutes int `json:"batch_minutes,omitempty"`
 }
 
 func (us UserSettings) String() string {
@@ -43,7 +71,142 @@ func (us UserSettings) String() string {
 	if us.Notifications {
 		notifications = "on"
 	}
-	return fmt.Sprintf("\tNotifications: %s", notifications)
+	text := fmt.Sprintf("\tNotifications: %s", notifications)
+
+	events := []string{}
+	for _, event := range notificationEvents {
+		value := settingOn
+		if us.DisabledEvents.ContainsAny(event) {
+			value = settingOff
+		}
+		events = append(events, event+" "+value)
+	}
+	text += fmt.Sprintf("\n\tEvents: %s", strings.Join(events, ", "))
+
+	muted := "none"
+	if us.MutedProjects.Len() > 0 {
+		projects := us.MutedProjects.Elems()
+		sort.Strings(projects)
+		muted = strings.Join(projects, ", ")
+	}
+	text += fmt.Sprintf("\n\tMuted projects: %s", muted)
+
+	quietHours := settingOff
+	if us.QuietHours != nil {
+		quietHours = us.QuietHours.String()
+	}
+	text += fmt.Sprintf("\n\tQuiet hours: %s", quietHours)
+
+	batch := settingOff
+	if us.BatchMinutes > 0 {
+		batch = fmt.Sprintf("every %d minutes", us.BatchMinutes)
+	}
+	text += fmt.Sprintf("\n\tBatch: %s", batch)
+	return text
+}
+
+// wantsNotification returns whether a notification of kind event, about an
+// issue of projectKey, should be sent. Notifications that are not about a
+// kind of event, like the personal subscriptions, only check the project.
+func (us *UserSettings) wantsNotification(event, projectKey string) bool {
+	if us == nil || !us.Notifications {
+		return false
+	}
+	if event != "" && us.DisabledEvents.ContainsAny(event) {
+		return false
+	}
+	if projectKey != "" && us.MutedProjects.ContainsAny(projectKey) {
+		return false
+	}
+	return true
+}
+
+// deferNotifications returns whether the notifications sent at t are
+// delivered later, by the quiet hours or in a batch.
+func (us *UserSettings) deferNotifications(t time.Time) bool {
+	if us == nil {
+		return false
+	}
+	if us.BatchMinutes > 0 {
+		return true
+	}
+	if us.QuietHours != nil {
+		end, _ := us.QuietHours.until(t)
+		return !end.IsZero()
+	}
+	return false
+}
+
+// QuietHours is a daily time range, possibly over midnight, during which the
+// notifications are not sent.
+type QuietHours struct {
+	// HH:MM
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	// IANA timezone name, UTC if empty. Filled in with the timezone of the
+	// user.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+func (q QuietHours) String() string {
+	timezone := q.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	return fmt.Sprintf("%s to %s (%s)", q.Start, q.End, timezone)
+}
+
+func (q QuietHours) validate() error {
+	_, err := q.until(time.Now())
+	if err != nil {
+		return err
+	}
+	if q.Start == q.End {
+		return errors.New("quiet hours must start and end at different times")
+	}
+	return nil
+}
+
+// until returns the end of the quiet hours t is in, or the zero time if t is
+// not in quiet hours.
+func (q QuietHours) until(t time.Time) (time.Time, error) {
+	loc := time.UTC
+	if q.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(q.Timezone)
+		if err != nil {
+			return time.Time{}, errors.Errorf("unknown timezone %q", q.Timezone)
+		}
+	}
+	startClock, err := time.Parse("15:04", q.Start)
+	if err != nil {
+		return time.Time{}, errors.Errorf("time must be HH:MM, not %q", q.Start)
+	}
+	endClock, err := time.Parse("15:04", q.End)
+	if err != nil {
+		return time.Time{}, errors.Errorf("time must be HH:MM, not %q", q.End)
+	}
+
+	t = t.In(loc)
+	y, m, d := t.Date()
+	start := time.Date(y, m, d, startClock.Hour(), startClock.Minute(), 0, 0, loc)
+	end := time.Date(y, m, d, endClock.Hour(), endClock.Minute(), 0, 0, loc)
+	switch {
+	case start.Before(end):
+		if !t.Before(start) && t.Before(end) {
+			return end, nil
+		}
+	case end.Before(start):
+		// Over midnight
+		if t.Before(end) {
+			return end, nil
+		}
+		if !t.Before(start) {
+			return time.Date(y, m, d+1, endClock.Hour(), endClock.Minute(), 0, 0, loc), nil
+		}
+	}
+	return time.Time{}, nil
 }
 
 type UserInfo struct {
@@ -53,6 +216,7 @@ type UserInfo struct {
 	InstanceType      string            `json:"instance_type"`
 	JIRAURL           string            `json:"jira_url,omitempty"`
 	InstanceDetails   map[string]string `json:"instance_details,omitempty"`
+	InstanceURLs      []string          `json:"instance_urls,omitempty"`
 }
 
 func httpUserConnect(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
@@ -83,6 +247,40 @@ func httpUserConnect(ji Instance, w http.ResponseWriter, r *http.Request) (int,
 	return http.StatusFound, nil
 }
 
+// httpUserDisconnect is the revoke link of the OAuth instances. The users of
+// an Atlassian Connect app disconnect from its pages in Jira.
+func httpUserDisconnect(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
+	switch ji.(type) {
+	case *jiraServerInstance, *jiraCloudOAuthInstance:
+	default:
+		return respondErr(w, http.StatusBadRequest,
+			errors.New("Must be a Jira Server or Jira Cloud OAuth instance, is "+ji.GetType()))
+	}
+	if r.Method != http.MethodGet {
+		return respondErr(w, http.StatusMethodNotAllowed,
+			errors.New("method "+r.Method+" is not allowed, must be GET"))
+	}
+
+	mattermostUserId := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserId == "" {
+		return respondErr(w, http.StatusUnauthorized, errors.New("not authorized"))
+	}
+
+	err := ji.GetPlugin().userDisconnect(ji, mattermostUserId)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	return ji.GetPlugin().respondSpecialTemplate(w, "/other/message.html", http.StatusOK,
+		"text/html", struct {
+			Header  string
+			Message string
+		}{
+			Header:  "Disconnected from Jira.",
+			Message: "It is now safe to close this browser window.",
+		})
+}
+
 func httpUserStart(ji Instance, w http.ResponseWriter, r *http.Request) (int, error) {
 	mattermostUserID := r.Header.Get("Mattermost-User-Id")
 	if mattermostUserID == "" {
@@ -113,17 +311,30 @@ func httpAPIGetUserInfo(p *Plugin, w http.ResponseWriter, r *http.Request) (int,
 			errors.New("not authorized"))
 	}
 
-	return respondJSON(w, getUserInfo(p, mattermostUserId))
+	return respondJSON(w, getUserInfo(p, mattermostUserId, r.URL.Query().Get(argInstanceURL)))
 }
 
-func getUserInfo(p *Plugin, mattermostUserId string) UserInfo {
+// getUserInfo returns the connection of the user to the Jira instance at
+// instanceURL, or to the instance of the user if it is empty.
+func getUserInfo(p *Plugin, mattermostUserId, instanceURL string) UserInfo {
 	resp := UserInfo{}
-	if ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance(); err == nil {
+	if urls, err := p.userStore.LoadUserInstances(mattermostUserId); err == nil {
+		sort.Strings(urls)
+		resp.InstanceURLs = urls
+	}
+	ji, err := p.loadUserInstance(mattermostUserId, instanceURL)
+	if multiple, ok := err.(*multipleInstancesError); ok {
+		// Show the first one, the webapp then selects it in its requests.
+		ji, err = p.loadInstance(multiple.URLs[0])
+	}
+	if err == nil {
 		resp.InstanceInstalled = true
 		resp.InstanceType = ji.GetType()
 		resp.InstanceDetails = ji.GetDisplayDetails()
 		resp.JIRAURL = ji.GetURL()
 		if jiraUser, err := ji.GetPlugin().userStore.LoadJIRAUser(ji, mattermostUserId); err == nil {
+			// The access tokens are not for the webapp
+			jiraUser.OAuth2Token = nil
 			resp.JIRAUser = jiraUser
 			resp.IsConnected = true
 		}
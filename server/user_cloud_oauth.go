@@ -0,0 +1,150 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func httpOAuth2Complete(p *Plugin, w http.ResponseWriter, r *http.Request) (status int, err error) {
+	// Prettify error output
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		errtext := err.Error()
+		if len(errtext) > 0 {
+			errtext = strings.ToUpper(errtext[:1]) + errtext[1:]
+		}
+		status, err = p.respondSpecialTemplate(w, "/other/message.html", status, "text/html", struct {
+			Header  string
+			Message string
+		}{
+			Header:  "Failed to connect to Jira.",
+			Message: errtext,
+		})
+	}()
+
+	if r.Method != http.MethodGet {
+		return respondErr(w, http.StatusMethodNotAllowed,
+			errors.New("method "+r.Method+" is not allowed, must be GET"))
+	}
+
+	mattermostUserId := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserId == "" {
+		return respondErr(w, http.StatusUnauthorized, errors.New("not authorized"))
+	}
+	mmuser, appErr := p.API.GetUser(mattermostUserId)
+	if appErr != nil {
+		return respondErr(w, http.StatusInternalServerError,
+			errors.WithMessage(appErr, "failed to load user "+mattermostUserId))
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		return respondErr(w, http.StatusBadRequest,
+			errors.Errorf("Jira refused the access: %s %s", errCode, query.Get("error_description")))
+	}
+	code, state := query.Get("code"), query.Get("state")
+	if code == "" || state == "" {
+		return respondErr(w, http.StatusBadRequest, errors.New("missing code or state"))
+	}
+
+	secret, err := p.otsStore.LoadOneTimeSecret(state)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	stateUserId, instanceURL, err := parseOAuth2State(secret)
+	if err != nil {
+		return respondErr(w, http.StatusUnauthorized,
+			errors.New("the connect link expired, please use /jira connect again"))
+	}
+	if stateUserId != mattermostUserId {
+		return respondErr(w, http.StatusUnauthorized, errors.New("not authorized, user id does not match link"))
+	}
+
+	ji, err := p.loadInstance(instanceURL)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	jci, ok := ji.(*jiraCloudOAuthInstance)
+	if !ok {
+		return respondErr(w, http.StatusBadRequest,
+			errors.New("Must be a Jira Cloud OAuth instance, is "+ji.GetType()))
+	}
+
+	token, err := jci.getOAuth2Config().Exchange(atlassianContext(), code)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError,
+			errors.WithMessage(err, "failed to obtain an OAuth2 access token"))
+	}
+
+	cloudId, err := jci.findCloudId(token)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest, err)
+	}
+	if cloudId != jci.CloudId {
+		jci.CloudId = cloudId
+		err = p.storeCloudOAuthInstance(jci)
+		if err != nil {
+			return respondErr(w, http.StatusInternalServerError, err)
+		}
+	}
+
+	jiraUser := JIRAUser{
+		PluginVersion: manifest.Version,
+		OAuth2Token:   token,
+	}
+
+	client, err := jci.GetClient(jiraUser)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	juser, err := client.GetSelf()
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	jiraUser.User = *juser
+
+	// Set default settings the first time a user connects
+	jiraUser.Settings = &UserSettings{Notifications: true}
+
+	err = p.StoreUserInfoNotify(jci, mattermostUserId, jiraUser)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	return p.respondSpecialTemplate(w, routeOAuth1Complete, http.StatusOK, "text/html", struct {
+		MattermostDisplayName string
+		JiraDisplayName       string
+		RevokeURL             string
+	}{
+		JiraDisplayName:       juser.DisplayName,
+		MattermostDisplayName: mmuser.GetDisplayName(model.SHOW_NICKNAME_FULLNAME),
+		RevokeURL:             path.Join(p.GetPluginURLPath(), routeUserDisconnect) + "?" + url.Values{argInstanceURL: {jci.GetURL()}}.Encode(),
+	})
+}
+
+// storeCloudOAuthInstance stores the changes to an installed instance, and
+// to the default instance if it is the one.
+func (p *Plugin) storeCloudOAuthInstance(jci *jiraCloudOAuthInstance) error {
+	err := p.instanceStore.StoreJIRAInstance(jci)
+	if err != nil {
+		return err
+	}
+	current, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	if err == nil && current.GetURL() == jci.GetURL() {
+		return p.currentInstanceStore.StoreCurrentJIRAInstance(jci)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"net/http"
+	"net/url"
 	"path"
 	"strings"
 
@@ -112,36 +113,10 @@ func httpOAuth1aComplete(jsi *jiraServerInstance, w http.ResponseWriter, r *http
 	}{
 		JiraDisplayName:       juser.DisplayName + " (" + juser.Name + ")",
 		MattermostDisplayName: mmuser.GetDisplayName(model.SHOW_NICKNAME_FULLNAME),
-		RevokeURL:             path.Join(jsi.Plugin.GetPluginURLPath(), routeUserDisconnect),
+		RevokeURL:             path.Join(jsi.Plugin.GetPluginURLPath(), routeUserDisconnect) + "?" + url.Values{argInstanceURL: {jsi.GetURL()}}.Encode(),
 	})
 }
 
-func httpOAuth1aDisconnect(ji *jiraServerInstance, w http.ResponseWriter, r *http.Request) (int, error) {
-	if r.Method != http.MethodGet {
-		return respondErr(w, http.StatusMethodNotAllowed,
-			errors.New("method "+r.Method+" is not allowed, must be GET"))
-	}
-
-	mattermostUserId := r.Header.Get("Mattermost-User-Id")
-	if mattermostUserId == "" {
-		return respondErr(w, http.StatusUnauthorized, errors.New("not authorized"))
-	}
-
-	err := ji.GetPlugin().userDisconnect(ji, mattermostUserId)
-	if err != nil {
-		return respondErr(w, http.StatusInternalServerError, err)
-	}
-
-	return ji.GetPlugin().respondSpecialTemplate(w, "/other/message.html", http.StatusOK,
-		"text/html", struct {
-			Header  string
-			Message string
-		}{
-			Header:  "Disconnected from Jira.",
-			Message: "It is now safe to close this browser window.",
-		})
-}
-
 func httpOAuth1aPublicKey(p *Plugin, w http.ResponseWriter, r *http.Request) (int, error) {
 	if r.Method != http.MethodGet {
 		return respondErr(w, http.StatusMethodNotAllowed,
@@ -18,11 +18,22 @@ func TestUserSettings_String(t *testing.T) {
 	}{
 		"notifications on": {
 			settings:       UserSettings{Notifications: false},
-			expectedOutput: "\tNotifications: off",
+			expectedOutput: "\tNotifications: off\n\tEvents: assigned on, mentioned on, commented on, status on\n\tMuted projects: none\n\tQuiet hours: off\n\tBatch: off",
 		},
 		"notifications off": {
 			settings:       UserSettings{Notifications: true},
-			expectedOutput: "\tNotifications: on",
+			expectedOutput: "\tNotifications: on\n\tEvents: assigned on, mentioned on, commented on, status on\n\tMuted projects: none\n\tQuiet hours: off\n\tBatch: off",
+		},
+		"preferences": {
+			settings: UserSettings{
+				Notifications:  true,
+				DisabledEvents: NewStringSet(notifyMentioned, notifyStatus),
+				MutedProjects:  NewStringSet("MM", "ABC"),
+				QuietHours:     &QuietHours{Start: "22:00", End: "07:30", Timezone: "Europe/Paris"},
+				BatchMinutes:   30,
+			},
+			expectedOutput: "\tNotifications: on\n\tEvents: assigned on, mentioned off, commented on, status off\n" +
+				"\tMuted projects: ABC, MM\n\tQuiet hours: 22:00 to 07:30 (Europe/Paris)\n\tBatch: every 30 minutes",
 		},
 	}
 	for name, tt := range tests {
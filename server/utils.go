@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	jira "github.com/andygrunwald/go-jira"
 	"github.com/pkg/errors"
@@ -31,6 +32,10 @@ func (p *Plugin) CreateBotDMPost(ji Instance, userId, message, postType string)
 	if jiraUser.Settings == nil || !jiraUser.Settings.Notifications {
 		return nil, nil
 	}
+	// Hold the DM during the quiet hours of the user, or for the next batch
+	if jiraUser.Settings.deferNotifications(time.Now()) {
+		return nil, p.deferNotification(ji, userId, message, postType)
+	}
 
 	conf := p.getConfig()
 	channel, appErr := p.API.GetDirectChannel(userId, conf.botUserID)
@@ -113,7 +118,7 @@ func replaceJiraAccountIds(ji Instance, body string) string {
 		}
 
 		if jiraUser.DisplayName != "" {
-			result = strings.ReplaceAll(result, uname, jiraUser.DisplayName)
+			result = strings.ReplaceAll(result, "[~"+uname+"]", "**"+jiraUser.DisplayName+"**")
 		}
 	}
 
@@ -0,0 +1,154 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is returned for the requests made while a circuit breaker
+// is open.
+type ErrCircuitOpen struct {
+	Until time.Time
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	return fmt.Sprintf("Jira is not responding, the requests to it are paused until %s",
+		e.Until.UTC().Format(time.RFC3339))
+}
+
+// CircuitBreaker stops the requests to a server after Threshold consecutive
+// failures, for Cooldown. Then it lets a single request through: if it
+// succeeds the circuit is closed again, otherwise it stays open for another
+// Cooldown.
+type CircuitBreaker struct {
+	lock      sync.Mutex
+	threshold int
+	cooldown  time.Duration
+
+	failures  int
+	openUntil time.Time
+	trial     bool
+	trips     int64
+
+	now func() time.Time
+}
+
+// NewCircuitBreaker returns a circuit breaker, disabled if threshold is 0.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Configure changes the threshold and the cooldown of the circuit breaker.
+func (cb *CircuitBreaker) Configure(threshold int, cooldown time.Duration) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.threshold, cb.cooldown = threshold, cooldown
+}
+
+// Allow returns an error if the circuit is open. Once the cooldown is over,
+// only one request is allowed until it succeeds or fails.
+func (cb *CircuitBreaker) Allow() error {
+	if cb == nil {
+		return nil
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.threshold <= 0 || cb.openUntil.IsZero() {
+		return nil
+	}
+	now := cb.now()
+	if now.Before(cb.openUntil) || cb.trial {
+		return &ErrCircuitOpen{Until: cb.openUntil}
+	}
+	cb.trial = true
+	return nil
+}
+
+// Success closes the circuit.
+func (cb *CircuitBreaker) Success() {
+	if cb == nil {
+		return
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.failures, cb.openUntil, cb.trial = 0, time.Time{}, false
+}
+
+// Failure counts a failure, and opens the circuit past the threshold, or if
+// the trial request of a half-open circuit failed.
+func (cb *CircuitBreaker) Failure() {
+	if cb == nil {
+		return
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	cb.failures++
+	if cb.threshold <= 0 {
+		return
+	}
+	if cb.trial || (cb.openUntil.IsZero() && cb.failures >= cb.threshold) {
+		if cb.openUntil.IsZero() {
+			cb.trips++
+		}
+		cb.openUntil = cb.now().Add(cb.cooldown)
+		cb.trial = false
+	}
+}
+
+// CircuitBreakerStatus is the state of a circuit breaker.
+type CircuitBreakerStatus struct {
+	State string `json:"state"`
+	// The consecutive failures
+	Failures int `json:"failures"`
+	// How many times the circuit was opened
+	Trips     int64     `json:"trips"`
+	OpenUntil time.Time `json:"open_until,omitempty"`
+}
+
+func (cb *CircuitBreaker) Status() CircuitBreakerStatus {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	status := CircuitBreakerStatus{
+		State:    CircuitClosed,
+		Failures: cb.failures,
+		Trips:    cb.trips,
+	}
+	if !cb.openUntil.IsZero() {
+		status.OpenUntil = cb.openUntil
+		status.State = CircuitOpen
+		if !cb.now().Before(cb.openUntil) {
+			status.State = CircuitHalfOpen
+		}
+	}
+	return status
+}
+
+func (s CircuitBreakerStatus) String() string {
+	switch s.State {
+	case CircuitOpen:
+		return fmt.Sprintf("open until %s, after %d failures", s.OpenUntil.UTC().Format(time.RFC3339), s.Failures)
+	case CircuitHalfOpen:
+		return "half-open, trying a request"
+	}
+	if s.Failures > 0 {
+		return fmt.Sprintf("closed, %d consecutive failures", s.Failures)
+	}
+	return CircuitClosed
+}
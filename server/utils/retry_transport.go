@@ -0,0 +1,204 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package utils
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy is how the failed requests are retried. The delays between
+// the attempts double from InitialBackoff, up to MaxBackoff, unless the
+// server asks for a delay with Retry-After. Delays longer than MaxRetryAfter
+// are not waited for, and neither are the retries that would wait longer
+// than MaxRetryTime in total.
+type RetryPolicy struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	MaxRetryAfter  time.Duration
+	MaxRetryTime   time.Duration
+
+	// Called before each retry, with the attempt that failed.
+	OnRetry func(req *http.Request, attempt int, resp *http.Response, err error)
+}
+
+type retryTransport struct {
+	http.RoundTripper
+	policy  RetryPolicy
+	breaker *CircuitBreaker
+	sleep   func(req *http.Request, d time.Duration) error
+}
+
+// WrapHTTPClientWithRetries wraps an http client, retrying the requests that
+// fail with a network error, a 429 Too Many Requests, or a 502, 503 or 504,
+// per policy. The requests that fail after all their retries, except with
+// 429, are counted as one failure by breaker, which can be nil.
+func WrapHTTPClientWithRetries(c *http.Client, policy RetryPolicy, breaker *CircuitBreaker) *http.Client {
+	client := *c
+	t := c.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	client.Transport = &retryTransport{
+		RoundTripper: t,
+		policy:       policy,
+		breaker:      breaker,
+		sleep:        sleepContext,
+	}
+	return &client
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	// The breaker counts the outcome of the request, not of each attempt.
+	// Rate limited means Jira is up.
+	unavailable := false
+	defer func() {
+		if unavailable {
+			t.breaker.Failure()
+		} else {
+			t.breaker.Success()
+		}
+	}()
+
+	waited := time.Duration(0)
+	for attempt := 0; ; attempt++ {
+		resp, err := t.RoundTripper.RoundTrip(req)
+		unavailable = isUnavailable(resp, err)
+		if !unavailable && resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+
+		if attempt >= t.policy.MaxRetries || !canRetry(req, resp, err) {
+			return resp, err
+		}
+		delay, ok := t.delay(attempt, resp)
+		if !ok || (t.policy.MaxRetryTime > 0 && waited+delay > t.policy.MaxRetryTime) {
+			return resp, err
+		}
+		waited += delay
+		next, err1 := rewindRequest(req)
+		if err1 != nil {
+			return resp, err
+		}
+		if t.policy.OnRetry != nil {
+			t.policy.OnRetry(req, attempt, resp, err)
+		}
+		if resp != nil && resp.Body != nil {
+			_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		if err = t.sleep(req, delay); err != nil {
+			return nil, err
+		}
+		req = next
+	}
+}
+
+// delay returns how long to wait before retrying, and false if it is longer
+// than allowed.
+func (t *retryTransport) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if t.policy.MaxRetryAfter > 0 && retryAfter > t.policy.MaxRetryAfter {
+				return 0, false
+			}
+			return retryAfter, true
+		}
+	}
+	delay := t.policy.InitialBackoff
+	for i := 0; i < attempt; i++ {
+		delay *= 2
+		if t.policy.MaxBackoff > 0 && delay >= t.policy.MaxBackoff {
+			return t.policy.MaxBackoff, true
+		}
+	}
+	return delay, true
+}
+
+func isUnavailable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// canRetry returns true if retrying req can't repeat its effect: the server
+// did not process it, or it is idempotent.
+func canRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// rewindRequest returns a copy of req to send again, with a new body.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return next, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("the request body can't be sent again")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	next.Body = body
+	return next, nil
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as an HTTP
+// date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+func sleepContext(req *http.Request, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
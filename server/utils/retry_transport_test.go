@@ -0,0 +1,150 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package utils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var statuses []int
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		status := http.StatusOK
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "7")
+		}
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	var delays []time.Duration
+	newClient := func(breaker *CircuitBreaker) *http.Client {
+		client := WrapHTTPClientWithRetries(&http.Client{}, RetryPolicy{
+			MaxRetries:     3,
+			InitialBackoff: time.Second,
+			MaxBackoff:     3 * time.Second,
+			MaxRetryAfter:  10 * time.Second,
+			MaxRetryTime:   10 * time.Second,
+		}, breaker)
+		client.Transport.(*retryTransport).sleep = func(req *http.Request, d time.Duration) error {
+			delays = append(delays, d)
+			return nil
+		}
+		return client
+	}
+	do := func(client *http.Client, method string) (int, error) {
+		req, err := http.NewRequest(method, ts.URL, strings.NewReader("payload"))
+		require.Nil(t, err)
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	t.Run("backoff", func(t *testing.T) {
+		statuses, bodies, delays = []int{503, 502, 504, 503}, nil, nil
+		status, err := do(newClient(nil), http.MethodGet)
+		require.Nil(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, delays)
+		assert.Equal(t, []string{"payload", "payload", "payload", "payload"}, bodies)
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		statuses, bodies, delays = []int{429}, nil, nil
+		status, err := do(newClient(nil), http.MethodPost)
+		require.Nil(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []time.Duration{7 * time.Second}, delays)
+		assert.Len(t, bodies, 2)
+	})
+
+	t.Run("retry time", func(t *testing.T) {
+		statuses, bodies, delays = []int{429, 429, 429}, nil, nil
+		status, err := do(newClient(nil), http.MethodGet)
+		require.Nil(t, err)
+		assert.Equal(t, http.StatusTooManyRequests, status)
+		assert.Equal(t, []time.Duration{7 * time.Second}, delays)
+		assert.Len(t, bodies, 2)
+	})
+
+	t.Run("not idempotent", func(t *testing.T) {
+		statuses, bodies, delays = []int{502}, nil, nil
+		status, err := do(newClient(nil), http.MethodPost)
+		require.Nil(t, err)
+		assert.Equal(t, http.StatusBadGateway, status)
+		assert.Len(t, bodies, 1)
+	})
+
+	t.Run("circuit breaker", func(t *testing.T) {
+		now := time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC)
+		breaker := NewCircuitBreaker(3, 30*time.Second)
+		breaker.now = func() time.Time { return now }
+		client := newClient(breaker)
+
+		// A request is one failure, whatever its retries.
+		statuses, bodies = []int{503, 503, 503, 503, 503, 503, 503, 503, 503, 503, 503, 503}, nil
+		for i := 0; i < 3; i++ {
+			status, err := do(client, http.MethodGet)
+			require.Nil(t, err)
+			assert.Equal(t, http.StatusServiceUnavailable, status)
+		}
+		assert.Len(t, bodies, 12)
+		assert.Equal(t, CircuitOpen, breaker.Status().State)
+		assert.Equal(t, int64(1), breaker.Status().Trips)
+
+		_, err := do(client, http.MethodGet)
+		require.NotNil(t, err)
+		require.IsType(t, &url.Error{}, err)
+		assert.IsType(t, &ErrCircuitOpen{}, err.(*url.Error).Err)
+		assert.Len(t, bodies, 12)
+
+		// Once the cooldown is over, a failed trial reopens the circuit.
+		now = now.Add(30 * time.Second)
+		assert.Equal(t, CircuitHalfOpen, breaker.Status().State)
+		client.Transport.(*retryTransport).policy.MaxRetries = 0
+		statuses = []int{503}
+		status, err := do(client, http.MethodGet)
+		require.Nil(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, CircuitOpen, breaker.Status().State)
+
+		now = now.Add(30 * time.Second)
+		status, err = do(client, http.MethodGet)
+		require.Nil(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, CircuitBreakerStatus{State: CircuitClosed, Trips: 1}, breaker.Status())
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC)
+	d, ok := parseRetryAfter("120", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, d)
+	d, ok = parseRetryAfter("Wed, 15 Jan 2020 12:00:30 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+	_, ok = parseRetryAfter("soon", now)
+	assert.False(t, ok)
+	_, ok = parseRetryAfter("", now)
+	assert.False(t, ok)
+}
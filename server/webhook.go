@@ -15,8 +15,8 @@ import (
 
 type Webhook interface {
 	Events() StringSet
-	PostToChannel(p *Plugin, channelId, fromUserId string) (*model.Post, int, error)
-	PostNotifications(p *Plugin) ([]*model.Post, int, error)
+	PostToChannel(p *Plugin, ji Instance, channelId, fromUserId string) (*model.Post, int, error)
+	PostNotifications(p *Plugin, ji Instance) ([]*model.Post, int, error)
 }
 
 type webhookField struct {
@@ -34,6 +34,9 @@ type webhook struct {
 	fields        []*model.SlackAttachmentField
 	notifications []webhookNotification
 	fieldInfo     webhookField
+
+	// watcherNotification is sent to the watchers of the issue.
+	watcherNotification *webhookNotification
 }
 
 type webhookNotification struct {
@@ -42,15 +45,40 @@ type webhookNotification struct {
 	message       string
 	postType      string
 	commentSelf   string
+
+	// The kind of notification, that users can turn off in their settings.
+	// Empty for personal subscriptions.
+	event string
+
+	// Set for personal subscriptions, instead of the Jira user
+	mattermostUserId string
+	watching         bool
 }
 
 func (wh *webhook) Events() StringSet {
 	return wh.eventTypes
 }
 
-func (wh webhook) PostToChannel(p *Plugin, channelId, fromUserId string) (*model.Post, int, error) {
+func (wh webhook) PostToChannel(p *Plugin, ji Instance, channelId, fromUserId string) (*model.Post, int, error) {
+	post, err := wh.newPost(p, ji, channelId, fromUserId, nil)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	_, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		return nil, appErr.StatusCode, appErr
+	}
+
+	return post, http.StatusOK, nil
+}
+
+// newPost formats wh with the subscription templates, falling back to the
+// templates set by the administrators, and to the built-in format. The
+// actions of the subscription are added as buttons.
+func (wh webhook) newPost(p *Plugin, ji Instance, channelId, fromUserId string, sub *ChannelSubscription) (*model.Post, error) {
 	if wh.headline == "" {
-		return nil, http.StatusBadRequest, errors.Errorf("unsupported webhook")
+		return nil, errors.Errorf("unsupported webhook")
 	}
 
 	post := &model.Post{
@@ -58,72 +86,113 @@ func (wh webhook) PostToChannel(p *Plugin, channelId, fromUserId string) (*model
 		UserId:    fromUserId,
 	}
 
-	text := ""
-	if wh.text != "" && !p.getConfig().HideDecriptionComment {
-		text = wh.text
-		// Get instance for replacing accountids in text. If no instance is available, just skip it.
-		ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-		if err == nil {
-			text = replaceJiraAccountIds(ji, text)
+	var templates NotificationTemplates
+	var actions []*model.PostAction
+	if sub != nil {
+		templates = sub.Templates
+		if ji != nil && wh.Issue.Key != "" && !wh.eventTypes.ContainsAny(eventDeleted, eventDeletedUnresolved) {
+			actions = postActions(ji, wh.Issue.Key, sub.Actions)
 		}
 	}
 
-	if text != "" || len(wh.fields) != 0 {
+	headline, text, color, err := wh.render(p.notificationTemplate(&wh, templates))
+	if err != nil {
+		p.errorf("Failed to execute the notification template, using the built-in format, err: %v", err)
+		headline, text, color = wh.headline, wh.text, defaultNotificationColor
+	}
+
+	if p.getConfig().HideDecriptionComment {
+		text = ""
+	}
+	// Replace accountids in text. If no instance is available, just skip it.
+	if text != "" && ji != nil {
+		text = replaceJiraAccountIds(ji, text)
+	}
+
+	if text != "" || len(wh.fields) != 0 || len(actions) != 0 {
 		model.ParseSlackAttachment(post, []*model.SlackAttachment{
 			{
-				// TODO is this supposed to be themed?
-				Color:    "#95b7d0",
-				Fallback: wh.headline,
-				Pretext:  wh.headline,
+				Color:    color,
+				Fallback: headline,
+				Pretext:  headline,
 				Text:     text,
 				Fields:   wh.fields,
+				Actions:  actions,
 			},
 		})
 	} else {
-		post.Message = wh.headline
+		post.Message = headline
 	}
 
-	_, appErr := p.API.CreatePost(post)
-	if appErr != nil {
-		return nil, appErr.StatusCode, appErr
-	}
-
-	return post, http.StatusOK, nil
+	return post, nil
 }
 
-func (wh *webhook) PostNotifications(p *Plugin) ([]*model.Post, int, error) {
-	if len(wh.notifications) == 0 {
+func (wh *webhook) PostNotifications(p *Plugin, ji Instance) ([]*model.Post, int, error) {
+	// We will only send webhook events if we have a connected instance.
+	if ji == nil {
+		// This isn't an internal server error. There's just no instance installed.
 		return nil, http.StatusOK, nil
 	}
 
-	// We will only send webhook events if we have a connected instance.
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	watchers, err := p.getWatcherNotifications(ji, wh)
 	if err != nil {
-		// This isn't an internal server error. There's just no instance installed.
+		p.errorf("PostNotifications: failed to get the watchers of %s, err: %v", wh.Issue.Key, err)
+	}
+	personal, err := p.getPersonalNotifications(ji, wh)
+	if err != nil {
+		p.errorf("PostNotifications: failed to get personal subscriptions, err: %v", err)
+	}
+	// The first notification for a user is the one they get, so the direct
+	// notifications come first, then the watchers, then the personal
+	// subscriptions.
+	notifications := append(wh.notifications[:len(wh.notifications):len(wh.notifications)], watchers...)
+	notifications = append(notifications, personal...)
+	if len(notifications) == 0 {
 		return nil, http.StatusOK, nil
 	}
 
+	projectKey := ""
+	if wh.Issue.Fields != nil {
+		projectKey = wh.Issue.Fields.Project.Key
+	}
+
 	posts := []*model.Post{}
-	for _, notification := range wh.notifications {
-		var mattermostUserId string
+	notified := NewStringSet()
+	for _, notification := range notifications {
+		mattermostUserId := notification.mattermostUserId
 		var err error
 
 		// prefer accountId to username when looking up UserIds
-		if notification.jiraAccountID != "" {
+		switch {
+		case mattermostUserId != "":
+		case notification.jiraAccountID != "":
 			mattermostUserId, err = p.userStore.LoadMattermostUserId(ji, notification.jiraAccountID)
-		} else {
+		default:
 			mattermostUserId, err = p.userStore.LoadMattermostUserId(ji, notification.jiraUsername)
 		}
 		if err != nil {
 			continue
 		}
 
+		// Users get at most one DM per event.
+		if notified.ContainsAny(mattermostUserId) {
+			continue
+		}
+
 		// Check if the user has permissions.
 		jiraUser, err2 := p.userStore.LoadJIRAUser(ji, mattermostUserId)
 		if err2 != nil {
 			// Not connected to Jira, so can't check permissions
 			continue
 		}
+		// Don't notify users of their own changes.
+		if wh.isAuthor(&jiraUser.User) {
+			continue
+		}
+		if !jiraUser.Settings.wantsNotification(notification.event, projectKey) {
+			continue
+		}
+		notified = notified.Add(mattermostUserId)
 		client, err2 := ji.GetClient(jiraUser)
 		if err2 != nil {
 			p.errorf("PostNotifications: error while getting jiraClient, err: %v", err2)
@@ -143,6 +212,17 @@ func (wh *webhook) PostNotifications(p *Plugin) ([]*model.Post, int, error) {
 			continue
 		}
 
+		if notification.watching {
+			watching, err := isWatching(client, wh.Issue.Key, jiraUser)
+			if err != nil {
+				p.errorf("PostNotifications: failed to get watchers: %v", err)
+				continue
+			}
+			if !watching {
+				continue
+			}
+		}
+
 		notification.message = replaceJiraAccountIds(ji, notification.message)
 
 		post, err := ji.GetPlugin().CreateBotDMPost(ji, mattermostUserId, notification.message, notification.postType)
@@ -155,6 +235,68 @@ func (wh *webhook) PostNotifications(p *Plugin) ([]*model.Post, int, error) {
 	return posts, http.StatusOK, nil
 }
 
+// getWatcherNotifications returns the notifications for the watchers of
+// the issue of wh, if it notifies them.
+func (p *Plugin) getWatcherNotifications(ji Instance, wh *webhook) ([]webhookNotification, error) {
+	if wh.watcherNotification == nil || wh.Issue.Key == "" {
+		return nil, nil
+	}
+	client, err := p.webhookClient(ji, wh)
+	if err != nil {
+		return nil, err
+	}
+	watchers, err := getWatchers(client, wh.Issue.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := []webhookNotification{}
+	for i := range watchers {
+		if wh.isAuthor(&watchers[i]) {
+			continue
+		}
+		notification := *wh.watcherNotification
+		notification.jiraUsername = watchers[i].Name
+		notification.jiraAccountID = watchers[i].AccountID
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+// errWebhookAuthorNotConnected is returned by webhookClient when there is no
+// client to read the issue of an event.
+var errWebhookAuthorNotConnected = errors.New("the author of the event is not connected to Mattermost")
+
+// webhookClient returns a client to read the issue of wh: the client of the
+// author of the event if they are connected, or the bot client on Jira
+// Cloud.
+func (p *Plugin) webhookClient(ji Instance, wh *webhook) (Client, error) {
+	author := wh.author()
+	key := author.AccountID
+	if key == "" {
+		key = author.Name
+	}
+	if key != "" {
+		mattermostUserId, err := p.userStore.LoadMattermostUserId(ji, key)
+		if err == nil {
+			jiraUser, err := p.userStore.LoadJIRAUser(ji, mattermostUserId)
+			if err == nil {
+				return ji.GetClient(jiraUser)
+			}
+		}
+	}
+
+	jci, ok := ji.(*jiraCloudInstance)
+	if !ok {
+		return nil, errWebhookAuthorNotConnected
+	}
+	jiraClient, err := jci.getJIRAClientForBot()
+	if err != nil {
+		return nil, err
+	}
+	return newCloudClient(jiraClient), nil
+}
+
 func newWebhook(jwh *JiraWebhook, eventType string, format string, args ...interface{}) *webhook {
 	return &webhook{
 		JiraWebhook: jwh,
@@ -163,7 +305,7 @@ func newWebhook(jwh *JiraWebhook, eventType string, format string, args ...inter
 	}
 }
 
-func (p *Plugin) GetWebhookURL(teamId, channelId string) (string, error) {
+func (p *Plugin) GetWebhookURL(instanceURL, teamId, channelId string) (string, error) {
 	cf := p.getConfig()
 
 	team, appErr := p.API.GetTeam(teamId)
@@ -181,5 +323,16 @@ func (p *Plugin) GetWebhookURL(teamId, channelId string) (string, error) {
 	v.Add("secret", secret)
 	v.Add("team", team.Name)
 	v.Add("channel", channel.Name)
+	v.Add(argInstanceURL, instanceURL)
 	return p.GetPluginURL() + routeIncomingWebhook + "?" + v.Encode(), nil
 }
+
+// GetSubscriptionsWebhookURL returns the URL of the webhook that Jira sends
+// all its events to, for the channel subscriptions of an instance.
+func (p *Plugin) GetSubscriptionsWebhookURL(instanceURL string) string {
+	v := url.Values{}
+	secret, _ := url.QueryUnescape(p.getConfig().Secret)
+	v.Add("secret", secret)
+	v.Add(argInstanceURL, instanceURL)
+	return p.GetPluginURL() + routeAPISubscribeWebhook + "?" + v.Encode()
+}
@@ -20,6 +20,8 @@ const (
 	PostTypeComment  = "custom_jira_comment"
 	PostTypeMention  = "custom_jira_mention"
 	PostTypeAssigned = "custom_jira_assigned"
+	PostTypeReporter = "custom_jira_reporter"
+	PostTypeWatcher  = "custom_jira_watcher"
 )
 
 // The keys listed here can be used in the Jira webhook URL to control what events
@@ -117,8 +119,10 @@ func httpWebhook(p *Plugin, w http.ResponseWriter, r *http.Request) (status int,
 		return http.StatusOK, nil
 	}
 
-	// Post the event to the channel
-	_, statusCode, err := wh.PostToChannel(p, channel.Id, p.getUserID())
+	// Post the event to the channel. If the instance is not installed, the
+	// event is still posted, just without resolving Jira account IDs.
+	ji, _ := p.loadInstance(r.FormValue(argInstanceURL))
+	_, statusCode, err := wh.PostToChannel(p, ji, channel.Id, p.getUserID())
 	if err != nil {
 		return respondErr(w, statusCode, err)
 	}
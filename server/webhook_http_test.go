@@ -39,15 +39,15 @@ func (wh testWebhookWrapper) Events() StringSet {
 	return wh.Webhook.Events()
 }
 
-func (wh *testWebhookWrapper) PostToChannel(p *Plugin, channelId, fromUserId string) (*model.Post, int, error) {
-	post, status, err := wh.Webhook.PostToChannel(p, channelId, fromUserId)
+func (wh *testWebhookWrapper) PostToChannel(p *Plugin, ji Instance, channelId, fromUserId string) (*model.Post, int, error) {
+	post, status, err := wh.Webhook.PostToChannel(p, ji, channelId, fromUserId)
 	if post != nil {
 		wh.postedToChannel = post
 	}
 	return post, status, err
 }
-func (wh *testWebhookWrapper) PostNotifications(p *Plugin) ([]*model.Post, int, error) {
-	posts, status, err := wh.Webhook.PostNotifications(p)
+func (wh *testWebhookWrapper) PostNotifications(p *Plugin, ji Instance) ([]*model.Post, int, error) {
+	posts, status, err := wh.Webhook.PostNotifications(p, ji)
 	if len(posts) != 0 {
 		wh.postedNotifications = append(wh.postedNotifications, posts...)
 	}
@@ -335,7 +335,7 @@ func TestWebhookHTTP(t *testing.T) {
 			Request:         testWebhookRequest("webhook-issue-comment-created-indentation.json"),
 			ExpectedSlackAttachment: true,
 			ExpectedHeadline: "User **commented** on story [TEST-4: unit testing](http://localhost:8082/browse/TEST-4)",
-			ExpectedText:     "> [~Test] creating a test comment\r\n> \r\n> a second line for the test comment",
+			ExpectedText:     "> [~Test] creating a test comment\n> \n> a second line for the test comment",
 			CurrentInstance:  true,
 		},
 		"SERVER (old version) issue commented (no issue_event_type_name)": {
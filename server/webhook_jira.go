@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	"github.com/andygrunwald/go-jira"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/markdown"
 )
 
 type JiraWebhook struct {
@@ -29,6 +31,23 @@ type JiraWebhook struct {
 	IssueEventTypeName string `json:"issue_event_type_name"`
 }
 
+// author returns the user who made the change, or wrote the comment, of the
+// event.
+func (jwh *JiraWebhook) author() *jira.User {
+	if jwh.User.Name != "" || jwh.User.AccountID != "" {
+		return &jwh.User
+	}
+	return &jwh.Comment.UpdateAuthor
+}
+
+// isAuthor returns whether user made the change, or wrote the comment, of the
+// event. Jira Server identifies users by name, Jira Cloud by account id.
+func (jwh *JiraWebhook) isAuthor(user *jira.User) bool {
+	author := jwh.author()
+	return (user.Name != "" && user.Name == author.Name) ||
+		(user.AccountID != "" && user.AccountID == author.AccountID)
+}
+
 func (jwh *JiraWebhook) mdJiraLink(title, suffix string) string {
 	// Use Self URL only to extract the full hostname from it
 	pos := strings.LastIndex(jwh.Issue.Self, "/rest/api")
@@ -39,7 +58,11 @@ func (jwh *JiraWebhook) mdJiraLink(title, suffix string) string {
 }
 
 func (jwh *JiraWebhook) mdIssueDescription() string {
-	return truncate(jwh.Issue.Fields.Description, 3000)
+	return markdown.Truncate(markdown.FromJira(jwh.Issue.Fields.Description), 3000)
+}
+
+func (jwh *JiraWebhook) mdComment() string {
+	return quoteIssueComment(markdown.Truncate(markdown.FromJira(jwh.Comment.Body), 3000))
 }
 
 func (jwh *JiraWebhook) mdIssueSummary() string {
@@ -75,12 +98,7 @@ func (jwh *JiraWebhook) mdIssueType() string {
 	return strings.ToLower(jwh.Issue.Fields.Type.Name)
 }
 
-func (jwh *JiraWebhook) expandIssue(p *Plugin) error {
-	ji, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
-	if err != nil {
-		return err
-	}
-
+func (jwh *JiraWebhook) expandIssue(p *Plugin, ji Instance) error {
 	// Jira Cloud comment event. We need to fetch issue data because it is not expanded in webhook payload.
 	isCommentEvent := jwh.WebhookEvent == "comment_created" || jwh.WebhookEvent == "comment_updated" || jwh.WebhookEvent == "comment_deleted"
 	if isCommentEvent && ji.GetType() == "cloud" {
@@ -91,6 +109,25 @@ func (jwh *JiraWebhook) expandIssue(p *Plugin) error {
 		jwh.Issue = *issue
 	}
 
+	// Without a bot, the issue can only be read by the author of the
+	// comment, if they are connected. Otherwise the event is processed with
+	// the fields of the issue it has.
+	if isCommentEvent && ji.GetType() == JIRATypeCloudOAuth {
+		client, err := p.webhookClient(ji, &webhook{JiraWebhook: jwh})
+		if err == errWebhookAuthorNotConnected {
+			p.infof("expandIssue: issue %s of the %s event not expanded: %v", jwh.Issue.ID, jwh.WebhookEvent, err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		issue, err := client.GetIssue(jwh.Issue.ID, nil)
+		if err != nil {
+			return err
+		}
+		jwh.Issue = *issue
+	}
+
 	return nil
 }
 
@@ -14,6 +14,8 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/markdown"
 )
 
 var webhookWrapperFunc func(wh Webhook) Webhook
@@ -138,6 +140,12 @@ func parseWebhookChangeLog(jwh *JiraWebhook) Webhook {
 			event = parseWebhookResolved(jwh, to)
 		case field == "status":
 			event = parseWebhookUpdatedField(jwh, eventUpdatedStatus, field, fieldId, fromWithDefault, toWithDefault)
+			appendNotificationForReporter(event,
+				fmt.Sprintf("%s **transitioned** %s, that you reported, from %s to %s", jwh.mdUser(), jwh.mdKeySummaryLink(), fromWithDefault, toWithDefault),
+				notifyStatus)
+			setNotificationForWatchers(event,
+				fmt.Sprintf("%s **transitioned** %s, that you watch, from %s to %s", jwh.mdUser(), jwh.mdKeySummaryLink(), fromWithDefault, toWithDefault),
+				notifyStatus)
 		case field == "priority":
 			event = parseWebhookUpdatedField(jwh, eventUpdatedPriority, field, fieldId, fromWithDefault, toWithDefault)
 		case field == "summary":
@@ -242,11 +250,18 @@ func parseWebhookCommentCreated(jwh *JiraWebhook) (Webhook, error) {
 		JiraWebhook: jwh,
 		eventTypes:  NewStringSet(eventCreatedComment),
 		headline:    fmt.Sprintf("%s **commented** on %s", commentAuthor, jwh.mdKeySummaryLink()),
-		text:        truncate(quoteIssueComment(jwh.Comment.Body), 3000),
+		text:        jwh.mdComment(),
 	}
 
 	appendCommentNotifications(wh, "**mentioned** you in a new comment on")
 
+	appendNotificationForReporter(wh,
+		fmt.Sprintf("%s **commented** on %s, that you reported:\n%s", commentAuthor, jwh.mdKeySummaryLink(), jwh.mdComment()),
+		notifyCommented)
+	setNotificationForWatchers(wh,
+		fmt.Sprintf("%s **commented** on %s, that you watch:\n%s", commentAuthor, jwh.mdKeySummaryLink(), jwh.mdComment()),
+		notifyCommented)
+
 	return wh, nil
 }
 
@@ -256,7 +271,7 @@ func appendCommentNotifications(wh *webhook, verb string) {
 	commentAuthor := mdUser(&jwh.Comment.UpdateAuthor)
 
 	message := fmt.Sprintf("%s %s %s:\n%s",
-		commentAuthor, verb, jwh.mdKeySummaryLink(), quoteIssueComment(jwh.Comment.Body))
+		commentAuthor, verb, jwh.mdKeySummaryLink(), jwh.mdComment())
 	assigneeMentioned := false
 
 	for _, u := range parseJIRAUsernamesFromText(wh.Comment.Body) {
@@ -280,6 +295,7 @@ func appendCommentNotifications(wh *webhook, verb string) {
 			message:     message,
 			postType:    PostTypeMention,
 			commentSelf: jwh.Comment.Self,
+			event:       notifyMentioned,
 		}
 
 		if isAccountId {
@@ -302,9 +318,10 @@ func appendCommentNotifications(wh *webhook, verb string) {
 	wh.notifications = append(wh.notifications, webhookNotification{
 		jiraUsername:  jwh.Issue.Fields.Assignee.Name,
 		jiraAccountID: jwh.Issue.Fields.Assignee.AccountID,
-		message:       fmt.Sprintf("%s **commented** on %s:\n>%s", commentAuthor, jwh.mdKeySummaryLink(), jwh.Comment.Body),
+		message:       fmt.Sprintf("%s **commented** on %s:\n%s", commentAuthor, jwh.mdKeySummaryLink(), jwh.mdComment()),
 		postType:      PostTypeComment,
 		commentSelf:   jwh.Comment.Self,
+		event:         notifyCommented,
 	})
 }
 
@@ -344,7 +361,7 @@ func parseWebhookCommentUpdated(jwh *JiraWebhook) (Webhook, error) {
 		JiraWebhook: jwh,
 		eventTypes:  NewStringSet(eventUpdatedComment),
 		headline:    fmt.Sprintf("%s **edited comment** in %s", mdUser(&jwh.Comment.UpdateAuthor), jwh.mdKeySummaryLink()),
-		text:        truncate(quoteIssueComment(jwh.Comment.Body), 3000),
+		text:        jwh.mdComment(),
 	}
 
 	appendCommentNotifications(wh, "**mentioned** you in a comment update on")
@@ -385,9 +402,43 @@ func appendNotificationForAssignee(wh *webhook) {
 		jiraUsername:  jwh.Issue.Fields.Assignee.Name,
 		jiraAccountID: jwh.Issue.Fields.Assignee.AccountID,
 		message:       fmt.Sprintf("%s **assigned** you to %s", jwh.mdUser(), jwh.mdKeySummaryLink()),
+		event:         notifyAssigned,
+	})
+}
+
+// appendNotificationForReporter modifies wh
+func appendNotificationForReporter(wh *webhook, message, event string) {
+	jwh := wh.JiraWebhook
+	if jwh.Issue.Fields == nil || jwh.Issue.Fields.Reporter == nil {
+		return
+	}
+
+	// Don't notify the reporter of their own changes.
+	if jwh.isAuthor(jwh.Issue.Fields.Reporter) {
+		return
+	}
+
+	wh.notifications = append(wh.notifications, webhookNotification{
+		jiraUsername:  jwh.Issue.Fields.Reporter.Name,
+		jiraAccountID: jwh.Issue.Fields.Reporter.AccountID,
+		message:       message,
+		postType:      PostTypeReporter,
+		commentSelf:   jwh.Comment.Self,
+		event:         event,
 	})
 }
 
+// setNotificationForWatchers modifies wh. The notification is sent to each
+// watcher of the issue, once they are fetched.
+func setNotificationForWatchers(wh *webhook, message, event string) {
+	wh.watcherNotification = &webhookNotification{
+		message:     message,
+		postType:    PostTypeWatcher,
+		commentSelf: wh.Comment.Self,
+		event:       event,
+	}
+}
+
 func parseWebhookReopened(jwh *JiraWebhook, from string) *webhook {
 	wh := newWebhook(jwh, eventUpdatedReopened, "**reopened**")
 	wh.fieldInfo = webhookField{"reopened", "resolution", from, "Open"}
@@ -408,8 +459,8 @@ func parseWebhookUpdatedField(jwh *JiraWebhook, eventType string, field, fieldId
 
 func parseWebhookUpdatedDescription(jwh *JiraWebhook, from, to string) *webhook {
 	wh := newWebhook(jwh, eventUpdatedDescription, "**edited** the description of")
-	fromFmttd := "\n**From:** " + truncate(from, 500)
-	toFmttd := "\n**To:** " + truncate(to, 500)
+	fromFmttd := "\n**From:** " + markdown.Truncate(markdown.FromJira(from), 500)
+	toFmttd := "\n**To:** " + markdown.Truncate(markdown.FromJira(to), 500)
 	wh.fieldInfo = webhookField{"description", "description", fromFmttd, toFmttd}
 	wh.text = jwh.mdIssueDescription()
 	return wh
@@ -437,6 +488,10 @@ func mergeWebhookEvents(events []*webhook) Webhook {
 
 	for _, event := range events {
 		merged.eventTypes = merged.eventTypes.Union(event.eventTypes)
+		merged.notifications = append(merged.notifications, event.notifications...)
+		if event.watcherNotification != nil {
+			merged.watcherNotification = event.watcherNotification
+		}
 		strikePre := "~~"
 		strikePost := "~~"
 		if event.fieldInfo.name == "description" || strings.HasPrefix(event.fieldInfo.from, "~~") {
@@ -0,0 +1,197 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	// WebhookMaxAttempts is how many times a webhook event is processed before
+	// it is moved to the dead letters.
+	WebhookMaxAttempts = 5
+
+	// Retries are delayed exponentially, starting with WebhookRetryDelay.
+	WebhookRetryDelay    = 30 * time.Second
+	WebhookRetryMaxDelay = time.Hour
+
+	// How often the queue is scanned for events due for a retry, or left
+	// behind by a restart or another server.
+	WebhookRetryInterval = 30 * time.Second
+
+	// How long a server may keep a webhook event to itself before it is
+	// considered lost, and is reprocessed.
+	WebhookClaimDuration = 5 * time.Minute
+)
+
+// webhookMessage is a subscription webhook event waiting to be processed,
+// along with the URL of the Jira instance it came from. It is persisted in
+// the KV store until it is processed successfully, or dead-lettered.
+type webhookMessage struct {
+	Id          string    `json:"id"`
+	InstanceURL string    `json:"instance_url"`
+	Data        []byte    `json:"data"`
+	Created     time.Time `json:"created"`
+
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+
+	// Progress of partially successful attempts, so that retries do not
+	// post duplicates.
+	NotificationsPosted   bool      `json:"notifications_posted,omitempty"`
+	PostedChannelIds      StringSet `json:"posted_channel_ids,omitempty"`
+	DigestSubscriptionIds StringSet `json:"digest_subscription_ids,omitempty"`
+	CommentsSynced        bool      `json:"comments_synced,omitempty"`
+
+	// When the message was handed to the workers, not persisted.
+	queuedAt time.Time
+}
+
+// webhookPermanentError is an error that retrying will not fix, such as a
+// malformed payload. The message is dead-lettered immediately.
+type webhookPermanentError struct {
+	error
+}
+
+func newWebhookMessage(instanceURL string, data []byte) *webhookMessage {
+	return &webhookMessage{
+		Id:          model.NewId(),
+		InstanceURL: instanceURL,
+		Data:        data,
+		Created:     time.Now(),
+	}
+}
+
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := WebhookRetryDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= WebhookRetryMaxDelay {
+			return WebhookRetryMaxDelay
+		}
+	}
+	return delay
+}
+
+// queueWebhookMessage hands msg to the webhook workers, unless they are
+// too busy. The messages that don't fit in the queue are counted in the
+// stats, as errors if lost is set.
+func (p *Plugin) queueWebhookMessage(msg *webhookMessage, lost bool) bool {
+	msg.queuedAt = time.Now()
+	select {
+	case p.webhookPool.getQueue() <- msg:
+		return true
+	default:
+		p.recordWebhookStat("jira/subscribe/queue_full", msg, time.Time{}, lost, !lost)
+		return false
+	}
+}
+
+// enqueueWebhookMessage persists msg, and hands it to the webhook workers. It
+// returns false if msg could neither be persisted nor queued.
+func (p *Plugin) enqueueWebhookMessage(msg *webhookMessage) bool {
+	msg.NextAttempt = time.Now().Add(WebhookClaimDuration)
+	err := p.webhookQueueStore.StoreWebhookMessage(msg)
+	if err != nil {
+		p.errorf("Failed to persist webhook message %s, err: %v", msg.Id, err)
+		return p.queueWebhookMessage(msg, true)
+	}
+
+	if !p.queueWebhookMessage(msg, false) {
+		// The workers are busy; release the message so that it is picked up
+		// from the store on the next retry pass.
+		msg.NextAttempt = time.Time{}
+		err = p.webhookQueueStore.StoreWebhookMessage(msg)
+		if err != nil {
+			p.errorf("Failed to release webhook message %s, err: %v", msg.Id, err)
+		}
+	}
+	return true
+}
+
+// finishWebhookMessage removes a successfully processed message from the
+// store, or schedules it for a retry, or moves it to the dead letters.
+func (p *Plugin) finishWebhookMessage(msg *webhookMessage, processErr error) {
+	if processErr == nil {
+		err := p.webhookQueueStore.DeleteWebhookMessage(msg.Id)
+		if err != nil {
+			p.errorf("Failed to delete processed webhook message %s, err: %v", msg.Id, err)
+		}
+		return
+	}
+
+	msg.Attempts++
+	msg.LastError = processErr.Error()
+
+	_, permanent := processErr.(*webhookPermanentError)
+	if !permanent && msg.Attempts < WebhookMaxAttempts {
+		msg.NextAttempt = time.Now().Add(webhookRetryDelay(msg.Attempts))
+		err := p.webhookQueueStore.StoreWebhookMessage(msg)
+		if err != nil {
+			p.errorf("Failed to schedule a retry of webhook message %s, err: %v", msg.Id, err)
+		}
+		return
+	}
+
+	msg.NextAttempt = time.Time{}
+	err := p.webhookQueueStore.StoreDeadWebhookMessage(msg)
+	if err != nil {
+		p.errorf("Failed to store dead webhook message %s, err: %v", msg.Id, err)
+		return
+	}
+	err = p.webhookQueueStore.DeleteWebhookMessage(msg.Id)
+	if err != nil {
+		p.errorf("Failed to delete dead webhook message %s from the queue, err: %v", msg.Id, err)
+	}
+}
+
+// retryWebhookMessages queues the persisted messages that are due for
+// processing: scheduled retries, and messages left behind by a restart or by
+// another server.
+func (p *Plugin) retryWebhookMessages() {
+	msgs, err := p.webhookQueueStore.LoadWebhookMessages()
+	if err != nil {
+		p.errorf("Failed to load webhook messages to retry, err: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, msg := range msgs {
+		if msg.NextAttempt.After(now) {
+			continue
+		}
+		claimed, err := p.webhookQueueStore.ClaimWebhookMessage(msg.Id, now.Add(WebhookClaimDuration))
+		if err != nil {
+			p.errorf("%v", err)
+			continue
+		}
+		if claimed == nil {
+			continue
+		}
+
+		if !p.queueWebhookMessage(claimed, false) {
+			// Still busy, the claim expires and the message is retried later.
+			return
+		}
+	}
+}
+
+// replayDeadWebhookMessage moves a dead-lettered message back to the queue.
+func (p *Plugin) replayDeadWebhookMessage(id string) error {
+	msg, err := p.webhookQueueStore.LoadDeadWebhookMessage(id)
+	if err != nil {
+		return err
+	}
+
+	msg.Attempts = 0
+	if !p.enqueueWebhookMessage(msg) {
+		return errors.Errorf("failed to queue webhook message %q", id)
+	}
+	return p.webhookQueueStore.DeleteDeadWebhookMessage(id)
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/expvar"
+)
+
+type mockWebhookQueueStore struct {
+	queued map[string]*webhookMessage
+	dead   map[string]*webhookMessage
+}
+
+func newMockWebhookQueueStore() *mockWebhookQueueStore {
+	return &mockWebhookQueueStore{
+		queued: map[string]*webhookMessage{},
+		dead:   map[string]*webhookMessage{},
+	}
+}
+
+func (store *mockWebhookQueueStore) StoreWebhookMessage(msg *webhookMessage) error {
+	stored := *msg
+	store.queued[msg.Id] = &stored
+	return nil
+}
+
+func (store *mockWebhookQueueStore) ClaimWebhookMessage(id string, until time.Time) (*webhookMessage, error) {
+	msg := store.queued[id]
+	if msg == nil || msg.NextAttempt.After(time.Now()) {
+		return nil, nil
+	}
+	msg.NextAttempt = until
+	stored := *msg
+	return &stored, nil
+}
+
+func (store *mockWebhookQueueStore) DeleteWebhookMessage(id string) error {
+	delete(store.queued, id)
+	return nil
+}
+
+func (store *mockWebhookQueueStore) LoadWebhookMessages() ([]*webhookMessage, error) {
+	msgs := []*webhookMessage{}
+	for _, msg := range store.queued {
+		stored := *msg
+		msgs = append(msgs, &stored)
+	}
+	return msgs, nil
+}
+
+func (store *mockWebhookQueueStore) StoreDeadWebhookMessage(msg *webhookMessage) error {
+	stored := *msg
+	store.dead[msg.Id] = &stored
+	return nil
+}
+
+func (store *mockWebhookQueueStore) LoadDeadWebhookMessage(id string) (*webhookMessage, error) {
+	msg := store.dead[id]
+	if msg == nil {
+		return nil, errors.New("not found")
+	}
+	stored := *msg
+	return &stored, nil
+}
+
+func (store *mockWebhookQueueStore) DeleteDeadWebhookMessage(id string) error {
+	delete(store.dead, id)
+	return nil
+}
+
+func (store *mockWebhookQueueStore) LoadDeadWebhookMessages() ([]*webhookMessage, error) {
+	msgs := []*webhookMessage{}
+	for _, msg := range store.dead {
+		stored := *msg
+		msgs = append(msgs, &stored)
+	}
+	return msgs, nil
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	assert.Equal(t, WebhookRetryDelay, webhookRetryDelay(1))
+	assert.Equal(t, 2*WebhookRetryDelay, webhookRetryDelay(2))
+	assert.Equal(t, 4*WebhookRetryDelay, webhookRetryDelay(3))
+	assert.Equal(t, WebhookRetryMaxDelay, webhookRetryDelay(100))
+}
+
+func TestWebhookQueue(t *testing.T) {
+	store := newMockWebhookQueueStore()
+	p := &Plugin{
+		webhookQueueStore: store,
+	}
+	p.webhookPool = newWebhookWorkerPool(p)
+	p.webhookPool.resize(0, 1)
+
+	msg := newWebhookMessage(mockCurrentInstanceURL, []byte("{}"))
+	require.True(t, p.enqueueWebhookMessage(msg))
+	require.Contains(t, store.queued, msg.Id)
+	assert.True(t, store.queued[msg.Id].NextAttempt.After(time.Now()), "queued message must be claimed")
+
+	// The buffer is full, the message is persisted and released for a retry.
+	overflow := newWebhookMessage(mockCurrentInstanceURL, []byte("{}"))
+	require.True(t, p.enqueueWebhookMessage(overflow))
+	require.Contains(t, store.queued, overflow.Id)
+	assert.True(t, store.queued[overflow.Id].NextAttempt.IsZero())
+
+	queued := <-p.webhookPool.getQueue()
+	require.Equal(t, msg.Id, queued.Id)
+
+	p.retryWebhookMessages()
+	retried := <-p.webhookPool.getQueue()
+	require.Equal(t, overflow.Id, retried.Id)
+
+	// Failures are retried until WebhookMaxAttempts.
+	for i := 1; i < WebhookMaxAttempts; i++ {
+		p.finishWebhookMessage(queued, errors.New("failed to post"))
+		require.Contains(t, store.queued, queued.Id)
+		assert.Equal(t, i, store.queued[queued.Id].Attempts)
+		assert.True(t, store.queued[queued.Id].NextAttempt.After(time.Now()))
+		p.retryWebhookMessages()
+		assert.Len(t, p.webhookPool.getQueue(), 0, "retry is not due yet")
+	}
+	p.finishWebhookMessage(queued, errors.New("failed to post"))
+	assert.NotContains(t, store.queued, queued.Id)
+	require.Contains(t, store.dead, queued.Id)
+	assert.Equal(t, "failed to post", store.dead[queued.Id].LastError)
+
+	// Permanent errors are not retried.
+	p.finishWebhookMessage(retried, &webhookPermanentError{errors.New("malformed")})
+	assert.NotContains(t, store.queued, retried.Id)
+	require.Contains(t, store.dead, retried.Id)
+	assert.Equal(t, 1, store.dead[retried.Id].Attempts)
+
+	// Replayed messages start over.
+	require.Nil(t, p.replayDeadWebhookMessage(queued.Id))
+	assert.NotContains(t, store.dead, queued.Id)
+	replayed := <-p.webhookPool.getQueue()
+	assert.Equal(t, queued.Id, replayed.Id)
+	assert.Equal(t, 0, replayed.Attempts)
+
+	p.finishWebhookMessage(replayed, nil)
+	assert.NotContains(t, store.queued, replayed.Id)
+}
+
+func TestWebhookQueueIndex(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	store := NewStore(p)
+
+	kv := map[string][]byte{}
+	api.On("KVGet", mock.Anything).Return(func(key string) []byte {
+		return kv[key]
+	}, nil)
+	api.On("KVSet", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		kv[args.String(0)] = args.Get(1).([]byte)
+	}).Return(nil)
+	// With failIndex, the index can't be updated, as in a burst of updates.
+	failIndex := false
+	api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, oldValue, newValue []byte) bool {
+		if failIndex && strings.HasPrefix(key, keyWebhookQueueIndex+"_") {
+			return false
+		}
+		kv[key] = newValue
+		return true
+	}, nil)
+	api.On("KVDelete", mock.Anything).Run(func(args mock.Arguments) {
+		delete(kv, args.String(0))
+	}).Return(nil)
+	scans := 0
+	api.On("KVList", mock.Anything, mock.Anything).Return(func(page, perPage int) []string {
+		if page == 0 {
+			scans++
+		}
+		keys := []string{}
+		for key := range kv {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if page*perPage >= len(keys) {
+			return []string{}
+		}
+		keys = keys[page*perPage:]
+		if len(keys) > perPage {
+			keys = keys[:perPage]
+		}
+		return keys
+	}, nil)
+
+	// A message queued before the index existed, and an unrelated key.
+	old := newWebhookMessage(mockCurrentInstanceURL, []byte("{}"))
+	data, err := json.Marshal(old)
+	require.Nil(t, err)
+	kv[prefixWebhookQueue+old.Id] = data
+	kv["unrelated"] = []byte("{}")
+
+	msgs, err := store.LoadWebhookMessages()
+	require.Nil(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, old.Id, msgs[0].Id)
+
+	msg := newWebhookMessage(mockCurrentInstanceURL, []byte("{}"))
+	require.Nil(t, store.StoreWebhookMessage(msg))
+	msgs, err = store.LoadWebhookMessages()
+	require.Nil(t, err)
+	require.Len(t, msgs, 2)
+
+	require.Nil(t, store.DeleteWebhookMessage(old.Id))
+	msgs, err = store.LoadWebhookMessages()
+	require.Nil(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, msg.Id, msgs[0].Id)
+	key := prefixWebhookQueue + msg.Id
+	assert.Equal(t, `["`+key+`"]`, string(kv[webhookQueueIndex.shardKey(key)]))
+
+	// The KV store is only scanned once, to build the index.
+	assert.Equal(t, 1, scans)
+
+	// A message that fails to be indexed is found by a scan.
+	failIndex = true
+	failed := newWebhookMessage(mockCurrentInstanceURL, []byte("{}"))
+	require.Nil(t, store.StoreWebhookMessage(failed))
+	failIndex = false
+	msgs, err = store.LoadWebhookMessages()
+	require.Nil(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, 2, scans)
+	msgs, err = store.LoadWebhookMessages()
+	require.Nil(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, 2, scans)
+}
+
+func TestParseWebhookPoolSettings(t *testing.T) {
+	procs, size, err := parseWebhookPoolSettings("", " ")
+	require.Nil(t, err)
+	assert.Equal(t, DefaultWebhookMaxProcsPerServer, procs)
+	assert.Equal(t, DefaultWebhookBufferSize, size)
+
+	procs, size, err = parseWebhookPoolSettings("5", "100")
+	require.Nil(t, err)
+	assert.Equal(t, 5, procs)
+	assert.Equal(t, 100, size)
+
+	for _, tc := range [][2]string{{"0", ""}, {"many", ""}, {"1000", ""}, {"", "-1"}, {"", "1000000"}} {
+		_, _, err = parseWebhookPoolSettings(tc[0], tc[1])
+		assert.NotNil(t, err, tc)
+	}
+}
+
+func TestWebhookWorkerPoolResize(t *testing.T) {
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {
+		conf.stats = expvar.NewUnpublishedStats(nil)
+	})
+	p.webhookPool = newWebhookWorkerPool(p)
+	p.webhookPool.resize(0, 2)
+
+	require.True(t, p.queueWebhookMessage(newWebhookMessage(mockCurrentInstanceURL, []byte("{}")), false))
+	require.True(t, p.queueWebhookMessage(newWebhookMessage(mockCurrentInstanceURL, []byte("{}")), false))
+	require.False(t, p.queueWebhookMessage(newWebhookMessage(mockCurrentInstanceURL, []byte("{}")), false))
+	full := p.getConfig().stats.EnsureEndpoint("jira/subscribe/queue_full").Get()
+	assert.Equal(t, int64(1), full.Total)
+	assert.Equal(t, int64(1), full.Ignored)
+	assert.Equal(t, int64(0), full.Errors)
+
+	// The messages waiting in the old queue are moved to the new one.
+	p.webhookPool.resize(0, 5)
+	assert.Eventually(t, func() bool {
+		queued, bufferSize, _, _ := p.webhookPool.status()
+		return queued == 2 && bufferSize == 5
+	}, time.Second, 10*time.Millisecond)
+	<-p.webhookPool.getQueue()
+	<-p.webhookPool.getQueue()
+
+	p.webhookPool.resize(3, 5)
+	_, _, busy, maxProcs := p.webhookPool.status()
+	assert.Equal(t, 0, busy)
+	assert.Equal(t, 3, maxProcs)
+	p.webhookPool.resize(1, 5)
+	_, _, _, maxProcs = p.webhookPool.status()
+	assert.Equal(t, 1, maxProcs)
+	p.webhookPool.resize(0, 5)
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+// notificationsTestUserStore connects every Jira user, as "mm-" + their
+// name.
+type notificationsTestUserStore struct {
+	mockUserStore
+}
+
+func (store notificationsTestUserStore) LoadMattermostUserId(ji Instance, jiraUserName string) (string, error) {
+	return "mm-" + jiraUserName, nil
+}
+
+func (store notificationsTestUserStore) LoadJIRAUser(ji Instance, mattermostUserId string) (JIRAUser, error) {
+	user := JIRAUser{Settings: &UserSettings{Notifications: true}}
+	user.Name = strings.TrimPrefix(mattermostUserId, "mm-")
+	return user, nil
+}
+
+type notificationsTestInstance struct {
+	Instance
+}
+
+func (ji notificationsTestInstance) GetClient(jiraUser JIRAUser) (Client, error) {
+	return notificationsTestClient{}, nil
+}
+
+type notificationsTestClient struct {
+	testClient
+}
+
+func (client notificationsTestClient) RESTGet(endpoint string, params map[string]string, dest interface{}) error {
+	switch {
+	case endpoint == "2/issue/PRJX-14/watchers":
+		data := map[string][]jira.User{
+			"watchers": {{Name: "craig"}, {Name: "levbrouk"}, {Name: "watcher1"}},
+		}
+		b, _ := json.Marshal(data)
+		return json.Unmarshal(b, dest)
+	case strings.HasSuffix(endpoint, "/comment/10082"):
+		return nil
+	}
+	return errors.New("not found")
+}
+
+func TestParseWebhookReporterAndWatchers(t *testing.T) {
+	data, err := getJiraTestData("webhook-server-issue-updated-commented-1.json")
+	require.Nil(t, err)
+	w, err := ParseWebhook(data)
+	require.Nil(t, err)
+	wh := w.(*webhook)
+
+	require.Len(t, wh.notifications, 1)
+	assert.Equal(t, "craig", wh.notifications[0].jiraUsername)
+	assert.Equal(t, PostTypeReporter, wh.notifications[0].postType)
+	assert.Equal(t, notifyCommented, wh.notifications[0].event)
+	assert.Contains(t, wh.notifications[0].message, "that you reported")
+	require.NotNil(t, wh.watcherNotification)
+	assert.Equal(t, PostTypeWatcher, wh.watcherNotification.postType)
+
+	// The reporter transitioned their own issue.
+	data, err = getJiraTestData("webhook-server-issue-updated-in-progress.json")
+	require.Nil(t, err)
+	w, err = ParseWebhook(data)
+	require.Nil(t, err)
+	wh = w.(*webhook)
+	assert.Empty(t, wh.notifications)
+	require.NotNil(t, wh.watcherNotification)
+	assert.Equal(t, notifyStatus, wh.watcherNotification.event)
+}
+
+func TestPostNotificationsToWatchers(t *testing.T) {
+	data, err := getJiraTestData("webhook-server-issue-updated-commented-1.json")
+	require.Nil(t, err)
+	w, err := ParseWebhook(data)
+	require.Nil(t, err)
+	wh := w.(*webhook)
+
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.currentInstanceStore = mockCurrentInstanceStore{p}
+	p.userStore = notificationsTestUserStore{}
+	current, err := p.currentInstanceStore.LoadCurrentJIRAInstance()
+	require.Nil(t, err)
+	ji := notificationsTestInstance{current}
+
+	api.On("KVGet", mock.Anything).Return(nil, nil)
+	api.On("GetDirectChannel", mock.Anything, mock.Anything).Return(func(userId, botId string) *model.Channel {
+		return &model.Channel{Id: "dm-" + userId}
+	}, nil)
+	posts := map[string]string{}
+	api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+		post := args.Get(0).(*model.Post)
+		posts[post.ChannelId] = post.Type
+	}).Return(&model.Post{}, nil)
+
+	_, status, err := wh.PostNotifications(p, ji)
+	require.Nil(t, err)
+	assert.Equal(t, 200, status)
+
+	// The reporter, who also watches the issue, gets one DM, and the author
+	// of the comment none.
+	assert.Equal(t, map[string]string{
+		"dm-mm-craig":    PostTypeReporter,
+		"dm-mm-watcher1": PostTypeWatcher,
+	}, posts)
+}
+
+func TestGetSubscriptionsWebhookURL(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+	siteURL := "https://mm.example.com"
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	p.updateConfig(func(conf *config) {
+		conf.Secret = "some%20secret"
+	})
+
+	u, err := url.Parse(p.GetSubscriptionsWebhookURL(otherInstanceURL))
+	require.Nil(t, err)
+	assert.Equal(t, "/plugins/jira"+routeAPISubscribeWebhook, u.Path)
+	assert.Equal(t, "some secret", u.Query().Get("secret"))
+	assert.Equal(t, otherInstanceURL, u.Query().Get(argInstanceURL))
+}
@@ -4,28 +4,167 @@
 package main
 
 import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/mattermost/mattermost-plugin-jira/server/utils"
 )
 
+const (
+	DefaultWebhookMaxProcsPerServer = 20
+	DefaultWebhookBufferSize        = 10000
+
+	MaxWebhookMaxProcsPerServer = 200
+	MaxWebhookBufferSize        = 100000
+)
+
+// parseWebhookPoolSettings parses the WebhookMaxProcsPerServer and
+// WebhookBufferSize settings, empty for the defaults.
+func parseWebhookPoolSettings(maxProcs, bufferSize string) (int, int, error) {
+	procs, size := DefaultWebhookMaxProcsPerServer, DefaultWebhookBufferSize
+	var err error
+	if maxProcs = strings.TrimSpace(maxProcs); maxProcs != "" {
+		procs, err = strconv.Atoi(maxProcs)
+		if err != nil || procs < 1 || procs > MaxWebhookMaxProcsPerServer {
+			return 0, 0, errors.Errorf("the number of webhook workers must be between 1 and %d, not %q",
+				MaxWebhookMaxProcsPerServer, maxProcs)
+		}
+	}
+	if bufferSize = strings.TrimSpace(bufferSize); bufferSize != "" {
+		size, err = strconv.Atoi(bufferSize)
+		if err != nil || size < 1 || size > MaxWebhookBufferSize {
+			return 0, 0, errors.Errorf("the webhook buffer size must be between 1 and %d, not %q",
+				MaxWebhookBufferSize, bufferSize)
+		}
+	}
+	return procs, size, nil
+}
+
+// webhookWorkerPool is the queue of the webhook messages, and the workers
+// processing it. Both can be resized while the plugin runs.
+type webhookWorkerPool struct {
+	p *Plugin
+
+	lock  sync.Mutex
+	queue chan *webhookMessage
+	stops []chan struct{}
+
+	// The number of workers processing a message, updated atomically
+	busy int32
+}
+
+func newWebhookWorkerPool(p *Plugin) *webhookWorkerPool {
+	return &webhookWorkerPool{p: p}
+}
+
+// resize starts or stops workers to have maxProcs of them. If the buffer
+// size changes, the workers are restarted on a new queue, and the messages
+// waiting in the old one are moved to it.
+func (pool *webhookWorkerPool) resize(maxProcs, bufferSize int) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if pool.queue == nil || cap(pool.queue) != bufferSize {
+		old := pool.queue
+		pool.queue = make(chan *webhookMessage, bufferSize)
+		for _, stop := range pool.stops {
+			close(stop)
+		}
+		pool.stops = nil
+		if old != nil {
+			go drainWebhookQueue(old, pool.queue)
+		}
+	}
+
+	for len(pool.stops) > maxProcs {
+		last := len(pool.stops) - 1
+		close(pool.stops[last])
+		pool.stops = pool.stops[:last]
+	}
+	for len(pool.stops) < maxProcs {
+		stop := make(chan struct{})
+		go webhookWorker{len(pool.stops), pool.p, pool, pool.queue, stop}.work()
+		pool.stops = append(pool.stops, stop)
+	}
+}
+
+// drainWebhookQueue moves the messages of a replaced queue to the new one.
+// The messages that don't fit, or that are sent to the old queue after it is
+// drained, are persisted and claimed, so they are retried once their claim
+// expires.
+func drainWebhookQueue(from <-chan *webhookMessage, to chan<- *webhookMessage) {
+	for {
+		select {
+		case msg := <-from:
+			select {
+			case to <- msg:
+			default:
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (pool *webhookWorkerPool) getQueue() chan *webhookMessage {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return pool.queue
+}
+
+// status returns the length and capacity of the queue, and the number of
+// busy and total workers.
+func (pool *webhookWorkerPool) status() (queued, bufferSize, busy, maxProcs int) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return len(pool.queue), cap(pool.queue), int(atomic.LoadInt32(&pool.busy)), len(pool.stops)
+}
+
 type webhookWorker struct {
 	id        int
 	p         *Plugin
-	workQueue <-chan []byte
+	pool      *webhookWorkerPool
+	workQueue <-chan *webhookMessage
+	stop      <-chan struct{}
 }
 
 func (ww webhookWorker) work() {
-	for rawData := range ww.workQueue {
-		err := ww.process(rawData)
-		if err != nil {
-			ww.p.errorf("WebhookWorker id: %d, error processing, err: %v", ww.id, err)
+	for {
+		select {
+		case <-ww.stop:
+			return
+		case msg := <-ww.workQueue:
+			atomic.AddInt32(&ww.pool.busy, 1)
+			ww.p.recordWebhookStat("jira/subscribe/queue_wait", msg, msg.queuedAt, false, false)
+			err := ww.process(msg)
+			atomic.AddInt32(&ww.pool.busy, -1)
+			if err != nil {
+				ww.p.errorf("WebhookWorker id: %d, error processing, attempt: %d, err: %v", ww.id, msg.Attempts+1, err)
+			}
+			ww.p.finishWebhookMessage(msg, err)
 		}
+	}
+}
 
+// recordWebhookStat records the time since start of processing msg.
+func (p *Plugin) recordWebhookStat(endpoint string, msg *webhookMessage, start time.Time, isError, isIgnored bool) {
+	stats := p.getConfig().stats
+	if stats == nil {
+		return
+	}
+	elapsed := time.Duration(0)
+	if !start.IsZero() {
+		elapsed = time.Since(start)
 	}
+	stats.EnsureEndpoint(endpoint).Record(utils.ByteSize(len(msg.Data)), 0, elapsed, isError, isIgnored)
 }
 
-func (ww webhookWorker) process(rawData []byte) (err error) {
+func (ww webhookWorker) process(msg *webhookMessage) (err error) {
 	conf := ww.p.getConfig()
 	start := time.Now()
 	defer func() {
@@ -38,39 +177,147 @@ func (ww webhookWorker) process(rawData []byte) (err error) {
 			isIgnored = true
 			err = nil
 		default:
-			// TODO save the payload here
+			// the payload is saved for a retry, or as a dead letter
 			isError = true
 		}
 		if conf.stats != nil {
-			conf.stats.EnsureEndpoint("jira/subscribe/processing").Record(utils.ByteSize(len(rawData)), 0, time.Since(start), isError, isIgnored)
+			conf.stats.EnsureEndpoint("jira/subscribe/processing").Record(utils.ByteSize(len(msg.Data)), 0, time.Since(start), isError, isIgnored)
 		}
 	}()
 
-	wh, err := ParseWebhook(rawData)
-	if err != nil {
+	// The cached responses of Jira are invalidated by the events that are
+	// not posted too.
+	if ww.p.clientCache != nil {
+		if ji, err1 := ww.p.loadInstance(msg.InstanceURL); err1 == nil {
+			ww.p.clientCache.invalidateForWebhook(ji.GetURL(), msg.Data)
+		}
+	}
+
+	stageStart := time.Now()
+	wh, err := ParseWebhook(msg.Data)
+	ww.p.recordWebhookStat("jira/subscribe/processing/parse", msg, stageStart, err != nil && err != ErrWebhookIgnored, err == ErrWebhookIgnored)
+	if err == ErrWebhookIgnored {
 		return err
 	}
+	if err != nil {
+		return &webhookPermanentError{err}
+	}
 
-	if _, _, err = wh.PostNotifications(ww.p); err != nil {
-		ww.p.errorf("WebhookWorker id: %d, error posting notifications, err: %v", ww.id, err)
+	ji, err := ww.p.loadInstance(msg.InstanceURL)
+	if err != nil {
+		return err
 	}
 
-	if err = wh.(*webhook).JiraWebhook.expandIssue(ww.p); err != nil {
+	expandIssue := func() error {
+		stageStart := time.Now()
+		err := wh.(*webhook).JiraWebhook.expandIssue(ww.p, ji)
+		ww.p.recordWebhookStat("jira/subscribe/processing/expand_issue", msg, stageStart, err != nil, false)
 		return err
 	}
 
-	channelIds, err := ww.p.getChannelsSubscribed(wh.(*webhook))
+	// The personal subscriptions of PostNotifications match against the
+	// expanded issue, so it is expanded first if there are any. Otherwise
+	// the direct notifications are posted even if it fails to be expanded.
+	expanded := false
+	if !msg.NotificationsPosted {
+		users, err1 := ww.p.getPersonalSubscriptionUsers(ji)
+		if err1 != nil || users.Len() > 0 {
+			if err = expandIssue(); err != nil {
+				return err
+			}
+			expanded = true
+		}
+	}
+
+	// Steps that succeeded are recorded in msg, and skipped if it is retried.
+	var postErr error
+	if !msg.NotificationsPosted {
+		stageStart = time.Now()
+		_, _, err = wh.PostNotifications(ww.p, ji)
+		ww.p.recordWebhookStat("jira/subscribe/processing/notifications", msg, stageStart, err != nil, false)
+		if err != nil {
+			ww.p.errorf("WebhookWorker id: %d, error posting notifications, err: %v", ww.id, err)
+			postErr = err
+		} else {
+			msg.NotificationsPosted = true
+		}
+	}
+
+	if !expanded {
+		if err = expandIssue(); err != nil {
+			return err
+		}
+	}
+
+	if !msg.CommentsSynced {
+		if err1 := ww.p.syncCommentToThreads(ji, wh.(*webhook)); err1 != nil {
+			ww.p.errorf("WebhookWorker id: %d, error syncing comment to threads, err: %v", ww.id, err1)
+			postErr = err1
+		} else {
+			msg.CommentsSynced = true
+		}
+	}
+
+	stageStart = time.Now()
+	channelSubs, digestSubs, err := ww.p.getSubscriptionsMatched(ji, wh.(*webhook))
 	if err != nil {
+		ww.p.recordWebhookStat("jira/subscribe/processing/channel_posts", msg, stageStart, true, false)
 		return err
 	}
+	var channelErr error
 	botUserId := ww.p.getUserID()
-	for _, channelId := range channelIds.Elems() {
-		if _, _, err1 := wh.PostToChannel(ww.p, channelId, botUserId); err1 != nil {
-			ww.p.errorf("WebhookWorker id: %d, error posting to channel, err: %v", ww.id, err)
+	for channelId, sub := range channelSubs {
+		if msg.PostedChannelIds.ContainsAny(channelId) {
+			continue
 		}
+		sub := sub
+
+		// Over the rate limit of the channel, the update is collapsed with the
+		// others into a single post. If the posts can't be counted, as in a
+		// burst of updates, the message is retried rather than posted.
+		collapsed, create, err1 := ww.p.collapseChannelPost(ji, wh.(*webhook), &sub, time.Now())
+		if err1 != nil {
+			ww.p.errorf("WebhookWorker id: %d, error checking the rate limit of the channel, err: %v", ww.id, err1)
+			postErr, channelErr = err1, err1
+			continue
+		}
+		if collapsed != nil {
+			if err1 = ww.p.postCollapsedUpdates(ji, &sub, collapsed, create); err1 != nil {
+				ww.p.errorf("WebhookWorker id: %d, error posting collapsed updates, err: %v", ww.id, err1)
+			}
+			msg.PostedChannelIds = msg.PostedChannelIds.Add(channelId)
+			continue
+		}
+
+		if _, _, err1 := ww.p.postToChannelThread(ji, wh.(*webhook), channelId, botUserId, &sub); err1 != nil {
+			ww.p.errorf("WebhookWorker id: %d, error posting to channel, err: %v", ww.id, err1)
+			postErr, channelErr = err1, err1
+			continue
+		}
+		msg.PostedChannelIds = msg.PostedChannelIds.Add(channelId)
+	}
+
+	for _, sub := range digestSubs {
+		if msg.DigestSubscriptionIds.ContainsAny(sub.Id) {
+			continue
+		}
+		if err1 := ww.p.addToDigest(ji, sub, wh.(*webhook)); err1 != nil {
+			ww.p.errorf("WebhookWorker id: %d, error adding to digest, err: %v", ww.id, err1)
+			postErr, channelErr = err1, err1
+			continue
+		}
+		msg.DigestSubscriptionIds = msg.DigestSubscriptionIds.Add(sub.Id)
+	}
+	ww.p.recordWebhookStat("jira/subscribe/processing/channel_posts", msg, stageStart, channelErr != nil, false)
+
+	if postErr != nil {
+		return postErr
 	}
 
-	if err := ww.p.NotifyWorkflow(wh.(*webhook)); err != nil {
+	stageStart = time.Now()
+	err = ww.p.NotifyWorkflow(wh.(*webhook))
+	ww.p.recordWebhookStat("jira/subscribe/processing/workflow", msg, stageStart, err != nil, false)
+	if err != nil {
 		ww.p.errorf("WebhookWorker id: %d, error notifying workflow, err: %v", ww.id, err)
 	}
 
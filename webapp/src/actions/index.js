@@ -6,7 +6,17 @@ import {getCurrentChannelId} from 'mattermost-redux/selectors/entities/common';
 
 import ActionTypes from 'action_types';
 import {doFetch, doFetchWithResponse, buildQueryString} from 'client';
-import {getPluginServerRoute} from 'selectors';
+import {getPluginServerRoute, getInstanceURL} from 'selectors';
+
+// instanceQueryString returns the query string of a request to the plugin,
+// with the Jira instance the user is connected to.
+const instanceQueryString = (state, params = {}) => {
+    const instanceURL = getInstanceURL(state);
+    if (!instanceURL) {
+        return buildQueryString(params);
+    }
+    return buildQueryString({...params, instance_url: instanceURL});
+};
 
 export const openCreateModal = (postId) => {
     return {
@@ -49,10 +59,10 @@ export const closeAttachCommentToIssueModal = () => {
 export const fetchJiraIssueMetadataForProjects = (projectKeys) => {
     return async (dispatch, getState) => {
         const baseUrl = getPluginServerRoute(getState());
-        const projectKeysParam = projectKeys.join(',');
+        const query = instanceQueryString(getState(), {'project-keys': projectKeys.join(',')});
         let data = null;
         try {
-            data = await doFetch(`${baseUrl}/api/v2/get-create-issue-metadata-for-project?project-keys=${projectKeysParam}`, {
+            data = await doFetch(`${baseUrl}/api/v2/get-create-issue-metadata-for-project${query}`, {
                 method: 'get',
             });
         } catch (error) {
@@ -79,7 +89,7 @@ export const fetchJiraProjectMetadata = () => {
         const baseUrl = getPluginServerRoute(getState());
         let data = null;
         try {
-            data = await doFetch(`${baseUrl}/api/v2/get-jira-project-metadata`, {
+            data = await doFetch(`${baseUrl}/api/v2/get-jira-project-metadata${instanceQueryString(getState())}`, {
                 method: 'get',
             });
         } catch (error) {
@@ -98,7 +108,7 @@ export const fetchJiraProjectMetadata = () => {
 export const searchIssues = (params) => {
     return async (dispatch, getState) => {
         const url = getPluginServerRoute(getState()) + '/api/v2/get-search-issues';
-        return doFetchWithResponse(`${url}${buildQueryString(params)}`);
+        return doFetchWithResponse(`${url}${instanceQueryString(getState(), params)}`);
     };
 };
 
@@ -106,7 +116,7 @@ export const createIssue = (payload) => {
     return async (dispatch, getState) => {
         const baseUrl = getPluginServerRoute(getState());
         try {
-            const data = await doFetch(`${baseUrl}/api/v2/create-issue`, {
+            const data = await doFetch(`${baseUrl}/api/v2/create-issue${instanceQueryString(getState())}`, {
                 method: 'post',
                 body: JSON.stringify(payload),
             });
@@ -121,7 +131,7 @@ export const attachCommentToIssue = (payload) => {
     return async (dispatch, getState) => {
         const baseUrl = getPluginServerRoute(getState());
         try {
-            const data = await doFetch(`${baseUrl}/api/v2/attach-comment-to-issue`, {
+            const data = await doFetch(`${baseUrl}/api/v2/attach-comment-to-issue${instanceQueryString(getState())}`, {
                 method: 'post',
                 body: JSON.stringify(payload),
             });
@@ -137,7 +147,7 @@ export const createChannelSubscription = (subscription) => {
     return async (dispatch, getState) => {
         const baseUrl = getPluginServerRoute(getState());
         try {
-            const data = await doFetch(`${baseUrl}/api/v2/subscriptions/channel`, {
+            const data = await doFetch(`${baseUrl}/api/v2/subscriptions/channel${instanceQueryString(getState())}`, {
                 method: 'post',
                 body: JSON.stringify(subscription),
             });
@@ -158,7 +168,7 @@ export const editChannelSubscription = (subscription) => {
     return async (dispatch, getState) => {
         const baseUrl = getPluginServerRoute(getState());
         try {
-            const data = await doFetch(`${baseUrl}/api/v2/subscriptions/channel`, {
+            const data = await doFetch(`${baseUrl}/api/v2/subscriptions/channel${instanceQueryString(getState())}`, {
                 method: 'put',
                 body: JSON.stringify(subscription),
             });
@@ -179,7 +189,7 @@ export const deleteChannelSubscription = (subscription) => {
     return async (dispatch, getState) => {
         const baseUrl = getPluginServerRoute(getState());
         try {
-            await doFetch(`${baseUrl}/api/v2/subscriptions/channel/${subscription.id}`, {
+            await doFetch(`${baseUrl}/api/v2/subscriptions/channel/${subscription.id}${instanceQueryString(getState())}`, {
                 method: 'delete',
             });
 
@@ -200,7 +210,7 @@ export const fetchChannelSubscriptions = (channelId) => {
         const baseUrl = getPluginServerRoute(getState());
         let data = null;
         try {
-            data = await doFetch(`${baseUrl}/api/v2/subscriptions/channel/${channelId}`, {
+            data = await doFetch(`${baseUrl}/api/v2/subscriptions/channel/${channelId}${instanceQueryString(getState())}`, {
                 method: 'get',
             });
         } catch (error) {
@@ -243,7 +253,7 @@ export function getConnected() {
         let data;
         const baseUrl = getPluginServerRoute(getState());
         try {
-            data = await doFetch(`${baseUrl}/api/v2/userinfo`, {
+            data = await doFetch(`${baseUrl}/api/v2/userinfo${instanceQueryString(getState())}`, {
                 method: 'get',
             });
         } catch (error) {
@@ -274,6 +284,11 @@ export function handleConnectChange(store) {
             type: ActionTypes.RECEIVED_CONNECTED,
             data: msg.data,
         });
+
+        // The user may still be connected to another Jira instance.
+        if (!msg.data.is_connected) {
+            getConnected()(store.dispatch, store.getState);
+        }
     };
 }
 
@@ -218,6 +218,7 @@ describe('components/EditChannelSettings', () => {
                 channel_id: testChannel.id,
                 filters: channelSubscriptionForCloud.filters,
                 name: channelSubscriptionForCloud.name,
+                actions: [],
             }
         );
         expect(editChannelSubscription).not.toHaveBeenCalled();
@@ -269,6 +270,7 @@ describe('components/EditChannelSettings', () => {
                 channel_id: testChannel.id,
                 filters: channelSubscriptionForServer.filters,
                 name: null,
+                actions: [],
             }
         );
         expect(editChannelSubscription).not.toHaveBeenCalled();
@@ -338,6 +340,7 @@ describe('components/EditChannelSettings', () => {
                     }],
                 },
                 name: 'SubTestName',
+                actions: [],
             }
         );
     });
@@ -368,6 +371,7 @@ describe('components/EditChannelSettings', () => {
                 channel_id: testChannel.id,
                 filters: channelSubscriptionForCloud.filters,
                 name: channelSubscriptionForCloud.name,
+                actions: [],
             }
         );
         expect(createChannelSubscription).not.toHaveBeenCalled();
@@ -51,6 +51,14 @@ const JiraEventOptions: ReactSelectOption[] = [
     {value: 'event_updated_components', label: 'Issue Updated: Components'},
 ];
 
+const PostActionOptions: ReactSelectOption[] = [
+    {value: 'assign', label: 'Assign to me'},
+    {value: 'transition', label: 'Transition'},
+    {value: 'comment', label: 'Comment'},
+    {value: 'watch', label: 'Watch'},
+    {value: 'priority', label: 'Change priority'},
+];
+
 export type Props = SharedProps & {
     finishEditSubscription: () => void;
     selectedSubscription: ChannelSubscription | null;
@@ -64,6 +72,7 @@ export type State = {
     getMetaDataErr: string | null;
     submitting: boolean;
     subscriptionName: string | null;
+    actions: string[];
     showConfirmModal: boolean;
     conflictingError: string | null;
 };
@@ -82,9 +91,11 @@ export default class EditChannelSettings extends PureComponent<Props, State> {
         };
 
         let subscriptionName = null;
+        let actions: string[] = [];
         if (props.selectedSubscription) {
             filters = Object.assign({}, filters, props.selectedSubscription.filters);
             subscriptionName = props.selectedSubscription.name;
+            actions = props.selectedSubscription.actions || [];
         }
 
         filters.fields = filters.fields || [];
@@ -102,6 +113,7 @@ export default class EditChannelSettings extends PureComponent<Props, State> {
             filters,
             fetchingIssueMetadata,
             subscriptionName,
+            actions,
             showConfirmModal: false,
             conflictingError: null,
         };
@@ -247,6 +259,10 @@ export default class EditChannelSettings extends PureComponent<Props, State> {
         });
     };
 
+    handleActionsChange = (id, value) => {
+        this.setState({actions: value || []});
+    };
+
     handleFilterFieldChange = (fields) => {
         this.setState({filters: {...this.state.filters, fields}});
         this.clearConflictingErrorMessage();
@@ -275,9 +291,11 @@ export default class EditChannelSettings extends PureComponent<Props, State> {
         };
 
         const subscription = {
+            ...this.props.selectedSubscription,
             channel_id: this.props.channel.id,
             filters,
             name: this.state.subscriptionName,
+            actions: this.state.actions,
         } as ChannelSubscription;
 
         this.setState({submitting: true, error: null});
@@ -372,6 +390,15 @@ export default class EditChannelSettings extends PureComponent<Props, State> {
                                 <span>{generateJQLStringFromSubscriptionFilters(this.props.jiraIssueMetadata, filterFields, this.state.filters)}</span>
                             </div>
                         </div>
+                        <ReactSelectSetting
+                            name={'actions'}
+                            label={'Post Actions'}
+                            onChange={this.handleActionsChange}
+                            options={PostActionOptions}
+                            isMulti={true}
+                            theme={this.props.theme}
+                            value={PostActionOptions.filter((option) => this.state.actions.includes(option.value))}
+                        />
                     </React.Fragment>
                 );
             }
@@ -27,7 +27,9 @@ export default class Hooks {
             shouldEnableCreate = this.settings.ui_enabled;
         }
 
-        if (messageTrimmed && messageTrimmed.startsWith('/jira create') && shouldEnableCreate) {
+        // With options, the issue is created by the server without the modal.
+        const createWithOptions = (/^\/jira create\s+--[a-z]/).test(messageTrimmed || '');
+        if (messageTrimmed && messageTrimmed.startsWith('/jira create') && shouldEnableCreate && !createWithOptions) {
             if (!isInstanceInstalled(this.store.getState())) {
                 this.store.dispatch(sendEphemeralPost('There is no Jira instance installed. Please contact your system administrator.'));
                 return Promise.resolve({});
@@ -38,6 +38,17 @@ function instanceType(state = '', action) {
     }
 }
 
+function instanceURL(state = '', action) {
+    // The Jira instance the requests to the plugin are for, the one the user
+    // is connected to. When it is empty, the server selects one.
+    switch (action.type) {
+    case ActionTypes.RECEIVED_CONNECTED:
+        return action.data.is_connected ? action.data.jira_url : '';
+    default:
+        return state;
+    }
+}
+
 function pluginSettings(state = null, action) {
     switch (action.type) {
     case ActionTypes.RECEIVED_PLUGIN_SETTINGS:
@@ -179,6 +190,7 @@ export default combineReducers({
     userConnected,
     instanceInstalled,
     instanceType,
+    instanceURL,
     pluginSettings,
     createModalVisible,
     createModal,
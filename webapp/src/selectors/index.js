@@ -60,3 +60,5 @@ export const isInstanceInstalled = (state) => getPluginState(state).instanceInst
 export const getPluginSettings = (state) => getPluginState(state).pluginSettings;
 
 export const getInstalledInstanceType = (state) => getPluginState(state).instanceType;
+
+export const getInstanceURL = (state) => getPluginState(state).instanceURL;
@@ -98,6 +98,25 @@ export type ChannelSubscriptionFilters = {
     events: string[];
     issue_types: string[];
     fields: FilterValue[];
+    jql?: string;
+};
+
+export type SubscriptionDigest = {
+    schedule: 'hourly' | 'daily' | 'weekly';
+    time?: string;
+    weekday?: string;
+    timezone?: string;
+};
+
+export type NotificationTemplate = {
+    headline?: string;
+    text?: string;
+    color?: string;
+};
+
+export type SubscriptionRateLimit = {
+    posts: number;
+    window_minutes?: number;
 };
 
 export type ChannelSubscription = {
@@ -105,4 +124,8 @@ export type ChannelSubscription = {
     channel_id: string;
     filters: ChannelSubscriptionFilters;
     name: string;
+    digest?: SubscriptionDigest;
+    templates?: {[event: string]: NotificationTemplate};
+    actions?: string[];
+    rate_limit?: SubscriptionRateLimit;
 }